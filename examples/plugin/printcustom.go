@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -25,7 +26,7 @@ func (segment PrintCustom) New(config map[string]string) segments.Segment {
 	return &PrintCustom{}
 }
 
-func (segment *PrintCustom) Run(wg *sync.WaitGroup) {
+func (segment *PrintCustom) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -53,6 +54,9 @@ func (segment *PrintCustom) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &PrintCustom{}
-	// TODO: edit the name you'll use in your config file here.
+	// TODO: edit the name you'll use in your config file here, and declare
+	// all config parameters parsed in New, such as
+	// segments.Param{Name: "threshold", Type: segments.Uint}, as any others
+	// are rejected.
 	segments.RegisterSegment("printcustom", segment)
 }
@@ -6,41 +6,58 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"plugin"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/pipeline"
+	"github.com/BelWue/flowpipeline/pipeline/config"
+	"github.com/BelWue/flowpipeline/segments"
 
 	_ "github.com/BelWue/flowpipeline/segments/alert/http"
 
 	_ "github.com/BelWue/flowpipeline/segments/controlflow/branch"
+	_ "github.com/BelWue/flowpipeline/segments/controlflow/switch"
+	_ "github.com/BelWue/flowpipeline/segments/controlflow/tee"
 
+	_ "github.com/BelWue/flowpipeline/segments/filter/aggregate"
+	_ "github.com/BelWue/flowpipeline/segments/filter/biflow"
+	_ "github.com/BelWue/flowpipeline/segments/filter/dedup"
 	_ "github.com/BelWue/flowpipeline/segments/filter/drop"
 	_ "github.com/BelWue/flowpipeline/segments/filter/elephant"
 
 	_ "github.com/BelWue/flowpipeline/segments/filter/flowfilter"
 
 	_ "github.com/BelWue/flowpipeline/segments/input/bpf"
+	_ "github.com/BelWue/flowpipeline/segments/input/connect"
 	_ "github.com/BelWue/flowpipeline/segments/input/diskbuffer"
 	_ "github.com/BelWue/flowpipeline/segments/input/goflow"
 	_ "github.com/BelWue/flowpipeline/segments/input/kafkaconsumer"
 	_ "github.com/BelWue/flowpipeline/segments/input/packet"
+	_ "github.com/BelWue/flowpipeline/segments/input/protobufreader"
 	_ "github.com/BelWue/flowpipeline/segments/input/replay"
 	_ "github.com/BelWue/flowpipeline/segments/input/stdin"
 
 	_ "github.com/BelWue/flowpipeline/segments/meta/monitoring"
 
 	_ "github.com/BelWue/flowpipeline/segments/modify/addcid"
+	_ "github.com/BelWue/flowpipeline/segments/modify/addlabels"
 	_ "github.com/BelWue/flowpipeline/segments/modify/addnetid"
 	_ "github.com/BelWue/flowpipeline/segments/modify/addrstrings"
 	_ "github.com/BelWue/flowpipeline/segments/modify/anonymize"
@@ -52,6 +69,7 @@ import (
 	_ "github.com/BelWue/flowpipeline/segments/modify/protomap"
 	_ "github.com/BelWue/flowpipeline/segments/modify/remoteaddress"
 	_ "github.com/BelWue/flowpipeline/segments/modify/reversedns"
+	_ "github.com/BelWue/flowpipeline/segments/modify/set"
 	_ "github.com/BelWue/flowpipeline/segments/modify/snmp"
 	_ "github.com/BelWue/flowpipeline/segments/modify/sync_timestamps"
 
@@ -60,11 +78,15 @@ import (
 	_ "github.com/BelWue/flowpipeline/segments/output/clickhouse"
 	_ "github.com/BelWue/flowpipeline/segments/output/csv"
 	_ "github.com/BelWue/flowpipeline/segments/output/influx"
+	_ "github.com/BelWue/flowpipeline/segments/output/ipfix"
 	_ "github.com/BelWue/flowpipeline/segments/output/json"
 	_ "github.com/BelWue/flowpipeline/segments/output/kafkaproducer"
 	_ "github.com/BelWue/flowpipeline/segments/output/lumberjack"
 	_ "github.com/BelWue/flowpipeline/segments/output/mongodb"
+	_ "github.com/BelWue/flowpipeline/segments/output/parquet"
 	_ "github.com/BelWue/flowpipeline/segments/output/prometheus"
+	_ "github.com/BelWue/flowpipeline/segments/output/protobuf"
+	_ "github.com/BelWue/flowpipeline/segments/output/publish"
 	_ "github.com/BelWue/flowpipeline/segments/output/sqlite"
 
 	_ "github.com/BelWue/flowpipeline/segments/print/count"
@@ -74,6 +96,8 @@ import (
 
 	_ "github.com/BelWue/flowpipeline/segments/analysis/toptalkers_metrics"
 	_ "github.com/BelWue/flowpipeline/segments/analysis/traffic_specific_toptalkers"
+
+	_ "github.com/BelWue/flowpipeline/segments/testing/generator"
 )
 
 var Version string
@@ -97,6 +121,10 @@ func main() {
 	version := flag.Bool("v", false, "print version")
 	prettyLogging := flag.Bool("j", false, "Json log")
 	configFile := flag.String("c", "config.yml", "location of the config file in yml format")
+	watchConfig := flag.Bool("w", false, "Watch the config file and reload the pipeline on changes, the same as sending SIGHUP")
+	validate := flag.Bool("validate", false, "Validate the config file and report all problems found without running it, exits non-zero if there are errors")
+	flushTimeout := flag.Duration("flushtimeout", segments.FlushTimeout, "Time each output segment is given to flush buffered flows on shutdown")
+	metricsAddr := flag.String("metrics", "", "Serve Prometheus metrics of all segments at /metrics on this address, e.g. ':9100'")
 	flag.Parse()
 
 	if *version {
@@ -127,39 +155,452 @@ func main() {
 	config, err := os.ReadFile(*configFile)
 	if err != nil {
 		log.Error().Err(err).Msg("Reading config file: ")
+		if *validate {
+			os.Exit(1)
+		}
 		return
 	}
 
-	pipelineCount := 1
+	if *validate {
+		os.Exit(validateConfig(config, *configFile))
+	}
+
+	defaultConcurrency := 1
 	if *concurrency == 0 {
-		pipelineCount = runtime.GOMAXPROCS(0)
+		defaultConcurrency = runtime.GOMAXPROCS(0)
 	} else {
-		pipelineCount = int(*concurrency)
+		defaultConcurrency = int(*concurrency)
 	}
 
-	segmentReprs := pipeline.SegmentReprsFromConfig(config)
-	for i := 0; i < pipelineCount; i++ {
-		segments := pipeline.SegmentsFromRepr(segmentReprs)
-		pipe := pipeline.New(segments...)
-		if pipe == nil {
-			log.Fatal().Msg("An error occured during pipeline initialization - Exiting")
-			return
-		}
-		pipe.Start()
-		pipe.AutoDrain()
-		defer pipe.Close()
+	segments.FlushTimeout = *flushTimeout
+
+	// Segments may shut down their pipeline gracefully, e.g. after reading
+	// a file, which is reported here.
+	ctx := context.Background()
+	shutdowns := make(chan shutdownRequest)
+
+	pipelineReprs, err := parsePipelines(config)
+	if err != nil {
+		logConfigErrors(err)
+		log.Fatal().Msg("An error occured during pipeline initialization - Exiting")
+		return
+	}
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+	pipes, err := startPipelines(ctx, pipelineReprs, defaultConcurrency, *metricsAddr != "", shutdowns)
+	if err != nil {
+		logConfigErrors(err)
+		log.Fatal().Msg("An error occured during pipeline initialization - Exiting")
+		return
+	}
+
+	var configChanges <-chan struct{}
+	if *watchConfig {
+		configChanges = watchConfigFile(*configFile)
 	}
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt, syscall.SIGINT)
-	signal.Notify(sigs, os.Interrupt, os.Interrupt)
-	<-sigs
-	log.Info().Msg("Received exit signal")
+	signal.Notify(sigs, os.Interrupt, syscall.SIGINT, syscall.SIGHUP)
+	for running := true; running; {
+		select {
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				log.Info().Msg("Received exit signal")
+				running = false
+				break
+			}
+			log.Info().Msg("Received SIGHUP, reloading config file")
+			reloadPipelines(ctx, pipes, defaultConcurrency, *configFile)
+		case <-configChanges:
+			log.Info().Msg("Config file changed, reloading")
+			reloadPipelines(ctx, pipes, defaultConcurrency, *configFile)
+		case request := <-shutdowns:
+			running = !closeRequested(pipes, request)
+		}
+	}
+	closePipelines(pipes, sigs)
+}
+
+// All running instances of a single pipeline defined in the config.
+type runningPipeline struct {
+	repr         config.PipelineRepr
+	instances    []*pipeline.Pipeline
+	instrumented bool                        // whether metrics are collected for the instances
+	closed       map[*pipeline.Pipeline]bool // instances closed on request of their segments
+	shutdowns    chan<- shutdownRequest
+}
+
+// Sent once an instance of a pipeline is shutting down, see watch.
+type shutdownRequest struct {
+	running *runningPipeline
+	pipe    *pipeline.Pipeline
+}
+
+// Parses the pipelines defined in the config, ordered as they need to be
+// shut down.
+func parsePipelines(config []byte) ([]config.PipelineRepr, error) {
+	pipelineReprs, err := pipeline.PipelineReprsFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.ShutdownOrder(pipelineReprs)
+}
+
+// Returns the number of instances to run of a pipeline.
+func instanceCount(pipelineRepr config.PipelineRepr, defaultConcurrency int) int {
+	if pipelineRepr.Concurrency > 0 {
+		return pipelineRepr.Concurrency
+	}
+	return defaultConcurrency
+}
+
+// Creates all instances of all pipelines before starting any of them, so
+// that all connect segments are subscribed before the first flow is
+// published.
+func startPipelines(ctx context.Context, pipelineReprs []config.PipelineRepr, defaultConcurrency int, instrumented bool, shutdowns chan<- shutdownRequest) ([]*runningPipeline, error) {
+	pipes := make([]*runningPipeline, len(pipelineReprs))
+	for i, pipelineRepr := range pipelineReprs {
+		pipes[i] = &runningPipeline{
+			repr:         pipelineRepr,
+			instances:    make([]*pipeline.Pipeline, instanceCount(pipelineRepr, defaultConcurrency)),
+			instrumented: instrumented,
+			closed:       make(map[*pipeline.Pipeline]bool),
+			shutdowns:    shutdowns,
+		}
+		for j := range pipes[i].instances {
+			pipe, err := pipes[i].newInstance(pipelineRepr, j)
+			if err != nil {
+				return nil, err
+			}
+			pipes[i].instances[j] = pipe
+		}
+	}
+	for _, running := range pipes {
+		for _, pipe := range running.instances {
+			running.start(ctx, pipe)
+		}
+	}
+	return pipes, nil
+}
+
+// Creates an instance of this pipeline from the given config, without
+// starting it.
+func (running *runningPipeline) newInstance(pipelineRepr config.PipelineRepr, instance int) (*pipeline.Pipeline, error) {
+	pipe, err := pipeline.NewFromPipelineRepr(pipelineRepr)
+	if err != nil {
+		return nil, err
+	}
+	if running.instrumented {
+		pipe.Instrument(pipelineRepr.Name, instance)
+	}
+	return pipe, nil
+}
+
+// Starts an instance of this pipeline created by newInstance.
+func (running *runningPipeline) start(ctx context.Context, pipe *pipeline.Pipeline) {
+	pipe.StartContext(ctx)
+	pipe.AutoDrain()
+	running.watch(pipe)
+}
+
+// Sends a shutdownRequest once the instance is shutting down, which is also
+// the case when it is closed.
+func (running *runningPipeline) watch(pipe *pipeline.Pipeline) {
+	go func() {
+		<-pipe.Done()
+		running.shutdowns <- shutdownRequest{running: running, pipe: pipe}
+	}()
+}
+
+// Closes an instance whose segments requested to shut it down. Once all
+// instances of a pipeline are closed, all pipelines whose input is a connect
+// segment receiving only from closed pipelines are closed as well, after
+// processing all flows in flight. Returns whether all pipelines are closed.
+func closeRequested(pipes []*runningPipeline, request shutdownRequest) bool {
+	running, pipe := request.running, request.pipe
+	if !slices.Contains(running.instances, pipe) || running.closed[pipe] {
+		return false // replaced by a reload or closed already
+	}
+	log.Info().Msgf("Shutdown of pipeline '%s' requested by a segment", running.repr.Name)
+	pipe.Close()
+	running.closed[pipe] = true
+
+	for closing := true; closing; {
+		closing = false
+		for _, consumer := range pipes {
+			if !consumer.allClosed() && consumer.drained(pipes) {
+				log.Info().Msgf("All pipelines publishing to pipeline '%s' are shut down, shutting it down", consumer.repr.Name)
+				consumer.close()
+				closing = true
+			}
+		}
+	}
+	for _, running := range pipes {
+		if !running.allClosed() {
+			return false
+		}
+	}
+	log.Info().Msg("All pipelines were shut down by their segments")
+	return true
+}
+
+// Whether all instances of this pipeline are closed.
+func (running *runningPipeline) allClosed() bool {
+	return len(running.closed) == len(running.instances)
+}
+
+// Whether this pipeline's input is a connect segment, and all pipelines
+// publishing to the topics it connects to are closed.
+func (running *runningPipeline) drained(pipes []*runningPipeline) bool {
+	if len(running.repr.Segments) == 0 || running.repr.Segments[0].Name != "connect" {
+		return false
+	}
+	_, connected := running.repr.Topics()
+	for _, publisher := range pipes {
+		published, _ := publisher.repr.Topics()
+		if !publisher.allClosed() && slices.ContainsFunc(published, func(topic string) bool { return slices.Contains(connected, topic) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// Closes all instances of this pipeline concurrently, skipping those closed
+// already.
+func (running *runningPipeline) close() {
+	var wg sync.WaitGroup
+	for _, pipe := range running.instances {
+		if running.closed[pipe] {
+			continue
+		}
+		running.closed[pipe] = true
+		wg.Add(1)
+		go func(pipe *pipeline.Pipeline) {
+			defer wg.Done()
+			pipe.Close()
+		}(pipe)
+	}
+	wg.Wait()
+}
+
+// Serves the metrics of all segments in the background.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", pipeline.MetricsHandler())
 	go func() {
-		<-time.After(time.Duration(15 * time.Second))
-		log.Fatal().Msg("Failed to shut down gracefully - force quitting")
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error().Err(err).Msgf("Failed to serve metrics on %s: ", addr)
+		}
+	}()
+	log.Info().Msgf("Serving metrics at %s/metrics", addr)
+}
+
+// Closes all pipelines in order, closing all instances of a pipeline
+// concurrently. Each of them stops its input segments, drains all flows still
+// in flight and lets its output segments flush. As these flushes are bounded
+// by segments.FlushTimeout, this only force quits if segments fail to
+// terminate, or if another exit signal is received.
+func closePipelines(pipes []*runningPipeline, sigs <-chan os.Signal) {
+	go func() {
+		select {
+		case <-time.After(time.Duration(len(pipes)) * (segments.FlushTimeout + 15*time.Second)):
+			log.Error().Msg("Failed to shut down gracefully - force quitting")
+		case <-sigs:
+			log.Error().Msg("Received another exit signal - force quitting")
+		}
 		os.Exit(5)
 	}()
+	for _, running := range pipes {
+		running.close()
+	}
+	log.Info().Msg("All pipelines shut down")
+}
+
+// Prints all problems found in the config and returns the exit code.
+func validateConfig(config []byte, configFile string) int {
+	// nothing else is running, so all messages logged by segments are theirs
+	validator := &pipeline.Validator{}
+	log.Logger = zerolog.New(validator).Hook(validator)
+	errorCount, warningCount := 0, 0
+	for _, problem := range validator.Validate(config) {
+		fmt.Printf("%s: %s\n", configFile, problem)
+		if problem.Warning {
+			warningCount += 1
+		} else {
+			errorCount += 1
+		}
+	}
+	fmt.Printf("%s: %d errors, %d warnings\n", configFile, errorCount, warningCount)
+	if errorCount > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Logs each of the errors returned when creating a pipeline on its own line.
+func logConfigErrors(err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			logConfigErrors(err)
+		}
+		return
+	}
+	log.Error().Msg(err.Error())
+}
+
+// Reloads all pipelines from the config file in place. Pipelines whose input
+// segment is unchanged keep running, all others are restarted. If the new
+// config can not be used, the previous one stays active. Adding or removing
+// pipelines or changing their concurrency requires a restart.
+func reloadPipelines(ctx context.Context, pipes []*runningPipeline, defaultConcurrency int, configFile string) {
+	config, err := os.ReadFile(configFile)
+	if err != nil {
+		log.Error().Err(err).Msg("Reading config file failed, keeping current config: ")
+		return
+	}
+	pipelineReprs, err := parsePipelines(config)
+	if err != nil {
+		logConfigErrors(err)
+		log.Error().Msg("Reloading config failed, keeping current config")
+		return
+	}
+	if len(pipelineReprs) != len(pipes) {
+		log.Error().Msg("Pipelines were added or removed, restart flowpipeline to apply - keeping current config")
+		return
+	}
+	for i, pipelineRepr := range pipelineReprs {
+		if pipelineRepr.Name != pipes[i].repr.Name || instanceCount(pipelineRepr, defaultConcurrency) != len(pipes[i].instances) {
+			log.Error().Msg("Pipelines were renamed, reordered or changed their concurrency, restart flowpipeline to apply - keeping current config")
+			return
+		}
+	}
+
+	// build all segments first, so that either all pipelines are reloaded
+	// or none of them
+	reloads := make([]*pipelineReload, len(pipes))
+	for i, running := range pipes {
+		reload, err := running.prepareReload(pipelineReprs[i])
+		if err != nil {
+			for _, reload := range reloads[:i] {
+				reload.discard()
+			}
+			logConfigErrors(err)
+			log.Error().Msg("Reloading config failed, keeping current config")
+			return
+		}
+		reloads[i] = reload
+	}
+	for _, reload := range reloads {
+		reload.apply(ctx)
+	}
+	log.Info().Msg("Reloaded config file")
+}
+
+// The reload of all instances of a single pipeline, see prepareReload.
+type pipelineReload struct {
+	running  *runningPipeline
+	repr     config.PipelineRepr
+	reloads  map[int]*pipeline.PreparedReload // by instance
+	restarts map[int]*pipeline.Pipeline       // replacing instances whose input segment changed
+}
+
+// Builds all instances of this pipeline, or the segments to be swapped into
+// them, for the given config without changing the running ones.
+func (running *runningPipeline) prepareReload(pipelineRepr config.PipelineRepr) (*pipelineReload, error) {
+	reload := &pipelineReload{
+		running:  running,
+		repr:     pipelineRepr,
+		reloads:  make(map[int]*pipeline.PreparedReload),
+		restarts: make(map[int]*pipeline.Pipeline),
+	}
+	for i, pipe := range running.instances {
+		if running.closed[pipe] {
+			continue
+		}
+		prepared, err := pipe.PrepareReload(pipelineRepr.SegmentReprs())
+		if errors.Is(err, pipeline.ErrInputChanged) {
+			var restarted *pipeline.Pipeline
+			if restarted, err = running.newInstance(pipelineRepr, i); err == nil {
+				reload.restarts[i] = restarted
+				continue
+			}
+		}
+		if err != nil {
+			reload.discard()
+			return nil, err
+		}
+		reload.reloads[i] = prepared
+	}
+	return reload, nil
+}
+
+// Swaps the prepared segments and instances in.
+func (reload *pipelineReload) apply(ctx context.Context) {
+	running := reload.running
+	for _, prepared := range reload.reloads {
+		if err := prepared.Apply(); err != nil {
+			// only if the instance is shutting down on request of a segment
+			log.Warn().Err(err).Msgf("Pipeline '%s' was not reloaded: ", running.repr.Name)
+		}
+	}
+	for i, restarted := range reload.restarts {
+		log.Info().Msgf("Input segment config of pipeline '%s' changed, restarting it", running.repr.Name)
+		running.instances[i].Close()
+		running.instances[i] = restarted
+		running.start(ctx, restarted)
+	}
+	running.repr = reload.repr
+}
+
+// Closes the prepared segments and instances without applying them.
+func (reload *pipelineReload) discard() {
+	for _, prepared := range reload.reloads {
+		prepared.Discard()
+	}
+	for _, restarted := range reload.restarts {
+		restarted.Close()
+	}
+}
+
+// Watches the directory containing the config file, as editors and
+// configuration management tools tend to replace files instead of writing
+// them. Bursts of events are coalesced into a single notification.
+func watchConfigFile(configFile string) <-chan struct{} {
+	changes := make(chan struct{})
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up config file watcher: ")
+		return changes
+	}
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		log.Error().Err(err).Msg("Failed to watch config file: ")
+		watcher.Close()
+		return changes
+	}
+	go func() {
+		var settled <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(configFile) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					settled = time.After(500 * time.Millisecond)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn().Err(err).Msg("Config file watcher: ")
+			case <-settled:
+				settled = nil
+				changes <- struct{}{}
+			}
+		}
+	}()
+	return changes
 }
 
 func zerologLogLevel(logLevel *string) zerolog.Level {
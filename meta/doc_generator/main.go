@@ -17,6 +17,68 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/segments"
+
+	// the parameters declared by segments are documented as well
+	_ "github.com/BelWue/flowpipeline/segments/alert/http"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/toptalkers_metrics"
+	_ "github.com/BelWue/flowpipeline/segments/analysis/traffic_specific_toptalkers"
+	_ "github.com/BelWue/flowpipeline/segments/controlflow/branch"
+	_ "github.com/BelWue/flowpipeline/segments/controlflow/switch"
+	_ "github.com/BelWue/flowpipeline/segments/controlflow/tee"
+	_ "github.com/BelWue/flowpipeline/segments/dev/filegate"
+	_ "github.com/BelWue/flowpipeline/segments/filter/aggregate"
+	_ "github.com/BelWue/flowpipeline/segments/filter/biflow"
+	_ "github.com/BelWue/flowpipeline/segments/filter/dedup"
+	_ "github.com/BelWue/flowpipeline/segments/filter/drop"
+	_ "github.com/BelWue/flowpipeline/segments/filter/elephant"
+	_ "github.com/BelWue/flowpipeline/segments/filter/flowfilter"
+	_ "github.com/BelWue/flowpipeline/segments/input/bpf"
+	_ "github.com/BelWue/flowpipeline/segments/input/connect"
+	_ "github.com/BelWue/flowpipeline/segments/input/diskbuffer"
+	_ "github.com/BelWue/flowpipeline/segments/input/goflow"
+	_ "github.com/BelWue/flowpipeline/segments/input/kafkaconsumer"
+	_ "github.com/BelWue/flowpipeline/segments/input/packet"
+	_ "github.com/BelWue/flowpipeline/segments/input/protobufreader"
+	_ "github.com/BelWue/flowpipeline/segments/input/replay"
+	_ "github.com/BelWue/flowpipeline/segments/input/stdin"
+	_ "github.com/BelWue/flowpipeline/segments/meta/monitoring"
+	_ "github.com/BelWue/flowpipeline/segments/modify/addcid"
+	_ "github.com/BelWue/flowpipeline/segments/modify/addlabels"
+	_ "github.com/BelWue/flowpipeline/segments/modify/addnetid"
+	_ "github.com/BelWue/flowpipeline/segments/modify/addrstrings"
+	_ "github.com/BelWue/flowpipeline/segments/modify/anonymize"
+	_ "github.com/BelWue/flowpipeline/segments/modify/aslookup"
+	_ "github.com/BelWue/flowpipeline/segments/modify/bgp"
+	_ "github.com/BelWue/flowpipeline/segments/modify/dropfields"
+	_ "github.com/BelWue/flowpipeline/segments/modify/geolocation"
+	_ "github.com/BelWue/flowpipeline/segments/modify/normalize"
+	_ "github.com/BelWue/flowpipeline/segments/modify/protomap"
+	_ "github.com/BelWue/flowpipeline/segments/modify/remoteaddress"
+	_ "github.com/BelWue/flowpipeline/segments/modify/reversedns"
+	_ "github.com/BelWue/flowpipeline/segments/modify/set"
+	_ "github.com/BelWue/flowpipeline/segments/modify/snmp"
+	_ "github.com/BelWue/flowpipeline/segments/modify/sync_timestamps"
+	_ "github.com/BelWue/flowpipeline/segments/output/clickhouse"
+	_ "github.com/BelWue/flowpipeline/segments/output/csv"
+	_ "github.com/BelWue/flowpipeline/segments/output/influx"
+	_ "github.com/BelWue/flowpipeline/segments/output/ipfix"
+	_ "github.com/BelWue/flowpipeline/segments/output/json"
+	_ "github.com/BelWue/flowpipeline/segments/output/kafkaproducer"
+	_ "github.com/BelWue/flowpipeline/segments/output/lumberjack"
+	_ "github.com/BelWue/flowpipeline/segments/output/mongodb"
+	_ "github.com/BelWue/flowpipeline/segments/output/parquet"
+	_ "github.com/BelWue/flowpipeline/segments/output/prometheus"
+	_ "github.com/BelWue/flowpipeline/segments/output/protobuf"
+	_ "github.com/BelWue/flowpipeline/segments/output/publish"
+	_ "github.com/BelWue/flowpipeline/segments/output/sqlite"
+	_ "github.com/BelWue/flowpipeline/segments/pass"
+	_ "github.com/BelWue/flowpipeline/segments/print/count"
+	_ "github.com/BelWue/flowpipeline/segments/print/printdots"
+	_ "github.com/BelWue/flowpipeline/segments/print/printflowdump"
+	_ "github.com/BelWue/flowpipeline/segments/print/toptalkers"
+	_ "github.com/BelWue/flowpipeline/segments/testing/generator"
 )
 
 type SegmentTree struct {
@@ -166,7 +228,10 @@ func _generateSegmentDoc(tree *SegmentTree, mdBuilder *MdBuilder) {
 		if tree.IsSegment {
 			fmt.Fprintf(mdBuilder, "_This segment is implemented in %s._", linkFromPath(tree.Path, filepath.Base(tree.Path)))
 			mdBuilder.WriteParagraph(extractPackageDoc(tree.Path))
-			fieldsDoc := extractConfigStructDoc(tree)
+			fieldsDoc := extractParamDoc(tree)
+			if fieldsDoc == "" {
+				fieldsDoc = extractConfigStructDoc(tree)
+			}
 			if fieldsDoc != "" {
 				mdBuilder.WriteParagraph(summary("Configuration options", fieldsDoc))
 			}
@@ -206,6 +271,27 @@ func extractPackageDoc(path string) string {
 	return strings.TrimSpace(node.Doc.Text())
 }
 
+// Documents the parameters a segment declared when registering itself. Returns
+// an empty string for segments not declaring any.
+func extractParamDoc(tree *SegmentTree) string {
+	var paramDocBuilder strings.Builder
+	for i, param := range segments.LookupParams(tree.Name) {
+		if i != 0 {
+			paramDocBuilder.WriteString("\n")
+		}
+		fmt.Fprintf(&paramDocBuilder, "* **%s** _%s_", param.Name, param.Type)
+		if param.Required {
+			paramDocBuilder.WriteString(", required")
+		} else if param.Default != "" {
+			fmt.Fprintf(&paramDocBuilder, ", default `%s`", param.Default)
+		}
+		if param.Description != "" {
+			fmt.Fprintf(&paramDocBuilder, ": %s", param.Description)
+		}
+	}
+	return paramDocBuilder.String()
+}
+
 // TODO: use examples from Type struct https://pkg.go.dev/go/doc@master#Type
 func extractConfigStructDoc(tree *SegmentTree) string {
 	type FieldDoc struct {
@@ -266,7 +352,7 @@ func extractConfigStructDoc(tree *SegmentTree) string {
 
 		onCorrectType(field.Type, func(fieldType *ast.SelectorExpr) any { // We handle base segments manually
 			baseTextOutputSegmentFields := []FieldDoc{
-				{"File", "*os.File", "Optional output file. If not set, stdout is used."},
+				{"File", "*segments.OutputFile", "Optional output file. If not set, stdout is used."},
 			}
 			switch fieldType.Sel.Name {
 			case "BaseSegment":
@@ -207,3 +207,25 @@ func (flow *EnrichedFlow) GetPps() uint64 {
 	ppns := flow.Packets / duration
 	return ppns
 }
+
+// Extends the timestamps of a flow to span another flow, that is, the earliest
+// receive and start timestamps and the latest end timestamp of both flows, not
+// considering missing timestamps. The seconds and milliseconds timestamps are
+// derived from the resulting nanosecond timestamps.
+func (flow *EnrichedFlow) SpanTimeStamps(other *EnrichedFlow) {
+	flow.SyncMissingTimeStamps()
+	other.SyncMissingTimeStamps()
+	flow.TimeReceivedNs = earliest(flow.TimeReceivedNs, other.TimeReceivedNs)
+	flow.TimeFlowStartNs = earliest(flow.TimeFlowStartNs, other.TimeFlowStartNs)
+	flow.TimeFlowEndNs = max(flow.TimeFlowEndNs, other.TimeFlowEndNs)
+	flow.TimeReceived, flow.TimeFlowStart, flow.TimeFlowStartMs, flow.TimeFlowEnd, flow.TimeFlowEndMs = 0, 0, 0, 0, 0
+	flow.SyncMissingTimeStamps()
+}
+
+// Returns the earlier of two timestamps, ignoring missing ones.
+func earliest(a uint64, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
@@ -485,17 +485,17 @@ type EnrichedFlow struct {
 	TimeIdleMean               uint64                    `protobuf:"varint,2156,opt,name=TimeIdleMean,proto3" json:"TimeIdleMean,omitempty"`                                                                 // new
 	TimeIdleStdDev             uint64                    `protobuf:"varint,2157,opt,name=TimeIdleStdDev,proto3" json:"TimeIdleStdDev,omitempty"`                                                             // new
 	// modify/addcid
-	Cid                           uint32                      `protobuf:"varint,2000,opt,name=Cid,proto3" json:"Cid,omitempty"`            // TODO: deprecate and provide as helper?
-	CidString                     string                      `protobuf:"bytes,2001,opt,name=CidString,proto3" json:"CidString,omitempty"` // deprecated, delete for v1.0.0
-	SrcCid                        uint32                      `protobuf:"varint,2012,opt,name=SrcCid,proto3" json:"SrcCid,omitempty"`
-	DstCid                        uint32                      `protobuf:"varint,2013,opt,name=DstCid,proto3" json:"DstCid,omitempty"`
+	Cid       uint32 `protobuf:"varint,2000,opt,name=Cid,proto3" json:"Cid,omitempty"`            // TODO: deprecate and provide as helper?
+	CidString string `protobuf:"bytes,2001,opt,name=CidString,proto3" json:"CidString,omitempty"` // deprecated, delete for v1.0.0
+	SrcCid    uint32 `protobuf:"varint,2012,opt,name=SrcCid,proto3" json:"SrcCid,omitempty"`
+	DstCid    uint32 `protobuf:"varint,2013,opt,name=DstCid,proto3" json:"DstCid,omitempty"`
 	// modify/addnetid
 	NetId                         uint32                      `protobuf:"varint,2017,opt,name=NetId,proto3" json:"NetId,omitempty"`
-	NetIdString                   string                      `protobuf:"varint,2018,opt,name=NetIdString,proto3" json:"NetIdString,omitempty"`
-	SrcId                         uint32                      `protobuf:"varint,2019,opt,name=SrcId,proto3" json:"NetId,omitempty"`
-	SrcIdString                   string                      `protobuf:"varint,2020,opt,name=SrcIdString,proto3" json:"NetIdString,omitempty"`
-	DstId                         uint32                      `protobuf:"varint,2021,opt,name=DstId,proto3" json:"NetId,omitempty"`
-	DstIdString                   string                      `protobuf:"varint,2022,opt,name=DstIdString,proto3" json:"DstIdString,omitempty"`
+	NetIdString                   string                      `protobuf:"bytes,2018,opt,name=NetIdString,proto3" json:"NetIdString,omitempty"`
+	SrcId                         uint32                      `protobuf:"varint,2019,opt,name=SrcId,proto3" json:"SrcId,omitempty"`
+	SrcIdString                   string                      `protobuf:"bytes,2020,opt,name=SrcIdString,proto3" json:"SrcIdString,omitempty"`
+	DstId                         uint32                      `protobuf:"varint,2021,opt,name=DstId,proto3" json:"DstId,omitempty"`
+	DstIdString                   string                      `protobuf:"bytes,2022,opt,name=DstIdString,proto3" json:"DstIdString,omitempty"`
 	SrcAddrAnon                   EnrichedFlow_AnonymizedType `protobuf:"varint,2160,opt,name=SrcAddrAnon,proto3,enum=flowpb.EnrichedFlow_AnonymizedType" json:"SrcAddrAnon,omitempty"`
 	DstAddrAnon                   EnrichedFlow_AnonymizedType `protobuf:"varint,2161,opt,name=DstAddrAnon,proto3,enum=flowpb.EnrichedFlow_AnonymizedType" json:"DstAddrAnon,omitempty"`
 	SrcAddrPreservedLen           uint32                      `protobuf:"varint,2162,opt,name=SrcAddrPreservedLen,proto3" json:"SrcAddrPreservedLen,omitempty"`
@@ -525,6 +525,15 @@ type EnrichedFlow struct {
 	DstASName       string `protobuf:"bytes,2184,opt,name=DstASName,proto3" json:"DstASName,omitempty"`
 	NextHopASName   string `protobuf:"bytes,2185,opt,name=NextHopASName,proto3" json:"NextHopASName,omitempty"`
 	SamplerHostName string `protobuf:"bytes,2186,opt,name=SamplerHostName,proto3" json:"SamplerHostName,omitempty"`
+	// filter/dedup
+	Observers uint32 `protobuf:"varint,2190,opt,name=Observers,proto3" json:"Observers,omitempty"` // number of exporters which exported this flow
+	// filter/biflow
+	ReverseBytes    uint64 `protobuf:"varint,2191,opt,name=ReverseBytes,proto3" json:"ReverseBytes,omitempty"`       // of the reverse direction, from DstAddr to SrcAddr
+	ReversePackets  uint64 `protobuf:"varint,2192,opt,name=ReversePackets,proto3" json:"ReversePackets,omitempty"`   // of the reverse direction, from DstAddr to SrcAddr
+	ReverseTcpFlags uint32 `protobuf:"varint,2193,opt,name=ReverseTcpFlags,proto3" json:"ReverseTcpFlags,omitempty"` // of the reverse direction, from DstAddr to SrcAddr
+	// modify/addlabels
+	SrcLabels map[string]string `protobuf:"bytes,2194,rep,name=SrcLabels,proto3" json:"SrcLabels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // attributes of the source prefix, such as its site or zone
+	DstLabels map[string]string `protobuf:"bytes,2195,rep,name=DstLabels,proto3" json:"DstLabels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // attributes of the destination prefix, such as its site or zone
 	// modify/snmp
 	SrcIfName  string `protobuf:"bytes,2003,opt,name=SrcIfName,proto3" json:"SrcIfName,omitempty"`    // TODO: rename to match InIf and OutIf
 	SrcIfDesc  string `protobuf:"bytes,2004,opt,name=SrcIfDesc,proto3" json:"SrcIfDesc,omitempty"`    // TODO: rename to match InIf and OutIf
@@ -533,7 +542,9 @@ type EnrichedFlow struct {
 	DstIfDesc  string `protobuf:"bytes,2007,opt,name=DstIfDesc,proto3" json:"DstIfDesc,omitempty"`    // TODO: rename to match InIf and OutIf
 	DstIfSpeed uint32 `protobuf:"varint,2008,opt,name=DstIfSpeed,proto3" json:"DstIfSpeed,omitempty"` // TODO: rename to match InIf and OutIf
 	// general
-	Note string `protobuf:"bytes,2016,opt,name=Note,proto3" json:"Note,omitempty"` // free-form field to implement anything
+	Note   string             `protobuf:"bytes,2016,opt,name=Note,proto3" json:"Note,omitempty"`                                                                                 // free-form field to implement anything
+	Labels map[string]string  `protobuf:"bytes,2196,rep,name=Labels,proto3" json:"Labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`   // free-form attributes, such as those added by plugins
+	Values map[string]float64 `protobuf:"bytes,2197,rep,name=Values,proto3" json:"Values,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"` // free-form numeric attributes, such as those added by plugins
 	// modify/addrstrings
 	SourceIP       string `protobuf:"bytes,2290,opt,name=SourceIP,proto3" json:"SourceIP,omitempty"`
 	DestinationIP  string `protobuf:"bytes,2291,opt,name=DestinationIP,proto3" json:"DestinationIP,omitempty"`
@@ -1500,6 +1511,48 @@ func (x *EnrichedFlow) GetSamplerHostName() string {
 	return ""
 }
 
+func (x *EnrichedFlow) GetObservers() uint32 {
+	if x != nil {
+		return x.Observers
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetReverseBytes() uint64 {
+	if x != nil {
+		return x.ReverseBytes
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetReversePackets() uint64 {
+	if x != nil {
+		return x.ReversePackets
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetReverseTcpFlags() uint32 {
+	if x != nil {
+		return x.ReverseTcpFlags
+	}
+	return 0
+}
+
+func (x *EnrichedFlow) GetSrcLabels() map[string]string {
+	if x != nil {
+		return x.SrcLabels
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetDstLabels() map[string]string {
+	if x != nil {
+		return x.DstLabels
+	}
+	return nil
+}
+
 func (x *EnrichedFlow) GetSrcIfName() string {
 	if x != nil {
 		return x.SrcIfName
@@ -1549,6 +1602,20 @@ func (x *EnrichedFlow) GetNote() string {
 	return ""
 }
 
+func (x *EnrichedFlow) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetValues() map[string]float64 {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
 func (x *EnrichedFlow) GetSourceIP() string {
 	if x != nil {
 		return x.SourceIP
@@ -1630,7 +1697,7 @@ var File_pb_enrichedflow_proto protoreflect.FileDescriptor
 
 const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\n" +
-	"\x15pb/enrichedflow.proto\x12\x06flowpb\"\xfd-\n" +
+	"\x15pb/enrichedflow.proto\x12\x06flowpb\"\xb34\n" +
 	"\fEnrichedFlow\x121\n" +
 	"\x04type\x18\x01 \x01(\x0e2\x1d.flowpb.EnrichedFlow.FlowTypeR\x04type\x12#\n" +
 	"\rtime_received\x18\x02 \x01(\x04R\ftimeReceived\x12(\n" +
@@ -1747,7 +1814,13 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\x03Cid\x18\xd0\x0f \x01(\rR\x03Cid\x12\x1d\n" +
 	"\tCidString\x18\xd1\x0f \x01(\tR\tCidString\x12\x17\n" +
 	"\x06SrcCid\x18\xdc\x0f \x01(\rR\x06SrcCid\x12\x17\n" +
-	"\x06DstCid\x18\xdd\x0f \x01(\rR\x06DstCid\x12F\n" +
+	"\x06DstCid\x18\xdd\x0f \x01(\rR\x06DstCid\x12\x15\n" +
+	"\x05NetId\x18\xe1\x0f \x01(\rR\x05NetId\x12!\n" +
+	"\vNetIdString\x18\xe2\x0f \x01(\tR\vNetIdString\x12\x15\n" +
+	"\x05SrcId\x18\xe3\x0f \x01(\rR\x05SrcId\x12!\n" +
+	"\vSrcIdString\x18\xe4\x0f \x01(\tR\vSrcIdString\x12\x15\n" +
+	"\x05DstId\x18\xe5\x0f \x01(\rR\x05DstId\x12!\n" +
+	"\vDstIdString\x18\xe6\x0f \x01(\tR\vDstIdString\x12F\n" +
 	"\vSrcAddrAnon\x18\xf0\x10 \x01(\x0e2#.flowpb.EnrichedFlow.AnonymizedTypeR\vSrcAddrAnon\x12F\n" +
 	"\vDstAddrAnon\x18\xf1\x10 \x01(\x0e2#.flowpb.EnrichedFlow.AnonymizedTypeR\vDstAddrAnon\x121\n" +
 	"\x13SrcAddrPreservedLen\x18\xf2\x10 \x01(\rR\x13SrcAddrPreservedLen\x121\n" +
@@ -1776,6 +1849,12 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\tDstASName\x18\x88\x11 \x01(\tR\tDstASName\x12%\n" +
 	"\rNextHopASName\x18\x89\x11 \x01(\tR\rNextHopASName\x12)\n" +
 	"\x0fSamplerHostName\x18\x8a\x11 \x01(\tR\x0fSamplerHostName\x12\x1d\n" +
+	"\tObservers\x18\x8e\x11 \x01(\rR\tObservers\x12#\n" +
+	"\fReverseBytes\x18\x8f\x11 \x01(\x04R\fReverseBytes\x12'\n" +
+	"\x0eReversePackets\x18\x90\x11 \x01(\x04R\x0eReversePackets\x12)\n" +
+	"\x0fReverseTcpFlags\x18\x91\x11 \x01(\rR\x0fReverseTcpFlags\x12B\n" +
+	"\tSrcLabels\x18\x92\x11 \x03(\v2#.flowpb.EnrichedFlow.SrcLabelsEntryR\tSrcLabels\x12B\n" +
+	"\tDstLabels\x18\x93\x11 \x03(\v2#.flowpb.EnrichedFlow.DstLabelsEntryR\tDstLabels\x12\x1d\n" +
 	"\tSrcIfName\x18\xd3\x0f \x01(\tR\tSrcIfName\x12\x1d\n" +
 	"\tSrcIfDesc\x18\xd4\x0f \x01(\tR\tSrcIfDesc\x12\x1f\n" +
 	"\n" +
@@ -1786,7 +1865,9 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\n" +
 	"DstIfSpeed\x18\xd8\x0f \x01(\rR\n" +
 	"DstIfSpeed\x12\x13\n" +
-	"\x04Note\x18\xe0\x0f \x01(\tR\x04Note\x12\x1b\n" +
+	"\x04Note\x18\xe0\x0f \x01(\tR\x04Note\x129\n" +
+	"\x06Labels\x18\x94\x11 \x03(\v2 .flowpb.EnrichedFlow.LabelsEntryR\x06Labels\x129\n" +
+	"\x06Values\x18\x95\x11 \x03(\v2 .flowpb.EnrichedFlow.ValuesEntryR\x06Values\x12\x1b\n" +
 	"\bSourceIP\x18\xf2\x11 \x01(\tR\bSourceIP\x12%\n" +
 	"\rDestinationIP\x18\xf3\x11 \x01(\tR\rDestinationIP\x12\x1d\n" +
 	"\tNextHopIP\x18\xf4\x11 \x01(\tR\tNextHopIP\x12\x1d\n" +
@@ -1797,7 +1878,19 @@ const file_pb_enrichedflow_proto_rawDesc = "" +
 	"\rEgressVrfIDBW\x18\xec\x13 \x01(\rR\rEgressVrfIDBW\x12%\n" +
 	"\rTimeFlowStart\x18\xea\x13 \x01(\x04R\rTimeFlowStart\x12\x1f\n" +
 	"\vsrc_as_path\x18\xd7\x17 \x03(\rR\tsrcAsPath\x12\x1f\n" +
-	"\vdst_as_path\x18\xd8\x17 \x03(\rR\tdstAsPath\"]\n" +
+	"\vdst_as_path\x18\xd8\x17 \x03(\rR\tdstAsPath\x1a<\n" +
+	"\x0eSrcLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a<\n" +
+	"\x0eDstLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a9\n" +
+	"\vValuesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\"]\n" +
 	"\bFlowType\x12\x0f\n" +
 	"\vFLOWUNKNOWN\x10\x00\x12\v\n" +
 	"\aSFLOW_5\x10\x01\x12\x0e\n" +
@@ -1861,7 +1954,7 @@ func file_pb_enrichedflow_proto_rawDescGZIP() []byte {
 }
 
 var file_pb_enrichedflow_proto_enumTypes = make([]protoimpl.EnumInfo, 6)
-var file_pb_enrichedflow_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pb_enrichedflow_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_pb_enrichedflow_proto_goTypes = []any{
 	(EnrichedFlow_FlowType)(0),             // 0: flowpb.EnrichedFlow.FlowType
 	(EnrichedFlow_LayerStack)(0),           // 1: flowpb.EnrichedFlow.LayerStack
@@ -1870,22 +1963,30 @@ var file_pb_enrichedflow_proto_goTypes = []any{
 	(EnrichedFlow_NormalizedType)(0),       // 4: flowpb.EnrichedFlow.NormalizedType
 	(EnrichedFlow_RemoteAddrType)(0),       // 5: flowpb.EnrichedFlow.RemoteAddrType
 	(*EnrichedFlow)(nil),                   // 6: flowpb.EnrichedFlow
+	nil,                                    // 7: flowpb.EnrichedFlow.SrcLabelsEntry
+	nil,                                    // 8: flowpb.EnrichedFlow.DstLabelsEntry
+	nil,                                    // 9: flowpb.EnrichedFlow.LabelsEntry
+	nil,                                    // 10: flowpb.EnrichedFlow.ValuesEntry
 }
 var file_pb_enrichedflow_proto_depIdxs = []int32{
-	0, // 0: flowpb.EnrichedFlow.type:type_name -> flowpb.EnrichedFlow.FlowType
-	1, // 1: flowpb.EnrichedFlow.layer_stack:type_name -> flowpb.EnrichedFlow.LayerStack
-	2, // 2: flowpb.EnrichedFlow.SrcAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
-	2, // 3: flowpb.EnrichedFlow.DstAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
-	2, // 4: flowpb.EnrichedFlow.SamplerAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
-	2, // 5: flowpb.EnrichedFlow.NextHopAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
-	3, // 6: flowpb.EnrichedFlow.ValidationStatus:type_name -> flowpb.EnrichedFlow.ValidationStatusType
-	4, // 7: flowpb.EnrichedFlow.Normalized:type_name -> flowpb.EnrichedFlow.NormalizedType
-	5, // 8: flowpb.EnrichedFlow.RemoteAddr:type_name -> flowpb.EnrichedFlow.RemoteAddrType
-	9, // [9:9] is the sub-list for method output_type
-	9, // [9:9] is the sub-list for method input_type
-	9, // [9:9] is the sub-list for extension type_name
-	9, // [9:9] is the sub-list for extension extendee
-	0, // [0:9] is the sub-list for field type_name
+	0,  // 0: flowpb.EnrichedFlow.type:type_name -> flowpb.EnrichedFlow.FlowType
+	1,  // 1: flowpb.EnrichedFlow.layer_stack:type_name -> flowpb.EnrichedFlow.LayerStack
+	2,  // 2: flowpb.EnrichedFlow.SrcAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
+	2,  // 3: flowpb.EnrichedFlow.DstAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
+	2,  // 4: flowpb.EnrichedFlow.SamplerAddrAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
+	2,  // 5: flowpb.EnrichedFlow.NextHopAnon:type_name -> flowpb.EnrichedFlow.AnonymizedType
+	3,  // 6: flowpb.EnrichedFlow.ValidationStatus:type_name -> flowpb.EnrichedFlow.ValidationStatusType
+	4,  // 7: flowpb.EnrichedFlow.Normalized:type_name -> flowpb.EnrichedFlow.NormalizedType
+	5,  // 8: flowpb.EnrichedFlow.RemoteAddr:type_name -> flowpb.EnrichedFlow.RemoteAddrType
+	7,  // 9: flowpb.EnrichedFlow.SrcLabels:type_name -> flowpb.EnrichedFlow.SrcLabelsEntry
+	8,  // 10: flowpb.EnrichedFlow.DstLabels:type_name -> flowpb.EnrichedFlow.DstLabelsEntry
+	9,  // 11: flowpb.EnrichedFlow.Labels:type_name -> flowpb.EnrichedFlow.LabelsEntry
+	10, // 12: flowpb.EnrichedFlow.Values:type_name -> flowpb.EnrichedFlow.ValuesEntry
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_pb_enrichedflow_proto_init() }
@@ -1899,7 +2000,7 @@ func file_pb_enrichedflow_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pb_enrichedflow_proto_rawDesc), len(file_pb_enrichedflow_proto_rawDesc)),
 			NumEnums:      6,
-			NumMessages:   1,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,52 @@
+package config
+
+// A config representation of a named pipeline. Configurations may define
+// several pipelines in a single process using the top-level `pipelines` key,
+// which are connected using the publish and connect segments.
+type PipelineRepr struct {
+	Name        string        `yaml:"name"`
+	Concurrency int           `yaml:"concurrency,omitempty"` // number of instances, 0 uses the -n flag
+	Segments    []SegmentRepr `yaml:"segments"`
+}
+
+// Returns the pipeline's segments, with the group of all connect segments
+// which do not set one defaulting to the pipeline's name. Thus, all instances
+// of this pipeline share their subscriptions, while other pipelines connecting
+// to the same topic receive their own copies of all flows.
+func (p PipelineRepr) SegmentReprs() []SegmentRepr {
+	segmentReprs := make([]SegmentRepr, len(p.Segments))
+	for i, segmentRepr := range p.Segments {
+		if segmentRepr.Name == "connect" && segmentRepr.Config.Config["group"] == "" {
+			config := map[string]string{"group": p.Name}
+			for k, v := range segmentRepr.Config.Config {
+				if k != "group" {
+					config[k] = v
+				}
+			}
+			segmentRepr.Config.Config = config
+		}
+		segmentReprs[i] = segmentRepr
+	}
+	return segmentReprs
+}
+
+// Returns the topics used by all publish and connect segments in this
+// pipeline, including nested ones.
+func (p PipelineRepr) Topics() (published []string, connected []string) {
+	var walk func(segmentReprs []SegmentRepr)
+	walk = func(segmentReprs []SegmentRepr) {
+		for _, segmentRepr := range segmentReprs {
+			switch segmentRepr.Name {
+			case "publish":
+				published = append(published, segmentRepr.ExpandedConfig()["topic"])
+			case "connect":
+				connected = append(connected, segmentRepr.ExpandedConfig()["topic"])
+			}
+			for _, nested := range segmentRepr.Nested() {
+				walk(nested.Segments)
+			}
+		}
+	}
+	walk(p.Segments)
+	return published, connected
+}
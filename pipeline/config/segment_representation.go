@@ -12,8 +12,54 @@ type SegmentRepr struct {
 	Config Config `yaml:"config"`         // to be expanded by our instance
 	Jobs   int    `yaml:"jobs,omitempty"` // parallel jobs running the pipeline
 
+	Buffer   int    `yaml:"buffer,omitempty"`   // number of flows queued in front of the segment
+	Overflow string `yaml:"overflow,omitempty"` // policy for flows arriving while the buffer is full, see segments.BufferedSegment
+
 	//Adds if/then/else - not part of config for backwards compability
 	BranchOptions `yaml:",inline"`
+	//Adds the subpipelines of tee
+	TeeOptions `yaml:",inline"`
+	//Adds the cases of switch
+	SwitchOptions `yaml:",inline"`
+}
+
+// A list of segments nested in another segment's config, along with its
+// position within that segment, e.g. "then", "pipelines/archive" or "cases/2".
+type NestedSegments struct {
+	Position string
+	Segments []SegmentRepr
+}
+
+// Returns all lists of segments nested in this segment's config.
+func (s *SegmentRepr) Nested() []NestedSegments {
+	nested := []NestedSegments{{"if", s.If}, {"then", s.Then}, {"else", s.Else}}
+	for _, teePipeline := range s.Pipelines {
+		nested = append(nested, NestedSegments{"pipelines/" + teePipeline.Name, teePipeline.Segments})
+	}
+	for i, switchCase := range s.Cases {
+		nested = append(nested, NestedSegments{"cases/" + strconv.Itoa(i+1), switchCase.Segments})
+	}
+	return append(nested, NestedSegments{"default", s.Default})
+}
+
+// Returns a copy of this SegmentRepr with all nested segments removed, but
+// any other options of the nested pipelines retained.
+func (s SegmentRepr) WithoutNested() SegmentRepr {
+	s.BranchOptions = BranchOptions{}
+	pipelines := make([]TeePipeline, len(s.Pipelines))
+	for i, teePipeline := range s.Pipelines {
+		teePipeline.Segments = nil
+		pipelines[i] = teePipeline
+	}
+	s.Pipelines = pipelines
+	cases := make([]SwitchCase, len(s.Cases))
+	for i, switchCase := range s.Cases {
+		switchCase.Segments = nil
+		cases[i] = switchCase
+	}
+	s.Cases = cases
+	s.Default = nil
+	return s
 }
 
 // Returns the SegmentRepr's Config with all its variables expanded. It tries
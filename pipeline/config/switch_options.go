@@ -0,0 +1,15 @@
+package config
+
+// Extention to the segment config definition. Adding the cases of the switch
+// segment.
+type SwitchOptions struct {
+	Cases   []SwitchCase  `yaml:"cases,omitempty,flow"`
+	Default []SegmentRepr `yaml:"default,omitempty,flow"`
+}
+
+// A single case of a switch segment, consisting of a flowfilter expression and
+// the segments handling the flows matching it.
+type SwitchCase struct {
+	Case     string        `yaml:"case"`
+	Segments []SegmentRepr `yaml:"segments,omitempty,flow"`
+}
@@ -0,0 +1,16 @@
+package config
+
+// Extention to the segment config definition. Adding named subpipelines for
+// the tee segment.
+type TeeOptions struct {
+	Pipelines []TeePipeline `yaml:"pipelines,omitempty,flow"`
+}
+
+// A single named subpipeline of a tee segment. Policy and BufferSize override
+// the tee segment's config for this subpipeline only.
+type TeePipeline struct {
+	Name       string        `yaml:"name"`
+	Policy     string        `yaml:"policy,omitempty"`
+	BufferSize int           `yaml:"buffersize,omitempty"`
+	Segments   []SegmentRepr `yaml:"segments,omitempty,flow"`
+}
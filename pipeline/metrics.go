@@ -0,0 +1,239 @@
+package pipeline
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+var (
+	segmentLabels = []string{"pipeline", "instance", "position", "segment"}
+
+	flowsIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowpipeline_segment_flows_in_total",
+		Help: "Number of flows received by a segment.",
+	}, segmentLabels)
+	flowsOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowpipeline_segment_flows_out_total",
+		Help: "Number of flows passed on by a segment.",
+	}, segmentLabels)
+	flowsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowpipeline_segment_flows_dropped_total",
+		Help: "Number of flows dropped by a filter segment.",
+	}, segmentLabels)
+	overflowDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowpipeline_segment_overflow_dropped_total",
+		Help: "Number of flows dropped in front of a segment as its buffer was full.",
+	}, segmentLabels)
+	sendBlocked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowpipeline_segment_send_blocked_seconds_total",
+		Help: "Time a segment spent waiting for the next segment to accept its flows.",
+	}, segmentLabels)
+	processingTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flowpipeline_segment_processing_seconds",
+		Help:    "Time between a segment receiving a flow and passing it on.",
+		Buckets: prometheus.ExponentialBuckets(0.000001, 4, 12), // 1µs to about 4s
+	}, segmentLabels)
+
+	metricsRegistry = prometheus.NewRegistry()
+)
+
+func init() {
+	metricsRegistry.MustRegister(flowsIn, flowsOut, flowsDropped, overflowDropped, sendBlocked, processingTime,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metricsRegistry.MustRegister(segments.PrefixListMetrics...)
+}
+
+// Returns a handler serving the metrics of all instrumented Pipelines, see
+// Instrument, and of the prefix lists of segments in the Prometheus exposition
+// format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// Identifies an instrumented Pipeline in its metrics.
+type metricLabels struct {
+	pipeline string
+	instance int
+	offset   int      // number of segments preceding this Pipeline, for the tail of reloadable Pipelines
+	names    []string // the configured names of the segments, unless the Pipeline was created from segments
+}
+
+// Instrument enables metrics for all segments of this Pipeline, which are
+// labelled with the given name and instance number of the pipeline, as well
+// as each segment's position and name. Segments are named as configured, or
+// by the name their type was registered with if the Pipeline was created
+// from segments directly using New. Segments nested in other segments,
+// such as the subpipelines of a branch, are accounted to their parent
+// segment. Must be called before the Pipeline is started. Instrumenting a
+// Pipeline adds two hops between all segments, thus it is not done by
+// default.
+func (pipeline *Pipeline) Instrument(name string, instance int) {
+	pipeline.metrics = &metricLabels{pipeline: name, instance: instance}
+	for _, segmentRepr := range pipeline.segmentReprs {
+		pipeline.metrics.names = append(pipeline.metrics.names, segmentRepr.Name)
+	}
+}
+
+// Inserts a meter around each of the managed segments, which relays all flows
+// going in and out of a segment while recording its metrics.
+func (pipeline *Pipeline) instrument() {
+	for i, segment := range pipeline.managedSegments() {
+		name := segmentName(segment)
+		if i < len(pipeline.metrics.names) {
+			name = pipeline.metrics.names[i]
+		}
+		labels := prometheus.Labels{
+			"pipeline": pipeline.metrics.pipeline,
+			"instance": strconv.Itoa(pipeline.metrics.instance),
+			"position": strconv.Itoa(pipeline.metrics.offset + i + 1),
+			"segment":  name,
+		}
+		m := &meter{
+			flowsIn:     flowsIn.With(labels),
+			flowsOut:    flowsOut.With(labels),
+			sendBlocked: sendBlocked.With(labels),
+			processing:  processingTime.With(labels),
+			pending:     make(map[*pb.EnrichedFlow]time.Time),
+			previous:    make(map[*pb.EnrichedFlow]time.Time),
+			rotated:     time.Now(),
+		}
+		in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+		segment.Rewire(in, out)
+		if filter, ok := segment.(segments.FilterSegment); ok {
+			m.dropped = flowsDropped.With(labels)
+			m.drops, m.done = make(chan *pb.EnrichedFlow), make(chan struct{})
+			filter.SubscribeDrops(m.drops)
+			if drop := pipeline.drops; drop != nil {
+				m.forward.Store(&drop)
+			}
+			go m.relayDrops()
+		}
+		if buffered, ok := segment.(*segments.BufferedSegment); ok {
+			buffered.OnOverflow = overflowDropped.With(labels).Inc
+		}
+		pipeline.meters = append(pipeline.meters, m)
+		pipeline.wg.Add(2)
+		go m.relayIn(pipeline.channels[i], in, pipeline.wg)
+		go m.relayOut(out, pipeline.channels[i+1], pipeline.wg)
+	}
+}
+
+// Returns the name a segment was registered with, which is ambiguous for
+// segments registered under several names.
+func segmentName(segment segments.Segment) string {
+	if buffered, ok := segment.(*segments.BufferedSegment); ok {
+		segment = buffered.Segment()
+	}
+	if parallelized, ok := segment.(*segments.ParallelizedSegment); ok {
+		if nested := parallelized.Segments(); len(nested) > 0 {
+			segment = nested[0]
+		}
+	}
+	return segments.RegisteredName(segment)
+}
+
+// Relays the flows of a single segment and records its metrics.
+type meter struct {
+	flowsIn     prometheus.Counter
+	flowsOut    prometheus.Counter
+	dropped     prometheus.Counter // only set for filter segments
+	sendBlocked prometheus.Counter
+	processing  prometheus.Observer
+
+	drops   chan *pb.EnrichedFlow
+	forward atomic.Pointer[chan *pb.EnrichedFlow] // the Pipeline's Drop channel, if subscribed
+	done    chan struct{}                         // closed once the segment has terminated
+
+	// Flows currently held by the segment and when they were received.
+	// Flows which never leave the segment, e.g. because they were dropped,
+	// are forgotten after some time by rotating these maps.
+	mutex    sync.Mutex
+	pending  map[*pb.EnrichedFlow]time.Time
+	previous map[*pb.EnrichedFlow]time.Time
+	rotated  time.Time
+}
+
+// Flows held by a segment for longer than this are not accounted in the
+// processing time histogram.
+const meterRetention = 10 * time.Second
+
+func (m *meter) relayIn(upstream <-chan *pb.EnrichedFlow, in chan<- *pb.EnrichedFlow, wg *sync.WaitGroup) {
+	defer func() {
+		close(in)
+		wg.Done()
+	}()
+	for msg := range upstream {
+		m.flowsIn.Inc()
+		now := time.Now()
+		m.mutex.Lock()
+		if now.Sub(m.rotated) > meterRetention {
+			m.previous, m.pending = m.pending, make(map[*pb.EnrichedFlow]time.Time)
+			m.rotated = now
+		}
+		m.pending[msg] = now
+		m.mutex.Unlock()
+		in <- msg
+	}
+}
+
+func (m *meter) relayOut(out <-chan *pb.EnrichedFlow, downstream chan<- *pb.EnrichedFlow, wg *sync.WaitGroup) {
+	defer func() {
+		close(downstream)
+		if m.done != nil {
+			close(m.done)
+		}
+		wg.Done()
+	}()
+	for msg := range out {
+		m.mutex.Lock()
+		received, ok := m.pending[msg]
+		if ok {
+			delete(m.pending, msg)
+		} else if received, ok = m.previous[msg]; ok {
+			delete(m.previous, msg)
+		}
+		m.mutex.Unlock()
+		if ok {
+			m.processing.Observe(time.Since(received).Seconds())
+		}
+
+		start := time.Now()
+		downstream <- msg
+		m.sendBlocked.Add(time.Since(start).Seconds())
+		m.flowsOut.Inc()
+	}
+}
+
+// Counts the flows dropped by a filter segment and passes them on to the
+// Pipeline's Drop channel, if anyone subscribed to it. Some segments close
+// their drop channel themselves when terminating.
+func (m *meter) relayDrops() {
+	for {
+		select {
+		case msg, ok := <-m.drops:
+			if !ok {
+				return
+			}
+			m.dropped.Inc()
+			m.mutex.Lock()
+			delete(m.pending, msg)
+			delete(m.previous, msg)
+			m.mutex.Unlock()
+			if forward := m.forward.Load(); forward != nil {
+				*forward <- msg
+			}
+		case <-m.done:
+			return
+		}
+	}
+}
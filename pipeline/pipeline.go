@@ -2,11 +2,13 @@
 package pipeline
 
 import (
+	"context"
 	"sync"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/pipeline/config"
 	"github.com/BelWue/flowpipeline/segments"
 	"github.com/BelWue/flowpipeline/segments/pass"
 )
@@ -20,6 +22,15 @@ type Pipeline struct {
 	Drop        chan *pb.EnrichedFlow
 	wg          *sync.WaitGroup
 	SegmentList []segments.Segment
+
+	segmentReprs []config.SegmentRepr // the configuration this Pipeline was created from
+	junction     *junction            // only set for reloadable Pipelines, see Reload
+	ctx          context.Context      // passed to all segments, cancelled on shutdown
+	cancel       context.CancelFunc
+	channels     []chan *pb.EnrichedFlow // connecting the managed segments, starting with In
+	metrics      *metricLabels           // only set for instrumented Pipelines, see Instrument
+	meters       []*meter                // one for each managed segment of an instrumented Pipeline, set on start
+	drops        chan *pb.EnrichedFlow   // the channel all segments' drops are sent to, see subscribeDrops
 }
 
 func (pipeline *Pipeline) GetInput() chan *pb.EnrichedFlow {
@@ -35,17 +46,42 @@ func (pipeline *Pipeline) GetDrop() <-chan *pb.EnrichedFlow {
 		return pipeline.Drop
 	}
 	pipeline.Drop = make(chan *pb.EnrichedFlow)
-	// Subscribe to drops from special segments, namely all based on
-	// BaseFilterSegment grouped in the filter directory.
-	for _, segment := range pipeline.SegmentList {
+	pipeline.subscribeDrops(pipeline.Drop)
+	// If there are no filter/* segments, this channel will never have
+	// messages available.
+	return pipeline.Drop
+}
+
+// Subscribe to drops from special segments, namely all based on
+// BaseFilterSegment grouped in the filter directory.
+func (pipeline *Pipeline) subscribeDrops(drop chan *pb.EnrichedFlow) {
+	pipeline.drops = drop
+	if pipeline.junction != nil {
+		pipeline.junction.subscribeDrops(drop)
+	}
+	if pipeline.meters != nil {
+		// the segments are subscribed to their meters already
+		for _, m := range pipeline.meters {
+			m.forward.Store(&drop)
+		}
+		return
+	}
+	for _, segment := range pipeline.managedSegments() {
 		value, implementsFilter := segment.(segments.FilterSegment)
 		if implementsFilter {
-			value.SubscribeDrops(pipeline.Drop)
+			value.SubscribeDrops(drop)
 		}
 	}
-	// If there are no filter/* segments, this channel will never have
-	// messages available.
-	return pipeline.Drop
+}
+
+// Returns the segments this Pipeline starts and closes itself. For reloadable
+// Pipelines, this excludes all segments behind the junction, as those are
+// managed by the junction's nested Pipelines.
+func (pipeline *Pipeline) managedSegments() []segments.Segment {
+	if pipeline.junction != nil {
+		return pipeline.SegmentList[:1]
+	}
+	return pipeline.SegmentList
 }
 
 // Starts up a goroutine specific to this Pipeline which reads any message from
@@ -60,19 +96,39 @@ func (pipeline *Pipeline) AutoDrain() {
 	}()
 }
 
-// Closes down a Pipeline by closing its In channel and waiting for all
-// segments to propagate this close event through the full pipeline,
-// terminating all segment goroutines and thus releasing the waitgroup.
+// Closes down a Pipeline gracefully. First, the context of all segments is
+// cancelled, which stops the input segments from accepting any new flows.
+// Then, its In channel is closed and any flows still in flight are drained
+// through the full pipeline, terminating all segment goroutines and thus
+// releasing the waitgroup. Output segments flush their buffers during this
+// phase, each bounded by segments.FlushTimeout. Finally, all segments are
+// given a chance to release their resources.
 // Blocking.
 func (pipeline *Pipeline) Close() {
-	defer func() {
-		recover() // in case In is already closed
-		pipeline.wg.Wait()
+	if pipeline.cancel != nil {
+		pipeline.cancel()
+	}
+	func() {
+		defer func() {
+			recover() // in case In is already closed
+		}()
+		close(pipeline.In)
 	}()
-	for _, segment := range pipeline.SegmentList {
+	pipeline.wg.Wait()
+	for _, segment := range pipeline.managedSegments() {
 		segment.Close()
 	}
-	close(pipeline.In)
+}
+
+// Returns a channel which is closed as soon as the Pipeline is shutting down,
+// either because Close was called or because one of its segments requested
+// it using segments.ShutdownParentPipeline. In the latter case, Close should
+// be called to complete the shutdown. Never closed before Start was called.
+func (pipeline *Pipeline) Done() <-chan struct{} {
+	if pipeline.ctx == nil {
+		return nil
+	}
+	return pipeline.ctx.Done()
 }
 
 // Initializes a new Pipeline object and then starts all segment goroutines
@@ -91,13 +147,39 @@ func New(segmentList ...segments.Segment) *Pipeline {
 		channels[i+1] = make(chan *pb.EnrichedFlow)
 		segment.Rewire(channels[i], channels[i+1])
 	}
-	return &Pipeline{In: channels[0], Out: channels[len(channels)-1], wg: &sync.WaitGroup{}, SegmentList: segmentList}
+	return &Pipeline{In: channels[0], Out: channels[len(channels)-1], wg: &sync.WaitGroup{}, SegmentList: segmentList, channels: channels}
 }
 
 // Starts the Pipeline by starting all segment goroutines therein.
 func (pipeline *Pipeline) Start() {
-	for _, segment := range pipeline.SegmentList {
+	pipeline.StartContext(context.Background())
+}
+
+// Marks the context of segments running in a Pipeline.
+type nestedKey struct{}
+
+// Starts the Pipeline like Start, but derives the context passed to all
+// segments from ctx. Pipelines nested in a segment should be started using
+// the context that segment was run with, so that shutdown requests from
+// nested segments reach the outermost Pipeline. Otherwise, shutdown requests
+// only shut down this Pipeline, regardless of any shutdown function ctx
+// carries.
+func (pipeline *Pipeline) StartContext(ctx context.Context) {
+	pipeline.ctx, pipeline.cancel = context.WithCancel(ctx)
+	ctx = pipeline.ctx
+	if ctx.Value(nestedKey{}) == nil {
+		ctx = context.WithValue(segments.WithShutdown(ctx, pipeline.cancel), nestedKey{}, true)
+	}
+	if pipeline.metrics != nil {
+		pipeline.instrument()
+	}
+	for _, segment := range pipeline.managedSegments() {
+		pipeline.wg.Add(1)
+		go segment.Run(ctx, pipeline.wg)
+	}
+	if pipeline.junction != nil {
+		pipeline.junction.start(ctx)
 		pipeline.wg.Add(1)
-		go segment.Run(pipeline.wg)
+		go pipeline.junction.run(pipeline.wg)
 	}
 }
@@ -1,68 +1,155 @@
 package pipeline
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/BelWue/flowpipeline/pipeline/config"
 	"github.com/BelWue/flowpipeline/segments"
-	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v2"
 )
 
+// Returned if a segment's New method returned nil. The segment itself logs
+// the reason.
+var ErrSegmentInit = errors.New("segment could not be initialized, see previous log messages")
+
+// Describes why a segment from a configuration could not be created. Errors
+// in segments nested in a branch are wrapped by a SegmentError for the
+// branch segment.
+type SegmentError struct {
+	Index int    // position of the segment in its list of segments, starting at 0
+	Name  string // name of the segment as configured
+	Param string // name of the offending config parameter, if any
+	Err   error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("segment %d (%s): %v", e.Index+1, e.Name, e.Err)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
 // Builds a list of Segment objects from raw configuration bytes and
-// initializes a Pipeline with them.
-func NewFromConfig(config []byte) *Pipeline {
+// initializes a Pipeline with them. Pipelines created this way can be
+// reconfigured at runtime using Reload. All problems found in the
+// configuration are returned joined in a single error, with each segment's
+// problems being a *SegmentError.
+func NewFromConfig(config []byte) (*Pipeline, error) {
 	// parse a list of SegmentReprs from yaml
-	segmentReprs := SegmentReprsFromConfig(config)
-
-	// build segments from it
-	segments := SegmentsFromRepr(segmentReprs)
+	segmentReprs, err := SegmentReprsFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
 
-	// we have Segments parsed and ready, instantiate them as actual pipeline
-	return New(segments...)
+	// build segments from it and instantiate them as actual pipeline
+	return newReloadable(segmentReprs)
 }
 
 // SegmentReprsFromConfig returns a list of segment representation objects from a config.
-func SegmentReprsFromConfig(configFile []byte) []config.SegmentRepr {
+func SegmentReprsFromConfig(configFile []byte) ([]config.SegmentRepr, error) {
 	// parse a list of SegmentReprs from yaml
 	segmentReprs := []config.SegmentRepr{}
-
-	err := yaml.Unmarshal(configFile, &segmentReprs)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error parsing configuration YAML: ")
+	if err := yaml.Unmarshal(configFile, &segmentReprs); err != nil {
+		return nil, fmt.Errorf("pipeline: error parsing configuration YAML: %w", err)
 	}
-
-	return segmentReprs
+	return segmentReprs, nil
 }
 
 // Creates a list of Segments from their config representations. Handles
-// recursive definitions found in Segments.
-func SegmentsFromRepr(segmentReprs []config.SegmentRepr) []segments.Segment {
+// recursive definitions found in Segments. Errors for all segments which
+// could not be created are joined together, see NewFromConfig.
+func SegmentsFromRepr(segmentReprs []config.SegmentRepr) ([]segments.Segment, error) {
+	return segmentsFromRepr(segmentReprs, 0)
+}
+
+// Creates Segments from a sublist of the configured segments, which starts at
+// the given offset, so that errors refer to the correct position.
+func segmentsFromRepr(segmentReprs []config.SegmentRepr, offset int) ([]segments.Segment, error) {
 	segmentList := make([]segments.Segment, len(segmentReprs))
+	var errs []error
 	for i, segmentrepr := range segmentReprs {
-		segmentTemplate := segments.LookupSegment(segmentrepr.Name) // a typed nil instance
+		segmentTemplate, err := segments.LookupSegment(segmentrepr.Name) // a typed nil instance
+		if err != nil {
+			errs = append(errs, &SegmentError{Index: offset + i, Name: segmentrepr.Name, Err: err})
+			continue
+		}
+
+		if err := checkBuffer(segmentrepr); err != nil {
+			errs = append(errs, &SegmentError{Index: offset + i, Name: segmentrepr.Name, Err: err})
+			continue
+		}
 
 		if segmentrepr.Jobs <= 1 {
-			segmentList[i] = segmentFromTemplate(segmentTemplate, segmentrepr)
+			segment, segmentErrs := segmentFromTemplate(segmentTemplate, segmentrepr, offset+i)
+			segmentList[i] = segment
+			errs = append(errs, segmentErrs...)
 		} else {
 			wrapper := &segments.ParallelizedSegment{}
 			for range segmentrepr.Jobs {
-				segment := segmentFromTemplate(segmentTemplate, segmentrepr)
-				if segment != nil {
-					wrapper.AddSegment(segment)
-				} else {
-					log.Fatal().Msgf("Configured segment '%s' could not be initialized properly, see previous messages.", segmentrepr.Name)
+				segment, segmentErrs := segmentFromTemplate(segmentTemplate, segmentrepr, offset+i)
+				if len(segmentErrs) > 0 {
+					errs = append(errs, segmentErrs...)
+					break
 				}
+				wrapper.AddSegment(segment)
 			}
 			segmentList[i] = wrapper
 		}
+		if segmentList[i] != nil && (segmentrepr.Buffer > 0 || segmentrepr.Overflow != "") {
+			segmentList[i] = segments.NewBufferedSegment(segmentList[i], segmentrepr.Buffer, segmentrepr.Overflow)
+		}
+	}
+	if len(errs) > 0 {
+		// release anything the segments created so far have acquired
+		for _, segment := range segmentList {
+			if segment != nil {
+				segment.Close()
+			}
+		}
+		return nil, errors.Join(errs...)
+	}
+	return segmentList, nil
+}
+
+// Checks the buffer and overflow options of a segment.
+func checkBuffer(segmentrepr config.SegmentRepr) error {
+	if segmentrepr.Buffer < 0 {
+		return errors.New("negative buffer")
+	}
+	if err := segments.CheckOverflow(segmentrepr.Overflow); err != nil {
+		return err
+	}
+	if segmentrepr.Overflow != "" && segmentrepr.Overflow != segments.OverflowBlock && segmentrepr.Buffer == 0 {
+		return fmt.Errorf("overflow policy '%s' requires a buffer", segmentrepr.Overflow)
 	}
-	return segmentList
+	return nil
 }
 
-func segmentFromTemplate(segmentTemplate segments.Segment, segmentrepr config.SegmentRepr) segments.Segment {
+func segmentFromTemplate(segmentTemplate segments.Segment, segmentrepr config.SegmentRepr, index int) (segments.Segment, []error) {
+	// check the config against the declared parameters and fill in defaults
+	segmentConfig, paramErrs := segments.ApplyParams(segmentrepr.Name, segmentrepr.ExpandedConfig())
+	if len(paramErrs) > 0 {
+		errs := make([]error, len(paramErrs))
+		for i, err := range paramErrs {
+			segmentErr := &SegmentError{Index: index, Name: segmentrepr.Name, Err: err}
+			var paramErr *segments.ParamError
+			if errors.As(err, &paramErr) {
+				segmentErr.Param = paramErr.Param
+			}
+			errs[i] = segmentErr
+		}
+		return nil, errs
+	}
 	// the Segment's New method knows how to handle our config
-	segment := segmentTemplate.New(segmentrepr.ExpandedConfig())
-	if segment != nil {
-		segment.AddCustomConfig(segmentrepr)
+	segment := segmentTemplate.New(segmentConfig)
+	if segment == nil {
+		return nil, []error{&SegmentError{Index: index, Name: segmentrepr.Name, Err: ErrSegmentInit}}
+	}
+	if err := segment.AddCustomConfig(segmentrepr); err != nil {
+		segment.Close()
+		return nil, []error{&SegmentError{Index: index, Name: segmentrepr.Name, Err: err}}
 	}
-	return segment
+	return segment, nil
 }
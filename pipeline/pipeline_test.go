@@ -1,11 +1,23 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
+	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
 	"github.com/BelWue/flowpipeline/segments/pass"
+
+	_ "github.com/BelWue/flowpipeline/segments/filter/drop"
 )
 
 func TestPipelineBuild(t *testing.T) {
@@ -36,16 +48,566 @@ func TestPipelineTeardown(t *testing.T) {
 	pipeline.Close() // fail test on halting ;)
 }
 
+// Shuts down its parent pipeline once it has seen a flow.
+type shutdownTest struct {
+	segments.BaseSegment
+}
+
+func (segment shutdownTest) New(config map[string]string) segments.Segment {
+	return &shutdownTest{}
+}
+
+func (segment *shutdownTest) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	for msg := range segment.In {
+		segments.ShutdownParentPipeline(ctx)
+		segment.Out <- msg
+	}
+}
+
+func TestPipelineShutdown(t *testing.T) {
+	pipeline := New(&shutdownTest{}, &pass.Pass{})
+	pipeline.Start()
+	go func() {
+		pipeline.In <- &pb.EnrichedFlow{Type: 3}
+	}()
+	select {
+	case <-pipeline.Done():
+	case <-time.After(time.Second):
+		t.Fatal("([error] Segment could not shut down its pipeline.")
+	}
+	// the flow is still in flight and has to be drained by Close
+	done := make(chan struct{})
+	go func() {
+		pipeline.Close()
+		close(done)
+	}()
+	if fmsg := <-pipeline.Out; fmsg.Type != 3 {
+		t.Error("([error] Pipeline did not drain flows on shutdown.")
+	}
+	<-done
+}
+
+func TestPipelineShutdownOnlyItself(t *testing.T) {
+	outer := false
+	ctx := segments.WithShutdown(context.Background(), func() { outer = true })
+	pipeline, other := New(&pass.Pass{}, &shutdownTest{}), New(&pass.Pass{})
+	pipeline.StartContext(ctx)
+	other.StartContext(ctx)
+	go func() {
+		pipeline.In <- &pb.EnrichedFlow{Type: 3}
+		<-pipeline.Out
+	}()
+	select {
+	case <-pipeline.Done():
+	case <-time.After(time.Second):
+		t.Fatal("([error] Segment could not shut down its pipeline.")
+	}
+	select {
+	case <-other.Done():
+		t.Error("([error] Segment shut down another pipeline started with the same context.")
+	default:
+	}
+	if outer {
+		t.Error("([error] Segment called the shutdown function of the context its pipeline was started with.")
+	}
+	pipeline.AutoDrain()
+	pipeline.Close()
+	other.AutoDrain()
+	other.Close()
+}
+
 func TestPipelineConfigSuccess(t *testing.T) {
-	pipeline := NewFromConfig([]byte(`---
-- segment: pass
+	pipeline, err := NewFromConfig([]byte(`---
+- segment: namedtest
   config:
-    foo: $baz
-    bar: $0`))
+    name: $baz
+    value: $0`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	pipeline.In <- &pb.EnrichedFlow{Type: 3}
+	fmsg := <-pipeline.Out
+	if fmsg.Type != 3 {
+		t.Error("([error] Pipeline built from config is not working.")
+	}
+}
+
+func TestPipelineReload(t *testing.T) {
+	pipeline, err := NewFromConfig([]byte(`---
+- segment: pass
+- segment: pass`))
+	if err != nil {
+		t.Fatal(err)
+	}
 	pipeline.Start()
+	drops := pipeline.GetDrop()
 	pipeline.In <- &pb.EnrichedFlow{Type: 3}
 	fmsg := <-pipeline.Out
 	if fmsg.Type != 3 {
 		t.Error("([error] Pipeline built from config is not working.")
 	}
+
+	err = pipeline.Reload([]byte(`---
+- segment: pass
+- segment: drop`))
+	if err != nil {
+		t.Errorf("([error] Pipeline reload failed: %v", err)
+	}
+	pipeline.In <- &pb.EnrichedFlow{Type: 3}
+	fmsg = <-drops
+	if fmsg.Type != 3 {
+		t.Error("([error] Reloaded segments are not working.")
+	}
+
+	if err := pipeline.Reload([]byte(`---
+- segment: pass
+- segment: nonexistent`)); err == nil {
+		t.Error("([error] Pipeline reload with unknown segment did not fail.")
+	}
+	if err := pipeline.Reload([]byte(`---
+- segment: drop`)); !errors.Is(err, ErrInputChanged) {
+		t.Errorf("([error] Pipeline reload with changed input returned %v.", err)
+	}
+
+	pipeline.AutoDrain()
+	pipeline.Close()
+	if err := pipeline.Reload([]byte(`---
+- segment: pass`)); err == nil {
+		t.Error("([error] Reloading a closed pipeline did not fail.")
+	}
+}
+
+func TestPipelinePrepareReload(t *testing.T) {
+	pipeline, err := NewFromConfig([]byte(`---
+- segment: pass
+- segment: pass`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	segmentReprs, err := SegmentReprsFromConfig([]byte(`---
+- segment: pass
+- segment: drop`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reload, err := pipeline.PrepareReload(segmentReprs)
+	if err != nil {
+		t.Fatalf("([error] Preparing a pipeline reload failed: %v", err)
+	}
+	reload.Discard()
+	pipeline.In <- &pb.EnrichedFlow{Type: 3}
+	if fmsg := <-pipeline.Out; fmsg.Type != 3 {
+		t.Error("([error] Discarding a prepared reload changed the pipeline.")
+	}
+
+	reload, err = pipeline.PrepareReload(segmentReprs)
+	if err != nil {
+		t.Fatalf("([error] Preparing a pipeline reload failed: %v", err)
+	}
+	if err := pipeline.ReloadSegmentReprs(segmentReprs[:1]); err != nil {
+		t.Fatalf("([error] Pipeline reload failed: %v", err)
+	}
+	if err := reload.Apply(); err == nil {
+		t.Error("([error] Applying a reload prepared before another reload did not fail.")
+	}
+
+	pipeline.AutoDrain()
+	pipeline.Close()
+}
+
+func TestPipelineReloadKeepsSegments(t *testing.T) {
+	pipeline, err := NewFromConfig([]byte(`---
+- segment: pass
+- segment: namedtest
+  config:
+    name: first
+- segment: namedtest
+  config:
+    name: second
+- segment: namedtest
+  config:
+    name: third`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	previous := pipeline.SegmentList
+	if err := pipeline.Reload([]byte(`---
+- segment: pass
+- segment: namedtest
+  config:
+    name: first
+- segment: drop
+- segment: namedtest
+  config:
+    name: third`)); err != nil {
+		t.Fatalf("([error] Pipeline reload failed: %v", err)
+	}
+	if len(pipeline.SegmentList) != 4 {
+		t.Fatalf("([error] Reloaded pipeline has %d segments, should be 4.", len(pipeline.SegmentList))
+	}
+	for _, i := range []int{0, 1, 3} {
+		if pipeline.SegmentList[i] != previous[i] {
+			t.Errorf("([error] Pipeline reload replaced the unchanged segment at position %d.", i+1)
+		}
+	}
+	if pipeline.SegmentList[2] == previous[2] {
+		t.Error("([error] Pipeline reload did not replace the changed segment.")
+	}
+
+	// inserting and removing segments keeps the segments around them
+	previous = pipeline.SegmentList
+	if err := pipeline.Reload([]byte(`---
+- segment: pass
+- segment: namedtest
+  config:
+    name: first
+- segment: namedtest
+  config:
+    name: third`)); err != nil {
+		t.Fatalf("([error] Pipeline reload failed: %v", err)
+	}
+	if len(pipeline.SegmentList) != 3 || pipeline.SegmentList[1] != previous[1] || pipeline.SegmentList[2] != previous[3] {
+		t.Error("([error] Pipeline reload removing a segment replaced the remaining ones.")
+	}
+	pipeline.In <- &pb.EnrichedFlow{Type: 3}
+	if fmsg := <-pipeline.Out; fmsg.Type != 3 {
+		t.Error("([error] Reloaded pipeline is not working.")
+	}
+
+	pipeline.AutoDrain()
+	pipeline.Close()
+}
+
+// A distinct type, as segments are named by their type, e.g. in metrics.
+type validateTest struct {
+	pass.Pass
+}
+
+// A distinct type accepting parameters, used to tell segments passing on all
+// flows apart in configurations.
+type namedTest struct {
+	pass.Pass
+}
+
+// Receives all log messages of the tests, set up before any pipeline runs.
+var validator = &Validator{}
+
+func init() {
+	log.Logger = log.Output(zerolog.MultiLevelWriter(os.Stderr, validator)).Hook(validator)
+	segments.RegisterSegment("namedtest", &namedTest{},
+		segments.Param{Name: "name"},
+		segments.Param{Name: "value"},
+	)
+	segments.RegisterSegment("validatetest", &validateTest{},
+		segments.Param{Name: "number", Type: segments.Int},
+		segments.Param{Name: "file", Type: segments.File},
+		segments.Param{Name: "name", Required: true},
+		segments.Param{Name: "greeting", Default: "hello"},
+	)
+}
+
+func (segment *validateTest) New(config map[string]string) segments.Segment {
+	if !segments.DryRun(config) {
+		log.Error().Msg("ValidateTest: Created outside of a dry run.")
+	}
+	if config["greeting"] != "hello" {
+		log.Warn().Err(errors.New(config["greeting"])).Msg("ValidateTest: Unusual greeting: ")
+	}
+	return &validateTest{}
+}
+
+func TestValidate(t *testing.T) {
+	problems := validator.Validate([]byte(`---
+- segment: validatetest
+  config:
+    number: 42
+    file: pipeline_test.go
+    name: foo
+    greeting: hi
+- segment: validatetest
+  config:
+    number: many
+    file: nonexistent.csv
+    typo: foo
+- segment: pass
+  if:
+  - segment: nonexistent`))
+	expected := []string{
+		"warning: segment 1 (validatetest): ValidateTest: Unusual greeting (hi)",
+		"error: segment 2 (validatetest): config parameter 'file': bad value 'nonexistent.csv': stat nonexistent.csv: no such file or directory",
+		"error: segment 2 (validatetest): config parameter 'number': bad value 'many': strconv.ParseInt: parsing \"many\": invalid syntax",
+		"error: segment 2 (validatetest): config parameter 'typo': not supported by this segment",
+		"error: segment 2 (validatetest): config parameter 'name': required, but not set",
+		"error: segment 3/if/1 (nonexistent): unknown segment",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("([error] Validation found %d problems, expected %d: %v", len(problems), len(expected), problems)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("([error] Validation found '%s', expected '%s'.", problem, expected[i])
+		}
+	}
+
+	problems = Validate([]byte(`- segment: [pass`))
+	if len(problems) != 1 || problems[0].Position != "" {
+		t.Errorf("([error] Validation of broken YAML returned %v.", problems)
+	}
+
+	problems = Validate([]byte(`---
+- segment: validatetest
+  config:
+    name: foo
+    greeting: hi`))
+	if len(problems) != 0 {
+		t.Errorf("([error] Validation without a Validator reported logged messages: %v", problems)
+	}
+}
+
+func TestApplyParams(t *testing.T) {
+	config, errs := segments.ApplyParams("validatetest", map[string]string{"name": "foo"})
+	if len(errs) != 0 || config["greeting"] != "hello" || config["name"] != "foo" {
+		t.Errorf("([error] Applying params returned %v, %v.", config, errs)
+	}
+	_, errs = segments.ApplyParams("validatetest", map[string]string{"nmae": "foo"})
+	if len(errs) != 2 || !errors.Is(errs[0], segments.ErrUnknownParam) || !errors.Is(errs[1], segments.ErrMissingParam) {
+		t.Errorf("([error] Applying bad params returned %v.", errs)
+	}
+	_, errs = segments.ApplyParams("pass", map[string]string{"name": "foo"})
+	if len(errs) != 1 || !errors.Is(errs[0], segments.ErrUnknownParam) {
+		t.Errorf("([error] Applying params to a segment without any returned %v.", errs)
+	}
+}
+
+func TestPipelineConfigErrors(t *testing.T) {
+	_, err := NewFromConfig([]byte(`---
+- segment: pass
+- segment: validatetest
+  config:
+    name: foo
+    number: many
+- segment: nonexistent`))
+	var segmentErr *SegmentError
+	if !errors.As(err, &segmentErr) || segmentErr.Index != 1 || segmentErr.Name != "validatetest" || segmentErr.Param != "number" {
+		t.Errorf("([error] Building a pipeline from a broken config returned %v.", err)
+	}
+	if !errors.Is(err, segments.ErrUnknownSegment) {
+		t.Errorf("([error] Building a pipeline with an unknown segment returned %v.", err)
+	}
+
+	_, err = NewFromConfig([]byte(`- segment: [pass`))
+	if err == nil {
+		t.Error("([error] Building a pipeline from broken YAML did not fail.")
+	}
+}
+
+func TestPipelineReprs(t *testing.T) {
+	pipelineReprs, err := PipelineReprsFromConfig([]byte(`---
+- segment: pass`))
+	if err != nil || len(pipelineReprs) != 1 || pipelineReprs[0].Name != "" || len(pipelineReprs[0].Segments) != 1 {
+		t.Errorf("([error] Parsing a single pipeline returned %v, %v.", pipelineReprs, err)
+	}
+
+	pipelineReprs, err = PipelineReprsFromConfig([]byte(`---
+pipelines:
+- name: alerting
+  segments:
+  - segment: connect
+    config:
+      topic: flows
+- name: storage
+  concurrency: 4
+  segments:
+  - segment: connect
+    config:
+      topic: flows
+- name: ingest
+  segments:
+  - segment: pass
+    then:
+    - segment: publish
+      config:
+        topic: flows`))
+	if err != nil || len(pipelineReprs) != 3 || pipelineReprs[1].Concurrency != 4 {
+		t.Fatalf("([error] Parsing named pipelines returned %v, %v.", pipelineReprs, err)
+	}
+	if group := pipelineReprs[1].SegmentReprs()[0].Config.Config["group"]; group != "storage" {
+		t.Errorf("([error] Connect segment defaults to group '%s', should be 'storage'.", group)
+	}
+	ordered, err := ShutdownOrder(pipelineReprs)
+	if err != nil || ordered[0].Name != "ingest" || ordered[1].Name != "alerting" || ordered[2].Name != "storage" {
+		t.Errorf("([error] Shutdown order is %v, %v, should start with the publishing pipeline.", ordered, err)
+	}
+
+	pipelineReprs[0].Segments = append(pipelineReprs[0].Segments, pipelineReprs[2].Segments...)
+	if _, err := ShutdownOrder(pipelineReprs); err == nil {
+		t.Error("([error] Pipelines connected in a cycle were accepted.")
+	}
+
+	for _, config := range []string{`pipelines: []`, `---
+pipelines:
+- segments:
+  - segment: pass`, `---
+pipelines:
+- name: a
+- name: a`, `---
+pipelines:
+- name: a
+  concurrency: -1`, `---
+pipelines:
+- name: a
+  sgments: []`} {
+		if _, err := PipelineReprsFromConfig([]byte(config)); err == nil {
+			t.Errorf("([error] Invalid pipelines were accepted:\n%s", config)
+		}
+	}
+}
+
+func init() {
+	// registers pass a second time, making the name of its type ambiguous
+	segments.RegisterSegment("metricstest", &pass.Pass{})
+}
+
+func TestPipelineMetrics(t *testing.T) {
+	for _, metric := range []interface{ Reset() }{flowsIn, flowsOut, flowsDropped, sendBlocked, processingTime} {
+		metric.Reset()
+	}
+	pipeline, err := NewFromConfig([]byte(`---
+- segment: metricstest
+- segment: pass
+- segment: drop`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Instrument("metricstest", 0)
+	drops := pipeline.GetDrop()
+	pipeline.Start()
+	pipeline.In <- &pb.EnrichedFlow{Proto: 6}
+	select {
+	case <-drops:
+	case <-time.After(time.Second):
+		t.Fatal("([error] Instrumented pipeline did not pass on a dropped flow.")
+	}
+	pipeline.Close()
+
+	labels := func(position string, segment string) prometheus.Labels {
+		return prometheus.Labels{"pipeline": "metricstest", "instance": "0", "position": position, "segment": segment}
+	}
+	for _, metric := range []struct {
+		name     string
+		counter  prometheus.Counter
+		expected float64
+	}{
+		{"flows in of metricstest", flowsIn.With(labels("1", "metricstest")), 1},
+		{"flows in of pass", flowsIn.With(labels("2", "pass")), 1},
+		{"flows out of pass", flowsOut.With(labels("2", "pass")), 1},
+		{"flows in of drop", flowsIn.With(labels("3", "drop")), 1},
+		{"flows out of drop", flowsOut.With(labels("3", "drop")), 0},
+		{"drops of drop", flowsDropped.With(labels("3", "drop")), 1},
+	} {
+		if value := testutil.ToFloat64(metric.counter); value != metric.expected {
+			t.Errorf("([error] Metric %s is %f, should be %f.", metric.name, value, metric.expected)
+		}
+	}
+	if count := testutil.CollectAndCount(processingTime, "flowpipeline_segment_processing_seconds"); count != 3 {
+		t.Errorf("([error] Processing time was recorded for %d segments, should be 3.", count)
+	}
+}
+
+// Holds back all flows until the gate channel is closed.
+type gateTest struct {
+	segments.BaseSegment
+}
+
+var gate chan struct{}
+
+func (segment gateTest) New(config map[string]string) segments.Segment {
+	return &gateTest{}
+}
+
+func (segment *gateTest) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	for msg := range segment.In {
+		<-gate
+		segment.Out <- msg
+	}
+}
+
+func init() {
+	segments.RegisterSegment("gatetest", &gateTest{})
+}
+
+func TestPipelineBuffer(t *testing.T) {
+	for _, overflow := range []string{"drop-newest", "drop-oldest", "spill"} {
+		gate = make(chan struct{})
+		pipeline, err := NewFromConfig([]byte(`---
+- segment: pass
+- segment: gatetest
+  buffer: 2
+  overflow: ` + overflow))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipeline.Start()
+		// the segment does not accept any flows, this must not block
+		for i := 1; i <= 10; i++ {
+			pipeline.In <- &pb.EnrichedFlow{Proto: uint32(i)}
+		}
+		close(gate)
+		go pipeline.Close()
+
+		var received []uint32
+		for fmsg := range pipeline.Out {
+			received = append(received, fmsg.Proto)
+		}
+		dropped := pipeline.SegmentList[1].(*segments.BufferedSegment).Dropped()
+		if len(received)+int(dropped) != 10 {
+			t.Errorf("([error] Overflow %s passed %d flows and dropped %d, should be 10 in total.", overflow, len(received), dropped)
+		}
+		for i := 1; i < len(received); i++ {
+			if received[i] <= received[i-1] {
+				t.Errorf("([error] Overflow %s reordered flows: %v", overflow, received)
+				break
+			}
+		}
+		// flows sent after the gate opened may pass, regardless of the policy
+		switch overflow {
+		case "drop-newest":
+			if dropped == 0 || received[0] != 1 {
+				t.Errorf("([error] Overflow %s passed %v, should pass the first flows only.", overflow, received)
+			}
+		case "drop-oldest":
+			if dropped == 0 || received[len(received)-1] != 10 {
+				t.Errorf("([error] Overflow %s passed %v, should pass the last flows.", overflow, received)
+			}
+		case "spill":
+			if dropped != 0 {
+				t.Errorf("([error] Overflow %s dropped %d flows.", overflow, dropped)
+			}
+		}
+	}
+
+	for _, config := range []string{`---
+- segment: pass
+  overflow: spill`, `---
+- segment: pass
+  buffer: 10
+  overflow: sometimes`, `---
+- segment: pass
+  buffer: -1`} {
+		if _, err := NewFromConfig([]byte(config)); err == nil {
+			t.Errorf("([error] Invalid buffer config was accepted:\n%s", config)
+		}
+	}
 }
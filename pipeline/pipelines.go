@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/BelWue/flowpipeline/pipeline/config"
+)
+
+// Returns the pipelines defined in a configuration. A configuration is either
+// a plain list of segments, which defines a single pipeline without a name,
+// or a mapping whose `pipelines` key contains a list of named pipelines:
+//
+//	pipelines:
+//	- name: ingest
+//	  segments:
+//	  - segment: goflow
+//	  - segment: publish
+//	    config:
+//	      topic: flows
+//	- name: storage
+//	  concurrency: 4
+//	  segments:
+//	  - segment: connect
+//	    config:
+//	      topic: flows
+//	  - segment: sqlite
+//	    config:
+//	      filename: flows.sqlite
+func PipelineReprsFromConfig(configFile []byte) ([]config.PipelineRepr, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(configFile, &document); err != nil {
+		return nil, fmt.Errorf("pipeline: error parsing configuration YAML: %w", err)
+	}
+	if _, ok := document.(map[interface{}]interface{}); !ok {
+		segmentReprs, err := SegmentReprsFromConfig(configFile)
+		if err != nil {
+			return nil, err
+		}
+		return []config.PipelineRepr{{Segments: segmentReprs}}, nil
+	}
+
+	var pipelines struct {
+		Pipelines []config.PipelineRepr `yaml:"pipelines"`
+	}
+	if err := yaml.UnmarshalStrict(configFile, &pipelines); err != nil {
+		return nil, fmt.Errorf("pipeline: error parsing configuration YAML: %w", err)
+	}
+	if len(pipelines.Pipelines) == 0 {
+		return nil, errors.New("pipeline: no pipelines configured in 'pipelines'")
+	}
+	var errs []error
+	names := make(map[string]bool)
+	for i, pipelineRepr := range pipelines.Pipelines {
+		switch {
+		case pipelineRepr.Name == "":
+			errs = append(errs, fmt.Errorf("pipeline: pipeline %d needs a 'name'", i+1))
+		case names[pipelineRepr.Name]:
+			errs = append(errs, fmt.Errorf("pipeline: duplicate pipeline name '%s'", pipelineRepr.Name))
+		}
+		names[pipelineRepr.Name] = true
+		if pipelineRepr.Concurrency < 0 {
+			errs = append(errs, fmt.Errorf("pipeline '%s': negative concurrency", pipelineRepr.Name))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return pipelines.Pipelines, nil
+}
+
+// Builds a reloadable Pipeline like NewFromConfig from a single pipeline of a
+// configuration. Each call creates another instance of this pipeline.
+func NewFromPipelineRepr(pipelineRepr config.PipelineRepr) (*Pipeline, error) {
+	return newReloadable(pipelineRepr.SegmentReprs())
+}
+
+// Returns the pipelines in the order they should be shut down in, which is
+// the order flows pass between them: every pipeline publishing to a topic
+// comes before all pipelines connecting to that topic, so that these can
+// process all flows in flight before being shut down themselves. Pipelines
+// are otherwise kept in their configured order. An error is returned if
+// pipelines are connected in a cycle, as these could not be shut down without
+// losing flows.
+func ShutdownOrder(pipelineReprs []config.PipelineRepr) ([]config.PipelineRepr, error) {
+	publishers := make(map[string][]int) // topic to the indices of the pipelines publishing to it
+	connected := make([][]string, len(pipelineReprs))
+	for i, pipelineRepr := range pipelineReprs {
+		var published []string
+		published, connected[i] = pipelineRepr.Topics()
+		for _, topic := range published {
+			publishers[topic] = append(publishers[topic], i)
+		}
+	}
+
+	var ordered []config.PipelineRepr
+	done := make([]bool, len(pipelineReprs))
+	// whether any pipeline publishing to one of the topics is still running
+	waiting := func(topics []string) bool {
+		for _, topic := range topics {
+			if slices.ContainsFunc(publishers[topic], func(j int) bool { return !done[j] }) {
+				return true
+			}
+		}
+		return false
+	}
+	for len(ordered) < len(pipelineReprs) {
+		progress := false
+		for i, pipelineRepr := range pipelineReprs {
+			if done[i] || waiting(connected[i]) {
+				continue
+			}
+			ordered = append(ordered, pipelineRepr)
+			done[i] = true
+			progress = true
+		}
+		if !progress {
+			var cycle []string
+			for i, pipelineRepr := range pipelineReprs {
+				if !done[i] {
+					cycle = append(cycle, fmt.Sprintf("'%s'", pipelineRepr.Name))
+				}
+			}
+			return nil, fmt.Errorf("pipeline: pipelines %s are connected in a cycle", strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,410 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/pipeline/config"
+	"github.com/BelWue/flowpipeline/segments"
+	"github.com/BelWue/flowpipeline/segments/pass"
+)
+
+// Returned by Reload if the first segment of the new configuration differs
+// from the running one. Replacing the input segment requires closing this
+// Pipeline and creating a new one.
+var ErrInputChanged = errors.New("pipeline: configuration of the first segment has changed")
+
+// A junction sits between the first segment of a reloadable Pipeline and all
+// remaining segments, the tail. Each segment of the tail runs in a nested
+// Pipeline of its own, a stage, and valves forward flows from the first segment
+// through all stages to the Out channel of the outer Pipeline. Any consecutive
+// stages can be exchanged at any time without losing flows, as the valve in
+// front of them stops accepting flows while they are drained, and the valve
+// behind them waits for the new stages.
+type junction struct {
+	in     <-chan *pb.EnrichedFlow
+	out    chan *pb.EnrichedFlow
+	parent *Pipeline
+	ctx    context.Context // passed to all stages, set on start
+
+	mutex    sync.Mutex // guards all of the following against concurrent reloads
+	stages   []*stage
+	valves   []*valve // valves[i] feeds stages[i], the last one feeds out
+	drops    chan *pb.EnrichedFlow
+	finished bool // whether all stages have terminated
+}
+
+// A single segment of the tail of a reloadable Pipeline.
+type stage struct {
+	repr     config.SegmentRepr
+	pipeline *Pipeline
+}
+
+// Forwards flows from one stage to the next.
+type valve struct {
+	from     <-chan *pb.EnrichedFlow
+	to       chan *pb.EnrichedFlow
+	halt     chan struct{} // closed to stop forwarding immediately
+	detached chan struct{} // closed to stop forwarding without closing to once from is closed
+	stopped  chan struct{} // closed once the valve stopped forwarding
+	ended    bool          // whether to was closed, only valid once stopped is closed
+}
+
+func newValve(from <-chan *pb.EnrichedFlow, to chan *pb.EnrichedFlow) *valve {
+	return &valve{from: from, to: to, halt: make(chan struct{}), detached: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (v *valve) run() {
+	defer close(v.stopped)
+	for {
+		select {
+		case msg, ok := <-v.from:
+			if !ok {
+				select {
+				case <-v.detached:
+				default:
+					close(v.to)
+					v.ended = true
+				}
+				return
+			}
+			v.to <- msg
+		case <-v.halt:
+			return
+		}
+	}
+}
+
+// Builds a Pipeline whose segments following the first one can be replaced
+// while it is running.
+func newReloadable(segmentReprs []config.SegmentRepr) (*Pipeline, error) {
+	segmentList, err := SegmentsFromRepr(segmentReprs)
+	if err != nil {
+		return nil, err
+	}
+	var head segments.Segment = &pass.Pass{}
+	if len(segmentList) > 0 {
+		head, segmentList = segmentList[0], segmentList[1:]
+	}
+
+	in, headOut, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	head.Rewire(in, headOut)
+	pipeline := &Pipeline{
+		In:           in,
+		Out:          out,
+		wg:           &sync.WaitGroup{},
+		SegmentList:  append([]segments.Segment{head}, segmentList...),
+		segmentReprs: segmentReprs,
+		channels:     []chan *pb.EnrichedFlow{in, headOut},
+	}
+	pipeline.junction = &junction{
+		in:     headOut,
+		out:    out,
+		parent: pipeline,
+		stages: newStages(segmentReprs[min(1, len(segmentReprs)):], segmentList),
+	}
+	return pipeline, nil
+}
+
+func newStages(segmentReprs []config.SegmentRepr, segmentList []segments.Segment) []*stage {
+	stages := make([]*stage, len(segmentList))
+	for i, segment := range segmentList {
+		stages[i] = &stage{repr: segmentReprs[i], pipeline: New(segment)}
+	}
+	return stages
+}
+
+// Reload reconfigures a running Pipeline created by NewFromConfig. All
+// segments but the first one are compared to the new configuration. The
+// longest unchanged sequences of segments at the start and at the end are
+// kept running, along with any state they hold. The segments in between are
+// rebuilt from the new configuration and swapped in, while the previous ones
+// are drained and closed, losing their state. Kept segments are reported in
+// metrics at the position they were started at. The first segment, usually an
+// input, is always kept running, and thus no flows are lost. If its
+// configuration differs, ErrInputChanged is returned and nothing is changed.
+// Any other error means the new configuration is unusable and the Pipeline
+// continues running with its current configuration. Reload updates the
+// SegmentList, thus it must not be called concurrently with other uses of
+// the Pipeline.
+func (pipeline *Pipeline) Reload(configFile []byte) error {
+	segmentReprs, err := SegmentReprsFromConfig(configFile)
+	if err != nil {
+		return err
+	}
+	return pipeline.ReloadSegmentReprs(segmentReprs)
+}
+
+// ReloadSegmentReprs reconfigures a running Pipeline like Reload, using the
+// already parsed segments of a configuration, e.g. those of a single pipeline
+// returned by PipelineReprsFromConfig.
+func (pipeline *Pipeline) ReloadSegmentReprs(segmentReprs []config.SegmentRepr) error {
+	reload, err := pipeline.PrepareReload(segmentReprs)
+	if err != nil {
+		return err
+	}
+	return reload.Apply()
+}
+
+// A reload of a Pipeline whose new segments are built, but not yet swapped
+// in, see PrepareReload.
+type PreparedReload struct {
+	pipeline     *Pipeline
+	segmentReprs []config.SegmentRepr
+	replacement  *replacement
+}
+
+// PrepareReload builds the segments needed to reconfigure a running Pipeline
+// like ReloadSegmentReprs, but leaves the Pipeline unchanged until Apply is
+// called. This allows reloading several Pipelines only if all of them accept
+// their new configuration. Any PreparedReload which is not applied has to be
+// discarded, and the Pipeline must not be reloaded in between.
+func (pipeline *Pipeline) PrepareReload(segmentReprs []config.SegmentRepr) (*PreparedReload, error) {
+	if pipeline.junction == nil {
+		return nil, errors.New("pipeline: only pipelines created from a configuration can be reloaded")
+	}
+	if err := checkSegmentNames(segmentReprs); err != nil {
+		return nil, err
+	}
+	if !sameInput(pipeline.segmentReprs, segmentReprs) {
+		return nil, ErrInputChanged
+	}
+
+	var tailReprs []config.SegmentRepr
+	if len(segmentReprs) > 0 {
+		tailReprs = segmentReprs[1:]
+	}
+	replacement, err := pipeline.junction.prepareSwap(tailReprs)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedReload{pipeline: pipeline, segmentReprs: segmentReprs, replacement: replacement}, nil
+}
+
+// Apply swaps the prepared segments into the Pipeline. It only fails if the
+// Pipeline was closed or reloaded since the reload was prepared, in which
+// case the prepared segments are discarded.
+func (reload *PreparedReload) Apply() error {
+	pipeline := reload.pipeline
+	tailSegments, replaced, err := pipeline.junction.swap(reload.replacement)
+	if err != nil {
+		return err
+	}
+	pipeline.segmentReprs = reload.segmentReprs
+	pipeline.SegmentList = append([]segments.Segment{pipeline.SegmentList[0]}, tailSegments...)
+	log.Info().Msgf("Pipeline reloaded, replaced %d of %d segments.", replaced, len(tailSegments))
+	return nil
+}
+
+// Discard closes the prepared segments without applying the reload.
+func (reload *PreparedReload) Discard() {
+	reload.replacement.discard()
+}
+
+// Checks recursively that all segment names refer to registered segments, so
+// that a typo in the first segment's name is not reported as ErrInputChanged.
+func checkSegmentNames(segmentReprs []config.SegmentRepr) error {
+	for _, segmentRepr := range segmentReprs {
+		if !segments.IsRegistered(segmentRepr.Name) {
+			return fmt.Errorf("pipeline: could not find a segment named '%s'", segmentRepr.Name)
+		}
+		for _, nested := range segmentRepr.Nested() {
+			if err := checkSegmentNames(nested.Segments); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sameInput(a []config.SegmentRepr, b []config.SegmentRepr) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	return reflect.DeepEqual(a[0], b[0])
+}
+
+// Starts all stages and valves of the junction.
+func (j *junction) start(ctx context.Context) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.ctx = ctx
+	j.valves = j.connect(j.in, j.stages, j.out, 0)
+}
+
+// Prepares and starts the given stages, as well as the valves connecting them,
+// from and to. The stages are placed at the given position in the tail.
+// Returns the valves, the first one reading from from and the last one writing
+// to to.
+func (j *junction) connect(from <-chan *pb.EnrichedFlow, stages []*stage, to chan *pb.EnrichedFlow, position int) []*valve {
+	valves := make([]*valve, 0, len(stages)+1)
+	for i, stage := range stages {
+		j.prepare(stage, position+i)
+		stage.pipeline.StartContext(j.ctx)
+		valves = append(valves, newValve(from, stage.pipeline.In))
+		from = stage.pipeline.Out
+	}
+	valves = append(valves, newValve(from, to))
+	for _, v := range valves {
+		go v.run()
+	}
+	return valves
+}
+
+// Waits until the first segment closed its output and all flows left the
+// stages, which in turn closes the outer Pipeline's Out channel, and closes
+// all stages.
+func (j *junction) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		j.mutex.Lock()
+		outlet := j.valves[len(j.valves)-1]
+		j.mutex.Unlock()
+		<-outlet.stopped
+		if outlet.ended {
+			break
+		}
+		// the outlet was replaced by a reload
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, stage := range j.stages {
+		stage.pipeline.Close()
+	}
+	j.finished = true
+}
+
+// The stages replacing all stages of a junction which differ from a new
+// configuration, see prepareSwap.
+type replacement struct {
+	base           []*stage // the stages of the junction when it was prepared
+	prefix, suffix int      // the number of unchanged stages at the start and at the end
+	stages         []*stage
+}
+
+// Builds the stages replacing all stages differing from the given
+// configuration, without changing the junction yet.
+func (j *junction) prepareSwap(segmentReprs []config.SegmentRepr) (*replacement, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.finished {
+		return nil, errors.New("pipeline: can not reload a closed pipeline")
+	}
+	if j.valves == nil {
+		return nil, errors.New("pipeline: can not reload a pipeline which was not started")
+	}
+
+	// the unchanged stages at the start and at the end are kept
+	prefix := 0
+	for prefix < min(len(j.stages), len(segmentReprs)) && reflect.DeepEqual(j.stages[prefix].repr, segmentReprs[prefix]) {
+		prefix += 1
+	}
+	suffix := 0
+	for suffix < min(len(j.stages), len(segmentReprs))-prefix && reflect.DeepEqual(j.stages[len(j.stages)-1-suffix].repr, segmentReprs[len(segmentReprs)-1-suffix]) {
+		suffix += 1
+	}
+	changedReprs := segmentReprs[prefix : len(segmentReprs)-suffix]
+	segmentList, err := segmentsFromRepr(changedReprs, 1+prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &replacement{
+		base:   slices.Clone(j.stages),
+		prefix: prefix,
+		suffix: suffix,
+		stages: newStages(changedReprs, segmentList),
+	}, nil
+}
+
+// Closes the stages of a replacement which is not swapped in.
+func (r *replacement) discard() {
+	for _, stage := range r.stages {
+		stage.pipeline.Close()
+	}
+}
+
+// Swaps in the stages of a replacement prepared by prepareSwap. Returns the
+// segments of all stages afterwards, as well as the number of replaced ones.
+func (j *junction) swap(r *replacement) ([]segments.Segment, int, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.finished {
+		r.discard()
+		return nil, 0, errors.New("pipeline: can not reload a closed pipeline")
+	}
+	if !slices.Equal(j.stages, r.base) {
+		r.discard()
+		return nil, 0, errors.New("pipeline: reloaded since the reload was prepared")
+	}
+	prefix, suffix, stages := r.prefix, r.suffix, r.stages
+	old := j.stages[prefix : len(j.stages)-suffix]
+	if len(stages) == 0 && len(old) == 0 {
+		return j.segments(), 0, nil
+	}
+
+	// stop accepting flows in front of the old stages, then drain them
+	first, last := j.valves[prefix], j.valves[len(j.stages)-suffix]
+	close(first.halt)
+	<-first.stopped
+	if first.ended {
+		// the first segment has terminated, the stages are closing down
+		r.discard()
+		return nil, 0, errors.New("pipeline: can not reload a closed pipeline")
+	}
+	if len(old) > 0 {
+		close(last.detached)
+		close(first.to)
+		<-last.stopped
+		for _, stage := range old {
+			stage.pipeline.Close()
+		}
+	}
+
+	valves := j.connect(first.from, stages, last.to, prefix)
+	j.valves = slices.Concat(j.valves[:prefix], valves, j.valves[len(j.stages)-suffix+1:])
+	j.stages = slices.Concat(j.stages[:prefix], stages, j.stages[len(j.stages)-suffix:])
+
+	return j.segments(), len(stages), nil
+}
+
+// Returns the segments of all stages.
+func (j *junction) segments() []segments.Segment {
+	segmentList := make([]segments.Segment, len(j.stages))
+	for i, stage := range j.stages {
+		segmentList[i] = stage.pipeline.SegmentList[0]
+	}
+	return segmentList
+}
+
+// Subscribes the segments of all stages to drop, see Pipeline.subscribeDrops.
+func (j *junction) subscribeDrops(drop chan *pb.EnrichedFlow) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.drops = drop
+	for _, stage := range j.stages {
+		stage.pipeline.subscribeDrops(drop)
+	}
+}
+
+// Sets up a stage at the given position in the tail before it is started, so
+// that its drops and metrics are handled like those of the outer Pipeline.
+func (j *junction) prepare(stage *stage, position int) {
+	if j.drops != nil {
+		stage.pipeline.subscribeDrops(j.drops)
+	}
+	if j.parent.metrics != nil {
+		stage.pipeline.metrics = &metricLabels{
+			pipeline: j.parent.metrics.pipeline,
+			instance: j.parent.metrics.instance,
+			offset:   1 + position,
+			names:    []string{stage.repr.Name},
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"github.com/BelWue/flowpipeline/pipeline/config"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// A single issue found in a configuration by Validate.
+type Problem struct {
+	Position string // position of the segment in the config, starting at 1, nested positions look like "2/then/1", or "storage/2" for named pipelines
+	Segment  string // name of the segment
+	Message  string
+	Warning  bool // set if this problem does not prevent the pipeline from starting
+}
+
+func (p Problem) String() string {
+	severity := "error"
+	if p.Warning {
+		severity = "warning"
+	}
+	if p.Position == "" {
+		return fmt.Sprintf("%s: %s", severity, p.Message)
+	}
+	return fmt.Sprintf("%s: segment %s (%s): %s", severity, p.Position, p.Segment, p.Message)
+}
+
+// Validate checks a configuration without starting any segments and reports
+// all problems found in it. This includes unknown segments, unknown or
+// malformed config parameters, missing files, and segments failing to read
+// their config. Segments are instantiated in dry run mode, so they should not
+// have any side effects. Use a Validator to report the messages logged by the
+// segments as well.
+func Validate(configFile []byte) []Problem {
+	return (&Validator{}).Validate(configFile)
+}
+
+// A Validator checks configurations like Validate, and reports anything
+// logged as a warning or error by the segments while reading their config as
+// problems of these segments. For this, the Validator has to receive the log
+// messages while validating, e.g. by using it as both the output and a hook
+// of the global logger, in which case fatal messages abort the segment's
+// initialization instead of exiting. Messages received while not validating
+// are ignored. The zero value is ready to use.
+type Validator struct {
+	validating sync.Mutex // held while validating, one configuration at a time
+	mutex      sync.Mutex // guards collector
+	collector  *logCollector
+}
+
+// Validate checks a configuration like the function Validate, including the
+// messages logged meanwhile.
+func (v *Validator) Validate(configFile []byte) []Problem {
+	pipelineReprs, err := PipelineReprsFromConfig(configFile)
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var problems []Problem
+		for _, err := range joined.Unwrap() {
+			problems = append(problems, Problem{Message: err.Error()})
+		}
+		return problems
+	} else if err != nil {
+		return []Problem{{Message: err.Error()}}
+	}
+
+	v.validating.Lock()
+	defer v.validating.Unlock()
+	collector := &logCollector{}
+	v.setCollector(collector)
+	defer v.setCollector(nil)
+
+	if _, err := ShutdownOrder(pipelineReprs); err != nil {
+		collector.add(err.Error(), false)
+	}
+	validateTopics(pipelineReprs, collector)
+	for _, pipelineRepr := range pipelineReprs {
+		prefix := ""
+		if pipelineRepr.Name != "" {
+			prefix = pipelineRepr.Name + "/"
+		}
+		validateSegmentReprs(pipelineRepr.SegmentReprs(), prefix, collector)
+	}
+	return collector.problems
+}
+
+// Warns about topics which are only used by either publish or connect
+// segments, as flows published to them are discarded, or never arrive.
+func validateTopics(pipelineReprs []config.PipelineRepr, collector *logCollector) {
+	published, connected := make(map[string]bool), make(map[string]bool)
+	var topics []string
+	for _, pipelineRepr := range pipelineReprs {
+		pipelinePublished, pipelineConnected := pipelineRepr.Topics()
+		for _, topic := range pipelinePublished {
+			published[topic] = true
+		}
+		for _, topic := range pipelineConnected {
+			connected[topic] = true
+		}
+		topics = append(append(topics, pipelinePublished...), pipelineConnected...)
+	}
+	reported := make(map[string]bool)
+	for _, topic := range topics {
+		if reported[topic] || topic == "" {
+			continue
+		}
+		reported[topic] = true
+		if !connected[topic] {
+			collector.add(fmt.Sprintf("topic '%s' is published to, but no pipeline connects to it", topic), true)
+		} else if !published[topic] {
+			collector.add(fmt.Sprintf("topic '%s' is connected to, but no pipeline publishes to it", topic), true)
+		}
+	}
+}
+
+func validateSegmentReprs(segmentReprs []config.SegmentRepr, prefix string, collector *logCollector) {
+	for i, segmentRepr := range segmentReprs {
+		position := prefix + strconv.Itoa(i+1)
+		collector.setCurrent(Problem{Position: position, Segment: segmentRepr.Name})
+		validateSegmentRepr(segmentRepr, collector)
+		for _, nested := range segmentRepr.Nested() {
+			validateSegmentReprs(nested.Segments, position+"/"+nested.Position+"/", collector)
+		}
+	}
+}
+
+func validateSegmentRepr(segmentRepr config.SegmentRepr, collector *logCollector) {
+	template, err := segments.LookupSegment(segmentRepr.Name)
+	if err != nil {
+		collector.add("unknown segment", false)
+		return
+	}
+
+	if err := checkBuffer(segmentRepr); err != nil {
+		collector.add(err.Error(), false)
+	}
+
+	segmentConfig, errs := segments.ApplyParams(segmentRepr.Name, segmentRepr.ExpandedConfig())
+	for _, err := range errs {
+		collector.add(err.Error(), false)
+	}
+	if len(errs) > 0 {
+		// the segment would not be created with this config either
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(fatalLog); !ok {
+				panic(r)
+			}
+		}
+	}()
+	errorsBefore := collector.errorCount()
+	segment := template.New(segments.WithDryRun(segmentConfig))
+	if segment == nil {
+		if collector.errorCount() == errorsBefore {
+			collector.add("segment could not be initialized", false)
+		}
+		return
+	}
+	// nested segments are validated on their own, do not let the branch
+	// or tee segments instantiate them again
+	if err := segment.AddCustomConfig(segmentRepr.WithoutNested()); err != nil {
+		collector.add(err.Error(), false)
+	}
+}
+
+// Panic value used to abort a segment's initialization instead of exiting
+// when it logs a fatal message.
+type fatalLog struct{}
+
+func (v *Validator) setCollector(collector *logCollector) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.collector = collector
+}
+
+// Returns the collector of the running validation, if any.
+func (v *Validator) running() *logCollector {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.collector
+}
+
+// Receives the JSON log lines written by zerolog while validating, see
+// logCollector.Write.
+func (v *Validator) Write(p []byte) (int, error) {
+	if collector := v.running(); collector != nil {
+		return collector.Write(p)
+	}
+	return len(p), nil
+}
+
+// Receives fatal messages while validating, see logCollector.Run.
+func (v *Validator) Run(e *zerolog.Event, level zerolog.Level, message string) {
+	if collector := v.running(); collector != nil {
+		collector.Run(e, level, message)
+	}
+}
+
+// Collects all warnings and errors logged by segments while they are
+// initialized and attributes them to the current segment.
+type logCollector struct {
+	mutex    sync.Mutex
+	current  Problem
+	problems []Problem
+	errors   int
+}
+
+func (c *logCollector) setCurrent(problem Problem) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.current = problem
+}
+
+func (c *logCollector) errorCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.errors
+}
+
+func (c *logCollector) add(message string, warning bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	problem := c.current
+	problem.Message = message
+	problem.Warning = warning
+	c.problems = append(c.problems, problem)
+	if !warning {
+		c.errors += 1
+	}
+}
+
+// Parses the JSON log lines written by zerolog, which include any additional
+// fields like errors as opposed to the messages passed to hooks.
+func (c *logCollector) Write(p []byte) (int, error) {
+	var line struct {
+		Level   string `json:"level"`
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(p, &line); err != nil {
+		return len(p), nil
+	}
+	message := line.Message
+	if line.Error != "" {
+		message = fmt.Sprintf("%s (%s)", strings.TrimRight(message, ": "), line.Error)
+	}
+	switch line.Level {
+	case zerolog.LevelWarnValue:
+		c.add(message, true)
+	case zerolog.LevelErrorValue:
+		c.add(message, false)
+	}
+	return len(p), nil
+}
+
+// Hook for fatal messages, which are not written as zerolog exits before.
+func (c *logCollector) Run(e *zerolog.Event, level zerolog.Level, message string) {
+	if level == zerolog.FatalLevel {
+		c.add(message, false)
+		panic(fatalLog{})
+	}
+}
@@ -6,6 +6,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/url"
 	"sync"
@@ -40,7 +41,7 @@ func (segment Http) New(config map[string]string) segments.Segment {
 	return &Http{Url: config["url"]}
 }
 
-func (segment *Http) Run(wg *sync.WaitGroup) {
+func (segment *Http) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -72,5 +73,7 @@ func (segment *Http) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Http{}
-	segments.RegisterSegment("http", segment)
+	segments.RegisterSegment("http", segment,
+		segments.Param{Name: "url", Required: true, Description: "the http or https URL flows are posted to as JSON"},
+	)
 }
@@ -214,6 +214,7 @@ type PrometheusExporter struct {
 
 	KafkaMessageCount prometheus.Counter
 	dbSize            prometheus.Gauge
+	server            *http.Server
 }
 
 // Initialize Prometheus Exporter
@@ -249,11 +250,19 @@ func (e *PrometheusExporter) ServeEndpoints(promParams *PrometheusParams) {
 			</body>
 		</html>`))
 	})
+	e.server = &http.Server{Addr: promParams.Endpoint, Handler: mux}
 	go func() {
-		err := http.ListenAndServe(promParams.Endpoint, mux)
-		if err != nil {
+		err := e.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			log.Error().Err(err).Msgf("ToptalkersMetrics: Failed to start https endpoint on port %s", promParams.Endpoint)
 		}
 	}()
 	log.Info().Msgf("ToptalkersMetrics: Enabled metrics on %s and %s, listening at %s.", promParams.MetricsPath, promParams.FlowdataPath, promParams.Endpoint)
 }
+
+// stop serving the endpoints, releasing the address for a new instance
+func (e *PrometheusExporter) Shutdown() {
+	if e.server != nil {
+		e.server.Close()
+	}
+}
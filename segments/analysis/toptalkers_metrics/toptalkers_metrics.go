@@ -25,6 +25,7 @@
 package toptalkers_metrics
 
 import (
+	"context"
 	"sync"
 
 	"github.com/BelWue/flowpipeline/segments"
@@ -66,7 +67,7 @@ func (segment ToptalkersMetrics) New(config map[string]string) segments.Segment
 	return newsegment
 }
 
-func (segment *ToptalkersMetrics) Run(wg *sync.WaitGroup) {
+func (segment *ToptalkersMetrics) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -108,9 +109,21 @@ func (segment *ToptalkersMetrics) Run(wg *sync.WaitGroup) {
 			segment.Drops <- msg
 		}
 	}
+	promExporter.Shutdown()
 }
 
 func init() {
 	segment := &ToptalkersMetrics{}
-	segments.RegisterSegment("toptalkers_metrics", segment)
+	segments.RegisterSegment("toptalkers_metrics", segment,
+		segments.Param{Name: "traffictype", Description: "name of the traffic type, exported as a label"},
+		segments.Param{Name: "buckets", Type: segments.Int, Default: "60", Description: "number of one second buckets in the sliding window"},
+		segments.Param{Name: "thresholdbuckets", Type: segments.Int, Default: "60", Description: "number of recent buckets used to compare against the thresholds"},
+		segments.Param{Name: "reportbuckets", Type: segments.Int, Default: "60", Description: "number of recent buckets used to calculate the reported averages"},
+		segments.Param{Name: "thresholdbps", Type: segments.Uint, Default: "0", Description: "only report talkers exceeding this average bits per second"},
+		segments.Param{Name: "thresholdpps", Type: segments.Uint, Default: "0", Description: "only report talkers exceeding this average packets per second"},
+		segments.Param{Name: "relevantaddress", Default: "destination", Description: "one of \"destination\", \"source\", \"both\" or \"connection\""},
+		segments.Param{Name: "endpoint", Default: ":8080", Description: "address to serve the metrics on"},
+		segments.Param{Name: "metricspath", Default: "/metrics", Description: "URL path of the metrics"},
+		segments.Param{Name: "flowdatapath", Default: "/flowdata", Description: "URL path of the flow data"},
+	)
 }
@@ -6,6 +6,7 @@
 package traffic_specific_toptalkers
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -47,7 +48,7 @@ func (segment TrafficSpecificToptalkers) New(config map[string]string) segments.
 	if config["metricspath"] == "" {
 		log.Info().Msg("ToptalkersMetrics: Missing configuration parameter 'metricspath'. Using default path \"/metrics\"")
 	} else {
-		newSegment.FlowdataPath = config["metricspath"]
+		newSegment.MetricsPath = config["metricspath"]
 	}
 	if config["flowdatapath"] == "" {
 		log.Info().Msg("ThresholdToptalkersMetrics: Missing configuration parameter 'flowdatapath'. Using default path \"/flowdata\"")
@@ -63,14 +64,15 @@ func (segment TrafficSpecificToptalkers) New(config map[string]string) segments.
 	return newSegment
 }
 
-func (segment *TrafficSpecificToptalkers) AddCustomConfig(segmentReprs config.SegmentRepr) {
+func (segment *TrafficSpecificToptalkers) AddCustomConfig(segmentReprs config.SegmentRepr) error {
 	for _, definition := range segmentReprs.Config.ThresholdMetricDefinition {
 		metric, err := segment.metricFromDefinition(definition)
 		if err != nil {
-			log.Error().Err(err).Msg("ThresholdToptalkersMetrics: Failed to add custom config")
+			return fmt.Errorf("ThresholdToptalkersMetrics: Failed to add custom config: %w", err)
 		}
 		segment.ThresholdMetricDefinition = append(segment.ThresholdMetricDefinition, metric)
 	}
+	return nil
 }
 
 func (segment *TrafficSpecificToptalkers) metricFromDefinition(definition *config.ThresholdMetricDefinition) (*ThresholdMetric, error) {
@@ -101,7 +103,7 @@ func (segment *TrafficSpecificToptalkers) metricFromDefinition(definition *confi
 	return &metric, nil
 }
 
-func (segment *TrafficSpecificToptalkers) Run(wg *sync.WaitGroup) {
+func (segment *TrafficSpecificToptalkers) Run(ctx context.Context, wg *sync.WaitGroup) {
 	var allDatabases *[]*toptalkers_metrics.Database
 	defer func() {
 		close(segment.Out)
@@ -190,5 +192,10 @@ func addMessageToMatchingToptalkers(msg *pb.EnrichedFlow, definition *ThresholdM
 
 func init() {
 	segment := &TrafficSpecificToptalkers{}
-	segments.RegisterSegment("traffic_specific_toptalkers", segment)
+	segments.RegisterSegment("traffic_specific_toptalkers", segment,
+		segments.Param{Name: "endpoint", Default: ":8080", Description: "address to serve the metrics on"},
+		segments.Param{Name: "metricspath", Default: "/metrics", Description: "URL path of the metrics"},
+		segments.Param{Name: "flowdatapath", Default: "/flowdata", Description: "URL path of the flow data"},
+		segments.Param{Name: "relevantaddress", Description: "overrides the relevant address of all definitions, one of \"destination\", \"source\", \"both\" or \"connection\""},
+	)
 }
@@ -1,6 +1,7 @@
 package traffic_specific_toptalkers
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -15,9 +16,12 @@ func TestSegment_Branch_passthrough(t *testing.T) {
 	msg := &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 123}, DstPort: 123, Packets: 1000, Bytes: 230000, Proto: 17} //Ntp (udp)
 	msg2 := &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 123}, DstPort: 443, Packets: 1, Bytes: 100, Proto: 6}
 
-	segment := segments.LookupSegment("traffic_specific_toptalkers")
+	segment, err := segments.LookupSegment("traffic_specific_toptalkers")
+	if err != nil {
+		t.Fatal(err)
+	}
 	//normally done via config
-	segment.AddCustomConfig(config.SegmentRepr{
+	err = segment.AddCustomConfig(config.SegmentRepr{
 		Config: config.Config{
 			ThresholdMetricDefinition: []*config.ThresholdMetricDefinition{
 				{
@@ -35,6 +39,9 @@ func TestSegment_Branch_passthrough(t *testing.T) {
 			},
 		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	segment = segment.New(map[string]string{})
 
@@ -47,7 +54,7 @@ func TestSegment_Branch_passthrough(t *testing.T) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	in <- msg
 	resultMsg := <-out
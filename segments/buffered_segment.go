@@ -0,0 +1,282 @@
+package segments
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	"github.com/BelWue/flowpipeline/pb"
+)
+
+// Policies of a BufferedSegment for flows arriving while its buffer is full.
+const (
+	OverflowBlock      = "block"       // wait for the segment, slowing down all previous segments
+	OverflowDropNewest = "drop-newest" // drop the arriving flow
+	OverflowDropOldest = "drop-oldest" // drop the longest buffered flow to make room for the arriving one
+	OverflowSpill      = "spill"       // write flows to a temporary file until there is room again
+)
+
+// Returns an error if the overflow policy is unknown.
+func CheckOverflow(overflow string) error {
+	switch overflow {
+	case "", OverflowBlock, OverflowDropNewest, OverflowDropOldest, OverflowSpill:
+		return nil
+	}
+	return fmt.Errorf("unknown overflow policy '%s', must be one of '%s', '%s', '%s' or '%s'", overflow, OverflowBlock, OverflowDropNewest, OverflowDropOldest, OverflowSpill)
+}
+
+// Wrapper queueing up to a configured number of flows in front of a segment,
+// so that short stalls of the segment do not slow down all previous
+// segments. What happens to flows arriving while the buffer is full is
+// determined by the overflow policy.
+type BufferedSegment struct {
+	BaseFilterSegment
+	segment  Segment
+	size     int
+	overflow string
+	inner    chan *pb.EnrichedFlow // from the buffer to the wrapped segment
+	dropped  atomic.Uint64
+	spilled  uint64
+
+	OnOverflow func() // optional, called for each flow dropped due to the overflow policy
+}
+
+// Wraps a segment with a buffer of the given size and overflow policy, which
+// may be empty to use the default policy "block".
+func NewBufferedSegment(segment Segment, size int, overflow string) *BufferedSegment {
+	if overflow == "" {
+		overflow = OverflowBlock
+	}
+	return &BufferedSegment{segment: segment, size: size, overflow: overflow}
+}
+
+func (segment *BufferedSegment) New(config map[string]string) Segment {
+	// This method should never be called, since BufferedSegment is just a wrapper for other segments
+	panic("BufferedSegment should not be instantiated using New()")
+}
+
+// Returns the wrapped segment.
+func (segment *BufferedSegment) Segment() Segment {
+	return segment.segment
+}
+
+// Returns the number of flows dropped so far due to the overflow policy.
+func (segment *BufferedSegment) Dropped() uint64 {
+	return segment.dropped.Load()
+}
+
+func (segment *BufferedSegment) Rewire(in chan *pb.EnrichedFlow, out chan *pb.EnrichedFlow) {
+	segment.In = in
+	segment.inner = make(chan *pb.EnrichedFlow)
+	segment.segment.Rewire(segment.inner, out)
+}
+
+func (segment *BufferedSegment) SubscribeDrops(drops chan<- *pb.EnrichedFlow) {
+	if filterSegment, ok := segment.segment.(FilterSegment); ok {
+		filterSegment.SubscribeDrops(drops)
+	}
+}
+
+func (segment *BufferedSegment) Close() {
+	segment.segment.Close()
+}
+
+func (segment *BufferedSegment) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	segmentWg := sync.WaitGroup{}
+	segmentWg.Add(1)
+	go segment.segment.Run(ctx, &segmentWg)
+
+	segment.buffer()
+	close(segment.inner)
+	segmentWg.Wait()
+
+	if dropped := segment.dropped.Load(); dropped > 0 {
+		log.Warn().Msgf("Buffer: Dropped %d flows in front of segment '%s' as its buffer of %d flows was full.", dropped, RegisteredName(segment.segment), segment.size)
+	}
+	if segment.spilled > 0 {
+		log.Info().Msgf("Buffer: Spilled %d flows in front of segment '%s' to disk as its buffer of %d flows was full.", segment.spilled, RegisteredName(segment.segment), segment.size)
+	}
+}
+
+// Moves flows from In to the wrapped segment until In is closed and all
+// buffered flows have been handed over.
+func (segment *BufferedSegment) buffer() {
+	var queue []*pb.EnrichedFlow
+	var spill *spillFile
+	defer func() {
+		if spill != nil {
+			spill.remove()
+		}
+	}()
+
+	in := segment.In
+	for in != nil || len(queue) > 0 {
+		// refill the queue from disk, keeping the order of all flows
+		for spill != nil && spill.count > 0 && len(queue) < segment.size {
+			msg, err := spill.read()
+			if err != nil {
+				log.Error().Err(err).Msgf("Buffer: Failed to read %d spilled flows, dropping them: ", spill.count)
+				segment.drop(int(spill.count))
+				spill.reset()
+				break
+			}
+			queue = append(queue, msg)
+		}
+
+		full := len(queue) >= segment.size
+		receive := in
+		if full && segment.overflow == OverflowBlock {
+			receive = nil
+		}
+		var send chan<- *pb.EnrichedFlow
+		var next *pb.EnrichedFlow
+		if len(queue) > 0 {
+			send, next = segment.inner, queue[0]
+		}
+
+		select {
+		case msg, ok := <-receive:
+			if !ok {
+				in = nil
+				continue
+			}
+			switch {
+			case !full && (spill == nil || spill.count == 0):
+				queue = append(queue, msg)
+			case segment.overflow == OverflowDropNewest:
+				segment.drop(1)
+			case segment.overflow == OverflowDropOldest:
+				queue = append(queue[1:], msg)
+				segment.drop(1)
+			case segment.overflow == OverflowSpill:
+				if spill == nil {
+					var err error
+					if spill, err = newSpillFile(); err != nil {
+						log.Error().Err(err).Msg("Buffer: Failed to create spill file, dropping flows instead: ")
+						segment.overflow = OverflowDropNewest
+						segment.drop(1)
+						continue
+					}
+				}
+				if err := spill.write(msg); err != nil {
+					log.Error().Err(err).Msg("Buffer: Failed to spill flow, dropping it: ")
+					segment.drop(1)
+					continue
+				}
+				segment.spilled += 1
+			}
+		case send <- next:
+			queue[0] = nil
+			queue = queue[1:]
+		}
+	}
+	// drain anything left on disk after In was closed
+	for spill != nil && spill.count > 0 {
+		msg, err := spill.read()
+		if err != nil {
+			log.Error().Err(err).Msgf("Buffer: Failed to read %d spilled flows, dropping them: ", spill.count)
+			segment.drop(int(spill.count))
+			return
+		}
+		segment.inner <- msg
+	}
+}
+
+func (segment *BufferedSegment) drop(count int) {
+	for range count {
+		segment.dropped.Add(1)
+		if segment.OnOverflow != nil {
+			segment.OnOverflow()
+		}
+	}
+}
+
+// A temporary file holding length-delimited flows, which are read back in the
+// order they were written. The file is truncated whenever all flows written
+// to it have been read.
+type spillFile struct {
+	file   *os.File
+	writer *bufio.Writer
+	reader *bufio.Reader
+	count  uint64 // number of flows written, but not yet read
+}
+
+func newSpillFile() (*spillFile, error) {
+	file, err := os.CreateTemp("", "flowpipeline-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (s *spillFile) write(msg *pb.EnrichedFlow) error {
+	if _, err := protodelim.MarshalTo(s.writer, msg); err != nil {
+		return err
+	}
+	s.count += 1
+	return nil
+}
+
+func (s *spillFile) read() (*pb.EnrichedFlow, error) {
+	if err := s.writer.Flush(); err != nil {
+		return nil, err
+	}
+	if s.reader == nil {
+		s.reader = bufio.NewReader(&appendedReader{file: s.file})
+	}
+	msg := &pb.EnrichedFlow{}
+	if err := protodelim.UnmarshalFrom(s.reader, msg); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	s.count -= 1
+	if s.count == 0 {
+		s.reset()
+	}
+	return msg, nil
+}
+
+// Reads a file which is appended to while being read. Unlike regular files,
+// it only reports the end of the file if nothing could be read at all, as
+// buffered readers would not try to read any data appended afterwards.
+type appendedReader struct {
+	file   *os.File
+	offset int64
+}
+
+func (r *appendedReader) Read(p []byte) (int, error) {
+	n, err := r.file.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Discards all contents, so that the file does not grow indefinitely.
+func (s *spillFile) reset() {
+	s.count, s.reader = 0, nil
+	s.writer.Reset(s.file)
+	if err := s.file.Truncate(0); err != nil {
+		log.Warn().Err(err).Msg("Buffer: Failed to truncate spill file: ")
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		log.Warn().Err(err).Msg("Buffer: Failed to rewind spill file: ")
+	}
+}
+
+func (s *spillFile) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
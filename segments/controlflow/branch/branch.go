@@ -48,6 +48,8 @@
 package branch
 
 import (
+	"context"
+	"fmt"
 	"strconv"
 	"sync"
 
@@ -62,7 +64,7 @@ import (
 // This mirrors the proper implementation in the pipeline package. This
 // duplication is to avoid the import cycle.
 type Pipeline interface {
-	Start()
+	StartContext(ctx context.Context)
 	Close()
 	GetInput() chan *pb.EnrichedFlow
 	GetOutput() <-chan *pb.EnrichedFlow
@@ -82,21 +84,34 @@ func (segment Branch) New(config map[string]string) segments.Segment {
 	if config["bypass-messages"] != "" {
 		b, err := strconv.ParseBool(config["bypass-messages"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Branch: Failed to parse bypass-messages config option")
+			log.Error().Err(err).Msg("Branch: Failed to parse bypass-messages config option")
+			return nil
 		}
 		bypassMessages = b
 	}
 	return &Branch{bypassMessages: bypassMessages}
 }
 
-func (segment *Branch) AddCustomConfig(segmentReprs config.SegmentRepr) {
-	segment.condition = pipeline.New(pipeline.SegmentsFromRepr(segmentReprs.If)...)
-	segment.then_branch = pipeline.New(pipeline.SegmentsFromRepr(segmentReprs.Then)...)
-	segment.else_branch = pipeline.New(pipeline.SegmentsFromRepr(segmentReprs.Else)...)
-
+func (segment *Branch) AddCustomConfig(segmentReprs config.SegmentRepr) error {
+	condition, err := pipeline.SegmentsFromRepr(segmentReprs.If)
+	if err != nil {
+		return fmt.Errorf("if: %w", err)
+	}
+	thenBranch, err := pipeline.SegmentsFromRepr(segmentReprs.Then)
+	if err != nil {
+		return fmt.Errorf("then: %w", err)
+	}
+	elseBranch, err := pipeline.SegmentsFromRepr(segmentReprs.Else)
+	if err != nil {
+		return fmt.Errorf("else: %w", err)
+	}
+	segment.condition = pipeline.New(condition...)
+	segment.then_branch = pipeline.New(thenBranch...)
+	segment.else_branch = pipeline.New(elseBranch...)
+	return nil
 }
 
-func (segment *Branch) Run(wg *sync.WaitGroup) {
+func (segment *Branch) Run(ctx context.Context, wg *sync.WaitGroup) {
 	if segment.condition == nil || segment.then_branch == nil || segment.else_branch == nil {
 		log.Error().Msg("Branch: Uninitialized branches. This is expected during standalone testing of this package. The actual test is done as part of the pipeline package, as this segment embeds further pipelines.")
 		return
@@ -112,9 +127,9 @@ func (segment *Branch) Run(wg *sync.WaitGroup) {
 		wg.Done()
 	}()
 
-	go segment.condition.Start()
-	go segment.then_branch.Start()
-	go segment.else_branch.Start()
+	segment.condition.StartContext(ctx)
+	segment.then_branch.StartContext(ctx)
+	segment.else_branch.StartContext(ctx)
 
 	go drainOutput(segment)
 	go forwardBasedOnCondition(segment)
@@ -199,5 +214,7 @@ func drainOutput(segment *Branch) {
 
 func init() {
 	segment := &Branch{}
-	segments.RegisterSegment("branch", segment)
+	segments.RegisterSegment("branch", segment,
+		segments.Param{Name: "bypass-messages", Type: segments.Bool, Default: "false", Description: "forward all incoming flows to the next segment, regardless of the branch they took"},
+	)
 }
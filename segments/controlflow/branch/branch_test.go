@@ -13,7 +13,7 @@ import (
 )
 
 func Test_Branch_passthrough(t *testing.T) {
-	pipeline := pipeline.NewFromConfig([]byte(`---
+	pipeline, err := pipeline.NewFromConfig([]byte(`---
 - segment: branch
   if:
   - segment: flowfilter
@@ -30,6 +30,9 @@ func Test_Branch_passthrough(t *testing.T) {
       policy: drop
       fields: OutIf
 `))
+	if err != nil {
+		t.Fatal(err)
+	}
 	pipeline.Start()
 	pipeline.In <- &pb.EnrichedFlow{Proto: 6, InIf: 1, OutIf: 1}
 	fmsg := <-pipeline.Out
@@ -44,7 +47,7 @@ func Test_Branch_passthrough(t *testing.T) {
 }
 
 func Test_Branch_DeadlockFreeGeneration_If(t *testing.T) {
-	pipeline := pipeline.NewFromConfig([]byte(`---
+	pipeline, err := pipeline.NewFromConfig([]byte(`---
 - segment: branch
   if:
   - segment: generator
@@ -57,6 +60,9 @@ func Test_Branch_DeadlockFreeGeneration_If(t *testing.T) {
       policy: drop
       fields: Bytes
 `))
+	if err != nil {
+		t.Fatal(err)
+	}
 	pipeline.Start()
 	pipeline.In <- &pb.EnrichedFlow{Proto: 42, Bytes: 42}
 	for i := 0; i < 5; i++ {
@@ -70,11 +76,14 @@ func Test_Branch_DeadlockFreeGeneration_If(t *testing.T) {
 }
 
 func Test_Branch_DeadlockFreeGeneration_Then(t *testing.T) {
-	pipeline := pipeline.NewFromConfig([]byte(`---
+	pipeline, err := pipeline.NewFromConfig([]byte(`---
 - segment: branch
   then:
   - segment: generator
 `))
+	if err != nil {
+		t.Fatal(err)
+	}
 	pipeline.Start()
 	pipeline.In <- &pb.EnrichedFlow{Proto: 42, Bytes: 42}
 	for i := 0; i < 5; i++ {
@@ -84,11 +93,14 @@ func Test_Branch_DeadlockFreeGeneration_Then(t *testing.T) {
 }
 
 func Test_Branch_DeadlockFreeGeneration_Else(t *testing.T) {
-	pipeline := pipeline.NewFromConfig([]byte(`---
+	pipeline, err := pipeline.NewFromConfig([]byte(`---
 - segment: branch
   else:
   - segment: generator
 `))
+	if err != nil {
+		t.Fatal(err)
+	}
 	pipeline.Start()
 	pipeline.In <- &pb.EnrichedFlow{Proto: 42, Bytes: 42}
 	for i := 0; i < 5; i++ {
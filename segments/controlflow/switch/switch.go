@@ -0,0 +1,187 @@
+// The `switch` segment routes flows to one of several subpipelines based on
+// [flowfilter syntax](https://github.com/BelWue/flowfilter) expressions. Similar
+// to the `branch` segment, it uses additional syntax that other segments do not
+// have access to, namely the `cases` key containing an ordered list of `case`
+// expressions, each with its own list of `segments`, and the `default` key
+// containing the segments for flows not matching any case.
+//
+// With the default `mode` `first`, flows are handed to the segments of the first
+// case they match. With `mode` `all`, flows are handed to the segments of every
+// case they match, which means that a flow matching several cases will leave
+// this segment several times, once for each case, as separate copies. In both
+// modes, flows matching no case are handed to the `default` segments, which
+// behave like a single `pass` segment if they are omitted. Flows leaving any of
+// the subpipelines are passed on to the next segment, and flows dropped within
+// them are dropped regularly.
+//
+// The expressions are parsed once when the segment is created. The following
+// example sends TCP, UDP and ICMP flows to separate exports and drops all others:
+//
+// ```yaml
+// - segment: switch
+//   cases:
+//   - case: proto tcp
+//     segments:
+//     - segment: sqlite
+//       config:
+//         filename: tcp.sqlite
+//   - case: proto udp
+//     segments:
+//     - segment: sqlite
+//       config:
+//         filename: udp.sqlite
+//   - case: proto icmp or proto icmpv6
+//     segments:
+//     - segment: printflowdump
+//   default:
+//   - segment: drop
+// ```
+package switchsegment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/BelWue/flowfilter/parser"
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/pipeline"
+	"github.com/BelWue/flowpipeline/pipeline/config"
+	"github.com/BelWue/flowpipeline/segments"
+	"github.com/BelWue/flowpipeline/segments/filter/flowfilter"
+)
+
+type Switch struct {
+	segments.BaseFilterSegment
+	Mode string // optional, default is "first", one of "first" or "all"
+
+	cases           []*route
+	defaultPipeline *pipeline.Pipeline
+}
+
+// A single case with its parsed expression and subpipeline.
+type route struct {
+	expression *parser.Expression
+	pipeline   *pipeline.Pipeline
+}
+
+func (segment Switch) New(config map[string]string) segments.Segment {
+	mode := "first"
+	if config["mode"] != "" {
+		if config["mode"] != "first" && config["mode"] != "all" {
+			log.Error().Msgf("Switch: Unknown mode '%s', must be one of 'first' or 'all'.", config["mode"])
+			return nil
+		}
+		mode = config["mode"]
+	}
+	return &Switch{Mode: mode}
+}
+
+func (segment *Switch) AddCustomConfig(segmentRepr config.SegmentRepr) error {
+	filter := &flowfilter.Filter{}
+	for i, switchCase := range segmentRepr.Cases {
+		expression, err := parser.Parse(switchCase.Case)
+		if err != nil {
+			return fmt.Errorf("case %d: syntax error in filter expression: %w", i+1, err)
+		}
+		if _, err := filter.CheckFlow(expression, &pb.EnrichedFlow{}); err != nil {
+			return fmt.Errorf("case %d: semantic error in filter expression: %w", i+1, err)
+		}
+		caseSegments, err := pipeline.SegmentsFromRepr(switchCase.Segments)
+		if err != nil {
+			return fmt.Errorf("case %d: %w", i+1, err)
+		}
+		segment.cases = append(segment.cases, &route{
+			expression: expression,
+			pipeline:   pipeline.New(caseSegments...),
+		})
+	}
+	defaultSegments, err := pipeline.SegmentsFromRepr(segmentRepr.Default)
+	if err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	segment.defaultPipeline = pipeline.New(defaultSegments...)
+	return nil
+}
+
+func (segment *Switch) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	if segment.defaultPipeline == nil {
+		log.Error().Msg("Switch: Uninitialized cases. This is expected during standalone testing of this package, flows are passed through.")
+		for msg := range segment.In {
+			segment.Out <- msg
+		}
+		return
+	}
+
+	subpipelines := []*pipeline.Pipeline{segment.defaultPipeline}
+	for _, switchCase := range segment.cases {
+		subpipelines = append(subpipelines, switchCase.pipeline)
+	}
+	var outputWg sync.WaitGroup
+	for _, subpipeline := range subpipelines {
+		drops := subpipeline.GetDrop() // subscribe before starting the segments
+		subpipeline.StartContext(ctx)
+		outputWg.Add(1)
+		go segment.forward(subpipeline.GetOutput(), drops, &outputWg)
+	}
+
+	filter := &flowfilter.Filter{}
+	for msg := range segment.In {
+		matched := false
+		for _, switchCase := range segment.cases {
+			if match, _ := filter.CheckFlow(switchCase.expression, msg); !match {
+				continue
+			}
+			if segment.Mode == "first" {
+				switchCase.pipeline.In <- msg
+				matched = true
+				break
+			}
+			// the cases process their flows concurrently, give each its own
+			switchCase.pipeline.In <- proto.Clone(msg).(*pb.EnrichedFlow)
+			matched = true
+		}
+		if !matched {
+			segment.defaultPipeline.In <- msg
+		}
+	}
+
+	for _, subpipeline := range subpipelines {
+		subpipeline.Close()
+	}
+	outputWg.Wait()
+}
+
+// Passes on the flows leaving a subpipeline and its drops until the
+// subpipeline is closed.
+func (segment *Switch) forward(out <-chan *pb.EnrichedFlow, drops <-chan *pb.EnrichedFlow, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for out != nil {
+		select {
+		case msg, ok := <-out:
+			if !ok {
+				out = nil
+				continue
+			}
+			segment.Out <- msg
+		case msg := <-drops:
+			if segment.Drops != nil {
+				segment.Drops <- msg
+			}
+		}
+	}
+}
+
+func init() {
+	segment := &Switch{}
+	segments.RegisterSegment("switch", segment,
+		segments.Param{Name: "mode", Default: "first", Description: "whether flows are handed to the first matching case only or to all matching cases, one of first or all"},
+	)
+}
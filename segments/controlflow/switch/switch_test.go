@@ -0,0 +1,107 @@
+package switchsegment
+
+import (
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/pipeline"
+	"github.com/BelWue/flowpipeline/segments"
+
+	_ "github.com/BelWue/flowpipeline/segments/filter/drop"
+	_ "github.com/BelWue/flowpipeline/segments/modify/dropfields"
+)
+
+func TestSegment_Switch_passthrough(t *testing.T) {
+	result := segments.TestSegment("switch", map[string]string{},
+		&pb.EnrichedFlow{Proto: 6})
+	if result == nil || result.Proto != 6 {
+		t.Error("([error] Segment Switch is not passing through flows.")
+	}
+}
+
+func TestSegment_Switch_first(t *testing.T) {
+	pipeline, err := pipeline.NewFromConfig([]byte(`---
+- segment: switch
+  cases:
+  - case: proto 6
+    segments:
+    - segment: dropfields
+      config:
+        policy: drop
+        fields: InIf
+  - case: proto 6 or proto 17
+    segments:
+    - segment: dropfields
+      config:
+        policy: drop
+        fields: OutIf
+  default:
+  - segment: drop
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	drops := pipeline.GetDrop()
+	pipeline.In <- &pb.EnrichedFlow{Proto: 6, InIf: 1, OutIf: 1}
+	fmsg := <-pipeline.Out
+	if fmsg.Proto != 6 || fmsg.InIf != 0 || fmsg.OutIf != 1 {
+		t.Errorf("([error] Segment Switch did not use the first case, state is Proto %d, InIf %d, OutIf %d, should be (6, 0, 1).", fmsg.Proto, fmsg.InIf, fmsg.OutIf)
+	}
+	pipeline.In <- &pb.EnrichedFlow{Proto: 17, InIf: 1, OutIf: 1}
+	fmsg = <-pipeline.Out
+	if fmsg.Proto != 17 || fmsg.InIf != 1 || fmsg.OutIf != 0 {
+		t.Errorf("([error] Segment Switch did not use the second case, state is Proto %d, InIf %d, OutIf %d, should be (17, 1, 0).", fmsg.Proto, fmsg.InIf, fmsg.OutIf)
+	}
+	pipeline.In <- &pb.EnrichedFlow{Proto: 1}
+	if fmsg = <-drops; fmsg.Proto != 1 {
+		t.Error("([error] Segment Switch did not use the default case.")
+	}
+	pipeline.Close()
+}
+
+func TestSegment_Switch_all(t *testing.T) {
+	pipeline, err := pipeline.NewFromConfig([]byte(`---
+- segment: switch
+  config:
+    mode: all
+  cases:
+  - case: proto 6
+    segments:
+    - segment: dropfields
+      config:
+        policy: drop
+        fields: InIf
+  - case: proto 6 or proto 17
+    segments:
+    - segment: dropfields
+      config:
+        policy: drop
+        fields: OutIf
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	pipeline.In <- &pb.EnrichedFlow{Proto: 6, InIf: 1, OutIf: 1}
+	first, second := <-pipeline.Out, <-pipeline.Out
+	if first.InIf+second.InIf != 1 || first.OutIf+second.OutIf != 1 {
+		t.Error("([error] Segment Switch did not hand copies of the flow to all matching cases.")
+	}
+	pipeline.In <- &pb.EnrichedFlow{Proto: 1, InIf: 1, OutIf: 1}
+	if fmsg := <-pipeline.Out; fmsg.InIf != 1 || fmsg.OutIf != 1 {
+		t.Error("([error] Segment Switch did not pass unmatched flows without a default.")
+	}
+	pipeline.Close()
+}
+
+func TestSegment_Switch_syntax(t *testing.T) {
+	_, err := pipeline.NewFromConfig([]byte(`---
+- segment: switch
+  cases:
+  - case: protoo 6
+`))
+	if err == nil {
+		t.Error("([error] Segment Switch accepted a syntax error in a case.")
+	}
+}
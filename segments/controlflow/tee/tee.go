@@ -0,0 +1,225 @@
+// The `tee` segment sends a copy of every flow to any number of named
+// subpipelines, while passing the original flow on to the next segment
+// unchanged. This allows exporting the same flows to several outputs with
+// different processing for each, e.g. reducing fields for one export only.
+// Similar to the `branch` segment, it uses additional syntax that other
+// segments do not have access to, namely the `pipelines` key containing a list
+// of subpipelines, each consisting of a `name` and a list of `segments`.
+//
+// Any changes made to flows within a subpipeline are not visible outside of
+// it, and anything leaving a subpipeline is discarded. The `policy` parameter
+// determines what happens if a subpipeline is slower than the flows arrive:
+// * `block` (default) waits for the subpipeline, slowing down the whole pipeline
+// * `drop` queues up to `buffersize` flows and drops any further flows for this subpipeline
+// * `buffer` queues up to `buffersize` flows and waits for the subpipeline after that
+//
+// Both `policy` and `buffersize` can be overridden for each subpipeline. The
+// number of flows dropped for a subpipeline is logged on shutdown.
+//
+// ```yaml
+// - segment: tee
+//   config:
+//     policy: drop
+//   pipelines:
+//   - name: archive
+//     policy: block
+//     segments:
+//     - segment: sqlite
+//       config:
+//         filename: archive.sqlite
+//   - name: kafka
+//     segments:
+//     - segment: dropfields
+//       config:
+//         policy: keep
+//         fields: SrcAddr,DstAddr,Bytes
+//     - segment: kafkaproducer
+//       config:
+//         server: kafka.example.com:9093
+//         topic: flows
+// ```
+package tee
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/pipeline"
+	"github.com/BelWue/flowpipeline/pipeline/config"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+const (
+	policyBlock  = "block"
+	policyDrop   = "drop"
+	policyBuffer = "buffer"
+)
+
+type Tee struct {
+	segments.BaseSegment
+	Policy     string // optional, default is "block", one of "block", "drop" or "buffer"
+	BufferSize int    // optional, default is 1024, the number of flows queued for each subpipeline with policies "drop" or "buffer"
+
+	outputs []*subpipeline
+}
+
+// A single subpipeline and the queue feeding it.
+type subpipeline struct {
+	name     string
+	policy   string
+	queue    chan *pb.EnrichedFlow
+	pipeline *pipeline.Pipeline
+	dropped  atomic.Uint64
+	closed   sync.Once
+}
+
+func (output *subpipeline) close() {
+	output.closed.Do(output.pipeline.Close)
+}
+
+func (segment Tee) New(config map[string]string) segments.Segment {
+	newsegment := &Tee{Policy: policyBlock, BufferSize: 1024}
+	if config["policy"] != "" {
+		if !validPolicy(config["policy"]) {
+			log.Error().Msgf("Tee: Unknown policy '%s', must be one of 'block', 'drop' or 'buffer'.", config["policy"])
+			return nil
+		}
+		newsegment.Policy = config["policy"]
+	}
+	if config["buffersize"] != "" {
+		bufferSize, err := strconv.Atoi(config["buffersize"])
+		if err != nil || bufferSize < 0 {
+			log.Error().Msgf("Tee: Could not parse 'buffersize' parameter '%s'.", config["buffersize"])
+			return nil
+		}
+		newsegment.BufferSize = bufferSize
+	}
+	return newsegment
+}
+
+func validPolicy(policy string) bool {
+	return policy == policyBlock || policy == policyDrop || policy == policyBuffer
+}
+
+func (segment *Tee) AddCustomConfig(segmentRepr config.SegmentRepr) error {
+	if err := segment.addPipelines(segmentRepr); err != nil {
+		// release the subpipelines built before the error
+		segment.Close()
+		segment.outputs = nil
+		return err
+	}
+	return nil
+}
+
+func (segment *Tee) addPipelines(segmentRepr config.SegmentRepr) error {
+	if len(segmentRepr.Pipelines) == 0 {
+		return fmt.Errorf("no subpipelines configured in 'pipelines'")
+	}
+	names := make(map[string]bool)
+	for _, teePipeline := range segmentRepr.Pipelines {
+		if teePipeline.Name == "" {
+			return fmt.Errorf("subpipelines need a 'name'")
+		}
+		if names[teePipeline.Name] {
+			return fmt.Errorf("duplicate subpipeline name '%s'", teePipeline.Name)
+		}
+		names[teePipeline.Name] = true
+
+		policy, bufferSize := segment.Policy, segment.BufferSize
+		if teePipeline.Policy != "" {
+			if !validPolicy(teePipeline.Policy) {
+				return fmt.Errorf("pipeline '%s': unknown policy '%s', must be one of 'block', 'drop' or 'buffer'", teePipeline.Name, teePipeline.Policy)
+			}
+			policy = teePipeline.Policy
+		}
+		if teePipeline.BufferSize < 0 {
+			return fmt.Errorf("pipeline '%s': negative buffersize", teePipeline.Name)
+		} else if teePipeline.BufferSize > 0 {
+			bufferSize = teePipeline.BufferSize
+		}
+		if policy == policyBlock {
+			bufferSize = 0
+		}
+
+		subSegments, err := pipeline.SegmentsFromRepr(teePipeline.Segments)
+		if err != nil {
+			return fmt.Errorf("pipeline '%s': %w", teePipeline.Name, err)
+		}
+		segment.outputs = append(segment.outputs, &subpipeline{
+			name:     teePipeline.Name,
+			policy:   policy,
+			queue:    make(chan *pb.EnrichedFlow, bufferSize),
+			pipeline: pipeline.New(subSegments...),
+		})
+	}
+	return nil
+}
+
+func (segment *Tee) Run(ctx context.Context, wg *sync.WaitGroup) {
+	var outputWg sync.WaitGroup
+	defer func() {
+		for _, output := range segment.outputs {
+			close(output.queue)
+		}
+		outputWg.Wait()
+		close(segment.Out)
+		wg.Done()
+	}()
+
+	for _, output := range segment.outputs {
+		output.pipeline.StartContext(ctx)
+		output.pipeline.AutoDrain()
+		outputWg.Add(1)
+		go func(output *subpipeline) {
+			defer outputWg.Done()
+			for msg := range output.queue {
+				output.pipeline.In <- msg
+			}
+			output.close()
+			if dropped := output.dropped.Load(); dropped > 0 {
+				log.Warn().Msgf("Tee: Dropped %d flows for subpipeline '%s' as it was too slow.", dropped, output.name)
+			}
+		}(output)
+	}
+
+	for msg := range segment.In {
+		for _, output := range segment.outputs {
+			clone := proto.Clone(msg).(*pb.EnrichedFlow)
+			if output.policy != policyDrop {
+				output.queue <- clone
+				continue
+			}
+			select {
+			case output.queue <- clone:
+			default:
+				if output.dropped.Add(1) == 1 {
+					log.Warn().Msgf("Tee: Subpipeline '%s' is too slow, dropping flows.", output.name)
+				}
+			}
+		}
+		segment.Out <- msg
+	}
+}
+
+// Closes all subpipelines, which is done by Run already unless the segment
+// was never run.
+func (segment *Tee) Close() {
+	for _, output := range segment.outputs {
+		output.close()
+	}
+}
+
+func init() {
+	segment := &Tee{}
+	segments.RegisterSegment("tee", segment,
+		segments.Param{Name: "policy", Default: policyBlock, Description: "what to do if a subpipeline is too slow, one of block, drop or buffer"},
+		segments.Param{Name: "buffersize", Type: segments.Uint, Default: "1024", Description: "number of flows queued for each subpipeline with policies drop or buffer"},
+	)
+}
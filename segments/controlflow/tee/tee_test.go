@@ -0,0 +1,197 @@
+package tee
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/pipeline"
+	"github.com/BelWue/flowpipeline/segments"
+
+	_ "github.com/BelWue/flowpipeline/segments/modify/dropfields"
+)
+
+// Records all flows it sees in a channel, blocking if nobody reads them.
+type record struct {
+	segments.BaseSegment
+}
+
+var recorded = make(chan *pb.EnrichedFlow)
+
+func (segment record) New(config map[string]string) segments.Segment {
+	return &record{}
+}
+
+func (segment *record) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	for msg := range segment.In {
+		recorded <- msg
+		segment.Out <- msg
+	}
+}
+
+// Counts how often it was closed.
+type closing struct {
+	segments.BaseSegment
+}
+
+var closed atomic.Int32
+
+func (segment closing) New(config map[string]string) segments.Segment {
+	return &closing{}
+}
+
+func (segment *closing) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	for msg := range segment.In {
+		segment.Out <- msg
+	}
+}
+
+func (segment *closing) Close() {
+	closed.Add(1)
+}
+
+func init() {
+	segments.RegisterSegment("teetestrecord", &record{})
+	segments.RegisterSegment("teetestclosing", &closing{})
+}
+
+func TestSegment_Tee_passthrough(t *testing.T) {
+	result := segments.TestSegment("tee", map[string]string{},
+		&pb.EnrichedFlow{Proto: 6})
+	if result == nil || result.Proto != 6 {
+		t.Error("([error] Segment Tee is not passing through flows.")
+	}
+}
+
+func TestSegment_Tee_copies(t *testing.T) {
+	pipeline, err := pipeline.NewFromConfig([]byte(`---
+- segment: tee
+  pipelines:
+  - name: reduced
+    segments:
+    - segment: dropfields
+      config:
+        policy: drop
+        fields: Bytes
+    - segment: teetestrecord
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	go func() {
+		pipeline.In <- &pb.EnrichedFlow{Proto: 6, Bytes: 42}
+	}()
+	copied := <-recorded
+	if copied.Proto != 6 || copied.Bytes != 0 {
+		t.Errorf("([error] Subpipeline did not receive a processed copy, state is Proto %d, Bytes %d, should be (6, 0).", copied.Proto, copied.Bytes)
+	}
+	fmsg := <-pipeline.Out
+	if fmsg.Proto != 6 || fmsg.Bytes != 42 {
+		t.Errorf("([error] Subpipeline modified the original flow, state is Proto %d, Bytes %d, should be (6, 42).", fmsg.Proto, fmsg.Bytes)
+	}
+	pipeline.Close()
+}
+
+func TestSegment_Tee_drop(t *testing.T) {
+	pipeline, err := pipeline.NewFromConfig([]byte(`---
+- segment: tee
+  config:
+    policy: drop
+    buffersize: 2
+  pipelines:
+  - name: stuck
+    segments:
+    - segment: teetestrecord
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	// nobody reads the recorded flows, the subpipeline must not block the pipeline
+	for i := 0; i < 10; i++ {
+		pipeline.In <- &pb.EnrichedFlow{Proto: 6}
+		<-pipeline.Out
+	}
+	tee := pipeline.SegmentList[0].(*Tee)
+	if dropped := tee.outputs[0].dropped.Load(); dropped == 0 || dropped > 8 {
+		t.Errorf("([error] Segment Tee dropped %d flows, should be between 1 and 8.", dropped)
+	}
+	closed := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-recorded:
+			case <-closed:
+				return
+			}
+		}
+	}()
+	pipeline.Close()
+	close(closed)
+}
+
+func TestSegment_Tee_config(t *testing.T) {
+	for _, config := range []string{`---
+- segment: tee
+`, `---
+- segment: tee
+  pipelines:
+  - name: a
+  - name: a
+`, `---
+- segment: tee
+  pipelines:
+  - name: a
+    policy: sometimes
+`} {
+		if _, err := pipeline.NewFromConfig([]byte(config)); err == nil {
+			t.Errorf("([error] Invalid tee config was accepted:\n%s", config)
+		}
+	}
+}
+
+func TestSegment_Tee_close(t *testing.T) {
+	closed.Store(0)
+	_, err := pipeline.NewFromConfig([]byte(`---
+- segment: tee
+  pipelines:
+  - name: a
+    segments:
+    - segment: teetestclosing
+  - name: b
+    policy: sometimes
+`))
+	if err == nil {
+		t.Fatal("([error] Invalid tee config was accepted.")
+	}
+	if closed.Load() != 1 {
+		t.Error("([error] Segment Tee did not close the subpipelines built before failing.")
+	}
+
+	closed.Store(0)
+	pipe, err := pipeline.NewFromConfig([]byte(`---
+- segment: tee
+  pipelines:
+  - name: a
+    segments:
+    - segment: teetestclosing
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe.Close()
+	if closed.Load() != 1 {
+		t.Error("([error] Segment Tee did not close its subpipelines without being run.")
+	}
+}
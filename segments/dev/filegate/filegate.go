@@ -3,6 +3,7 @@
 package filegate
 
 import (
+	"context"
 	"errors"
 	"os"
 	"sync"
@@ -26,7 +27,8 @@ func (segment *Filegate) New(config map[string]string) segments.Segment {
 		segment.filename = config["filename"]
 		log.Info().Msgf("Filegate: gate file is %s", segment.filename)
 	} else {
-		log.Fatal().Msgf("Filegate: No filename config option")
+		log.Error().Msgf("Filegate: No filename config option")
+		return nil
 	}
 	// do config stuff here, add it to fields maybe
 	return segment
@@ -41,7 +43,7 @@ func checkFileExists(filename string) bool {
 	return true
 }
 
-func (segment *Filegate) Run(wg *sync.WaitGroup) {
+func (segment *Filegate) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		// This defer clause is important and needs to be present in
 		// any Segment.Run method in some form, but with at least the
@@ -60,5 +62,7 @@ func (segment *Filegate) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Filegate{}
-	segments.RegisterSegment("filegate", segment)
+	segments.RegisterSegment("filegate", segment,
+		segments.Param{Name: "filename", Required: true, Description: "flows are held back as long as this file exists"},
+	)
 }
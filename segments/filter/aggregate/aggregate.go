@@ -1,44 +1,225 @@
+// The `aggregate` segment merges flows with the same key into a single flow,
+// which condenses sampled flows from several routers before expensive outputs
+// or analyses. Unlike other filters, it does not pass on the flows it
+// receives, but only the merged flows.
+//
+// The `key` parameter is a comma-separated list of flow fields, by default the
+// 5-tuple. `SrcAddr` and `DstAddr` may be followed by a prefix length, such as
+// `SrcAddr/24` or `SrcAddr/24/64` to use different lengths for IPv4 and IPv6,
+// in which case addresses are aggregated by their prefix, and the merged flows
+// contain the prefix in `SrcAddr` and its length in `SrcNet`. For instance,
+// `Cid,Proto` results in a flow per customer and protocol.
+//
+// Merged flows are exported once no flow of their key has been received for
+// `inactivetimeout`, or after `activetimeout` since their first flow has been
+// received, and when the pipeline is shut down. Their `Bytes` and `Packets`
+// are summed, their `TcpFlags` are ORed and their timestamps span all of their
+// flows. Any other field is kept only if it is the same in all of their flows.
+// As `SamplingRate` is no exception, flows sampled at different rates should
+// be normalized using the `normalize` segment first.
 package aggregate
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
 )
 
+// The fields merged specifically by mergeFlow, all other fields are cleared
+// unless they are the same in all flows.
+var mergedFields = map[string]bool{
+	"Bytes": true, "Packets": true, "TcpFlags": true,
+	"TimeReceived": true, "TimeReceivedNs": true,
+	"TimeFlowStart": true, "TimeFlowStartMs": true, "TimeFlowStartNs": true,
+	"TimeFlowEnd": true, "TimeFlowEndMs": true, "TimeFlowEndNs": true,
+}
+
+// The indices of all fields to clear unless they are the same in all flows.
+var comparedFields []int
+
+func init() {
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(pb.EnrichedFlow{})) {
+		if field.IsExported() && !mergedFields[field.Name] {
+			comparedFields = append(comparedFields, field.Index[0])
+		}
+	}
+}
+
 type Aggregate struct {
 	segments.BaseSegment
+	Key             string // optional, default is the 5-tuple, comma-separated list of fields
+	ActiveTimeout   string // optional, default is 1m
+	InactiveTimeout string // optional, default is 15s
+
+	fields  []keyField
+	masks   [2][2]net.IPMask // for SrcAddr and DstAddr, IPv4 and IPv6 each
+	lengths [2][2]uint32
+	cache   *FlowExporter
+}
 
-	cache FlowExporter
+type keyField struct {
+	name  string
+	index []int
 }
 
 func (segment Aggregate) New(config map[string]string) segments.Segment {
-	return &Aggregate{cache: FlowExporter{}}
+	newsegment := &Aggregate{
+		Key:             config["key"],
+		ActiveTimeout:   config["activetimeout"],
+		InactiveTimeout: config["inactivetimeout"],
+	}
+	if newsegment.Key == "" {
+		newsegment.Key = "SrcAddr,DstAddr,SrcPort,DstPort,Proto"
+	}
+	if newsegment.ActiveTimeout == "" {
+		newsegment.ActiveTimeout = "1m"
+	}
+	if newsegment.InactiveTimeout == "" {
+		newsegment.InactiveTimeout = "15s"
+	}
+
+	for _, field := range strings.Split(newsegment.Key, ",") {
+		name, lengths, hasPrefix := strings.Cut(strings.TrimSpace(field), "/")
+		structField, ok := reflect.TypeOf(pb.EnrichedFlow{}).FieldByName(name)
+		if !ok || !structField.IsExported() {
+			log.Error().Msgf("Aggregate: Unknown field '%s' in 'key' parameter.", name)
+			return nil
+		}
+		newsegment.fields = append(newsegment.fields, keyField{name: name, index: structField.Index})
+		if !hasPrefix {
+			continue
+		}
+		var address int
+		switch name {
+		case "SrcAddr":
+			address = 0
+		case "DstAddr":
+			address = 1
+		default:
+			log.Error().Msgf("Aggregate: Prefix lengths are only supported for SrcAddr and DstAddr, not '%s'.", name)
+			return nil
+		}
+		if err := newsegment.setPrefix(address, lengths); err != nil {
+			log.Error().Err(err).Msgf("Aggregate: Invalid prefix length in 'key' parameter for %s: ", name)
+			return nil
+		}
+	}
+
+	cache, err := NewFlowExporter(newsegment.ActiveTimeout, newsegment.InactiveTimeout)
+	if err != nil {
+		log.Error().Err(err).Msg("Aggregate: Could not parse timeouts: ")
+		return nil
+	}
+	if cache.activeTimeout <= 0 || cache.inactiveTimeout <= 0 {
+		log.Error().Msg("Aggregate: Timeouts have to be positive durations.")
+		return nil
+	}
+	cache.flowKey = newsegment.flowKey
+	newsegment.cache = cache
+	return newsegment
+}
+
+// Sets the masks of an address from prefix lengths such as `24` or `24/64`. A
+// single length applies to both IPv4 and IPv6, limited to the address length.
+func (segment *Aggregate) setPrefix(address int, lengths string) error {
+	v4, v6, found := strings.Cut(lengths, "/")
+	if !found {
+		v6 = v4
+	}
+	for family, length := range []string{v4, v6} {
+		bits := 32 + 96*family
+		parsed, err := strconv.Atoi(length)
+		if err != nil || parsed < 0 || (found && parsed > bits) {
+			return fmt.Errorf("'%s' is not a prefix length", length)
+		}
+		parsed = min(parsed, bits)
+		segment.masks[address][family] = net.CIDRMask(parsed, bits)
+		segment.lengths[address][family] = uint32(parsed)
+	}
+	return nil
+}
+
+// Replaces the addresses of a flow by their prefixes, if configured.
+func (segment *Aggregate) mask(flow *pb.EnrichedFlow) {
+	for address, field := range []*[]byte{&flow.SrcAddr, &flow.DstAddr} {
+		ip := net.IP(*field)
+		family := 1
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, family = ip4, 0
+		}
+		mask := segment.masks[address][family]
+		if mask == nil || len(ip) != len(mask) {
+			continue
+		}
+		*field = ip.Mask(mask)
+		if address == 0 {
+			flow.SrcNet = segment.lengths[address][family]
+		} else {
+			flow.DstNet = segment.lengths[address][family]
+		}
+	}
+}
+
+// Returns the key of a flow, consisting of the values of the configured fields.
+func (segment *Aggregate) flowKey(flow *pb.EnrichedFlow) FlowKey {
+	value := reflect.ValueOf(flow).Elem()
+	var key []byte
+	for _, field := range segment.fields {
+		key = fmt.Appendf(key, "%v\x00", value.FieldByIndex(field.index).Interface())
+	}
+	return FlowKey{Fields: string(key)}
 }
 
-func (segment *Aggregate) Run(wg *sync.WaitGroup) {
+// Merges a flow into the flow merged so far, see the package documentation.
+func mergeFlow(msg *pb.EnrichedFlow, flow *pb.EnrichedFlow) {
+	msg.Bytes += flow.Bytes
+	msg.Packets += flow.Packets
+	msg.TcpFlags |= flow.TcpFlags
+	msg.SpanTimeStamps(flow)
+
+	merged, value := reflect.ValueOf(msg).Elem(), reflect.ValueOf(flow).Elem()
+	for _, i := range comparedFields {
+		if field := merged.Field(i); !field.IsZero() && !reflect.DeepEqual(field.Interface(), value.Field(i).Interface()) {
+			field.SetZero()
+		}
+	}
+}
+
+func (segment *Aggregate) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
 	}()
 
-	for {
-		select {
-		case msg, ok := <-segment.In:
-			if !ok {
-				return
-			}
+	segment.cache.Start(nil, nil)
+	go func() {
+		for msg := range segment.In {
+			segment.mask(msg)
 			segment.cache.InsertFlow(msg)
-		case msg, ok := <-segment.cache.Flows:
-			if !ok {
-				return
-			}
-			segment.Out <- msg
 		}
+		segment.cache.Stop()
+		segment.cache.ExportAll()
+		close(segment.cache.Flows)
+	}()
+	for msg := range segment.cache.Flows {
+		segment.Out <- msg
 	}
 }
 
 func init() {
 	segment := &Aggregate{}
-	segments.RegisterSegment("aggregate", segment)
+	segments.RegisterSegment("aggregate", segment,
+		segments.Param{Name: "key", Default: "SrcAddr,DstAddr,SrcPort,DstPort,Proto", Description: "comma-separated list of fields to merge flows by, SrcAddr and DstAddr may be followed by prefix lengths such as /24 or /24/64"},
+		segments.Param{Name: "activetimeout", Type: segments.Duration, Default: "1m", Description: "duration after the first flow after which merged flows are exported"},
+		segments.Param{Name: "inactivetimeout", Type: segments.Duration, Default: "15s", Description: "duration without flows after which merged flows are exported"},
+	)
 }
@@ -0,0 +1,131 @@
+package aggregate
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Aggregate Segment test, passthrough test
+func TestSegment_Aggregate_passthrough(t *testing.T) {
+	result := segments.TestSegment("aggregate", map[string]string{},
+		&pb.EnrichedFlow{Type: 3})
+
+	if result.Type != 3 {
+		t.Error("([error] Segment Aggregate is not working.")
+	}
+}
+
+// Aggregate Segment test, merges flows by the 5-tuple
+func TestSegment_Aggregate_merge(t *testing.T) {
+	flow := func(srcPort uint32, start uint64, end uint64, flags uint32, sampler string) *pb.EnrichedFlow {
+		return &pb.EnrichedFlow{
+			SrcAddr: net.ParseIP("192.0.2.1").To4(), DstAddr: net.ParseIP("198.51.100.1").To4(),
+			SrcPort: srcPort, DstPort: 443, Proto: 6,
+			Bytes: 1000, Packets: 2, TcpFlags: flags, SamplingRate: 32,
+			TimeFlowStartNs: start, TimeFlowEndNs: end,
+			SamplerAddress: net.ParseIP(sampler).To4(),
+		}
+	}
+	merged, _ := segments.TestSegmentFlows("aggregate", map[string]string{}, []*pb.EnrichedFlow{
+		flow(1000, 20, 30, 0x02, "192.0.2.254"),
+		flow(2000, 10, 20, 0x10, "192.0.2.254"),
+		flow(1000, 10, 25, 0x10, "192.0.2.253"),
+		flow(1000, 30, 40, 0x01, "192.0.2.254"),
+	})
+	if len(merged) != 2 {
+		t.Fatalf("([error] Segment Aggregate exported %d flows, expected 2.", len(merged))
+	}
+	for _, flow := range merged {
+		if flow.SrcPort != 1000 {
+			continue
+		}
+		if flow.Bytes != 3000 || flow.Packets != 6 || flow.TcpFlags != 0x13 {
+			t.Errorf("([error] Segment Aggregate did not merge the counters: %v", flow)
+		}
+		if flow.TimeFlowStartNs != 10 || flow.TimeFlowEndNs != 40 {
+			t.Errorf("([error] Segment Aggregate did not merge the timestamps: %v", flow)
+		}
+		if flow.SamplerAddress != nil || flow.SamplingRate != 32 || flow.DstPort != 443 {
+			t.Errorf("([error] Segment Aggregate did not merge the other fields: %v", flow)
+		}
+	}
+}
+
+// Aggregate Segment test, merges flows by prefix and by other fields
+func TestSegment_Aggregate_key(t *testing.T) {
+	flows := []*pb.EnrichedFlow{
+		{SrcAddr: net.ParseIP("192.0.2.1"), Bytes: 1, Cid: 1, Proto: 6},
+		{SrcAddr: net.ParseIP("192.0.2.200").To4(), Bytes: 2, Cid: 1, Proto: 17},
+		{SrcAddr: net.ParseIP("2001:db8:0:1::1"), Bytes: 4, Cid: 2, Proto: 6},
+		{SrcAddr: net.ParseIP("2001:db8:0:2::1"), Bytes: 8, Cid: 2, Proto: 6},
+	}
+	merged, _ := segments.TestSegmentFlows("aggregate", map[string]string{"key": "SrcAddr/24/48"}, flows)
+	if len(merged) != 2 {
+		t.Fatalf("([error] Segment Aggregate exported %d flows by prefix, expected 2.", len(merged))
+	}
+	for _, flow := range merged {
+		switch net.IP(flow.SrcAddr).String() {
+		case "192.0.2.0":
+			if flow.Bytes != 3 || flow.SrcNet != 24 {
+				t.Errorf("([error] Segment Aggregate merged the IPv4 prefix incorrectly: %v", flow)
+			}
+		case "2001:db8::":
+			if flow.Bytes != 12 || flow.SrcNet != 48 {
+				t.Errorf("([error] Segment Aggregate merged the IPv6 prefix incorrectly: %v", flow)
+			}
+		default:
+			t.Errorf("([error] Segment Aggregate exported an unexpected prefix: %v", flow)
+		}
+	}
+
+	flows = []*pb.EnrichedFlow{
+		{Bytes: 1, Cid: 1, Proto: 6},
+		{Bytes: 2, Cid: 1, Proto: 17},
+		{Bytes: 4, Cid: 2, Proto: 6},
+		{Bytes: 8, Cid: 1, Proto: 6},
+	}
+	merged, _ = segments.TestSegmentFlows("aggregate", map[string]string{"key": "Cid,Proto"}, flows)
+	if len(merged) != 3 {
+		t.Fatalf("([error] Segment Aggregate exported %d flows by Cid and Proto, expected 3.", len(merged))
+	}
+
+	for _, key := range []string{"Nonexistent", "Proto/24", "SrcAddr/33/64"} {
+		if (Aggregate{}).New(map[string]string{"key": key}) != nil {
+			t.Errorf("([error] Segment Aggregate accepted the key '%s'.", key)
+		}
+	}
+}
+
+// Aggregate Segment test, exports merged flows after the inactive timeout
+func TestSegment_Aggregate_timeout(t *testing.T) {
+	segment := Aggregate{}.New(map[string]string{"inactivetimeout": "50ms"})
+	if segment == nil {
+		t.Fatal("([error] Segment Aggregate failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+
+	in <- &pb.EnrichedFlow{Proto: 6, Bytes: 1}
+	in <- &pb.EnrichedFlow{Proto: 6, Bytes: 2}
+	select {
+	case flow := <-out:
+		if flow.Bytes != 3 {
+			t.Errorf("([error] Segment Aggregate exported %d bytes, expected 3.", flow.Bytes)
+		}
+	case <-time.After(time.Second):
+		t.Error("([error] Segment Aggregate did not export after the inactive timeout.")
+	}
+	close(in)
+	for range out {
+	}
+	wg.Wait()
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,7 +13,6 @@ import (
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"google.golang.org/protobuf/proto"
 )
 
 type FlowKey struct {
@@ -23,6 +23,7 @@ type FlowKey struct {
 	Proto   uint32
 	IPTos   uint8
 	InIface uint32
+	Fields  string // the key fields selected in the aggregate segment, used instead of the above
 }
 
 func NewFlowKeyFromFlow(flow *pb.EnrichedFlow) FlowKey {
@@ -73,16 +74,22 @@ type FlowRecord struct {
 	SamplerAddress  net.IP
 	HardwareAddress net.HardwareAddr
 	Packets         []gopacket.Packet
-	Flows           []*pb.EnrichedFlow
+	Flow            *pb.EnrichedFlow // all flows inserted so far merged into one, see InsertFlow
 }
 
 func BuildFlow(f *FlowRecord) *pb.EnrichedFlow {
+	if len(f.Packets) == 0 && f.Flow != nil {
+		return f.Flow
+	}
 	msg := &pb.EnrichedFlow{}
 	msg.Type = pb.EnrichedFlow_EBPF
 	msg.SamplerAddress = f.SamplerAddress
 	msg.TimeReceived = uint64(f.TimeReceived.UnixNano())
 	msg.TimeFlowStart = uint64(f.TimeReceived.UnixNano())
 	msg.TimeFlowEnd = uint64(f.LastUpdated.UnixNano())
+	msg.TimeReceivedNs = uint64(f.TimeReceived.UnixNano())
+	msg.TimeFlowStartNs = uint64(f.TimeReceived.UnixNano())
+	msg.TimeFlowEndNs = uint64(f.LastUpdated.UnixNano())
 	for i, pkt := range f.Packets {
 		if i == 0 {
 			msg.InIf = uint32(pkt.Metadata().InterfaceIndex)
@@ -155,16 +162,6 @@ func BuildFlow(f *FlowRecord) *pb.EnrichedFlow {
 		msg.Bytes += uint64(pkt.Metadata().Length)
 		msg.Packets += 1
 	}
-	for _, flow := range f.Flows {
-		proto.Merge(msg, flow)
-		// TODO: how to do this without custom behaviour for each field?
-		// TimeFlowStart: use earlier
-		// TimeFlowEnd: use later
-		// TimeReceived: use earlier
-		// Bytes: add, considering sampling rate
-		// Packets: add, considering sampling rate
-		// copy the rest?
-	}
 	return msg
 }
 
@@ -173,12 +170,17 @@ type FlowExporter struct {
 	inactiveTimeout time.Duration
 	samplerAddress  net.IP
 	hardwareAddress net.HardwareAddr
+	flowKey         func(*pb.EnrichedFlow) FlowKey // used by InsertFlow, default is NewFlowKeyFromFlow
 
 	Flows chan *pb.EnrichedFlow
 
 	mutex *sync.RWMutex
 	stop  chan bool
 	cache map[FlowKey]*FlowRecord
+
+	virtual bool      // whether the clock is driven by packet timestamps, see StartVirtual
+	now     time.Time // the virtual clock
+	next    time.Time // the next time the virtual clock expires flows
 }
 
 func NewFlowExporter(activeTimeout string, inactiveTimeout string) (*FlowExporter, error) {
@@ -210,6 +212,21 @@ func (f *FlowExporter) Start(samplerAddress net.IP, hardwareAddress net.Hardware
 	go f.exportActive()
 }
 
+// Starts the export using a virtual clock driven by the timestamps of the
+// inserted packets instead of the wall clock, which is used when reading
+// captured packets from files. Flows are expired by the same timeouts relative
+// to the packet timestamps, and the exported flows only depend on the inserted
+// packets. No goroutines are started, flows are exported by Insert and by
+// ExportAll, which has to be called once all packets have been inserted.
+func (f *FlowExporter) StartVirtual(samplerAddress net.IP, hardwareAddress net.HardwareAddr) {
+	log.Info().Msg("FlowExporter: Starting export using packet timestamps.")
+
+	f.samplerAddress = samplerAddress
+	f.hardwareAddress = hardwareAddress
+	f.stop = make(chan bool)
+	f.virtual = true
+}
+
 func (f *FlowExporter) Stop() {
 	log.Info().Msg("FlowExporter: Stopping export goroutines.")
 	close(f.stop)
@@ -264,6 +281,9 @@ func (f *FlowExporter) Insert(pkt gopacket.Packet) {
 	var exists bool
 
 	f.mutex.Lock()
+	if f.virtual {
+		f.advance(pkt.Metadata().Timestamp)
+	}
 	if record, exists = f.cache[key]; !exists {
 		f.cache[key] = new(FlowRecord)
 		f.cache[key].TimeReceived = pkt.Metadata().Timestamp
@@ -283,21 +303,37 @@ func (f *FlowExporter) Insert(pkt gopacket.Packet) {
 	f.mutex.Unlock()
 }
 
+// Inserts a flow, which is merged with all other flows of the same key. The
+// flows are merged as they are inserted, so that only a single flow is kept
+// per key. As flows usually arrive well after they ended, the timeouts apply
+// to the time they were inserted.
 func (f *FlowExporter) InsertFlow(flow *pb.EnrichedFlow) {
-	key := NewFlowKeyFromFlow(flow)
+	var key FlowKey
+	if f.flowKey != nil {
+		key = f.flowKey(flow)
+	} else {
+		key = NewFlowKeyFromFlow(flow)
+	}
 
 	var record *FlowRecord
 	var exists bool
 
+	now := time.Now()
 	f.mutex.Lock()
 	if record, exists = f.cache[key]; !exists {
 		f.cache[key] = new(FlowRecord)
-		f.cache[key].TimeReceived = time.Unix(int64(flow.TimeFlowStart), 0)
+		f.cache[key].TimeReceived = now
 		record = f.cache[key]
 	}
-	record.LastUpdated = time.Unix(int64(flow.TimeFlowEnd), 0)
+	record.LastUpdated = now
 	record.SamplerAddress = f.samplerAddress
-	record.Flows = append(record.Flows, flow)
+	if record.Flow == nil {
+		flow.SyncMissingTimeStamps()
+		record.Flow = flow
+	} else {
+		mergeFlow(record.Flow, flow)
+	}
+	f.mutex.Unlock()
 }
 
 func (f *FlowExporter) ConsumeFrom(pkts chan gopacket.Packet) {
@@ -314,6 +350,86 @@ func (f *FlowExporter) ConsumeFrom(pkts chan gopacket.Packet) {
 	}
 }
 
+// Advances the virtual clock, expiring flows whenever it passes a multiple of
+// the inactive timeout, just like the tickers of the wall clock export do.
+// The mutex has to be held.
+func (f *FlowExporter) advance(now time.Time) {
+	if !now.After(f.now) {
+		return // packets are not necessarily ordered
+	}
+	f.now = now
+	if f.next.IsZero() {
+		f.next = now.Add(f.inactiveTimeout)
+		return
+	}
+	for !now.Before(f.next) {
+		f.expire(f.next)
+		if len(f.cache) == 0 {
+			// skip any gaps between packets
+			f.next = f.next.Add((now.Sub(f.next)/f.inactiveTimeout + 1) * f.inactiveTimeout)
+		} else {
+			f.next = f.next.Add(f.inactiveTimeout)
+		}
+	}
+}
+
+// Exports all flows which exceeded a timeout at the given time, ordered by
+// their start. The mutex has to be held.
+func (f *FlowExporter) expire(now time.Time) {
+	var keys []FlowKey
+	for key, record := range f.cache {
+		if now.Sub(record.LastUpdated) > f.inactiveTimeout || now.Sub(record.TimeReceived) > f.activeTimeout {
+			keys = append(keys, key)
+		}
+	}
+	f.exportOrdered(keys)
+}
+
+// Exports all flows remaining in the cache, ordered by their start. This is
+// used at the end of the packets inserted using a virtual clock.
+func (f *FlowExporter) ExportAll() {
+	f.mutex.Lock()
+	keys := make([]FlowKey, 0, len(f.cache))
+	for key := range f.cache {
+		keys = append(keys, key)
+	}
+	f.exportOrdered(keys)
+	f.mutex.Unlock()
+}
+
+func (f *FlowExporter) exportOrdered(keys []FlowKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := f.cache[keys[i]], f.cache[keys[j]]
+		if !a.TimeReceived.Equal(b.TimeReceived) {
+			return a.TimeReceived.Before(b.TimeReceived)
+		}
+		return keys[i].less(keys[j])
+	})
+	for _, key := range keys {
+		f.export(key)
+	}
+}
+
+func (k FlowKey) less(o FlowKey) bool {
+	switch {
+	case k.SrcAddr != o.SrcAddr:
+		return k.SrcAddr < o.SrcAddr
+	case k.DstAddr != o.DstAddr:
+		return k.DstAddr < o.DstAddr
+	case k.SrcPort != o.SrcPort:
+		return k.SrcPort < o.SrcPort
+	case k.DstPort != o.DstPort:
+		return k.DstPort < o.DstPort
+	case k.Proto != o.Proto:
+		return k.Proto < o.Proto
+	case k.IPTos != o.IPTos:
+		return k.IPTos < o.IPTos
+	case k.InIface != o.InIface:
+		return k.InIface < o.InIface
+	}
+	return k.Fields < o.Fields
+}
+
 func (f *FlowExporter) export(key FlowKey) {
 	flowRecord := f.cache[key]
 	delete(f.cache, key)
@@ -0,0 +1,216 @@
+// The `biflow` segment stitches the flows of both directions of a connection
+// into a single bidirectional flow, as exporters usually export each direction
+// as a separate flow.
+//
+// Flows are matched by their 5-tuple, with the addresses and ports of the
+// reverse direction swapped, if they are received within `timeout` of each
+// other. Further flows of the same direction received before a match are
+// merged into the first. These flows are neither passed on nor dropped, just
+// like the reverse flows stitched into biflows, but their number is logged
+// when the segment terminates. The resulting biflow contains the direction of the
+// initiator of the connection, with the counters of the other direction in
+// `ReverseBytes`, `ReversePackets` and `ReverseTcpFlags`, and timestamps
+// spanning both directions. The initiator is the direction which started
+// first, or if both started at the same time, the one with the higher source
+// port, as clients usually use ephemeral ports. `BiFlowDirection` is set to 1
+// (initiator) if the initiator could be determined, or to 0 (arbitrary)
+// otherwise.
+//
+// Flows which were not matched within `timeout` are passed on as they are if
+// `unmatched` is set to `emit`, which is the default, or dropped if it is set
+// to `drop`.
+package biflow
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Biflow struct {
+	segments.BaseFilterSegment
+	Timeout       time.Duration // optional, default is 10s
+	DropUnmatched bool          // optional, default is false, whether to drop flows without a reverse flow instead of passing them on
+
+	pending map[connection]*half // flows waiting for their reverse flow
+	queue   []*half              // ordered by their first flow
+	merged  int                  // number of flows merged into pending flows of the same direction
+}
+
+type endpoint struct {
+	addr netip.Addr
+	port uint32
+}
+
+// The endpoints of a connection are ordered, so both directions have the same
+// connection.
+type connection struct {
+	a, b  endpoint
+	proto uint32
+}
+
+// A flow waiting for its reverse flow.
+type half struct {
+	connection connection
+	src        endpoint
+	deadline   time.Time
+	flow       *pb.EnrichedFlow
+	matched    bool
+}
+
+func newEndpoint(addr []byte, port uint32) endpoint {
+	address, _ := netip.AddrFromSlice(addr)
+	return endpoint{addr: address.Unmap(), port: port}
+}
+
+func (e endpoint) less(o endpoint) bool {
+	if c := e.addr.Compare(o.addr); c != 0 {
+		return c < 0
+	}
+	return e.port < o.port
+}
+
+func (segment Biflow) New(config map[string]string) segments.Segment {
+	newsegment := &Biflow{
+		Timeout: 10 * time.Second,
+		pending: make(map[connection]*half),
+	}
+	if config["timeout"] != "" {
+		timeout, err := time.ParseDuration(config["timeout"])
+		if err != nil || timeout <= 0 {
+			log.Error().Err(err).Msg("Biflow: Could not parse 'timeout' parameter, it has to be a positive duration: ")
+			return nil
+		}
+		newsegment.Timeout = timeout
+	}
+	switch config["unmatched"] {
+	case "", "emit":
+	case "drop":
+		newsegment.DropUnmatched = true
+	default:
+		log.Error().Msgf("Biflow: Unknown value '%s' of 'unmatched' parameter, it has to be 'emit' or 'drop'.", config["unmatched"])
+		return nil
+	}
+	return newsegment
+}
+
+func (segment *Biflow) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		for len(segment.queue) > 0 {
+			segment.expire()
+		}
+		if segment.merged > 0 {
+			log.Info().Msgf("Biflow: Merged %d flows into earlier flows of the same direction.", segment.merged)
+		}
+		close(segment.Out)
+		wg.Done()
+	}()
+
+	ticker := time.NewTicker(segments.ExpiryInterval(segment.Timeout))
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.insert(msg)
+		case now := <-ticker.C:
+			for len(segment.queue) > 0 && (segment.queue[0].matched || !now.Before(segment.queue[0].deadline)) {
+				segment.expire()
+			}
+		}
+	}
+}
+
+// Stitches a flow to its pending reverse flow, or keeps it pending.
+func (segment *Biflow) insert(msg *pb.EnrichedFlow) {
+	msg.SyncMissingTimeStamps()
+	src, dst := newEndpoint(msg.SrcAddr, msg.SrcPort), newEndpoint(msg.DstAddr, msg.DstPort)
+	conn := connection{a: src, b: dst, proto: msg.Proto}
+	if dst.less(src) {
+		conn.a, conn.b = dst, src
+	}
+
+	if h, ok := segment.pending[conn]; ok {
+		if h.src == src && src != dst {
+			merge(h.flow, msg)
+			segment.merged++
+			return
+		}
+		h.matched = true
+		delete(segment.pending, conn)
+		segment.Out <- stitch(h.flow, msg)
+		return
+	}
+	h := &half{connection: conn, src: src, deadline: time.Now().Add(segment.Timeout), flow: msg}
+	segment.pending[conn] = h
+	segment.queue = append(segment.queue, h)
+}
+
+// Removes the oldest pending flow, passing it on or dropping it if it has not
+// been matched.
+func (segment *Biflow) expire() {
+	h := segment.queue[0]
+	segment.queue[0] = nil
+	segment.queue = segment.queue[1:]
+	if h.matched {
+		return
+	}
+	delete(segment.pending, h.connection)
+	if !segment.DropUnmatched {
+		segment.Out <- h.flow
+	} else if segment.Drops != nil {
+		segment.Drops <- h.flow
+	}
+}
+
+// Merges another flow of the same direction into a pending flow.
+func merge(flow *pb.EnrichedFlow, other *pb.EnrichedFlow) {
+	flow.Bytes += other.Bytes
+	flow.Packets += other.Packets
+	flow.TcpFlags |= other.TcpFlags
+	flow.SpanTimeStamps(other)
+}
+
+// Returns the biflow of two flows of opposite directions.
+func stitch(a *pb.EnrichedFlow, b *pb.EnrichedFlow) *pb.EnrichedFlow {
+	forward, reverse := a, b
+	known := true
+	switch {
+	case a.TimeFlowStartNs != b.TimeFlowStartNs && a.TimeFlowStartNs != 0 && b.TimeFlowStartNs != 0:
+		if b.TimeFlowStartNs < a.TimeFlowStartNs {
+			forward, reverse = b, a
+		}
+	case a.SrcPort != b.SrcPort:
+		if b.SrcPort > a.SrcPort {
+			forward, reverse = b, a
+		}
+	default:
+		known = false
+	}
+
+	forward.ReverseBytes = reverse.Bytes
+	forward.ReversePackets = reverse.Packets
+	forward.ReverseTcpFlags = reverse.TcpFlags
+	forward.SpanTimeStamps(reverse)
+	forward.BiFlowDirection = 0 // arbitrary
+	if known {
+		forward.BiFlowDirection = 1 // initiator
+	}
+	return forward
+}
+
+func init() {
+	segment := &Biflow{}
+	segments.RegisterSegment("biflow", segment,
+		segments.Param{Name: "timeout", Type: segments.Duration, Default: "10s", Description: "duration within which the flows of both directions are matched"},
+		segments.Param{Name: "unmatched", Default: "emit", Description: "either \"emit\" or \"drop\" flows without a reverse flow"},
+	)
+}
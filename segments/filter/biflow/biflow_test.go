@@ -0,0 +1,85 @@
+package biflow
+
+import (
+	"net"
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Biflow Segment test, passthrough test
+func TestSegment_Biflow_passthrough(t *testing.T) {
+	result := segments.TestSegment("biflow", map[string]string{},
+		&pb.EnrichedFlow{Type: 3})
+
+	if result.Type != 3 {
+		t.Error("([error] Segment Biflow is not working.")
+	}
+}
+
+func flow(src string, srcPort uint32, dst string, dstPort uint32, start uint64, bytes uint64, flags uint32) *pb.EnrichedFlow {
+	return &pb.EnrichedFlow{
+		SrcAddr: net.ParseIP(src), SrcPort: srcPort,
+		DstAddr: net.ParseIP(dst), DstPort: dstPort,
+		Proto: 6, TimeFlowStartNs: start, TimeFlowEndNs: start + 100,
+		Bytes: bytes, Packets: 1, TcpFlags: flags,
+	}
+}
+
+// Biflow Segment test, stitches both directions
+func TestSegment_Biflow_stitch(t *testing.T) {
+	flows := []*pb.EnrichedFlow{
+		// the response is received first, but started later
+		flow("198.51.100.1", 443, "192.0.2.1", 50000, 2000, 5000, 0x12),
+		flow("192.0.2.1", 50000, "198.51.100.1", 443, 1000, 300, 0x02),
+		// started at the same time, the client uses an ephemeral port
+		flow("2001:db8::1", 40000, "2001:db8::2", 22, 1000, 100, 0x18),
+		flow("2001:db8::2", 22, "2001:db8::1", 40000, 1000, 200, 0x18),
+		flow("2001:db8::2", 22, "2001:db8::1", 40000, 1500, 300, 0x01),
+		// unmatched
+		flow("192.0.2.1", 50001, "198.51.100.1", 443, 1000, 300, 0x02),
+	}
+	result, _ := segments.TestSegmentFlows("biflow", map[string]string{}, flows)
+	if len(result) != 4 {
+		t.Fatalf("([error] Segment Biflow emitted %d flows, expected 4.", len(result))
+	}
+	web := result[0]
+	if web.SrcPort != 50000 || web.Bytes != 300 || web.ReverseBytes != 5000 || web.ReversePackets != 1 || web.ReverseTcpFlags != 0x12 || web.BiFlowDirection != 1 {
+		t.Errorf("([error] Segment Biflow did not stitch the flows by their start: %v", web)
+	}
+	if web.TimeFlowStartNs != 1000 || web.TimeFlowEndNs != 2100 {
+		t.Errorf("([error] Segment Biflow did not span the timestamps of both flows: %v", web)
+	}
+	ssh := result[1]
+	if ssh.SrcPort != 40000 || ssh.Bytes != 100 || ssh.ReverseBytes != 200 || ssh.BiFlowDirection != 1 {
+		t.Errorf("([error] Segment Biflow did not stitch the flows by their ports: %v", ssh)
+	}
+	if result[2].SrcPort != 22 || result[2].Bytes != 300 || result[2].ReverseBytes != 0 {
+		t.Errorf("([error] Segment Biflow did not pass on a second flow of a stitched connection: %v", result[2])
+	}
+	if result[3].SrcPort != 50001 || result[3].BiFlowDirection != 0 {
+		t.Errorf("([error] Segment Biflow did not pass on an unmatched flow: %v", result[3])
+	}
+
+	result, dropped := segments.TestSegmentFlows("biflow", map[string]string{"unmatched": "drop"}, flows)
+	if len(result) != 2 || len(dropped) != 2 {
+		t.Errorf("([error] Segment Biflow emitted %d and dropped %d flows, expected 2 and 2.", len(result), len(dropped))
+	}
+
+	if (Biflow{}).New(map[string]string{"unmatched": "keep"}) != nil {
+		t.Error("([error] Segment Biflow accepted an invalid 'unmatched' parameter.")
+	}
+}
+
+// Biflow Segment test, merges flows of the same direction until matched
+func TestSegment_Biflow_merge(t *testing.T) {
+	result, _ := segments.TestSegmentFlows("biflow", map[string]string{}, []*pb.EnrichedFlow{
+		flow("192.0.2.1", 50000, "198.51.100.1", 53, 1000, 100, 0),
+		flow("192.0.2.1", 50000, "198.51.100.1", 53, 3000, 100, 0),
+		flow("198.51.100.1", 53, "192.0.2.1", 50000, 2000, 500, 0),
+	})
+	if len(result) != 1 || result[0].Bytes != 200 || result[0].Packets != 2 || result[0].ReverseBytes != 500 || result[0].TimeFlowEndNs != 3100 {
+		t.Errorf("([error] Segment Biflow did not merge the flows of one direction: %v", result)
+	}
+}
@@ -0,0 +1,228 @@
+// The `dedup` segment drops duplicates of flows exported by several routers
+// along their path, which would otherwise be counted multiple times.
+//
+// Flows are considered duplicates if they have the same 5-tuple and are
+// received from different `SamplerAddress`es within `window` of the first of
+// them. Each flow is thus delayed by `window`, after which the flows of a
+// single exporter are kept. This is the exporter receiving the flow on one of
+// its `externalinterfaces`, i.e. the border router where the flow entered the
+// network, or else the first exporter listed in `prefer`, or else the
+// exporter whose flow was received first. All flows of that exporter are kept,
+// as it may have exported several records of a long flow within the window.
+//
+// The `externalinterfaces` parameter is a comma-separated list of interfaces
+// given as `sampler:ifindex`, such as `192.0.2.1:10` or `[2001:db8::1]:10`,
+// or as just `ifindex` to match the interface of any exporter. The `prefer`
+// parameter is a comma-separated list of exporter addresses.
+//
+// If `annotate` is set, the kept flows are modified to contain the number of
+// exporters which exported them in their `Observers` field.
+package dedup
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Dedup struct {
+	segments.BaseFilterSegment
+	Window   time.Duration // optional, default is 5s
+	Annotate bool          // optional, default is false, whether to set the Observers field of kept flows
+
+	prefer   map[netip.Addr]int   // the preference of samplers, lower is better
+	external map[iface]bool       // with an invalid address matching any sampler
+	pending  map[flowKey]*pending // flows within their window
+	queue    []*pending           // ordered by their first flow
+}
+
+type flowKey struct {
+	srcAddr, dstAddr netip.Addr
+	srcPort, dstPort uint32
+	proto            uint32
+}
+
+type iface struct {
+	sampler netip.Addr
+	index   uint32
+}
+
+// The flows of one key received within the window.
+type pending struct {
+	key      flowKey
+	deadline time.Time
+	flows    []*pb.EnrichedFlow
+}
+
+// Returns an address from a byte slice, IPv4 addresses mapped to IPv6 are
+// returned as IPv4 addresses.
+func addr(ip []byte) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+func (segment Dedup) New(config map[string]string) segments.Segment {
+	newsegment := &Dedup{
+		Window:   5 * time.Second,
+		prefer:   make(map[netip.Addr]int),
+		external: make(map[iface]bool),
+		pending:  make(map[flowKey]*pending),
+	}
+	if config["window"] != "" {
+		window, err := time.ParseDuration(config["window"])
+		if err != nil || window <= 0 {
+			log.Error().Err(err).Msg("Dedup: Could not parse 'window' parameter, it has to be a positive duration: ")
+			return nil
+		}
+		newsegment.Window = window
+	}
+	if config["annotate"] != "" {
+		annotate, err := strconv.ParseBool(config["annotate"])
+		if err != nil {
+			log.Error().Err(err).Msg("Dedup: Could not parse 'annotate' parameter: ")
+			return nil
+		}
+		newsegment.Annotate = annotate
+	}
+	if config["prefer"] != "" {
+		for i, sampler := range strings.Split(config["prefer"], ",") {
+			address, err := netip.ParseAddr(strings.TrimSpace(sampler))
+			if err != nil {
+				log.Error().Err(err).Msg("Dedup: Could not parse 'prefer' parameter: ")
+				return nil
+			}
+			if _, ok := newsegment.prefer[address.Unmap()]; !ok {
+				newsegment.prefer[address.Unmap()] = i
+			}
+		}
+	}
+	if config["externalinterfaces"] != "" {
+		for _, item := range strings.Split(config["externalinterfaces"], ",") {
+			item = strings.TrimSpace(item)
+			var external iface
+			index := item
+			if host, port, err := net.SplitHostPort(item); err == nil {
+				sampler, err := netip.ParseAddr(host)
+				if err != nil {
+					log.Error().Err(err).Msg("Dedup: Could not parse 'externalinterfaces' parameter: ")
+					return nil
+				}
+				external.sampler, index = sampler.Unmap(), port
+			}
+			parsed, err := strconv.ParseUint(index, 10, 32)
+			if err != nil {
+				log.Error().Err(err).Msgf("Dedup: Could not parse interface '%s' in 'externalinterfaces' parameter: ", item)
+				return nil
+			}
+			external.index = uint32(parsed)
+			newsegment.external[external] = true
+		}
+	}
+	return newsegment
+}
+
+func (segment *Dedup) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		for len(segment.queue) > 0 {
+			segment.release()
+		}
+		close(segment.Out)
+		wg.Done()
+	}()
+
+	ticker := time.NewTicker(segments.ExpiryInterval(segment.Window))
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			key := flowKey{
+				srcAddr: addr(msg.SrcAddr),
+				dstAddr: addr(msg.DstAddr),
+				srcPort: msg.SrcPort,
+				dstPort: msg.DstPort,
+				proto:   msg.Proto,
+			}
+			if p, ok := segment.pending[key]; ok {
+				p.flows = append(p.flows, msg)
+				continue
+			}
+			p := &pending{key: key, deadline: time.Now().Add(segment.Window), flows: []*pb.EnrichedFlow{msg}}
+			segment.pending[key] = p
+			segment.queue = append(segment.queue, p)
+		case now := <-ticker.C:
+			for len(segment.queue) > 0 && !now.Before(segment.queue[0].deadline) {
+				segment.release()
+			}
+		}
+	}
+}
+
+// Releases the oldest pending flows, keeping the flows of the best sampler and
+// dropping all others.
+func (segment *Dedup) release() {
+	p := segment.queue[0]
+	segment.queue[0] = nil
+	segment.queue = segment.queue[1:]
+	delete(segment.pending, p.key)
+
+	observers := make(map[netip.Addr]bool)
+	best, bestRank := netip.Addr{}, int64(-1)
+	for i, flow := range p.flows {
+		sampler := addr(flow.SamplerAddress)
+		if observers[sampler] {
+			continue
+		}
+		observers[sampler] = true
+		if rank := segment.rank(flow, i); bestRank == -1 || rank < bestRank {
+			best, bestRank = sampler, rank
+		}
+	}
+	for _, flow := range p.flows {
+		if addr(flow.SamplerAddress) == best {
+			if segment.Annotate {
+				flow.Observers = uint32(len(observers))
+			}
+			segment.Out <- flow
+		} else if segment.Drops != nil {
+			segment.Drops <- flow
+		}
+	}
+}
+
+// Returns the rank of the sampler of a flow, lower is better. The position is
+// that of the flow within the pending flows.
+func (segment *Dedup) rank(flow *pb.EnrichedFlow, position int) int64 {
+	sampler := addr(flow.SamplerAddress)
+	rank := len(segment.prefer)
+	if preference, ok := segment.prefer[sampler]; ok {
+		rank = preference
+	}
+	if !segment.external[iface{sampler, flow.InIf}] && !segment.external[iface{netip.Addr{}, flow.InIf}] {
+		rank += len(segment.prefer) + 1
+	}
+	// the order in which flows were received decides ties, int64 as int has
+	// only 32 bits on some platforms
+	return int64(rank)<<32 | int64(position)
+}
+
+func init() {
+	segment := &Dedup{}
+	segments.RegisterSegment("dedup", segment,
+		segments.Param{Name: "window", Type: segments.Duration, Default: "5s", Description: "duration within which flows of different exporters are considered duplicates"},
+		segments.Param{Name: "prefer", Description: "comma-separated list of exporter addresses whose flows are kept, in order of preference"},
+		segments.Param{Name: "externalinterfaces", Description: "comma-separated list of interfaces as sampler:ifindex or ifindex, flows received on them are kept"},
+		segments.Param{Name: "annotate", Type: segments.Bool, Default: "false", Description: "set the Observers field of the kept flows"},
+	)
+}
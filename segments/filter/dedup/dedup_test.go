@@ -0,0 +1,92 @@
+package dedup
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Dedup Segment test, passthrough test
+func TestSegment_Dedup_passthrough(t *testing.T) {
+	result := segments.TestSegment("dedup", map[string]string{},
+		&pb.EnrichedFlow{Type: 3})
+
+	if result.Type != 3 {
+		t.Error("([error] Segment Dedup is not working.")
+	}
+}
+
+func flow(sampler string, inIf uint32, srcPort uint32) *pb.EnrichedFlow {
+	return &pb.EnrichedFlow{
+		SamplerAddress: net.ParseIP(sampler),
+		InIf:           inIf,
+		SrcAddr:        net.ParseIP("192.0.2.1"),
+		DstAddr:        net.ParseIP("198.51.100.1"),
+		SrcPort:        srcPort,
+		DstPort:        443,
+		Proto:          6,
+	}
+}
+
+// Dedup Segment test, keeps the flows of the best exporter
+func TestSegment_Dedup_duplicates(t *testing.T) {
+	flows := []*pb.EnrichedFlow{
+		flow("192.0.2.253", 1, 1000),
+		flow("192.0.2.254", 2, 1000),
+		flow("192.0.2.252", 3, 1000),
+		flow("192.0.2.254", 2, 1000), // a second record from the same exporter
+		flow("192.0.2.253", 1, 2000),
+	}
+	kept, dropped := segments.TestSegmentFlows("dedup", map[string]string{"annotate": "true"}, flows)
+	if len(kept) != 2 || len(dropped) != 3 {
+		t.Fatalf("([error] Segment Dedup kept %d and dropped %d flows, expected 2 and 3.", len(kept), len(dropped))
+	}
+	if kept[0] != flows[0] || kept[0].Observers != 3 || kept[1] != flows[4] || kept[1].Observers != 1 {
+		t.Errorf("([error] Segment Dedup did not keep the first exporter's flows: %v", kept)
+	}
+
+	kept, _ = segments.TestSegmentFlows("dedup", map[string]string{"prefer": "192.0.2.252,192.0.2.254"}, flows)
+	if len(kept) != 2 || kept[0] != flows[2] || kept[0].Observers != 0 {
+		t.Errorf("([error] Segment Dedup did not keep the preferred exporter's flows: %v", kept)
+	}
+
+	kept, _ = segments.TestSegmentFlows("dedup", map[string]string{"prefer": "192.0.2.252", "externalinterfaces": "192.0.2.254:2,7"}, flows)
+	if len(kept) != 3 || kept[0] != flows[1] || kept[1] != flows[3] {
+		t.Errorf("([error] Segment Dedup did not keep the flows received on an external interface: %v", kept)
+	}
+
+	if (Dedup{}).New(map[string]string{"externalinterfaces": "192.0.2.1:eth0"}) != nil {
+		t.Error("([error] Segment Dedup accepted an invalid interface.")
+	}
+}
+
+// Dedup Segment test, does not consider flows outside of the window duplicates
+func TestSegment_Dedup_window(t *testing.T) {
+	segment := Dedup{}.New(map[string]string{"window": "50ms"})
+	if segment == nil {
+		t.Fatal("([error] Segment Dedup failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+
+	in <- flow("192.0.2.253", 1, 1000)
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("([error] Segment Dedup did not release a flow after its window.")
+	}
+	in <- flow("192.0.2.254", 1, 1000)
+	close(in)
+	if _, ok := <-out; !ok {
+		t.Error("([error] Segment Dedup dropped a flow outside of the window.")
+	}
+	wg.Wait()
+}
@@ -3,6 +3,7 @@
 package drop
 
 import (
+	"context"
 	"sync"
 
 	"github.com/BelWue/flowpipeline/segments"
@@ -16,7 +17,7 @@ func (segment Drop) New(config map[string]string) segments.Segment {
 	return &Drop{}
 }
 
-func (segment *Drop) Run(wg *sync.WaitGroup) {
+func (segment *Drop) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
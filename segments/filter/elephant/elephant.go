@@ -11,6 +11,7 @@
 package elephant
 
 import (
+	"context"
 	"math"
 	"strconv"
 	"strings"
@@ -118,7 +119,7 @@ func (segment Elephant) New(config map[string]string) segments.Segment {
 	}
 }
 
-func (segment *Elephant) Run(wg *sync.WaitGroup) {
+func (segment *Elephant) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -187,5 +188,11 @@ func (segment *Elephant) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Elephant{}
-	segments.RegisterSegment("elephant", segment)
+	segments.RegisterSegment("elephant", segment,
+		segments.Param{Name: "aspect", Default: "bytes", Description: "what qualifies a flow as an elephant, one of \"bytes\", \"bps\", \"packets\" or \"pps\""},
+		segments.Param{Name: "percentile", Type: segments.Float, Default: "99.00", Description: "flows below this percentile are dropped, e.g. 95.00 outputs the top 5%"},
+		segments.Param{Name: "exact", Type: segments.Bool, Default: "false", Description: "calculate exact percentiles instead of estimating them using P-square"},
+		segments.Param{Name: "window", Type: segments.Int, Default: "300", Description: "size of the sliding window in seconds"},
+		segments.Param{Name: "rampuptime", Type: segments.Int, Default: "0", Description: "seconds after start during which all flows are dropped"},
+	)
 }
@@ -1,6 +1,7 @@
 package elephant
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -14,7 +15,11 @@ import (
 
 // Elephant Segment test, passthrough test
 func TestSegment_Elephant_passthrough(t *testing.T) {
-	segment := segments.LookupSegment("elephant").New(map[string]string{})
+	template, err := segments.LookupSegment("elephant")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Elephant: ")
+	}
+	segment := template.New(map[string]string{})
 	if segment == nil {
 		log.Fatal().Msg("Configured segment 'elephant' could not be initialized properly, see previous messages.")
 	}
@@ -24,7 +29,7 @@ func TestSegment_Elephant_passthrough(t *testing.T) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	in <- &pb.EnrichedFlow{Bytes: 10}
 	<-out
@@ -50,7 +55,7 @@ func BenchmarkElephant(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{}
@@ -4,6 +4,7 @@
 package flowfilter
 
 import (
+	"context"
 	"sync"
 
 	"github.com/rs/zerolog/log"
@@ -13,6 +14,8 @@ import (
 	"github.com/BelWue/flowpipeline/segments"
 )
 
+// TODO: support matching the SrcLabels and DstLabels set by the addlabels
+// segment once the flowfilter syntax supports labels.
 type FlowFilter struct {
 	segments.BaseFilterSegment
 	Filter string // optional, default is empty
@@ -40,7 +43,7 @@ func (segment FlowFilter) New(config map[string]string) segments.Segment {
 	return newSegment
 }
 
-func (segment *FlowFilter) Run(wg *sync.WaitGroup) {
+func (segment *FlowFilter) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -60,5 +63,7 @@ func (segment *FlowFilter) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &FlowFilter{}
-	segments.RegisterSegment("flowfilter", segment)
+	segments.RegisterSegment("flowfilter", segment,
+		segments.Param{Name: "filter", Description: "the filter expression, see the flowfilter syntax"},
+	)
 }
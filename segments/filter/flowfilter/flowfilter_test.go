@@ -1,6 +1,7 @@
 package flowfilter
 
 import (
+	"context"
 	"math/rand"
 	"os"
 	"sync"
@@ -48,7 +49,7 @@ func BenchmarkFlowFilter(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcPort: uint32(rand.Intn(100))}
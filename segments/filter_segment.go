@@ -5,6 +5,8 @@
 package segments
 
 import (
+	"time"
+
 	"github.com/BelWue/flowpipeline/pb"
 )
 
@@ -27,3 +29,11 @@ type BaseFilterSegment struct {
 func (segment *BaseFilterSegment) SubscribeDrops(drops chan<- *pb.EnrichedFlow) {
 	segment.Drops = drops
 }
+
+// Returns the interval at which segments holding back flows for the given
+// duration, such as the dedup and biflow filters, should check for flows to
+// release. It is a tenth of the duration, bounded to between a millisecond and
+// a second.
+func ExpiryInterval(duration time.Duration) time.Duration {
+	return min(max(duration/10, time.Millisecond), time.Second)
+}
@@ -0,0 +1,166 @@
+package segments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BelWue/flowpipeline/pb"
+)
+
+// A field of pb.EnrichedFlow selected by its name in the configuration of a
+// segment, such as `Bytes`. Entries of map fields are selected by the name of
+// the field and their key, such as `SrcLabels.zone` or `labels.site`. Names of
+// fields are matched case-insensitively, keys are not.
+type FlowField struct {
+	Name  string // as selected
+	index []int
+	key   string // of the entry of a map field
+	typ   reflect.Type
+}
+
+// Returns the field selected by a name, or an error if there is no such field.
+// A map field without a key selects the whole map, see IsMap.
+func ParseFlowField(name string) (FlowField, error) {
+	field := FlowField{Name: strings.TrimSpace(name)}
+	fieldName, key, isEntry := strings.Cut(field.Name, ".")
+	flowType := reflect.TypeOf(pb.EnrichedFlow{})
+	structField, found := flowType.FieldByName(fieldName)
+	if !found {
+		structField, found = flowType.FieldByNameFunc(func(name string) bool {
+			return strings.EqualFold(name, fieldName)
+		})
+	}
+	if !found || !structField.IsExported() {
+		return field, fmt.Errorf("field '%s' does not exist", fieldName)
+	}
+	field.index, field.typ = structField.Index, structField.Type
+	if isEntry {
+		if structField.Type.Kind() != reflect.Map {
+			return field, fmt.Errorf("field '%s' is not a map", fieldName)
+		}
+		if key == "" {
+			return field, fmt.Errorf("field '%s' is a map, select one of its entries as '%s.<key>'", fieldName, fieldName)
+		}
+		field.key, field.typ = key, structField.Type.Elem()
+	}
+	return field, nil
+}
+
+// Returns all exported fields of pb.EnrichedFlow, with maps selected as a whole.
+func AllFlowFields() []FlowField {
+	var fields []FlowField
+	flowType := reflect.TypeOf(pb.EnrichedFlow{})
+	for i := 0; i < flowType.NumField(); i++ {
+		if structField := flowType.Field(i); structField.IsExported() {
+			fields = append(fields, FlowField{Name: structField.Name, index: structField.Index, typ: structField.Type})
+		}
+	}
+	return fields
+}
+
+// Returns whether the field selects a whole map instead of a single value,
+// which segments requiring single values reject.
+func (field FlowField) IsMap() bool {
+	return field.typ.Kind() == reflect.Map
+}
+
+// Returns the type of the values of the field.
+func (field FlowField) Type() reflect.Type {
+	return field.typ
+}
+
+// Returns the name of the field usable as a Prometheus label name or such,
+// which replaces the dot separating the key of a map entry by an underscore.
+func (field FlowField) LabelName() string {
+	return strings.ReplaceAll(field.Name, ".", "_")
+}
+
+// Returns the value of the field of a flow. The value of missing map entries
+// is the zero value of the map's values.
+func (field FlowField) Value(flow *pb.EnrichedFlow) any {
+	value := reflect.ValueOf(flow).Elem().FieldByIndex(field.index)
+	if field.key == "" {
+		return value.Interface()
+	}
+	entry := value.MapIndex(reflect.ValueOf(field.key))
+	if !entry.IsValid() {
+		return reflect.Zero(field.typ).Interface()
+	}
+	return entry.Interface()
+}
+
+// Returns the value of the field of a flow formatted as a string, with
+// addresses in their usual notation and maps as JSON objects.
+func (field FlowField) Format(flow *pb.EnrichedFlow) string {
+	switch value := field.Value(flow).(type) {
+	case []uint8: // this is necessary for proper formatting
+		if len(value) == 0 {
+			return ""
+		}
+		return net.IP(value).String()
+	case uint32: // this is because FormatUint is much faster than Sprint
+		return strconv.FormatUint(uint64(value), 10)
+	case uint64: // this is because FormatUint is much faster than Sprint
+		return strconv.FormatUint(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case string: // this is because doing nothing is also much faster than Sprint
+		return value
+	case map[string]string, map[string]float64:
+		if reflect.ValueOf(value).Len() == 0 {
+			return ""
+		}
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// Resets the field of a flow to its zero value, or removes the entry of a map.
+func (field FlowField) Clear(flow *pb.EnrichedFlow) {
+	value := reflect.ValueOf(flow).Elem().FieldByIndex(field.index)
+	if field.key == "" {
+		value.SetZero()
+	} else if !value.IsNil() {
+		value.SetMapIndex(reflect.ValueOf(field.key), reflect.Value{})
+	}
+}
+
+// Copies the field or the map entry of one flow to another.
+func (field FlowField) Copy(to, from *pb.EnrichedFlow) {
+	source := reflect.ValueOf(from).Elem().FieldByIndex(field.index)
+	destination := reflect.ValueOf(to).Elem().FieldByIndex(field.index)
+	if field.key == "" {
+		destination.Set(source)
+		return
+	}
+	key := reflect.ValueOf(field.key)
+	entry := source.MapIndex(key)
+	if !entry.IsValid() {
+		return
+	}
+	if destination.IsNil() {
+		destination.Set(reflect.MakeMap(destination.Type()))
+	}
+	destination.SetMapIndex(key, entry)
+}
+
+// Sets the field or the map entry of a flow to a value, which is converted to
+// the type of the field and has to be convertible to it.
+func (field FlowField) Set(flow *pb.EnrichedFlow, value any) {
+	converted := reflect.ValueOf(value).Convert(field.typ)
+	destination := reflect.ValueOf(flow).Elem().FieldByIndex(field.index)
+	if field.key == "" {
+		destination.Set(converted)
+		return
+	}
+	if destination.IsNil() {
+		destination.Set(reflect.MakeMap(destination.Type()))
+	}
+	destination.SetMapIndex(reflect.ValueOf(field.key), converted)
+}
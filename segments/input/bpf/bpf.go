@@ -19,6 +19,7 @@
 package bpf
 
 import (
+	"context"
 	"strconv"
 	"sync"
 	"time"
@@ -109,11 +110,16 @@ func (segment Bpf) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *Bpf) Run(wg *sync.WaitGroup) {
+func (segment *Bpf) Run(ctx context.Context, wg *sync.WaitGroup) {
 	err := segment.dumper.Start()
 	if err != nil {
 		log.Error().Err(err).Msg("Bpf: error starting up BPF dumping: ")
-		segment.ShutdownParentPipeline()
+		segments.ShutdownParentPipeline(ctx)
+		for msg := range segment.In {
+			segment.Out <- msg
+		}
+		close(segment.Out)
+		wg.Done()
 		return
 	}
 	segment.exporter.Start(segment.dumper.SamplerAddress)
@@ -144,5 +150,10 @@ func (segment *Bpf) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Bpf{}
-	segments.RegisterSegment("bpf", segment)
+	segments.RegisterSegment("bpf", segment,
+		segments.Param{Name: "device", Required: true, Description: "the name of the device to capture, e.g. \"eth0\""},
+		segments.Param{Name: "activetimeout", Type: segments.Duration, Default: "30m", Description: "duration after which long-running flows are exported"},
+		segments.Param{Name: "inactivetimeout", Type: segments.Duration, Default: "15s", Description: "duration of inactivity after which flows are exported"},
+		segments.Param{Name: "buffersize", Type: segments.Int, Default: "65536", Description: "size of the ring buffer in bytes, rounded up to a multiple of the page size"},
+	)
 }
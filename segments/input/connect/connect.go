@@ -0,0 +1,226 @@
+// The `connect` segment receives flows from `publish` segments in other
+// pipelines of the same flowpipeline process and introduces them into its
+// pipeline. Flows are exchanged using in-process channels identified by the
+// `topic` parameter, which is required.
+//
+// Each flow published to a topic is received once by every `group` of
+// `connect` segments subscribed to it, i.e. by a single member of each group.
+// In configurations defining several pipelines, the `group` defaults to the
+// name of the pipeline, thus all instances of a pipeline running concurrently
+// share their flows, while each pipeline connecting to a topic receives all of
+// its flows. Publishers are slowed down if a group does not keep up. On
+// shutdown, connect segments keep receiving flows until all publishers of
+// their topic have been closed, so that flows in flight are not lost.
+//
+// ```yaml
+// pipelines:
+// - name: ingest
+//   segments:
+//   - segment: goflow
+//   - segment: publish
+//     config:
+//       topic: flows
+// - name: storage
+//   concurrency: 4
+//   segments:
+//   - segment: connect
+//     config:
+//       topic: flows
+//   - segment: sqlite
+//     config:
+//       filename: flows.sqlite
+// ```
+package connect
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Connect struct {
+	segments.BaseSegment
+	Topic string // required, the topic to receive flows from
+	Group string // optional, default is the pipeline's name, connect segments in the same group share their flows
+
+	group *group
+}
+
+// All connect segments subscribed to the same topic under the same name.
+type group struct {
+	flows   chan *pb.EnrichedFlow
+	members int
+	left    chan struct{} // closed once the last member has unsubscribed
+}
+
+// All publishers of the same topic.
+type publishers struct {
+	count int
+	gone  chan struct{} // closed once the last publisher has been closed
+}
+
+var (
+	topicsMutex sync.RWMutex
+	topics      = make(map[string]map[string]*group) // topic to group name to group
+	publishing  = make(map[string]*publishers)       // topic to its publishers
+)
+
+func subscribe(topic string, name string) *group {
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	if topics[topic] == nil {
+		topics[topic] = make(map[string]*group)
+	}
+	g := topics[topic][name]
+	if g == nil {
+		g = &group{flows: make(chan *pb.EnrichedFlow), left: make(chan struct{})}
+		topics[topic][name] = g
+	}
+	g.members += 1
+	return g
+}
+
+func unsubscribe(topic string, name string) {
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	g := topics[topic][name]
+	if g == nil {
+		return
+	}
+	g.members -= 1
+	if g.members == 0 {
+		close(g.left)
+		delete(topics[topic], name)
+	}
+}
+
+// Registers a publisher of the topic, which connect segments wait for when
+// shutting down. Must be followed by a call to Unadvertise once the publisher
+// will publish no more flows.
+func Advertise(topic string) {
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	p := publishing[topic]
+	if p == nil {
+		p = &publishers{gone: make(chan struct{})}
+		publishing[topic] = p
+	}
+	p.count += 1
+}
+
+// Unregisters a publisher of the topic previously registered by Advertise.
+func Unadvertise(topic string) {
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	p := publishing[topic]
+	if p == nil {
+		return
+	}
+	p.count -= 1
+	if p.count == 0 {
+		close(p.gone)
+		delete(publishing, topic)
+	}
+}
+
+// Returns a channel which is closed once all current publishers of the topic
+// have been closed.
+func unpublished(topic string) <-chan struct{} {
+	topicsMutex.RLock()
+	defer topicsMutex.RUnlock()
+	if p := publishing[topic]; p != nil {
+		return p.gone
+	}
+	gone := make(chan struct{})
+	close(gone)
+	return gone
+}
+
+// Publish hands a copy of the flow to each group of connect segments
+// subscribed to the topic, blocking until all of them received it or
+// unsubscribed. It returns the number of groups the flow was delivered to.
+func Publish(topic string, msg *pb.EnrichedFlow) int {
+	topicsMutex.RLock()
+	groups := make([]*group, 0, len(topics[topic]))
+	for _, g := range topics[topic] {
+		groups = append(groups, g)
+	}
+	topicsMutex.RUnlock()
+
+	delivered := 0
+	for _, g := range groups {
+		select {
+		case g.flows <- proto.Clone(msg).(*pb.EnrichedFlow):
+			delivered += 1
+		case <-g.left:
+		}
+	}
+	return delivered
+}
+
+func (segment Connect) New(config map[string]string) segments.Segment {
+	if config["topic"] == "" {
+		log.Error().Msg("Connect: Parameter 'topic' is required.")
+		return nil
+	}
+	newsegment := &Connect{Topic: config["topic"], Group: config["group"]}
+	// subscribe right away, so that no flows are missed while the
+	// pipelines start up
+	if !segments.DryRun(config) {
+		newsegment.group = subscribe(newsegment.Topic, newsegment.Group)
+	}
+	return newsegment
+}
+
+func (segment *Connect) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	var flows <-chan *pb.EnrichedFlow
+	if segment.group != nil {
+		flows = segment.group.flows
+	}
+	done := ctx.Done()
+	var gone <-chan struct{}
+	for {
+		select {
+		case <-done:
+			// publishers may still be draining their pipelines, which
+			// would block them if flows were no longer received
+			done, gone = nil, unpublished(segment.Topic)
+		case <-gone:
+			// stop receiving, but keep forwarding until In is closed
+			gone, flows = nil, nil
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.Out <- msg
+		case msg := <-flows:
+			segment.Out <- msg
+		}
+	}
+}
+
+// Unsubscribes from the topic, publishers will no longer wait for this
+// segment.
+func (segment *Connect) Close() {
+	if segment.group != nil {
+		unsubscribe(segment.Topic, segment.Group)
+		segment.group = nil
+	}
+}
+
+func init() {
+	segment := &Connect{}
+	segments.RegisterSegment("connect", segment,
+		segments.Param{Name: "topic", Required: true, Description: "the topic to receive flows from, as used by publish segments"},
+		segments.Param{Name: "group", Description: "connect segments in the same group share the flows of a topic, defaults to the name of the pipeline"},
+	)
+}
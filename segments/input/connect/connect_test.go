@@ -0,0 +1,63 @@
+package connect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/pipeline"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+func TestSegment_Connect_passthrough(t *testing.T) {
+	result := segments.TestSegment("connect", map[string]string{"topic": "passthrough"},
+		&pb.EnrichedFlow{Proto: 6})
+	if result == nil || result.Proto != 6 {
+		t.Error("([error] Segment Connect is not passing through flows.")
+	}
+}
+
+func TestSegment_Connect_groups(t *testing.T) {
+	connected := func(group string) *pipeline.Pipeline {
+		pipeline := pipeline.New(Connect{}.New(map[string]string{"topic": "groups", "group": group}))
+		pipeline.Start()
+		return pipeline
+	}
+	a1, a2, b := connected("a"), connected("a"), connected("b")
+
+	delivered := make(chan int)
+	go func() {
+		delivered <- Publish("groups", &pb.EnrichedFlow{Proto: 6})
+	}()
+	select {
+	case fmsg := <-b.Out:
+		if fmsg.Proto != 6 {
+			t.Error("([error] Segment Connect received a modified flow.")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("([error] Segment Connect did not receive a published flow.")
+	}
+	select {
+	case <-a1.Out:
+	case <-a2.Out:
+	case <-time.After(time.Second):
+		t.Fatal("([error] No member of the group received a published flow.")
+	}
+	select {
+	case <-a1.Out:
+		t.Error("([error] Both members of a group received the same flow.")
+	case <-a2.Out:
+		t.Error("([error] Both members of a group received the same flow.")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if count := <-delivered; count != 2 {
+		t.Errorf("([error] Flow was delivered to %d groups, should be 2.", count)
+	}
+
+	for _, pipeline := range []*pipeline.Pipeline{a1, a2, b} {
+		pipeline.Close()
+	}
+	if count := Publish("groups", &pb.EnrichedFlow{}); count != 0 {
+		t.Errorf("([error] Flow was delivered to %d groups after closing them, should be 0.", count)
+	}
+}
@@ -8,6 +8,7 @@ package diskbuffer
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -69,37 +70,45 @@ func (segment *DiskBuffer) New(config map[string]string) segments.Segment {
 	if segment.BufferDir != "" {
 		fi, err := os.Stat(segment.BufferDir)
 		if err != nil {
-			log.Fatal().Msgf("Diskbuffer: Could not obtain file info for file %s", segment.BufferDir)
+			log.Error().Msgf("Diskbuffer: Could not obtain file info for file %s", segment.BufferDir)
+			return nil
 		}
 		if !fi.IsDir() {
-			log.Fatal().Msgf("Diskbuffer: bufferdir %s must be a directory", segment.BufferDir)
+			log.Error().Msgf("Diskbuffer: bufferdir %s must be a directory", segment.BufferDir)
+			return nil
 		}
 		if unix.Access(segment.BufferDir, unix.W_OK) != nil {
-			log.Fatal().Msg("Diskbuffer: bufferdir must be writeable")
+			log.Error().Msg("Diskbuffer: bufferdir must be writeable")
+			return nil
 		}
 	} else {
-		log.Fatal().Msg("Diskbuffer: bufferdir must exist")
+		log.Error().Msg("Diskbuffer: bufferdir must exist")
+		return nil
 	}
 	// parse HighMemoryMark option
 	segment.HighMemoryMark = defaultHighMemoryMark
 	if config["highmemorymark"] != "" {
 		segment.HighMemoryMark, err = strconv.Atoi(config["highmemorymark"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse highmemorymark config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse highmemorymark config option: ")
+			return nil
 		}
 		if segment.HighMemoryMark < 10 || segment.HighMemoryMark > 95 {
-			log.Fatal().Msg("Diskbuffer: HighMemoryMark must be between 10 and 95")
+			log.Error().Msg("Diskbuffer: HighMemoryMark must be between 10 and 95")
+			return nil
 		}
 	}
 
 	segment.ReadingMemoryMark = defaultReadingMemoryMark
 	if config["readingmemorymark"] != "" {
-		segment.ReadingMemoryMark, err = strconv.Atoi(config["highmemorymark"])
+		segment.ReadingMemoryMark, err = strconv.Atoi(config["readingmemorymark"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse readingmemorymark config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse readingmemorymark config option: ")
+			return nil
 		}
 		if segment.ReadingMemoryMark < 1 || segment.ReadingMemoryMark > 50 {
-			log.Fatal().Msg("Diskbuffer: HighMemoryMark must be between 1 and 50")
+			log.Error().Msg("Diskbuffer: ReadingMemoryMark must be between 1 and 50")
+			return nil
 		}
 
 	}
@@ -109,26 +118,31 @@ func (segment *DiskBuffer) New(config map[string]string) segments.Segment {
 	if config["lowmemorymark"] != "" {
 		segment.LowMemoryMark, err = strconv.Atoi(config["lowmemorymark"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse lowmemorymark config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse lowmemorymark config option: ")
+			return nil
 		}
 		if segment.LowMemoryMark < 5 || segment.LowMemoryMark > 70 {
-			log.Fatal().Msg("Diskbuffer: HighMemoryMark must be between 5 and 70")
+			log.Error().Msg("Diskbuffer: LowMemoryMark must be between 5 and 70")
+			return nil
 		}
 	}
 
 	//sanity check: lowmemorymark < highmemorymark
 	if segment.LowMemoryMark > segment.HighMemoryMark {
-		log.Fatal().Msg("Diskbuffer: HighMemoryMark must be greater than LowMemoryMark")
+		log.Error().Msg("Diskbuffer: HighMemoryMark must be greater than LowMemoryMark")
+		return nil
 	}
 	if segment.ReadingMemoryMark > segment.LowMemoryMark {
-		log.Fatal().Msg("Diskbuffer: LowMemoryMark must be greater than ReadingMemoryMark")
+		log.Error().Msg("Diskbuffer: LowMemoryMark must be greater than ReadingMemoryMark")
+		return nil
 	}
 
 	segment.MaxCacheSize = defaultMaxCacheSize
 	if config["maxcachesize"] != "" {
-		segment.FileSize, err = humanize.ParseBytes(config["maxcachesize"])
+		segment.MaxCacheSize, err = humanize.ParseBytes(config["maxcachesize"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse maxcachesize config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse maxcachesize config option: ")
+			return nil
 		}
 	}
 
@@ -137,7 +151,8 @@ func (segment *DiskBuffer) New(config map[string]string) segments.Segment {
 	if config["filesize"] != "" {
 		segment.FileSize, err = humanize.ParseBytes(config["filesize"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse filesize config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse filesize config option: ")
+			return nil
 		}
 	}
 
@@ -146,7 +161,8 @@ func (segment *DiskBuffer) New(config map[string]string) segments.Segment {
 	if config["batchsize"] != "" {
 		segment.BatchSize, err = strconv.Atoi(config["batchsize"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse batchsize config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse batchsize config option: ")
+			return nil
 		}
 	}
 	if segment.BatchSize < 0 {
@@ -156,7 +172,8 @@ func (segment *DiskBuffer) New(config map[string]string) segments.Segment {
 	if config["batchdebug"] != "" {
 		batchDebug, err := strconv.ParseBool(config["batchdebug"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse batchdebug config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse batchdebug config option: ")
+			return nil
 		}
 		// set proper BatchDebugPrintf function
 		if batchDebug {
@@ -171,7 +188,8 @@ func (segment *DiskBuffer) New(config map[string]string) segments.Segment {
 	if config["queuestatusinterval"] != "" {
 		segment.QueueStatusInterval, err = time.ParseDuration(config["queuestatusinterval"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse queuestatussnterval config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse queuestatussnterval config option: ")
+			return nil
 		}
 	}
 
@@ -179,7 +197,8 @@ func (segment *DiskBuffer) New(config map[string]string) segments.Segment {
 	if config["queuesize"] != "" {
 		buflen, err = strconv.Atoi(config["queuesize"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Diskbuffer: Failed to parse queuesize config option: ")
+			log.Error().Err(err).Msg("Diskbuffer: Failed to parse queuesize config option: ")
+			return nil
 		}
 	} else {
 		buflen = defaultQueueSize
@@ -453,7 +472,7 @@ func QueueStatus(segment *DiskBuffer, BufferWG *sync.WaitGroup, StopQueueStatusI
 	}
 }
 
-func (segment *DiskBuffer) Run(wg *sync.WaitGroup) {
+func (segment *DiskBuffer) Run(ctx context.Context, wg *sync.WaitGroup) {
 	var BufferWG sync.WaitGroup
 	var ReadWriteWG sync.WaitGroup
 	var CacheFiles []string
@@ -486,7 +505,10 @@ func (segment *DiskBuffer) Run(wg *sync.WaitGroup) {
 		wg.Done()
 		log.Info().Msg("Diskbuffer: All writer functions have stopped, exiting…")
 	}()
-	defer BufferWG.Wait()
+	defer segments.Flush("Diskbuffer", func(context.Context) error {
+		BufferWG.Wait() // writes any flows left in memory to disk
+		return nil
+	})
 
 	// print queue status information
 	StopQueueStatusInterval := make(chan struct{})
@@ -578,5 +600,16 @@ func (segment *DiskBuffer) Run(wg *sync.WaitGroup) {
 // register segment
 func init() {
 	segment := &DiskBuffer{}
-	segments.RegisterSegment("diskbuffer", segment)
+	segments.RegisterSegment("diskbuffer", segment,
+		segments.Param{Name: "bufferdir", Required: true, Description: "existing, writable directory to store the buffer files in"},
+		segments.Param{Name: "highmemorymark", Type: segments.Int, Default: "70", Description: "fill level of the memory buffer in percent at which flows are written to disk, between 10 and 95"},
+		segments.Param{Name: "lowmemorymark", Type: segments.Int, Default: "30", Description: "fill level of the memory buffer in percent at which writing to disk stops, between 5 and 70"},
+		segments.Param{Name: "readingmemorymark", Type: segments.Int, Default: "5", Description: "fill level of the memory buffer in percent at which flows are read from disk, between 1 and 50"},
+		segments.Param{Name: "maxcachesize", Default: "1GB", Description: "maximum size of all buffer files on disk, e.g. \"10GB\""},
+		segments.Param{Name: "filesize", Default: "50MB", Description: "maximum size of a single buffer file, e.g. \"100MB\""},
+		segments.Param{Name: "batchsize", Type: segments.Int, Default: "128", Description: "number of flows written to or read from disk at once"},
+		segments.Param{Name: "batchdebug", Type: segments.Bool, Default: "false", Description: "log debug messages for each batch"},
+		segments.Param{Name: "queuestatusinterval", Type: segments.Duration, Default: "0s", Description: "interval for logging the fill level of the memory buffer, disabled if 0"},
+		segments.Param{Name: "queuesize", Type: segments.Int, Default: "65536", Description: "number of flows held in memory, at least 64"},
+	)
 }
@@ -25,7 +25,6 @@ import (
 
 	"github.com/netsampler/goflow2/v2/utils/debug"
 
-	"github.com/netsampler/goflow2/v2/transport"
 	_ "github.com/netsampler/goflow2/v2/transport/file"
 	_ "github.com/netsampler/goflow2/v2/transport/kafka"
 
@@ -103,27 +102,34 @@ func (segment Goflow) New(config map[string]string) segments.Segment {
 	}
 }
 
-func (segment *Goflow) Run(wg *sync.WaitGroup) {
+func (segment *Goflow) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
 	}()
+	// the receivers stop once the pipeline is shutting down, or once In is
+	// closed without that
+	receiving, stop := context.WithCancel(ctx)
+	defer stop()
 	segment.goflow_in = make(chan *pb.EnrichedFlow)
-	segment.startGoFlow(&channelDriver{segment.goflow_in})
-	for {
+	segment.startGoFlow(receiving, &channelDriver{segment.goflow_in})
+	// keep reading the flows decoded by goflow until all receivers have
+	// stopped, as they wait for their workers, which are blocked sending
+	// any queued flows otherwise
+	goflowIn, in := segment.goflow_in, segment.In
+	for goflowIn != nil || in != nil {
 		select {
-		case msg, ok := <-segment.goflow_in:
+		case msg, ok := <-goflowIn:
 			if !ok {
-				// do not return here, as this might leave the
-				// segment.In channel blocking in our
-				// predecessor segment
-				segment.goflow_in = nil // make unavailable for select
-				// TODO: think about restarting goflow?
+				goflowIn = nil // make unavailable for select
+				continue
 			}
 			segment.Out <- msg
-		case msg, ok := <-segment.In:
+		case msg, ok := <-in:
 			if !ok {
-				return
+				in = nil
+				stop()
+				continue
 			}
 			segment.Out <- msg
 		}
@@ -150,23 +156,31 @@ func (d *channelDriver) Close(context.Context) error {
 	return nil
 }
 
-func (segment *Goflow) startGoFlow(transport transport.TransportInterface) {
+func (segment *Goflow) startGoFlow(ctx context.Context, driver *channelDriver) {
 	formatter, err := format.FindFormat("bin")
 	if err != nil {
 		log.Fatal().Err(err).Msg("Goflow: Failed loading formatter")
-		segment.ShutdownParentPipeline()
+		segments.ShutdownParentPipeline(ctx)
 	}
 	var pipes []utils.FlowPipe
 
+	// closes the transport once all receivers have stopped
+	var receivers sync.WaitGroup
+	receivers.Add(len(segment.Listen))
+	go func() {
+		receivers.Wait()
+		driver.Close(context.Background())
+	}()
 	for _, listenAddrUrl := range segment.Listen {
 		go func(listenAddrUrl url.URL) {
+			defer receivers.Done()
 			var err error
 
 			hostname := listenAddrUrl.Hostname()
 			portU64, _ := strconv.ParseUint(listenAddrUrl.Port(), 10, 64)
 			if portU64 > math.MaxInt {
 				log.Fatal().Err(err).Msg("Goflow: Port out of range")
-				segment.ShutdownParentPipeline()
+				segments.ShutdownParentPipeline(ctx)
 				return
 			}
 			port := int(portU64)
@@ -189,7 +203,7 @@ func (segment *Goflow) startGoFlow(transport transport.TransportInterface) {
 			recv, err := utils.NewUDPReceiver(cfg)
 			if err != nil {
 				log.Fatal().Err(err).Msg("Goflow: Failed creating UDP receiver")
-				segment.ShutdownParentPipeline()
+				segments.ShutdownParentPipeline(ctx)
 				return
 			}
 
@@ -201,12 +215,12 @@ func (segment *Goflow) startGoFlow(transport transport.TransportInterface) {
 			flowProducer, err := protoproducer.CreateProtoProducer(cfgm, protoproducer.CreateSamplingSystem)
 			if err != nil {
 				log.Fatal().Err(err).Msg("Goflow: Failed creating proto producer")
-				segment.ShutdownParentPipeline()
+				segments.ShutdownParentPipeline(ctx)
 			}
 
 			cfgPipe := &utils.PipeConfig{
 				Format:           formatter,
-				Transport:        transport,
+				Transport:        driver,
 				Producer:         flowProducer,
 				NetFlowTemplater: metrics.NewDefaultPromTemplateSystem, // wrap template system to get Prometheus info
 			}
@@ -239,11 +253,22 @@ func (segment *Goflow) startGoFlow(transport transport.TransportInterface) {
 				log.Fatal().Err(err).Msg("Goflow: Failed starting goflow receiver")
 			}
 
+			// stop accepting flows once the pipeline is shutting down
+			<-ctx.Done()
+			if err := recv.Stop(); err != nil {
+				log.Warn().Err(err).Msgf("Goflow: Failed stopping receiver on port %d", port)
+			} else {
+				log.Info().Msgf("Goflow: Stopped listening on port %d.", port)
+			}
+
 		}(listenAddrUrl)
 	}
 }
 
 func init() {
 	segment := &Goflow{}
-	segments.RegisterSegment("goflow", segment)
+	segments.RegisterSegment("goflow", segment,
+		segments.Param{Name: "listen", Default: "sflow://:6343,netflow://:2055", Description: "comma-separated list of URLs to listen on, with the scheme being one of sflow, netflow or nfl"},
+		segments.Param{Name: "workers", Type: segments.Uint, Default: "1", Description: "number of workers to spawn for each listen URL"},
+	)
 }
@@ -1,7 +1,13 @@
 package goflow
 
 import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
@@ -9,9 +15,73 @@ import (
 
 // Goflow Segment test, passthrough test only, functionality is tested by Goflow package
 func TestSegment_Goflow_passthrough(t *testing.T) {
-	result := segments.TestSegment("goflow", map[string]string{"port": "2055"},
+	result := segments.TestSegment("goflow", map[string]string{"listen": "netflow://:2055"},
 		&pb.EnrichedFlow{})
 	if result == nil {
 		t.Error("([error] Segment Goflow is not passing through flows.")
 	}
 }
+
+// Goflow Segment test, flows received before shutting down are not lost
+func TestSegment_Goflow_drain(t *testing.T) {
+	socket, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := socket.LocalAddr().(*net.UDPAddr).Port
+	socket.Close()
+
+	segment := Goflow{}.New(map[string]string{"listen": fmt.Sprintf("netflow://127.0.0.1:%d", port)})
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(ctx, wg)
+	time.Sleep(100 * time.Millisecond) // for the receiver to listen
+
+	// a NetFlow v5 packet containing several records
+	const records = 5
+	packet := make([]byte, 24+48*records)
+	binary.BigEndian.PutUint16(packet[0:], 5)
+	binary.BigEndian.PutUint16(packet[2:], records)
+	binary.BigEndian.PutUint32(packet[8:], uint32(time.Now().Unix()))
+	for i := range records {
+		record := packet[24+48*i:]
+		copy(record[0:], []byte{192, 0, 2, byte(i)})
+		binary.BigEndian.PutUint32(record[16:], 1)   // packets
+		binary.BigEndian.PutUint32(record[20:], 100) // bytes
+		record[38] = 6
+	}
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // for the flows to be decoded
+
+	// shut down like Pipeline.Close, before reading any flows
+	cancel()
+	close(in)
+	received := 0
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case _, ok := <-out:
+			if !ok {
+				done = true
+			} else {
+				received += 1
+			}
+		case <-timeout:
+			t.Fatalf("([error] Segment Goflow did not close its output after receiving %d flows.", received)
+		}
+	}
+	wg.Wait()
+	if received != records {
+		t.Errorf("([error] Segment Goflow passed on %d of %d flows received before shutting down.", received, records)
+	}
+}
@@ -44,7 +44,11 @@ func (h *Handler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama
 				session.MarkMessage(message, "")
 				flowMsg := new(pb.LegacyEnrichedFlow)
 				if err := proto.Unmarshal(message.Value, flowMsg); err == nil {
-					h.flows <- flowMsg.ConvertToEnrichedFlow()
+					select {
+					case h.flows <- flowMsg.ConvertToEnrichedFlow():
+					case <-session.Context().Done():
+						return nil
+					}
 				} else {
 					log.Warn().Err(err).Msg("KafkaConsumer: Error decoding flow, this might be due to the use of Goflow custom fields. Original error:\n  ")
 				}
@@ -54,7 +58,11 @@ func (h *Handler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama
 					log.Error().Err(err).Msg("KafkaConsumer: Failed unmarshalling message")
 					continue
 				}
-				h.flows <- &msg.EnrichedFlow
+				select {
+				case h.flows <- &msg.EnrichedFlow:
+				case <-session.Context().Done():
+					return nil
+				}
 			}
 		case <-session.Context().Done():
 			return nil
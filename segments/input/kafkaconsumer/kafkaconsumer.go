@@ -49,7 +49,6 @@ type KafkaConsumer struct {
 
 	startingOffset int64
 	saramaConfig   *sarama.Config
-	shutdown       chan bool //required for gracefull shutdown
 }
 
 func (segment KafkaConsumer) New(config map[string]string) segments.Segment {
@@ -61,7 +60,6 @@ func (segment KafkaConsumer) New(config map[string]string) segments.Segment {
 		log.Warn().Err(err).Msg("KafkaConsumer: failed to fetch hostname")
 		newsegment.saramaConfig.ClientID = "unknown"
 	}
-	newsegment.shutdown = make(chan bool)
 
 	if config["server"] == "" || config["topic"] == "" || config["group"] == "" {
 		log.Error().Msg("KafkaConsumer: Missing required configuration parameters.")
@@ -210,13 +208,7 @@ func (segment KafkaConsumer) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *KafkaConsumer) Close() {
-	log.Info().Msg("KafkaConsumer: Received connection shutdown command")
-	segment.shutdown <- true
-	close(segment.shutdown)
-}
-
-func (segment *KafkaConsumer) Run(wg *sync.WaitGroup) {
+func (segment *KafkaConsumer) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -235,7 +227,7 @@ func (segment *KafkaConsumer) Run(wg *sync.WaitGroup) {
 
 	handlerCtx, handlerCancel := context.WithCancel(context.Background())
 	var handler = &Handler{
-		ready:  make(chan bool),
+		ready:  make(chan bool, 1),
 		flows:  make(chan *pb.EnrichedFlow),
 		legacy: segment.Legacy,
 	}
@@ -264,11 +256,8 @@ func (segment *KafkaConsumer) Run(wg *sync.WaitGroup) {
 					select {
 					case <-time.After(retryInterval):
 						continue connectionRetryLoop
-					case <-segment.shutdown:
-						log.Info().Msg("KafkaConsumer: Aborting connection attempt")
-						handler.ready <- false
-						break connectionRetryLoop
 					case <-handlerCtx.Done():
+						log.Info().Msg("KafkaConsumer: Aborting connection attempt")
 						return
 					}
 				} else {
@@ -291,7 +280,17 @@ func (segment *KafkaConsumer) Run(wg *sync.WaitGroup) {
 			log.Warn().Err(kafkaErr).Msg("KafaConsumer: kafka error")
 		}
 	}()
-	handlerReady := <-handler.ready
+	var handlerReady bool
+	select {
+	case handlerReady = <-handler.ready:
+	case <-ctx.Done():
+		log.Info().Msg("KafkaConsumer: Aborting connection attempt")
+		handlerCancel()
+		for msg := range segment.In {
+			segment.Out <- msg
+		}
+		return
+	}
 	if !handlerReady {
 		log.Error().Msg("KafkaConsumer: Failed to establish connection.")
 		handlerCancel()
@@ -315,10 +314,13 @@ func (segment *KafkaConsumer) Run(wg *sync.WaitGroup) {
 			} else {
 				segment.Out <- msg
 			}
-		case <-segment.shutdown:
+		case <-ctx.Done():
 			log.Info().Msg("KafkaConsumer: Closing connection")
 			handlerCancel()
-			log.Info().Msg("KafkaConsumer: Connection Closed")
+			// keep forwarding until our predecessor closes In
+			for msg := range segment.In {
+				segment.Out <- msg
+			}
 			return
 		case handlerReady = <-handler.ready:
 			if !handlerReady {
@@ -333,5 +335,18 @@ func (segment *KafkaConsumer) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &KafkaConsumer{}
-	segments.RegisterSegment("kafkaconsumer", segment)
+	segments.RegisterSegment("kafkaconsumer", segment,
+		segments.Param{Name: "server", Required: true, Description: "the Kafka broker to connect to, e.g. \"kafka.example.com:9093\""},
+		segments.Param{Name: "topic", Required: true, Description: "the topic to consume"},
+		segments.Param{Name: "group", Required: true, Description: "the consumer group to join"},
+		segments.Param{Name: "user", Description: "SASL user name, required if auth is enabled"},
+		segments.Param{Name: "pass", Description: "SASL password, required if auth is enabled"},
+		segments.Param{Name: "tls", Type: segments.Bool, Default: "true", Description: "use TLS to connect to Kafka"},
+		segments.Param{Name: "auth", Type: segments.Bool, Default: "true", Description: "authenticate using SASL"},
+		segments.Param{Name: "startat", Default: "newest", Description: "where fresh consumer groups start consuming, either \"oldest\" or \"newest\""},
+		segments.Param{Name: "timeout", Type: segments.Duration, Default: "15s", Description: "timeout for connecting to Kafka"},
+		segments.Param{Name: "legacy", Type: segments.Bool, Default: "false", Description: "expect flows in the legacy protobuf format"},
+		segments.Param{Name: "strategy", Default: "sticky", Description: "comma-separated list of rebalancing strategies, any of \"sticky\", \"roundrobin\" and \"range\""},
+		segments.Param{Name: "kafka-version", Default: "3.8.0", Description: "the Kafka version to assume for the protocol"},
+	)
 }
@@ -0,0 +1,148 @@
+//go:build linux
+// +build linux
+
+package packet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// Returns the capture files given by a comma-separated list of files and
+// directories, of which all regular files are used.
+func captureFiles(source string) ([]string, error) {
+	var files []string
+	for _, name := range strings.Split(source, ",") {
+		name = strings.TrimSpace(name)
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, name)
+			continue
+		}
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries { // sorted by name
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(name, entry.Name()))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found in '%s'", source)
+	}
+	return files, nil
+}
+
+// Opens a pcapng or pcap file, using libpcap if a filter is given.
+func openCapture(filename string, filter string) (*gopacket.PacketSource, func(), error) {
+	if filter != "" && cgoEnabled {
+		handle := getPcapFile(filename, filter)
+		return gopacket.NewPacketSource(handle, handle.LinkType()), handle.Close, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	close := func() { file.Close() }
+	if handle, err := pcapgo.NewNgReader(file, pcapgo.DefaultNgReaderOptions); err == nil {
+		return gopacket.NewPacketSource(handle, handle.LinkType()), close, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	handle, err := pcapgo.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return gopacket.NewPacketSource(handle, handle.LinkType()), close, nil
+}
+
+// Reads the packets of all files, merged in the order of their timestamps,
+// and closes the channel once all have been read. If Speed is set, packets are
+// delayed to replay them at that multiple of their original speed.
+func (segment *Packet) replay(ctx context.Context, packets chan<- gopacket.Packet) {
+	defer close(packets)
+
+	type capture struct {
+		name   string
+		source *gopacket.PacketSource
+		next   gopacket.Packet
+	}
+	var captures []*capture
+	// reads the next packet, returning false at the end of the file
+	advance := func(c *capture) bool {
+		packet, err := c.source.NextPacket()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Warn().Err(err).Msgf("Packet: Stopped reading %s early: ", c.name)
+			}
+			return false
+		}
+		c.next = packet
+		return true
+	}
+	for _, name := range segment.files {
+		source, close, err := openCapture(name, segment.Filter)
+		if err != nil {
+			log.Error().Err(err).Msgf("Packet: Could not read capture from file %s: ", name)
+			continue
+		}
+		defer close()
+		c := &capture{name: name, source: source}
+		if advance(c) {
+			captures = append(captures, c)
+		}
+	}
+
+	var start, first time.Time // of the replay and of its first packet
+	for len(captures) > 0 {
+		// ties are broken by the order of the files
+		i := 0
+		for j, c := range captures {
+			if c.next.Metadata().Timestamp.Before(captures[i].next.Metadata().Timestamp) {
+				i = j
+			}
+		}
+		c := captures[i]
+		timestamp := c.next.Metadata().Timestamp
+		if segment.Speed > 0 {
+			if start.IsZero() {
+				start, first = time.Now(), timestamp
+			}
+			due := start.Add(time.Duration(float64(timestamp.Sub(first)) / segment.Speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		select {
+		case packets <- c.next:
+		case <-ctx.Done():
+			return
+		}
+		if !advance(c) {
+			captures = append(captures[:i], captures[i+1:]...)
+		}
+	}
+}
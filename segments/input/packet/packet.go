@@ -13,18 +13,27 @@
 // - `pcapgo`, the only completely CGO-free, pure-Go method that should work anywhere, but does not support BPF filters
 // - `pcap`, a wrapper around libpcap, requires that at compile- and runtime
 // - `pfring`, a wrapper around PF_RING, requires the appropriate libraries as well as the loaded kernel module
-// - `file`, a `pcapgo` replay reader for PCAP files which will fallback to `pcap` automatically if either:
-//   1. the file is not in `.pcapng` format, but using the legacy `.pcap` format
-//   2. a BPF filter was specified
+// - `file`, a `pcapgo` replay reader for `.pcapng` and `.pcap` files which will fallback to `pcap` automatically if a BPF filter was specified
 //
 // The filter parameter available for some methods will filter packets before they are
 // aggregated in any flow cache.
+//
+// When using the `file` method, `source` may be a comma-separated list of files
+// and directories, whose packets are merged in the order of their timestamps.
+// The flow cache then uses a virtual clock driven by these timestamps, so that
+// the timeouts apply to the time the packets were captured at, and replaying
+// the same files always results in the same flows. Flows still in the cache
+// are exported at the end of the files. By default, packets are read as fast
+// as possible, while `speed` replays them at a multiple of their original
+// speed, e.g. `1` for real time or `10` for ten times as fast.
 package packet
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -44,11 +53,14 @@ type Packet struct {
 
 	exporter *aggregate.FlowExporter
 
-	Method          string // required, default is "pcap", one of the available capture methods "pcapgo|pcap|pfring|file"
-	Source          string // required, the name of the source to capture from, depending on the method an interface or file name is required
-	Filter          string // optional, a BPF filter which is applied when using a libpcap-based method
-	ActiveTimeout   string // optional, default is 30m
-	InactiveTimeout string // optional, default is 15s
+	Method          string  // required, default is "pcap", one of the available capture methods "pcapgo|pcap|pfring|file"
+	Source          string  // required, the name of the source to capture from, depending on the method an interface or file names are required
+	Filter          string  // optional, a BPF filter which is applied when using a libpcap-based method
+	ActiveTimeout   string  // optional, default is 30m
+	InactiveTimeout string  // optional, default is 15s
+	Speed           float64 // optional, default is 0 which means as fast as possible, the replay speed for the file method
+
+	files []string
 }
 
 type opt struct {
@@ -141,9 +153,17 @@ func (segment Packet) New(config map[string]string) segments.Segment {
 		return nil
 	}
 	if newsegment.Method == "file" {
-		if err, newsegment.Source = c.parseOption(opt{"source", "", []string{}, "rfile"}); err != nil {
+		if newsegment.files, err = captureFiles(config["source"]); err != nil {
+			log.Error().Err(err).Msg("Packet: Field 'source' must be set to existing files or directories: ")
 			return nil
 		}
+		newsegment.Source = config["source"]
+		if config["speed"] != "" {
+			if newsegment.Speed, err = strconv.ParseFloat(config["speed"], 64); err != nil || newsegment.Speed < 0 {
+				log.Error().Err(err).Msg("Packet: Could not parse 'speed' parameter, it has to be a non-negative number: ")
+				return nil
+			}
+		}
 	} else {
 		if err, newsegment.Source = c.parseOption(opt{"source", "", []string{}, "iface"}); err != nil {
 			return nil
@@ -165,7 +185,7 @@ func (segment Packet) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *Packet) Run(wg *sync.WaitGroup) {
+func (segment *Packet) Run(ctx context.Context, wg *sync.WaitGroup) {
 	var pktsrc *gopacket.PacketSource
 	switch segment.Method {
 	case "pcapgo":
@@ -192,35 +212,10 @@ func (segment *Packet) Run(wg *sync.WaitGroup) {
 			defer ring.Close()
 			pktsrc = gopacket.NewPacketSource(ring, layers.LinkTypeEthernet)
 		}
-	case "file":
-		f, err := os.Open(segment.Source)
-		if err != nil {
-			log.Fatal().Err(err).Msg("Packet: Could not open file: ")
-		}
-		defer f.Close()
-
-		if segment.Filter != "" && cgoEnabled {
-			handle := getPcapFile(segment.Source, segment.Filter)
-			pktsrc = gopacket.NewPacketSource(handle, handle.LinkType())
-		} else if handle, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions); err == nil {
-			pktsrc = gopacket.NewPacketSource(handle, handle.LinkType())
-		} else {
-			if err.Error() == "Unknown magic a1b2c3d4" && cgoEnabled {
-				if cgoEnabled {
-					log.Warn().Msgf("Packet: Legacy pcap file detected, falling back to using libpcap instead of pure-Go implementation.")
-					handle := getPcapFile(segment.Source, segment.Filter)
-					pktsrc = gopacket.NewPacketSource(handle, handle.LinkType())
-				} else {
-					log.Fatal().Msgf("Packet: Could not read legacy pcap file, and classic libpcap is unavailable.")
-				}
-			} else {
-				log.Fatal().Err(err).Msg("Packet: Could not read capture from file: ")
-			}
-		}
 	}
 
 	if segment.Method == "file" {
-		segment.exporter.Start(nil, nil)
+		segment.exporter.StartVirtual(nil, nil)
 	} else {
 		iface, _ := net.InterfaceByName(segment.Source)
 		var samplerAddress net.IP
@@ -238,13 +233,19 @@ func (segment *Packet) Run(wg *sync.WaitGroup) {
 	}
 
 	go func() {
-		segment.exporter.ConsumeFrom(pktsrc.Packets())
 		if segment.Method == "file" {
-			log.Info().Msg("Packet: The pcap has ended.")
-			segment.ShutdownParentPipeline()
-		} else {
-			log.Fatal().Msg("Packet: The packet stream has ended for an unknown reason.")
+			packets := make(chan gopacket.Packet)
+			go segment.replay(ctx, packets)
+			segment.exporter.ConsumeFrom(packets)
+			if ctx.Err() == nil {
+				segment.exporter.ExportAll()
+			}
+			log.Info().Msg("Packet: All pcap files have ended.")
+			segments.ShutdownParentPipeline(ctx)
+			return
 		}
+		segment.exporter.ConsumeFrom(pktsrc.Packets())
+		log.Fatal().Msg("Packet: The packet stream has ended for an unknown reason.")
 	}()
 
 	defer func() {
@@ -270,5 +271,12 @@ func (segment *Packet) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Packet{}
-	segments.RegisterSegment("packet", segment)
+	segments.RegisterSegment("packet", segment,
+		segments.Param{Name: "method", Default: "pcapgo", Description: "capture method, one of \"pcapgo\", \"pcap\", \"pfring\" or \"file\""},
+		segments.Param{Name: "source", Required: true, Description: "the interface to capture from, or a comma-separated list of PCAP files and directories to read if method is \"file\""},
+		segments.Param{Name: "filter", Description: "BPF filter applied to all packets, requires a libpcap-based method"},
+		segments.Param{Name: "activetimeout", Type: segments.Duration, Default: "30m", Description: "duration after which long-running flows are exported"},
+		segments.Param{Name: "inactivetimeout", Type: segments.Duration, Default: "15s", Description: "duration of inactivity after which flows are exported"},
+		segments.Param{Name: "speed", Type: segments.Float, Default: "0", Description: "replay files at this multiple of their original speed, as fast as possible if 0"},
+	)
 }
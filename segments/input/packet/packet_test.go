@@ -1,3 +1,179 @@
 package packet
 
-// TODO: how to fake device/file presence on testing host
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// TODO: how to fake device presence on testing host
+
+// Returns an Ethernet frame containing a UDP packet.
+func udpPacket(t *testing.T, srcPort uint16) []byte {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{192, 0, 2, 2}}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: 53}
+	if err := udp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, eth, ip, udp, gopacket.Payload("flow")); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type capturedPacket struct {
+	timestamp time.Time
+	srcPort   uint16
+}
+
+// Writes a pcap file, or a pcapng file if ng is set.
+func writeCapture(t *testing.T, filename string, ng bool, packets []capturedPacket) {
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var write func(gopacket.CaptureInfo, []byte) error
+	if ng {
+		w, err := pcapgo.NewNgWriter(file, layers.LinkTypeEthernet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Flush()
+		write = w.WritePacket
+	} else {
+		w := pcapgo.NewWriter(file)
+		if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+			t.Fatal(err)
+		}
+		write = w.WritePacket
+	}
+	for _, packet := range packets {
+		data := udpPacket(t, packet.srcPort)
+		info := gopacket.CaptureInfo{Timestamp: packet.timestamp, CaptureLength: len(data), Length: len(data)}
+		if err := write(info, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Runs the segment until it has read all files, returning the flows.
+func replay(t *testing.T, config map[string]string) []*pb.EnrichedFlow {
+	segment := Packet{}.New(config)
+	if segment == nil {
+		t.Fatal("([error] Segment Packet failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	done := make(chan struct{})
+	ctx := segments.WithShutdown(context.Background(), func() { close(done) })
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(ctx, wg)
+
+	var flows []*pb.EnrichedFlow
+	for {
+		select {
+		case flow := <-out:
+			flows = append(flows, flow)
+		case <-done:
+			close(in)
+			for flow := range out {
+				flows = append(flows, flow)
+			}
+			wg.Wait()
+			return flows
+		}
+	}
+}
+
+// Packet Segment test, replays files using the packet timestamps
+func TestSegment_Packet_file(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// a short flow, and the same flow again an hour later
+	writeCapture(t, filepath.Join(dir, "a.pcap"), false, []capturedPacket{
+		{start, 1000},
+		{start.Add(10 * time.Second), 1000},
+		{start.Add(20 * time.Second), 1000},
+		{start.Add(time.Hour), 1000},
+	})
+	// a long flow, interleaved with the first file
+	var packets []capturedPacket
+	for i := range 16 {
+		packets = append(packets, capturedPacket{start.Add(5*time.Second + time.Duration(i)*10*time.Second), 2000})
+	}
+	writeCapture(t, filepath.Join(dir, "b.pcapng"), true, packets)
+
+	config := map[string]string{"method": "file", "source": dir, "activetimeout": "1m", "inactivetimeout": "15s"}
+	flows := replay(t, config)
+
+	var short []uint64
+	var long []*pb.EnrichedFlow
+	var packetCount uint64
+	for _, flow := range flows {
+		packetCount += flow.Packets
+		switch flow.SrcPort {
+		case 1000:
+			short = append(short, flow.Packets)
+		case 2000:
+			long = append(long, flow)
+		}
+	}
+	if packetCount != 20 {
+		t.Errorf("([error] Segment Packet exported %d packets, expected 20.", packetCount)
+	}
+	if len(short) != 2 || short[0] != 3 || short[1] != 1 {
+		t.Errorf("([error] Segment Packet did not use the inactive timeout on packet timestamps, got flows with %v packets.", short)
+	}
+	if len(long) < 3 {
+		t.Errorf("([error] Segment Packet did not use the active timeout on packet timestamps, got %d flows.", len(long))
+	}
+	for _, flow := range long {
+		if duration := time.Duration(flow.TimeFlowEndNs - flow.TimeFlowStartNs); duration > time.Minute+15*time.Second {
+			t.Errorf("([error] Segment Packet exported a flow lasting %s.", duration)
+		}
+	}
+
+	// the same files always result in the same flows
+	again := replay(t, config)
+	if len(again) != len(flows) {
+		t.Fatalf("([error] Segment Packet exported %d flows on the second replay, expected %d.", len(again), len(flows))
+	}
+	for i := range flows {
+		if again[i].SrcPort != flows[i].SrcPort || again[i].Packets != flows[i].Packets || again[i].TimeFlowStartNs != flows[i].TimeFlowStartNs {
+			t.Errorf("([error] Segment Packet exported flow %d differently on the second replay.", i)
+		}
+	}
+}
+
+// Packet Segment test, paced replay
+func TestSegment_Packet_speed(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeCapture(t, filepath.Join(dir, "a.pcap"), false, []capturedPacket{
+		{start, 1000},
+		{start.Add(10 * time.Second), 2000},
+	})
+	began := time.Now()
+	flows := replay(t, map[string]string{"method": "file", "source": filepath.Join(dir, "a.pcap"), "speed": "50"})
+	if elapsed := time.Since(began); elapsed < 200*time.Millisecond {
+		t.Errorf("([error] Segment Packet replayed 10s at 50x speed in %s.", elapsed)
+	}
+	if len(flows) != 2 {
+		t.Errorf("([error] Segment Packet exported %d flows, expected 2.", len(flows))
+	}
+}
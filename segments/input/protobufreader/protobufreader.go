@@ -0,0 +1,171 @@
+// The `protobufreader` segment reads flows written by the `protobuf` segment
+// from stdin or the files given by `filename` and introduces them into the
+// pipeline. The `filename` parameter may be a glob pattern such as
+// `flows-*.pb.zst` to read all files created by the `protobuf` segment's
+// rotation, which are read one after another in lexical order, i.e. in the
+// order they were written. Compressed files are detected automatically.
+//
+// The `eofcloses` parameter can be used to gracefully terminate the pipeline
+// after reading all input.
+package protobufreader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+	"github.com/BelWue/flowpipeline/segments/output/protobuf"
+)
+
+// The first bytes of any zstd compressed file.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+type ProtobufReader struct {
+	segments.BaseSegment
+	FileNames []string // optional, default is empty which means read from stdin
+	EofCloses bool     // optional, default is false, closes the pipeline gracefully after all input was read
+}
+
+func (segment ProtobufReader) New(config map[string]string) segments.Segment {
+	newsegment := &ProtobufReader{}
+
+	if config["filename"] != "" {
+		filenames, err := filepath.Glob(config["filename"])
+		if err != nil {
+			log.Error().Err(err).Msg("ProtobufReader: Could not parse 'filename' parameter: ")
+			return nil
+		}
+		if len(filenames) == 0 {
+			log.Error().Msgf("ProtobufReader: No file matches '%s'.", config["filename"])
+			return nil
+		}
+		// Glob returns the matches in lexical order
+		newsegment.FileNames = filenames
+	} else {
+		log.Info().Msg("ProtobufReader: 'filename' unset, using stdin.")
+	}
+
+	if config["eofcloses"] != "" {
+		eofCloses, err := strconv.ParseBool(config["eofcloses"])
+		if err != nil {
+			log.Error().Err(err).Msg("ProtobufReader: Could not parse 'eofcloses' parameter: ")
+			return nil
+		}
+		newsegment.EofCloses = eofCloses
+	}
+	return newsegment
+}
+
+func (segment *ProtobufReader) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	fromFiles := make(chan *pb.EnrichedFlow)
+	go func() {
+		if len(segment.FileNames) == 0 {
+			if err := read(ctx, os.Stdin, fromFiles); err != nil {
+				log.Error().Err(err).Msg("ProtobufReader: Failed to read flows from stdin: ")
+			}
+		}
+		for _, filename := range segment.FileNames {
+			if err := readFile(ctx, filename, fromFiles); err != nil {
+				log.Error().Err(err).Msgf("ProtobufReader: Failed to read flows from %s: ", filename)
+			}
+		}
+		if ctx.Err() == nil && segment.EofCloses {
+			log.Info().Msg("ProtobufReader: Read all input, closing pipeline")
+			segments.ShutdownParentPipeline(ctx)
+		}
+	}()
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			// stop reading, but keep forwarding until In is closed
+			done, fromFiles = nil, nil
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.Out <- msg
+		case msg := <-fromFiles:
+			segment.Out <- msg
+		}
+	}
+}
+
+func readFile(ctx context.Context, filename string, flows chan<- *pb.EnrichedFlow) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return read(ctx, file, flows)
+}
+
+// Reads the header and all flows from a single output of the protobuf
+// segment, decompressing it if necessary, until its end or until the context
+// is cancelled.
+func read(ctx context.Context, input io.Reader, flows chan<- *pb.EnrichedFlow) error {
+	reader := bufio.NewReader(input)
+	if start, _ := reader.Peek(len(zstdMagic)); bytes.Equal(start, zstdMagic) {
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		reader = bufio.NewReader(decoder)
+	}
+
+	magic := make([]byte, len(protobuf.Magic))
+	if _, err := io.ReadFull(reader, magic); err == io.EOF {
+		return nil // empty input
+	} else if err != nil || !bytes.Equal(magic, protobuf.Magic) {
+		return errors.New("not written by the protobuf segment, the header is missing")
+	}
+	version, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+	if version > protobuf.SchemaVersion {
+		log.Warn().Msgf("ProtobufReader: Input uses schema version %d, but only version %d is known, fields may be misinterpreted.", version, protobuf.SchemaVersion)
+	}
+
+	for {
+		msg := &pb.EnrichedFlow{}
+		if err := protodelim.UnmarshalFrom(reader, msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		select {
+		case flows <- msg:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func init() {
+	segment := &ProtobufReader{}
+	segments.RegisterSegment("protobufreader", segment,
+		segments.Param{Name: "filename", Description: "file to read flows from instead of stdin, may be a glob pattern matching several files"},
+		segments.Param{Name: "eofcloses", Type: segments.Bool, Default: "false", Description: "shut down the pipeline after reading all input"},
+	)
+}
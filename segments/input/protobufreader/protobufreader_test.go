@@ -0,0 +1,66 @@
+package protobufreader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+	_ "github.com/BelWue/flowpipeline/segments/output/protobuf"
+)
+
+// ProtobufReader Segment test, passthrough test only
+func TestSegment_ProtobufReader_passthrough(t *testing.T) {
+	result := segments.TestSegment("protobufreader", map[string]string{},
+		&pb.EnrichedFlow{Proto: 6})
+	if result == nil || result.Proto != 6 {
+		t.Error("([error] Segment ProtobufReader is not passing through flows.")
+	}
+}
+
+// ProtobufReader Segment test, reading files written by the protobuf segment
+func TestSegment_ProtobufReader_read(t *testing.T) {
+	dir := t.TempDir()
+	for i, config := range []map[string]string{
+		{"filename": filepath.Join(dir, "flows-1.pb")},
+		{"filename": filepath.Join(dir, "flows-2.pb"), "zstd": "3"},
+	} {
+		segments.TestSegment("protobuf", config, &pb.EnrichedFlow{Proto: uint32(i + 1)})
+	}
+	if err := os.WriteFile(filepath.Join(dir, "flows-3.pb"), []byte("{\"proto\":6}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	segment := ProtobufReader{}.New(map[string]string{"filename": filepath.Join(dir, "flows-*.pb")})
+	if segment == nil {
+		t.Fatal("([error] Segment ProtobufReader failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(ctx, wg)
+
+	for i := 1; i <= 2; i++ {
+		if msg := <-out; msg.Proto != uint32(i) {
+			t.Errorf("([error] Segment ProtobufReader read flow %d as %v.", i, msg)
+		}
+	}
+	cancel()
+	close(in)
+	for msg := range out {
+		t.Errorf("([error] Segment ProtobufReader read unexpected flow %v.", msg)
+	}
+	wg.Wait()
+}
+
+// ProtobufReader Segment test, patterns without any matching files
+func TestSegment_ProtobufReader_nomatch(t *testing.T) {
+	if segment := (ProtobufReader{}).New(map[string]string{"filename": filepath.Join(t.TempDir(), "*.pb")}); segment != nil {
+		t.Error("([error] Segment ProtobufReader accepted a pattern without matches.")
+	}
+}
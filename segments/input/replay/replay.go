@@ -4,11 +4,15 @@
 // contain all columns/fields that are exported from the `EnrichedFlow` type. If
 // `respecttiming` is set to `true`, the segment will respect the timing of the original
 // flows and will replay them accordingly. Otherwise, the segment will emit all flows
-// instantly after each other.
+// instantly after each other. The deprecated `ignoretiming` parameter is an alias
+// of `respecttiming`, despite its name. Map fields such as `Labels` are read from
+// the JSON objects the `sqlite` segment stores them as.
 package replay
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
@@ -53,8 +57,8 @@ func (segment Replay) New(config map[string]string) segments.Segment {
 	fileName := config["filename"]
 
 	respectTiming := true
-	if config["ignoretiming"] != "" {
-		if parsed, err := strconv.ParseBool(config["ignoretiming"]); err == nil {
+	if config["respecttiming"] != "" {
+		if parsed, err := strconv.ParseBool(config["respecttiming"]); err == nil {
 			respectTiming = parsed
 		} else {
 			log.Error().Msg("StdIn: Could not parse 'respecttiming' parameter, using default 'true'.")
@@ -62,6 +66,15 @@ func (segment Replay) New(config map[string]string) segments.Segment {
 	} else {
 		log.Info().Msg("StdIn: 'respecttiming' set to default 'true'.")
 	}
+	if config["ignoretiming"] != "" {
+		// older configurations used this name, which was read as respecttiming
+		log.Warn().Msg("Replay: Parameter 'ignoretiming' is deprecated, use 'respecttiming' instead.")
+		if parsed, err := strconv.ParseBool(config["ignoretiming"]); err == nil {
+			respectTiming = parsed
+		} else {
+			log.Error().Msg("Replay: Could not parse 'ignoretiming' parameter, ignoring it.")
+		}
+	}
 
 	if !fileExists(fileName) {
 		log.Error().Msgf("Replay: The given database '%s' does not exist.", fileName)
@@ -80,7 +93,7 @@ func (segment Replay) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *Replay) Run(wg *sync.WaitGroup) {
+func (segment *Replay) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -138,6 +151,7 @@ func readFromDB(db *sql.DB) ([]*pb.EnrichedFlow, error) {
 		}
 
 		fieldPointers := make([]any, len(exportedFields))
+		maps := make(map[string]*string) // JSON objects of map fields by their name
 		var typ, bgpCommunities, asPath, mplsTtl, mplsLabel, mplsIp, layerStack, layerSize, ipv6RoutingHeaderAddresses, srcAddrAnon, dstAddrAnon, samplerAddrAnon, nextHopAnon, validationStatus, normalized, remoteAddr, srcAsPath, dstAsPath string
 		for i, fieldName := range exportedFields {
 			switch fieldName {
@@ -178,6 +192,11 @@ func readFromDB(db *sql.DB) ([]*pb.EnrichedFlow, error) {
 			case "DstAsPath":
 				fieldPointers[i] = &dstAsPath
 			default:
+				if field, _ := t.FieldByName(fieldName); field.Type.Kind() == reflect.Map {
+					maps[fieldName] = new(string)
+					fieldPointers[i] = maps[fieldName]
+					continue
+				}
 				fieldPointers[i] = v.FieldByName(fieldName).Addr().Interface()
 			}
 		}
@@ -206,6 +225,11 @@ func readFromDB(db *sql.DB) ([]*pb.EnrichedFlow, error) {
 		flow.RemoteAddr = pb.EnrichedFlow_RemoteAddrType(pb.EnrichedFlow_RemoteAddrType_value[remoteAddr])
 		flow.SrcAsPath, err = parseUint32Slice(srcAsPath)
 		flow.DstAsPath, err = parseUint32Slice(dstAsPath)
+		for fieldName, encoded := range maps {
+			if err == nil && *encoded != "" {
+				err = json.Unmarshal([]byte(*encoded), v.FieldByName(fieldName).Addr().Interface())
+			}
+		}
 
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to parse row data from database.")
@@ -241,7 +265,11 @@ func replay(flows []*pb.EnrichedFlow, respectTiming bool, out chan *pb.EnrichedF
 
 func init() {
 	segment := &Replay{}
-	segments.RegisterSegment("replay", segment)
+	segments.RegisterSegment("replay", segment,
+		segments.Param{Name: "filename", Type: segments.File, Required: true, Description: "the sqlite database to read the flows from"},
+		segments.Param{Name: "respecttiming", Type: segments.Bool, Default: "true", Description: "replay flows in the timing they were recorded in, instead of all at once"},
+		segments.Param{Name: "ignoretiming", Type: segments.Bool, Description: "deprecated alias of respecttiming, which it takes precedence over"},
+	)
 }
 
 func parseSlice[T any](s string, elementHandler func(string) (T, error)) ([]T, error) {
@@ -3,12 +3,37 @@
 // segment, which allows flowpipelines to be piped into each other. This segment can
 // also read files created with the `json` segment. The `eofcloses` parameter can
 // therefore be used to gracefully terminate the pipeline after reading the file.
+// Input compressed using the `json` segment's `zstd` option is detected and
+// decompressed automatically.
+//
+// If `follow` is set, the file given by `filename` is read like `tail -F` does:
+// starting at its current end, lines appended to it are read as they are
+// written. If the file is truncated, it is read from its start again, and if
+// it is replaced, for instance by log rotation, the rest of the old file is
+// read before continuing with the new one. A file not existing yet is read
+// from its start once it is created. Compressed files can't be followed.
+//
+// If `directory` is set instead, every `*.json` and `*.json.zst` file in that
+// directory is read, in lexical order, and deleted afterwards, or moved to the
+// directory given by `moveto`. New files are picked up as they appear, so
+// files should be moved into the directory once they are complete, as the
+// `json` segment does with its rotated files. Here, `eofcloses` terminates the
+// pipeline once no files are left. Both modes check for changes every
+// `pollinterval`.
 package stdin
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/pb"
@@ -19,21 +44,81 @@ import (
 	"sync"
 )
 
+// The first bytes of any zstd compressed file.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
 type StdIn struct {
 	segments.BaseSegment
 	scanner *bufio.Scanner
 
-	FileName  string // optional, default is empty which means read from stdin
-	EofCloses bool   // optional, default is false. Closes Pipeleine gracefully after input file was read
+	FileName     string        // optional, default is empty which means read from stdin
+	EofCloses    bool          // optional, default is false. Closes Pipeleine gracefully after input file was read
+	Follow       bool          // optional, default is false, keeps reading the file as it grows
+	Directory    string        // optional, default is empty, reads all files in this directory instead
+	MoveTo       string        // optional, default is empty which means files read from Directory are deleted
+	PollInterval time.Duration // optional, default is 1s
 }
 
 func (segment StdIn) New(config map[string]string) segments.Segment {
-	newsegment := &StdIn{}
+	newsegment := &StdIn{PollInterval: time.Second}
 
 	var filename string = "stdout"
 	var file *os.File
 	var err error
 	var eofCloses bool = false
+	if config["eofcloses"] != "" {
+		if parsedClose, err := strconv.ParseBool(config["eofcloses"]); err == nil {
+			eofCloses = parsedClose
+		} else {
+			log.Error().Msg("StdIn: Could not parse 'eofcloses' parameter, using default false.")
+		}
+	}
+	if config["follow"] != "" {
+		if newsegment.Follow, err = strconv.ParseBool(config["follow"]); err != nil {
+			log.Error().Err(err).Msg("StdIn: Could not parse 'follow' parameter: ")
+			return nil
+		}
+	}
+	if config["pollinterval"] != "" {
+		if newsegment.PollInterval, err = time.ParseDuration(config["pollinterval"]); err != nil || newsegment.PollInterval <= 0 {
+			log.Error().Err(err).Msg("StdIn: Could not parse 'pollinterval' parameter, it has to be a positive duration: ")
+			return nil
+		}
+	}
+	newsegment.Directory, newsegment.MoveTo = config["directory"], config["moveto"]
+
+	switch {
+	case newsegment.Directory != "":
+		if config["filename"] != "" || newsegment.Follow {
+			log.Error().Msg("StdIn: Parameter 'directory' can't be combined with 'filename' or 'follow'.")
+			return nil
+		}
+		for _, dir := range []string{newsegment.Directory, newsegment.MoveTo} {
+			if info, err := os.Stat(dir); dir != "" && (err != nil || !info.IsDir()) {
+				log.Error().Err(err).Msgf("StdIn: Directory %s is not accessible: ", dir)
+				return nil
+			}
+		}
+		log.Info().Msgf("StdIn: Reading files from %s.", newsegment.Directory)
+		newsegment.EofCloses = eofCloses
+		return newsegment
+	case newsegment.MoveTo != "":
+		log.Error().Msg("StdIn: Parameter 'moveto' requires 'directory'.")
+		return nil
+	case newsegment.Follow:
+		if config["filename"] == "" {
+			log.Error().Msg("StdIn: Parameter 'follow' requires 'filename'.")
+			return nil
+		}
+		if eofCloses {
+			log.Error().Msg("StdIn: Parameter 'follow' can't be combined with 'eofcloses'.")
+			return nil
+		}
+		log.Info().Msgf("StdIn: Following %s.", config["filename"])
+		newsegment.FileName = config["filename"]
+		return newsegment
+	}
+
 	if config["filename"] != "" {
 		file, err = os.Open(config["filename"])
 		if err != nil {
@@ -41,20 +126,15 @@ func (segment StdIn) New(config map[string]string) segments.Segment {
 			return nil
 		}
 		filename = config["filename"]
-		if config["eofcloses"] != "" {
-			if parsedClose, err := strconv.ParseBool(config["eofcloses"]); err == nil {
-				eofCloses = parsedClose
-			} else {
-				log.Error().Msg("StdIn: Could not parse 'eofcloses' parameter, using default false.")
-			}
-		} else {
+		if config["eofcloses"] == "" {
 			log.Info().Msg("StdIn: 'eofcloses' set to default false.")
 		}
 	} else {
 		file = os.Stdin
+		eofCloses = false
 		log.Info().Msg("StdIn: 'filename' unset, using stdIn.")
 	}
-	newsegment.scanner = bufio.NewScanner(file)
+	newsegment.scanner = bufio.NewScanner(newDecompressor(file))
 
 	newsegment.FileName = filename
 	newsegment.EofCloses = eofCloses
@@ -62,34 +142,28 @@ func (segment StdIn) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *StdIn) Run(wg *sync.WaitGroup) {
+func (segment *StdIn) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
 	}()
 	fromStdin := make(chan []byte)
 	go func() {
-		for {
-			scan := segment.scanner.Scan()
-			if err := segment.scanner.Err(); err != nil {
-				log.Warn().Err(err).Msg("StdIn: Skipping a flow, could not read line from stdin: ")
-				continue
-			}
-			if segment.EofCloses && !scan && segment.scanner.Err() == nil {
-				log.Info().Msgf("StdIn: Reached eof of %s, closing pipeline", segment.FileName)
-				segment.ShutdownParentPipeline()
-				return
-			}
-			if len(segment.scanner.Text()) == 0 {
-				continue
-			}
-			// we need to get full representation of text and cast it to []byte
-			// because scanner.Bytes doesn't return all content.
-			fromStdin <- []byte(segment.scanner.Text())
+		switch {
+		case segment.Directory != "":
+			segment.spool(ctx, fromStdin)
+		case segment.Follow:
+			segment.follow(ctx, fromStdin)
+		default:
+			segment.scan(ctx, fromStdin)
 		}
 	}()
+	done := ctx.Done()
 	for {
 		select {
+		case <-done:
+			// stop reading, but keep forwarding until In is closed
+			done, fromStdin = nil, nil
 		case msg, ok := <-segment.In:
 			if !ok {
 				return
@@ -107,7 +181,270 @@ func (segment *StdIn) Run(wg *sync.WaitGroup) {
 	}
 }
 
+// Reads all lines from stdin or a file once.
+func (segment *StdIn) scan(ctx context.Context, lines chan<- []byte) {
+	for {
+		scan := segment.scanner.Scan()
+		if err := segment.scanner.Err(); err != nil {
+			log.Error().Err(err).Msgf("StdIn: Failed to read from %s: ", segment.FileName)
+			return
+		}
+		if !scan {
+			if segment.EofCloses {
+				log.Info().Msgf("StdIn: Reached eof of %s, closing pipeline", segment.FileName)
+				segments.ShutdownParentPipeline(ctx)
+			}
+			return
+		}
+		if len(segment.scanner.Text()) == 0 {
+			continue
+		}
+		// we need to get full representation of text and cast it to []byte
+		// because scanner.Bytes doesn't return all content.
+		select {
+		case lines <- []byte(segment.scanner.Text()):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reads lines appended to FileName, handling truncation and replacement of
+// the file, until the context is cancelled.
+func (segment *StdIn) follow(ctx context.Context, lines chan<- []byte) {
+	var file *os.File
+	var reader *bufio.Reader
+	var offset int64
+	var partial []byte // an incomplete last line
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	// reads all complete lines and returns false once the context is cancelled
+	readLines := func() bool {
+		for {
+			line, err := reader.ReadBytes('\n')
+			offset += int64(len(line))
+			partial = append(partial, line...)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Warn().Err(err).Msgf("StdIn: Failed to read from %s: ", segment.FileName)
+				}
+				return true
+			}
+			if !sendLine(ctx, lines, partial) {
+				return false
+			}
+			partial = nil
+		}
+	}
+
+	ticker := time.NewTicker(segment.PollInterval)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		if file == nil {
+			var err error
+			if file, err = os.Open(segment.FileName); err == nil {
+				reader, offset, partial = bufio.NewReader(file), 0, nil
+				if first {
+					// like tail, start at the end of the existing file
+					offset, err = file.Seek(0, io.SeekEnd)
+				} else {
+					log.Info().Msgf("StdIn: Reading new file %s.", segment.FileName)
+				}
+			}
+			if err != nil {
+				log.Warn().Err(err).Msgf("StdIn: Failed to open %s, retrying: ", segment.FileName)
+				if file != nil {
+					file.Close()
+					file = nil
+				}
+			}
+		}
+		if file != nil {
+			if start, _ := reader.Peek(len(zstdMagic)); offset == 0 && bytes.Equal(start, zstdMagic) {
+				log.Error().Msgf("StdIn: Can't follow %s, it is compressed.", segment.FileName)
+				return
+			}
+			if !readLines() {
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if file == nil {
+			continue
+		}
+		current, err := file.Stat()
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(segment.FileName)
+		switch {
+		case err != nil:
+			// the file is missing during its rotation, keep reading the old one
+			continue
+		case !os.SameFile(info, current):
+			// read whatever was written to the old file before it was replaced
+			if !readLines() || (len(partial) > 0 && !sendLine(ctx, lines, partial)) {
+				return
+			}
+			file.Close()
+			file = nil
+		case info.Size() < offset:
+			log.Info().Msgf("StdIn: File %s has been truncated, reading from its start.", segment.FileName)
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				log.Warn().Err(err).Msgf("StdIn: Failed to read from %s: ", segment.FileName)
+			}
+			reader.Reset(file)
+			offset, partial = 0, nil
+		}
+	}
+}
+
+// Reads and removes all files in Directory, picking up new ones until the
+// context is cancelled or, if EofCloses is set, until no files are left.
+func (segment *StdIn) spool(ctx context.Context, lines chan<- []byte) {
+	failed := make(map[string]bool) // files which are not read again
+	ticker := time.NewTicker(segment.PollInterval)
+	defer ticker.Stop()
+	for {
+		var filenames []string
+		for _, pattern := range []string{"*.json", "*.json.zst"} {
+			matches, err := filepath.Glob(filepath.Join(segment.Directory, pattern))
+			if err != nil {
+				log.Error().Err(err).Msgf("StdIn: Failed to list %s: ", segment.Directory)
+				return
+			}
+			for _, filename := range matches {
+				if !failed[filename] {
+					filenames = append(filenames, filename)
+				}
+			}
+		}
+		sort.Strings(filenames)
+
+		for _, filename := range filenames {
+			err := readFile(ctx, filename, lines)
+			if ctx.Err() != nil {
+				// keep partially read files, they are read again after a restart
+				return
+			}
+			if err != nil {
+				log.Error().Err(err).Msgf("StdIn: Failed to read %s, leaving it in place: ", filename)
+				failed[filename] = true
+				continue
+			}
+			if segment.MoveTo != "" {
+				err = os.Rename(filename, filepath.Join(segment.MoveTo, filepath.Base(filename)))
+			} else {
+				err = os.Remove(filename)
+			}
+			if err != nil {
+				log.Error().Err(err).Msgf("StdIn: Failed to remove %s after reading it: ", filename)
+				failed[filename] = true
+			}
+		}
+
+		if len(filenames) == 0 && segment.EofCloses {
+			log.Info().Msgf("StdIn: Read all files in %s, closing pipeline", segment.Directory)
+			segments.ShutdownParentPipeline(ctx)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reads all lines from a file, which may be compressed.
+func readFile(ctx context.Context, filename string, lines chan<- []byte) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	input := newDecompressor(file)
+	defer input.Close()
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if !sendLine(ctx, lines, scanner.Bytes()) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// Sends a copy of a line without its line break, returning false if the
+// context has been cancelled.
+func sendLine(ctx context.Context, lines chan<- []byte, line []byte) bool {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return true
+	}
+	select {
+	case lines <- bytes.Clone(line):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Reads its input, decompressing it if it starts like a zstd compressed
+// file. The input is only inspected once it is read from.
+type decompressor struct {
+	input   *bufio.Reader
+	decoder *zstd.Decoder
+	checked bool
+}
+
+func newDecompressor(input io.Reader) *decompressor {
+	return &decompressor{input: bufio.NewReader(input)}
+}
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	if !d.checked {
+		d.checked = true
+		if start, _ := d.input.Peek(len(zstdMagic)); bytes.Equal(start, zstdMagic) {
+			decoder, err := zstd.NewReader(d.input, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return 0, err
+			}
+			d.decoder = decoder
+		}
+	}
+	if d.decoder != nil {
+		return d.decoder.Read(p)
+	}
+	return d.input.Read(p)
+}
+
+// Releases the resources of the decoder, if any.
+func (d *decompressor) Close() {
+	if d.decoder != nil {
+		d.decoder.Close()
+	}
+}
+
 func init() {
 	segment := &StdIn{}
-	segments.RegisterSegment("stdin", segment)
+	segments.RegisterSegment("stdin", segment,
+		segments.Param{Name: "filename", Description: "file to read flows from instead of stdin, which has to exist unless 'follow' is set"},
+		segments.Param{Name: "eofcloses", Type: segments.Bool, Default: "false", Description: "shut down the pipeline after reading the whole file, or all files in 'directory'"},
+		segments.Param{Name: "follow", Type: segments.Bool, Default: "false", Description: "keep reading lines appended to 'filename', like tail -F"},
+		segments.Param{Name: "directory", Description: "read and delete all *.json and *.json.zst files in this directory instead"},
+		segments.Param{Name: "moveto", Description: "move files read from 'directory' here instead of deleting them"},
+		segments.Param{Name: "pollinterval", Type: segments.Duration, Default: "1s", Description: "how often to check for new data in the 'follow' and 'directory' modes"},
+	)
 }
@@ -2,12 +2,16 @@ package stdin
 
 import (
 	"bufio"
+	"context"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog"
 )
 
@@ -34,7 +38,7 @@ func BenchmarkStdin(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{}
@@ -42,3 +46,145 @@ func BenchmarkStdin(b *testing.B) {
 	}
 	close(in)
 }
+
+// runs the segment, returning its Out channel and a function stopping it
+func startStdIn(t *testing.T, config map[string]string) (<-chan *pb.EnrichedFlow, func()) {
+	segment := StdIn{}.New(config)
+	if segment == nil {
+		t.Fatal("([error] Segment StdIn failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(ctx, wg)
+	return out, func() {
+		cancel()
+		close(in)
+		for range out {
+		}
+		wg.Wait()
+	}
+}
+
+func expectFlows(t *testing.T, out <-chan *pb.EnrichedFlow, ports ...uint32) {
+	t.Helper()
+	for _, port := range ports {
+		select {
+		case msg := <-out:
+			if msg.SrcPort != port {
+				t.Errorf("([error] Segment StdIn read SrcPort %d, expected %d.", msg.SrcPort, port)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("([error] Segment StdIn did not read the flow with SrcPort %d.", port)
+		}
+	}
+}
+
+func appendFile(t *testing.T, filename string, content string) {
+	t.Helper()
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// StdIn Segment test, follows a file through truncation and rotation
+func TestSegment_StdIn_follow(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.json")
+	appendFile(t, filename, "{\"srcPort\":1}\n")
+	out, stop := startStdIn(t, map[string]string{"filename": filename, "follow": "true", "pollinterval": "10ms"})
+	defer stop()
+	time.Sleep(50 * time.Millisecond)
+
+	// existing lines are skipped, incomplete lines are read once complete
+	appendFile(t, filename, "{\"srcPort\":2}\n{\"srcPort\"")
+	expectFlows(t, out, 2)
+	appendFile(t, filename, ":3}\n")
+	expectFlows(t, out, 3)
+
+	if err := os.Truncate(filename, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, filename, "{\"srcPort\":4}\n")
+	expectFlows(t, out, 4)
+
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, filename+".1", "{\"srcPort\":5}\n")
+	appendFile(t, filename, "{\"srcPort\":6}\n")
+	expectFlows(t, out, 5, 6)
+}
+
+// StdIn Segment test, follows a file created after starting
+func TestSegment_StdIn_followMissing(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.json")
+	config, errs := segments.ApplyParams("stdin", map[string]string{"filename": filename, "follow": "true", "pollinterval": "10ms"})
+	if len(errs) > 0 {
+		t.Fatalf("([error] Segment StdIn does not accept following a missing file: %v", errs)
+	}
+	out, stop := startStdIn(t, config)
+	defer stop()
+	time.Sleep(50 * time.Millisecond)
+
+	appendFile(t, filename, "{\"srcPort\":1}\n")
+	expectFlows(t, out, 1)
+
+	if (StdIn{}).New(map[string]string{"filename": filename + ".missing"}) != nil {
+		t.Error("([error] Segment StdIn accepted a missing file without 'follow'.")
+	}
+}
+
+// StdIn Segment test, reads plain and compressed files from a directory
+func TestSegment_StdIn_directory(t *testing.T) {
+	spool, done := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(spool, "a.json"), []byte("{\"srcPort\":1}\n{\"srcPort\":2}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	encoder, _ := zstd.NewWriter(nil)
+	compressed := encoder.EncodeAll([]byte("{\"srcPort\":3}\n"), nil)
+	if err := os.WriteFile(filepath.Join(spool, "b.json.zst"), compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(spool, "c.json.tmp"), []byte("{\"srcPort\":9}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, stop := startStdIn(t, map[string]string{"directory": spool, "moveto": done, "pollinterval": "10ms"})
+	defer stop()
+	expectFlows(t, out, 1, 2, 3)
+
+	if err := os.Rename(filepath.Join(spool, "c.json.tmp"), filepath.Join(spool, "c.json")); err != nil {
+		t.Fatal(err)
+	}
+	expectFlows(t, out, 9)
+
+	time.Sleep(50 * time.Millisecond)
+	for _, name := range []string{"a.json", "b.json.zst", "c.json"} {
+		if _, err := os.Stat(filepath.Join(done, name)); err != nil {
+			t.Errorf("([error] Segment StdIn did not move %s: %v", name, err)
+		}
+	}
+	if remaining, _ := os.ReadDir(spool); len(remaining) != 0 {
+		t.Errorf("([error] Segment StdIn left %d files in the directory.", len(remaining))
+	}
+}
+
+// StdIn Segment test, reads a compressed file
+func TestSegment_StdIn_zstd(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.json.zst")
+	encoder, _ := zstd.NewWriter(nil)
+	if err := os.WriteFile(filename, encoder.EncodeAll([]byte("{\"srcPort\":1}\n{\"srcPort\":2}\n"), nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, stop := startStdIn(t, map[string]string{"filename": filename})
+	defer stop()
+	expectFlows(t, out, 1, 2)
+}
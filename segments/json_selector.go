@@ -0,0 +1,211 @@
+package segments
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A compiled expression selecting values from a decoded JSON document. The
+// syntax is a subset of JMESPath, supporting:
+//
+//   - fields such as `results` or `"quoted-name"`, and sub-expressions such
+//     as `tenant.id`
+//   - indexes such as `results[0]` or `results[-1]`
+//   - list projections such as `results[*].prefix`, applying the rest of the
+//     expression to every element of a list
+//   - multi-select lists such as `results[*].[prefix, tenant.id]`
+//
+// Selecting a field of anything but an object, or an index of anything but a
+// list, returns nil, which projections omit from their results.
+type jsonSelector []jsonStep
+
+type jsonStep struct {
+	field   *string        // selects a field of an object
+	index   *int           // selects an element of a list
+	project bool           // applies the following steps to each element of a list
+	multi   []jsonSelector // selects a list of values
+}
+
+// Applies the selector to a document decoded into `any`.
+func (selector jsonSelector) apply(value any) any {
+	for i, step := range selector {
+		if value == nil {
+			return nil
+		}
+		switch {
+		case step.field != nil:
+			object, _ := value.(map[string]any)
+			value = object[*step.field]
+		case step.index != nil:
+			list, _ := value.([]any)
+			index := *step.index
+			if index < 0 {
+				index += len(list)
+			}
+			if index < 0 || index >= len(list) {
+				return nil
+			}
+			value = list[index]
+		case step.project:
+			list, ok := value.([]any)
+			if !ok {
+				return nil
+			}
+			projected := []any{}
+			for _, element := range list {
+				if result := selector[i+1:].apply(element); result != nil {
+					projected = append(projected, result)
+				}
+			}
+			return projected
+		case step.multi != nil:
+			values := make([]any, len(step.multi))
+			for j, selector := range step.multi {
+				values[j] = selector.apply(value)
+			}
+			value = values
+		}
+	}
+	return value
+}
+
+// Parses a selector, see jsonSelector for its syntax.
+func parseJSONSelector(expression string) (jsonSelector, error) {
+	parser := &jsonSelectorParser{input: expression}
+	selector, err := parser.selector()
+	if err != nil {
+		return nil, err
+	}
+	if parser.skipSpace(); parser.pos < len(parser.input) {
+		return nil, fmt.Errorf("unexpected '%c' at position %d", parser.input[parser.pos], parser.pos)
+	}
+	return selector, nil
+}
+
+type jsonSelectorParser struct {
+	input string
+	pos   int
+}
+
+func (p *jsonSelectorParser) skipSpace() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// Returns the next character without consuming it, or 0 at the end.
+func (p *jsonSelectorParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *jsonSelectorParser) selector() (jsonSelector, error) {
+	var selector jsonSelector
+	for {
+		c := p.peek()
+		switch {
+		case c == '[':
+			step, err := p.bracket()
+			if err != nil {
+				return nil, err
+			}
+			selector = append(selector, step)
+		case c == '.' && len(selector) > 0:
+			p.pos++
+			if p.peek() == '[' {
+				// only a multi-select list may follow a dot
+				step, err := p.bracket()
+				if err != nil {
+					return nil, err
+				}
+				if step.multi == nil {
+					return nil, fmt.Errorf("expected a multi-select list at position %d", p.pos)
+				}
+				selector = append(selector, step)
+				continue
+			}
+			field, err := p.field()
+			if err != nil {
+				return nil, err
+			}
+			selector = append(selector, jsonStep{field: &field})
+		case len(selector) == 0:
+			field, err := p.field()
+			if err != nil {
+				return nil, err
+			}
+			selector = append(selector, jsonStep{field: &field})
+		default:
+			return selector, nil
+		}
+	}
+}
+
+func (p *jsonSelectorParser) field() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	if p.peek() == '"' {
+		end := strings.IndexByte(p.input[p.pos+1:], '"')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted field at position %d", start)
+		}
+		p.pos += end + 2
+		return p.input[start+1 : p.pos-1], nil
+	}
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (p.pos == start || c < '0' || c > '9') {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos == len(p.input) {
+			return "", errors.New("expected a field at the end")
+		}
+		return "", fmt.Errorf("expected a field at position %d", start)
+	}
+	return p.input[start:p.pos], nil
+}
+
+// Parses an index, a projection or a multi-select list.
+func (p *jsonSelectorParser) bracket() (jsonStep, error) {
+	p.pos++ // the opening bracket
+	var step jsonStep
+	switch c := p.peek(); {
+	case c == '*':
+		p.pos++
+		step.project = true
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos++; p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9'; p.pos++ {
+		}
+		index, err := strconv.Atoi(p.input[start:p.pos])
+		if err != nil {
+			return step, fmt.Errorf("invalid index at position %d", start)
+		}
+		step.index = &index
+	default:
+		for {
+			selector, err := p.selector()
+			if err != nil {
+				return step, err
+			}
+			step.multi = append(step.multi, selector)
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+		}
+	}
+	if p.peek() != ']' {
+		return step, fmt.Errorf("expected ']' at position %d", p.pos)
+	}
+	p.pos++
+	return step, nil
+}
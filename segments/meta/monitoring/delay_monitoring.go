@@ -5,6 +5,7 @@
 package monitoring
 
 import (
+	"context"
 	"net/http"
 	"strconv"
 	"sync"
@@ -80,7 +81,7 @@ func (segment DelayMonitoring) New(config map[string]string) segments.Segment {
 	return &newSegment
 }
 
-func (segment *DelayMonitoring) Run(wg *sync.WaitGroup) {
+func (segment *DelayMonitoring) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -201,5 +202,9 @@ func (e *PrometheusExporter) ServeEndpoints(endpoint string) {
 
 func init() {
 	segment := &DelayMonitoring{}
-	segments.RegisterSegment("delay_monitoring", segment)
+	segments.RegisterSegment("delay_monitoring", segment,
+		segments.Param{Name: "endpoint", Default: ":8080", Description: "address to serve the metrics on"},
+		segments.Param{Name: "samplingRate", Type: segments.Int, Default: "1000", Description: "only every n-th flow is used to calculate the delay"},
+		segments.Param{Name: "alpha", Type: segments.Float, Default: "0.2", Description: "smoothing factor of the exponential moving average"},
+	)
 }
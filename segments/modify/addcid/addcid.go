@@ -22,22 +22,21 @@
 // regardless of the reason for the flow being unmatched (absence of RemoteAddress
 // field, actually no matching entry in data base).
 //
+// The prefix list is reloaded whenever it changes, see the `addnetid` segment.
+//
 // Roadmap:
 // * figure out how to deal with customers talking to one another
 package addcid
 
 import (
-	"encoding/csv"
-	"io"
+	"context"
 	"net"
-	"os"
 	"strconv"
 	"sync"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/segments"
-	"github.com/bwNetFlow/ip_prefix_trie"
 )
 
 type AddCid struct {
@@ -46,8 +45,7 @@ type AddCid struct {
 	DropUnmatched bool   // optional, default is false, determines whether flows are dropped when no Cid is found
 	MatchBoth     bool   // optional, default is false, determines whether src and dst addresses are matched seperately and not according to remote addresses
 
-	trieV4 ip_prefix_trie.TrieNode
-	trieV6 ip_prefix_trie.TrieNode
+	prefixes *segments.PrefixList
 }
 
 func (segment AddCid) New(config map[string]string) segments.Segment {
@@ -59,8 +57,11 @@ func (segment AddCid) New(config map[string]string) segments.Segment {
 	if err != nil {
 		log.Info().Msg("AddCid: 'matchboth' set to default 'false'.")
 	}
-	if config["filename"] == "" {
-		log.Error().Msg("AddCid: This segment requires a 'filename' parameter.")
+	prefixes, err := segments.NewPrefixList("AddCid", config, func(values []string) (any, error) {
+		return strconv.ParseInt(values[0], 10, 32)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("AddCid: Could not set up prefix list: ")
 		return nil
 	}
 	log.Info().Msg("AddCid: This segment is deprecated and should be replaced by the addnetid segment with useintids set to true.")
@@ -69,16 +70,20 @@ func (segment AddCid) New(config map[string]string) segments.Segment {
 		FileName:      config["filename"],
 		DropUnmatched: drop,
 		MatchBoth:     both,
+		prefixes:      prefixes,
 	}
 }
 
-func (segment *AddCid) Run(wg *sync.WaitGroup) {
+func (segment *AddCid) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
 	}()
 
-	segment.readPrefixList()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	segment.prefixes.Start(ctx)
+
 	for msg := range segment.In {
 		var laddress net.IP
 		if !segment.MatchBoth {
@@ -95,31 +100,16 @@ func (segment *AddCid) Run(wg *sync.WaitGroup) {
 			}
 
 			// prepare matching the address into a prefix and its associated CID
-			if laddress.To4() == nil {
-				retCid, _ := segment.trieV6.Lookup(laddress).(int64) // try to get a CID
-				msg.Cid = uint32(retCid)
-			} else {
-				retCid, _ := segment.trieV4.Lookup(laddress).(int64) // try to get a CID
-				msg.Cid = uint32(retCid)
-			}
+			retCid, _ := segment.prefixes.Lookup(laddress).(int64) // try to get a CID
+			msg.Cid = uint32(retCid)
 			if segment.DropUnmatched && msg.Cid == 0 {
 				continue
 			}
 		} else {
-			if net.IP(msg.SrcAddr).To4() == nil {
-				retCid, _ := segment.trieV6.Lookup(msg.SrcAddr).(int64) // try to get a CID
-				msg.SrcCid = uint32(retCid)
-			} else {
-				retCid, _ := segment.trieV4.Lookup(msg.SrcAddr).(int64) // try to get a CID
-				msg.SrcCid = uint32(retCid)
-			}
-			if net.IP(msg.DstAddr).To4() == nil {
-				retCid, _ := segment.trieV6.Lookup(msg.DstAddr).(int64) // try to get a CID
-				msg.DstCid = uint32(retCid)
-			} else {
-				retCid, _ := segment.trieV4.Lookup(msg.DstAddr).(int64) // try to get a CID
-				msg.DstCid = uint32(retCid)
-			}
+			retCid, _ := segment.prefixes.Lookup(msg.SrcAddr).(int64) // try to get a CID
+			msg.SrcCid = uint32(retCid)
+			retCid, _ = segment.prefixes.Lookup(msg.DstAddr).(int64)
+			msg.DstCid = uint32(retCid)
 			if msg.SrcCid == 0 && msg.DstCid != 0 {
 				msg.Cid = msg.DstCid
 			} else if msg.DstCid == 0 && msg.SrcCid != 0 {
@@ -130,54 +120,13 @@ func (segment *AddCid) Run(wg *sync.WaitGroup) {
 	}
 }
 
-func (segment *AddCid) readPrefixList() {
-	f, err := os.Open(segments.ContainerVolumePrefix + segment.FileName)
-	if err != nil {
-		log.Error().Err(err).Msg("AddCid: Could not open prefix list: ")
-		return
-	}
-	defer f.Close()
-
-	csvr := csv.NewReader(f)
-	var count int
-	for {
-		row, err := csvr.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				log.Warn().Err(err).Msg("AddCid: Encountered non-CSV line in prefix list: ")
-				continue
-			}
-		}
-
-		cid, err := strconv.ParseInt(row[1], 10, 32)
-		if err != nil {
-			log.Warn().Err(err).Msg("AddCid: Encountered non-integer customer id: ")
-			continue
-		}
-
-		// copied from net.IP module to detect v4/v6
-		var added bool
-		for i := 0; i < len(row[0]); i++ {
-			switch row[0][i] {
-			case '.':
-				segment.trieV4.Insert(cid, []string{row[0]})
-				added = true
-			case ':':
-				segment.trieV6.Insert(cid, []string{row[0]})
-				added = true
-			}
-			if added {
-				count += 1
-				break
-			}
-		}
-	}
-	log.Info().Msgf("AddCid: Read prefix list with %d prefixes.", count)
-}
-
 func init() {
 	segment := &AddCid{}
-	segments.RegisterSegment("addcid", segment)
+	segments.RegisterSegment("addcid", segment,
+		append([]segments.Param{
+			{Name: "filename", Type: segments.File, Required: true, Description: "CSV file mapping prefixes to customer IDs"},
+			{Name: "dropunmatched", Type: segments.Bool, Default: "false", Description: "drop flows without a matching customer ID"},
+			{Name: "matchboth", Type: segments.Bool, Default: "false", Description: "match both addresses instead of only the remote address"},
+		}, segments.PrefixListParams...)...,
+	)
 }
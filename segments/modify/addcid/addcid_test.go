@@ -1,6 +1,7 @@
 package addcid
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -63,7 +64,7 @@ func BenchmarkAddCid(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}}
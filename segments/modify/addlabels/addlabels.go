@@ -0,0 +1,124 @@
+package addlabels
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/BelWue/flowpipeline/segments"
+	"github.com/rs/zerolog/log"
+)
+
+// The `addlabels` segment adds labels, which are arbitrary attributes such as
+// the site, tenant or security zone of a network, to the `SrcLabels` and
+// `DstLabels` fields of flows according to the IP prefix their addresses
+// match. These prefixes are sourced from a csv file consisting of lines in the
+// format `ip prefix,value,value,...`, with the names of the values given in
+// order by the `labels` parameter. For example, with `labels` set to
+// `site,tenant,zone`:
+//
+// ```csv
+// # prefix,site,tenant,zone
+// 192.168.88.0/25,fra1,customer-a,dmz
+// 192.168.88.128/25,fra1,,internal
+// 2001:db8:1::/48,ber2,customer-b,
+// ```
+//
+// Empty values are not added, and labels already set on a flow, for instance
+// by a previous `addlabels` segment, are kept unless the matching prefix sets
+// them too. The labels can be used by output segments selecting fields, such as
+// `SrcLabels.zone` in the `prometheus` and `influx` segments, and are written to
+// clickhouse if its `labels` parameter is set. They can not be matched in
+// `flowfilter` expressions yet, as its syntax does not support labels.
+//
+// Instead of or in addition to the csv file, prefixes can be sourced from an
+// HTTP endpoint returning JSON and from a SQL query in the same way as by the
+// `addnetid` segment, with the selector or the query returning the values of
+// all labels after the prefix, for example
+// `results[*].[prefix, site.slug, tenant.slug, custom_fields.zone]`. Null values
+// are treated as empty. The prefix list is reloaded as described for the
+// `addnetid` segment too.
+type AddLabels struct {
+	segments.BaseSegment
+	FileName string   // optional, required unless another source is set
+	Labels   []string // required, the names of the values of each prefix
+
+	prefixes *segments.PrefixList
+}
+
+func (segment AddLabels) New(config map[string]string) segments.Segment {
+	var labels []string
+	for _, label := range strings.Split(config["labels"], ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		log.Error().Msg("AddLabels: The 'labels' parameter is required to name the values of each prefix.")
+		return nil
+	}
+	prefixes, err := segments.NewPrefixList("AddLabels", config, func(values []string) (any, error) {
+		if len(values) > len(labels) {
+			return nil, fmt.Errorf("expected at most %d values but got %d", len(labels), len(values))
+		}
+		result := make(map[string]string, len(values))
+		for i, value := range values {
+			if value = strings.TrimSpace(value); value != "" {
+				result[labels[i]] = value
+			}
+		}
+		return result, nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("AddLabels: Could not set up prefix list: ")
+		return nil
+	}
+
+	return &AddLabels{
+		FileName: config["filename"],
+		Labels:   labels,
+		prefixes: prefixes,
+	}
+}
+
+func (segment *AddLabels) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	segment.prefixes.Start(ctx)
+
+	for msg := range segment.In {
+		if labels, ok := segment.prefixes.Lookup(msg.SrcAddr).(map[string]string); ok && len(labels) > 0 {
+			if msg.SrcLabels == nil {
+				msg.SrcLabels = make(map[string]string, len(labels))
+			}
+			for label, value := range labels {
+				msg.SrcLabels[label] = value
+			}
+		}
+		if labels, ok := segment.prefixes.Lookup(msg.DstAddr).(map[string]string); ok && len(labels) > 0 {
+			if msg.DstLabels == nil {
+				msg.DstLabels = make(map[string]string, len(labels))
+			}
+			for label, value := range labels {
+				msg.DstLabels[label] = value
+			}
+		}
+		segment.Out <- msg
+	}
+}
+
+func init() {
+	segment := &AddLabels{}
+	segments.RegisterSegment("addlabels", segment,
+		append([]segments.Param{
+			{Name: "filename", Type: segments.File, Description: "CSV file mapping prefixes to the values of their labels, required unless another source is set"},
+			{Name: "labels", Required: true, Description: "comma-separated names of the values following each prefix, such as site,tenant,zone"},
+		}, append(segments.PrefixListParams, segments.PrefixSourceParams...)...)...,
+	)
+}
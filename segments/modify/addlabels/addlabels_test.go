@@ -0,0 +1,65 @@
+package addlabels
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+func writePrefixes(t *testing.T) string {
+	filename := filepath.Join(t.TempDir(), "labels.csv")
+	err := os.WriteFile(filename, []byte(`# prefix,site,tenant,zone
+192.168.88.0/24,fra1,customer-a,dmz
+192.168.88.128/25,fra1,,internal
+2001:db8:1::/48,ber2,customer-b,
+2001:db8:2::/48,,,
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// AddLabels Segment test, labels both addresses
+func TestSegment_AddLabels_labels(t *testing.T) {
+	config := map[string]string{"filename": writePrefixes(t), "labels": "site, tenant, zone"}
+	result := segments.TestSegment("addlabels", config,
+		&pb.EnrichedFlow{SrcAddr: net.ParseIP("192.168.88.42"), DstAddr: net.ParseIP("2001:db8:1::1")})
+	if result.SrcLabels["site"] != "fra1" || result.SrcLabels["tenant"] != "customer-a" || result.SrcLabels["zone"] != "dmz" {
+		t.Errorf("([error] Segment AddLabels did not add the labels of the source address: %v", result.SrcLabels)
+	}
+	if len(result.DstLabels) != 2 || result.DstLabels["site"] != "ber2" || result.DstLabels["tenant"] != "customer-b" {
+		t.Errorf("([error] Segment AddLabels did not add the labels of the destination address: %v", result.DstLabels)
+	}
+
+	// the most specific prefix wins, empty values and existing labels are kept
+	result = segments.TestSegment("addlabels", config,
+		&pb.EnrichedFlow{SrcAddr: net.ParseIP("192.168.88.142"), SrcLabels: map[string]string{"tenant": "customer-c", "zone": "dmz"}})
+	if len(result.SrcLabels) != 3 || result.SrcLabels["site"] != "fra1" || result.SrcLabels["tenant"] != "customer-c" || result.SrcLabels["zone"] != "internal" {
+		t.Errorf("([error] Segment AddLabels did not merge the labels of the source address: %v", result.SrcLabels)
+	}
+	if result.DstLabels != nil {
+		t.Errorf("([error] Segment AddLabels added labels to an address matching no prefix: %v", result.DstLabels)
+	}
+
+	// rows without any values are skipped
+	result = segments.TestSegment("addlabels", config,
+		&pb.EnrichedFlow{SrcAddr: net.ParseIP("2001:db8:2::1")})
+	if result.SrcLabels != nil {
+		t.Errorf("([error] Segment AddLabels added labels of a prefix without values: %v", result.SrcLabels)
+	}
+}
+
+// AddLabels Segment test, rejects invalid configurations
+func TestSegment_AddLabels_invalid(t *testing.T) {
+	if (AddLabels{}).New(map[string]string{"filename": "labels.csv"}) != nil {
+		t.Error("([error] Segment AddLabels accepted a configuration without labels.")
+	}
+	if (AddLabels{}).New(map[string]string{"labels": "site"}) != nil {
+		t.Error("([error] Segment AddLabels accepted a configuration without a source.")
+	}
+}
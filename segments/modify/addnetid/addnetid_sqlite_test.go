@@ -0,0 +1,44 @@
+//go:build cgo
+// +build cgo
+
+package addnetid
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// str mode, prefixes of a SQL query
+func TestSegment_AddNetIdString_sqlquery(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "prefixes.sqlite")
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE prefixes (prefix TEXT, customer TEXT);
+		INSERT INTO prefixes VALUES ('192.0.2.0/24', 'customer-1'), ('198.51.100.0/24', NULL)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := map[string]string{"sqldriver": "sqlite3", "sqldsn": filename, "sqlquery": "SELECT prefix, customer FROM prefixes", "matchboth": "true"}
+	result := segments.TestSegment("addnetid", config,
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}})
+	if result.SrcIdString != "customer-1" || result.DstIdString != "" {
+		t.Errorf("([error] Segment AddNetId did not look up the prefixes of the query: %v", result)
+	}
+
+	// several queries, later ones taking precedence
+	config["sqlquery"] = "SELECT prefix, customer FROM prefixes; SELECT '198.51.100.0/24', 'customer-2';"
+	result = segments.TestSegment("addnetid", config,
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}})
+	if result.SrcIdString != "customer-1" || result.DstIdString != "customer-2" {
+		t.Errorf("([error] Segment AddNetId did not look up the prefixes of several queries: %v", result)
+	}
+}
@@ -1,9 +1,16 @@
 package addnetid
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
@@ -173,6 +180,184 @@ func TestSegment_AddNetIdString_bothDstAddr(t *testing.T) {
 	}
 }
 
+// int mode, the prefix list is reloaded once changed unless malformed
+func TestSegment_AddNetId_reload(t *testing.T) {
+	for _, config := range []map[string]string{
+		{"useintids": "true", "watch": "false", "reloadinterval": "10ms"},
+		{"useintids": "true"},
+	} {
+		config["filename"] = filepath.Join(t.TempDir(), "prefixes.csv")
+		if err := os.WriteFile(config["filename"], []byte("192.0.2.0/24,1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		segment := AddNetId{}.New(config)
+		if segment == nil {
+			t.Fatal("([error] Segment AddNetId failed to initialize.")
+		}
+		in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+		segment.Rewire(in, out)
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go segment.Run(context.Background(), wg)
+		lookup := func() uint32 {
+			in <- &pb.EnrichedFlow{RemoteAddr: 2, SrcAddr: []byte{192, 0, 2, 1}}
+			return (<-out).NetId
+		}
+		// waits for the lookup to return the expected id
+		expect := func(netid uint32, message string) {
+			for deadline := time.Now().Add(5 * time.Second); lookup() != netid; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal(message)
+				}
+			}
+		}
+
+		expect(1, "([error] Segment AddNetId did not load the prefix list.")
+		if err := os.WriteFile(config["filename"], []byte("192.0.2.0/24,42\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		expect(42, "([error] Segment AddNetId did not reload the changed prefix list.")
+		if err := os.WriteFile(config["filename"], []byte("192.0.2.0/24,7\n198.51.100.0/24,x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+		if lookup() != 42 {
+			t.Error("([error] Segment AddNetId did not keep the previous prefix list when reloading a malformed one.")
+		}
+		close(in)
+		wg.Wait()
+	}
+}
+
+// int mode, malformed lines of a prefix list are skipped
+func TestSegment_AddNetId_malformedLines(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "prefixes.csv")
+	if err := os.WriteFile(filename, []byte("192.0.2.0/24,1\na\"b,1\n198.51.100.0/24,2\n\"203.0.113.0/24,3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for address, netid := range map[string]uint32{"192.0.2.1": 1, "198.51.100.1": 2, "203.0.113.1": 0} {
+		result := segments.TestSegment("addnetid", map[string]string{"filename": filename, "useintids": "true"},
+			&pb.EnrichedFlow{RemoteAddr: 2, SrcAddr: net.ParseIP(address)})
+		if result == nil || result.NetId != netid {
+			t.Errorf("([error] Segment AddNetId did not skip the malformed lines of the prefix list for %s.", address)
+		}
+	}
+}
+
+// int mode, prefixes of an HTTP endpoint are merged with the file and refreshed
+func TestSegment_AddNetId_url(t *testing.T) {
+	var lock sync.Mutex
+	status, body := http.StatusOK, `{"results": [
+		{"prefix": "198.51.100.0/24", "tenant": {"id": 20}},
+		{"prefix": "203.0.113.0/24", "tenant": null},
+		{"prefix": "2001:db8::/32", "tenant": {"id": 30}}
+	]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	filename := filepath.Join(t.TempDir(), "prefixes.csv")
+	if err := os.WriteFile(filename, []byte("192.0.2.0/24,1\n198.51.100.0/24,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	segment := AddNetId{}.New(map[string]string{
+		"filename":       filename,
+		"url":            server.URL + "/api/ipam/prefixes/?limit=0",
+		"selector":       "results[*].[prefix, tenant.id]",
+		"header":         "Authorization: Token secret",
+		"useintids":      "true",
+		"matchboth":      "true",
+		"reloadinterval": "10ms",
+	})
+	if segment == nil {
+		t.Fatal("([error] Segment AddNetId failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+	lookup := func(address string) uint32 {
+		in <- &pb.EnrichedFlow{SrcAddr: net.ParseIP(address), DstAddr: net.ParseIP("192.0.2.1")}
+		return (<-out).SrcId
+	}
+
+	for address, netid := range map[string]uint32{"198.51.100.1": 20, "203.0.113.1": 0, "2001:db8::1": 30, "192.0.2.1": 1} {
+		if result := lookup(address); result != netid {
+			t.Errorf("([error] Segment AddNetId looked up %d instead of %d for %s.", result, netid, address)
+		}
+	}
+
+	lock.Lock()
+	status = http.StatusInternalServerError
+	lock.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	if lookup("198.51.100.1") != 20 {
+		t.Error("([error] Segment AddNetId did not keep the previous prefixes of a failing endpoint.")
+	}
+	lock.Lock()
+	status, body = http.StatusOK, `{"results": [{"prefix": "198.51.100.0/24", "tenant": {"id": 21}}]}`
+	lock.Unlock()
+	for deadline := time.Now().Add(5 * time.Second); lookup("198.51.100.1") != 21; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("([error] Segment AddNetId did not refresh the prefixes of an endpoint.")
+		}
+	}
+	if lookup("2001:db8::1") != 0 {
+		t.Error("([error] Segment AddNetId kept a prefix removed from the endpoint.")
+	}
+	close(in)
+	wg.Wait()
+
+	for _, config := range []map[string]string{
+		{"url": server.URL},
+		{"url": server.URL, "selector": "results[*].[prefix"},
+		{"url": server.URL + ",not a url", "selector": "results[*].[prefix, tenant.id]"},
+		{"sqlquery": "SELECT prefix, id FROM prefixes", "sqldriver": "nonexistent"},
+	} {
+		if (AddNetId{}).New(config) != nil {
+			t.Errorf("([error] Segment AddNetId accepted an invalid source: %v", config)
+		}
+	}
+}
+
+// str mode, prefixes of several endpoints are merged, later ones taking
+// precedence
+func TestSegment_AddNetIdString_urls(t *testing.T) {
+	var urls []string
+	for _, body := range []string{
+		`[["192.0.2.0/24", "first"], ["198.51.100.0/24", "first"]]`,
+		`[["198.51.100.0/24", "second"]]`,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	result := segments.TestSegment("addnetid", map[string]string{"url": strings.Join(urls, ", "), "selector": "[*]", "matchboth": "true"},
+		&pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, DstAddr: []byte{198, 51, 100, 1}})
+	if result.SrcIdString != "first" || result.DstIdString != "second" {
+		t.Errorf("([error] Segment AddNetId did not merge the prefixes of several endpoints: %v", result)
+	}
+}
+
+// the PostgreSQL driver is available in all builds
+func TestSegment_AddNetId_pgx(t *testing.T) {
+	if (AddNetId{}).New(map[string]string{"sqldriver": "pgx", "sqldsn": "postgres://localhost/flows", "sqlquery": "SELECT prefix, id FROM prefixes"}) == nil {
+		t.Error("([error] Segment AddNetId does not support the pgx driver.")
+	}
+}
+
 // AddNetId Segment benchmark passthrough
 func BenchmarkAddCNetId(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
@@ -185,7 +370,7 @@ func BenchmarkAddCNetId(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}}
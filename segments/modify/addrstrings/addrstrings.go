@@ -16,6 +16,7 @@
 package addrstrings
 
 import (
+	"context"
 	"strings"
 	"sync"
 
@@ -39,7 +40,7 @@ func (segment AddrStrings) New(config map[string]string) segments.Segment {
 	}
 }
 
-func (segment *AddrStrings) Run(wg *sync.WaitGroup) {
+func (segment *AddrStrings) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -77,5 +78,7 @@ func (segment *AddrStrings) Run(wg *sync.WaitGroup) {
 // register segment
 func init() {
 	segment := &AddrStrings{}
-	segments.RegisterSegment("addrstrings", segment)
+	segments.RegisterSegment("addrstrings", segment,
+		segments.Param{Name: "macseparator", Default: "colon", Description: "separator used in MAC addresses, either \"colon\" or \"dash\""},
+	)
 }
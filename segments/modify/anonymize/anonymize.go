@@ -7,6 +7,7 @@
 package anonymize
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
@@ -150,7 +151,7 @@ func modeFromConfig(s string) (Mode, error) {
 	}
 }
 
-func (segment *Anonymize) Run(wg *sync.WaitGroup) {
+func (segment *Anonymize) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -227,5 +228,11 @@ func (anon SubnetAnonymizer) reduceIPToSubnet(ip net.IP, originalAddrPreservedLe
 
 func init() {
 	segment := &Anonymize{}
-	segments.RegisterSegment("anonymize", segment)
+	segments.RegisterSegment("anonymize", segment,
+		segments.Param{Name: "mode", Default: "cryptopan", Description: "one of \"cryptopan\", \"subnet\" or \"all\""},
+		segments.Param{Name: "key", Description: "key for Crypto-PAn, required for the modes cryptopan and all"},
+		segments.Param{Name: "fields", Default: "DstAddr,NextHop,SamplerAddress,SrcAddr", Description: "comma-separated list of address fields to anonymize"},
+		segments.Param{Name: "maskV4", Type: segments.Int, Default: "16", Description: "prefix length IPv4 addresses are truncated to in subnet mode, between 8 and 32"},
+		segments.Param{Name: "maskV6", Type: segments.Int, Default: "52", Description: "prefix length IPv6 addresses are truncated to in subnet mode, between 4 and 128"},
+	)
 }
@@ -1,6 +1,7 @@
 package anonymize
 
 import (
+	"context"
 	"net"
 	"os"
 	"sync"
@@ -37,7 +38,7 @@ func BenchmarkAnonymize(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 123}, NextHop: []byte{193, 168, 88, 2}}
@@ -62,7 +63,7 @@ func TestCryptoPan(t *testing.T) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 123}, NextHop: []byte{193, 168, 88, 2}}
 	msg := <-out
@@ -108,7 +109,7 @@ func TestSubnet(t *testing.T) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 123}, NextHop: []byte{193, 168, 88, 2}}
 	msg := <-out
@@ -166,7 +167,7 @@ func TestBoth(t *testing.T) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 123}, NextHop: []byte{193, 168, 88, 2}}
 	msg := <-out
@@ -224,7 +225,7 @@ func BenchmarkSubnet_1000(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, NextHop: []byte{192, 168, 88, 143}, Proto: 45}
@@ -248,7 +249,7 @@ func BenchmarkCryptopan_1000(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, NextHop: []byte{192, 168, 88, 143}, Proto: 45}
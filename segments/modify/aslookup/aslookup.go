@@ -8,6 +8,7 @@
 package aslookup
 
 import (
+	"context"
 	"net"
 	"os"
 	"sync"
@@ -81,7 +82,7 @@ func (segment AsLookup) New(config map[string]string) segments.Segment {
 	return newSegment
 }
 
-func (segment *AsLookup) Run(wg *sync.WaitGroup) {
+func (segment *AsLookup) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -113,5 +114,8 @@ func (segment *AsLookup) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &AsLookup{}
-	segments.RegisterSegment("aslookup", segment)
+	segments.RegisterSegment("aslookup", segment,
+		segments.Param{Name: "filename", Type: segments.File, Required: true, Description: "the lookup file"},
+		segments.Param{Name: "type", Default: "db", Description: "format of the lookup file, either \"db\" or \"mrt\""},
+	)
 }
@@ -30,6 +30,7 @@
 package bgp
 
 import (
+	"context"
 	"net"
 	"os"
 	"slices"
@@ -113,7 +114,7 @@ func (segment Bgp) New(config map[string]string) segments.Segment {
 	return newSegment
 }
 
-func (segment *Bgp) Run(wg *sync.WaitGroup) {
+func (segment *Bgp) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -208,5 +209,9 @@ func (segment *Bgp) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Bgp{}
-	segments.RegisterSegment("bgp", segment)
+	segments.RegisterSegment("bgp", segment,
+		segments.Param{Name: "filename", Type: segments.File, Required: true, Description: "YAML file configuring the BGP sessions"},
+		segments.Param{Name: "fallbackrouter", Description: "name of the session used for flows whose sampler has no session of its own"},
+		segments.Param{Name: "usefallbackonly", Type: segments.Bool, Default: "false", Description: "use the fallback session for all flows"},
+	)
 }
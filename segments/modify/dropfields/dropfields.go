@@ -3,10 +3,12 @@
 // "keep" or "drop". It will then either keep or drop all fields specified in the
 // fields parameter. For a list of fields, check our
 // [protobuf definition](https://github.com/bwNetFlow/protobuf/blob/master/flow-messages-enriched.proto).
+// Entries of map fields are selected as `<field>.<key>`, such as `labels.site`,
+// which keeps or drops only that entry.
 package dropfields
 
 import (
-	"reflect"
+	"context"
 	"regexp"
 	"strings"
 	"sync"
@@ -32,6 +34,8 @@ type DropFields struct {
 	segments.BaseSegment
 	Policy Policy   // required, determines whether to keep or drop fields
 	Fields []string // required, determines which fields are kept/dropped
+
+	fields []segments.FlowField
 }
 
 func (segment *DropFields) New(config map[string]string) segments.Segment {
@@ -47,48 +51,50 @@ func (segment *DropFields) New(config map[string]string) segments.Segment {
 	case "drop":
 		policy = PolicyDrop
 	default:
-		log.Fatal().Msg("DropFields: The 'policy' parameter is required to be either 'keep' or 'drop'.")
+		log.Error().Msg("DropFields: The 'policy' parameter is required to be either 'keep' or 'drop'.")
+		return nil
 	}
 
 	// parse fields
-	fields = FieldSplitRegex.Split(strings.TrimSpace(config["fields"]), -1)
+	if trimmed := strings.TrimSpace(config["fields"]); trimmed != "" {
+		fields = FieldSplitRegex.Split(trimmed, -1)
+	}
 	if len(fields) == 0 {
 		log.Warn().Msg("DropFields: The 'fields' parameter can not be empty.")
 	}
+	var flowFields []segments.FlowField
+	for _, name := range fields {
+		field, err := segments.ParseFlowField(name)
+		if err != nil {
+			log.Error().Err(err).Msgf("DropFields: Field '%s' specified in 'fields' is invalid: ", name)
+			return nil
+		}
+		flowFields = append(flowFields, field)
+	}
 
 	return &DropFields{
 		Policy: policy,
 		Fields: fields,
+		fields: flowFields,
 	}
 }
 
-func (segment *DropFields) Run(wg *sync.WaitGroup) {
+func (segment *DropFields) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
 	}()
 	for original := range segment.In {
-		// get reflected value of original flow
-		reflectedOriginal := reflect.ValueOf(original).Elem()
 		switch segment.Policy {
 		case PolicyKeep:
 			resultFlow := &pb.EnrichedFlow{}
-			for _, fieldName := range segment.Fields {
-				originalField := reflectedOriginal.FieldByName(fieldName)
-				resultFlowDestinationField := reflect.ValueOf(resultFlow).Elem().FieldByName(fieldName)
-				if originalField.IsValid() && resultFlowDestinationField.CanSet() {
-					resultFlowDestinationField.Set(originalField)
-				} else {
-					log.Fatal().Msgf("DropFields: Field '%s' is not valid or can not be set.", fieldName)
-				}
+			for _, field := range segment.fields {
+				field.Copy(resultFlow, original)
 			}
 			segment.Out <- resultFlow
 		case PolicyDrop:
-			for _, fieldName := range segment.Fields {
-				originalField := reflect.Indirect(reflectedOriginal).FieldByName(fieldName)
-				if originalField.IsValid() && originalField.CanSet() {
-					originalField.SetZero()
-				}
+			for _, field := range segment.fields {
+				field.Clear(original)
 			}
 			segment.Out <- original
 		}
@@ -97,5 +103,8 @@ func (segment *DropFields) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &DropFields{}
-	segments.RegisterSegment("dropfields", segment)
+	segments.RegisterSegment("dropfields", segment,
+		segments.Param{Name: "policy", Required: true, Description: "either \"keep\" or \"drop\" the listed fields"},
+		segments.Param{Name: "fields", Required: true, Description: "comma-separated list of fields to keep or drop"},
+	)
 }
@@ -1,6 +1,7 @@
 package dropfields
 
 import (
+	"context"
 	"os"
 	"reflect"
 	"sync"
@@ -65,6 +66,21 @@ var (
 				Packets: 424242,
 			},
 		},
+		"drop one label": {
+			config: map[string]string{"policy": "drop", "fields": "labels.site"},
+			input:  pb.EnrichedFlow{Labels: map[string]string{"site": "fra1", "zone": "dmz"}},
+			expected: &pb.EnrichedFlow{
+				Labels: map[string]string{"zone": "dmz"},
+			},
+		},
+		"keep one label and a map": {
+			config: map[string]string{"policy": "keep", "fields": "Labels.zone, values"},
+			input:  pb.EnrichedFlow{Bytes: 42, Labels: map[string]string{"site": "fra1", "zone": "dmz"}, Values: map[string]float64{"rtt": 1.5}},
+			expected: &pb.EnrichedFlow{
+				Labels: map[string]string{"zone": "dmz"},
+				Values: map[string]float64{"rtt": 1.5},
+			},
+		},
 		"keep two fields": {
 			config: map[string]string{"policy": "keep", "fields": " SrcAddr , SrcPort"},
 			input:  testPacketTwo,
@@ -93,14 +109,14 @@ func BenchmarkDropFields(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 	os.Stdout, _ = os.Open(os.DevNull)
 
-	segment := DropFields{Policy: PolicyDrop, Fields: []string{"SrcAddr"}}
+	segment := (&DropFields{}).New(map[string]string{"policy": "drop", "fields": "SrcAddr"})
 
 	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
 	segment.Rewire(in, out)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}}
@@ -9,6 +9,7 @@
 package geolocation
 
 import (
+	"context"
 	"net"
 	"strconv"
 	"sync"
@@ -54,7 +55,7 @@ func (segment GeoLocation) New(config map[string]string) segments.Segment {
 	return newSegment
 }
 
-func (segment *GeoLocation) Run(wg *sync.WaitGroup) {
+func (segment *GeoLocation) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -111,5 +112,9 @@ func (segment *GeoLocation) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &GeoLocation{}
-	segments.RegisterSegment("geolocation", segment)
+	segments.RegisterSegment("geolocation", segment,
+		segments.Param{Name: "filename", Type: segments.File, Required: true, Description: "the MaxMind database to look addresses up in"},
+		segments.Param{Name: "dropunmatched", Type: segments.Bool, Default: "false", Description: "drop flows without a location"},
+		segments.Param{Name: "matchboth", Type: segments.Bool, Default: "false", Description: "look up both addresses instead of only the remote address"},
+	)
 }
@@ -1,6 +1,7 @@
 package geolocation
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -63,7 +64,7 @@ func BenchmarkGeoLocation(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{RemoteAddr: 2, DstAddr: []byte{2, 125, 160, 218}}
@@ -10,6 +10,7 @@
 package normalize
 
 import (
+	"context"
 	"strconv"
 	"sync"
 
@@ -41,7 +42,7 @@ func (segment Normalize) New(config map[string]string) segments.Segment {
 	}
 }
 
-func (segment *Normalize) Run(wg *sync.WaitGroup) {
+func (segment *Normalize) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -63,5 +64,7 @@ func (segment *Normalize) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Normalize{}
-	segments.RegisterSegment("normalize", segment)
+	segments.RegisterSegment("normalize", segment,
+		segments.Param{Name: "fallback", Type: segments.Uint, Description: "sampling rate assumed for flows without one, such flows are not normalized if unset"},
+	)
 }
@@ -1,6 +1,7 @@
 package normalize
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -49,7 +50,7 @@ func BenchmarkNormalize(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SamplingRate: 0, Bytes: 1}
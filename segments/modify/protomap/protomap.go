@@ -5,6 +5,7 @@
 package protomap
 
 import (
+	"context"
 	"sync"
 
 	"github.com/BelWue/flowpipeline/segments"
@@ -20,7 +21,7 @@ func (segment Protomap) New(config map[string]string) segments.Segment {
 	return &Protomap{}
 }
 
-func (segment *Protomap) Run(wg *sync.WaitGroup) {
+func (segment *Protomap) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
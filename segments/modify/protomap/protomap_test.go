@@ -1,6 +1,7 @@
 package protomap
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -40,7 +41,7 @@ func BenchmarkProtomap(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{Proto: 6}
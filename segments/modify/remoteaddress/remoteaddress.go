@@ -26,14 +26,12 @@
 //     info is cleared in this case.
 //
 // Any optional parameters relate to the `cidr` policy only and behave as in the
-// `addnetid` segment.
+// `addnetid` segment, including the reloading of the prefix list.
 package remoteaddress
 
 import (
-	"encoding/csv"
-	"io"
+	"context"
 	"net"
-	"os"
 	"strconv"
 	"sync"
 
@@ -41,7 +39,6 @@ import (
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
-	"github.com/bwNetFlow/ip_prefix_trie"
 )
 
 type RemoteAddress struct {
@@ -50,8 +47,7 @@ type RemoteAddress struct {
 	FileName      string // optional, required if policy is set to 'cidr', default is empty
 	DropUnmatched bool   // optional, default is false, relevant to 'cidr' only, determines what to do with unmatched flows
 
-	trieV4 ip_prefix_trie.TrieNode
-	trieV6 ip_prefix_trie.TrieNode
+	prefixes *segments.PrefixList
 }
 
 func (segment RemoteAddress) New(config map[string]string) segments.Segment {
@@ -63,18 +59,22 @@ func (segment RemoteAddress) New(config map[string]string) segments.Segment {
 	if err != nil {
 		log.Info().Msg("RemoteAddress: 'dropunmatched' set to default 'false'.")
 	}
-	if config["policy"] == "cidr" && config["filename"] == "" {
-		log.Error().Msg("RemoteAddress: This segment requires a 'filename' parameter.")
-		return nil
-	}
-	return &RemoteAddress{
+	newsegment := &RemoteAddress{
 		Policy:        config["policy"],
 		FileName:      config["filename"],
 		DropUnmatched: drop,
 	}
+	if config["policy"] == "cidr" {
+		newsegment.prefixes, err = segments.NewPrefixList("RemoteAddress", config, nil)
+		if err != nil {
+			log.Error().Err(err).Msg("RemoteAddress: Could not set up prefix list: ")
+			return nil
+		}
+	}
+	return newsegment
 }
 
-func (segment *RemoteAddress) Run(wg *sync.WaitGroup) {
+func (segment *RemoteAddress) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -82,15 +82,14 @@ func (segment *RemoteAddress) Run(wg *sync.WaitGroup) {
 
 	switch segment.Policy {
 	case "cidr":
-		segment.readPrefixList()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		segment.prefixes.Start(ctx)
+
 		for msg := range segment.In {
 			var ret string
 			for i, addr := range []net.IP{msg.SrcAddr, msg.DstAddr} {
-				if addr.To4() == nil {
-					ret, _ = segment.trieV6.Lookup(addr).(string)
-				} else {
-					ret, _ = segment.trieV4.Lookup(addr).(string)
-				}
+				ret, _ = segment.prefixes.Lookup(addr).(string)
 				if ret != "" {
 					msg.RemoteAddr = pb.EnrichedFlow_RemoteAddrType(i + 1)
 					break
@@ -128,48 +127,13 @@ func (segment *RemoteAddress) Run(wg *sync.WaitGroup) {
 	}
 }
 
-func (segment *RemoteAddress) readPrefixList() {
-	f, err := os.Open(segments.ContainerVolumePrefix + segment.FileName)
-	if err != nil {
-		log.Error().Err(err).Msg("RemoteAddress: Could not open prefix list: ")
-		return
-	}
-	defer f.Close()
-
-	csvr := csv.NewReader(f)
-	var count int
-	for {
-		row, err := csvr.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				log.Warn().Err(err).Msg("RemoteAddress: Encountered non-CSV line in prefix list: ")
-				continue
-			}
-		}
-
-		// copied from net.IP module to detect v4/v6
-		var added bool
-		for i := 0; i < len(row[0]); i++ {
-			switch row[0][i] {
-			case '.':
-				segment.trieV4.Insert(row[1], []string{row[0]})
-				added = true
-			case ':':
-				segment.trieV6.Insert(row[1], []string{row[0]})
-				added = true
-			}
-			if added {
-				count += 1
-				break
-			}
-		}
-	}
-	log.Info().Msgf("RemoteAddress: Read prefix list with %d prefixes.", count)
-}
-
 func init() {
 	segment := &RemoteAddress{}
-	segments.RegisterSegment("remoteaddress", segment)
+	segments.RegisterSegment("remoteaddress", segment,
+		append([]segments.Param{
+			{Name: "policy", Required: true, Description: "one of \"cidr\", \"border\", \"user\" or \"clear\""},
+			{Name: "filename", Type: segments.File, Description: "CSV file of local prefixes, required for the cidr policy"},
+			{Name: "dropunmatched", Type: segments.Bool, Default: "false", Description: "drop flows matching no prefix, cidr policy only"},
+		}, segments.PrefixListParams...)...,
+	)
 }
@@ -1,6 +1,7 @@
 package remoteaddress
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -56,7 +57,7 @@ func BenchmarkRemoteAddress(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 42}}
@@ -33,7 +33,7 @@ func (segment ReverseDns) New(config map[string]string) segments.Segment {
 	var cache bool = true
 	if config["cache"] != "" {
 		var err error
-		if cache, err = strconv.ParseBool(config["cache"]); err == nil {
+		if cache, err = strconv.ParseBool(config["cache"]); err != nil {
 			log.Error().Msg("ReverseDns: Invalid 'cache' parameter.")
 			return nil
 		}
@@ -49,6 +49,9 @@ func (segment ReverseDns) New(config map[string]string) segments.Segment {
 			log.Error().Msg("ReverseDns: Invalid 'refreshinterval' parameter.")
 			return nil
 		}
+		if segments.DryRun(config) {
+			return newsegment
+		}
 		go func() {
 			t := time.NewTicker(duration)
 			defer t.Stop()
@@ -60,7 +63,7 @@ func (segment ReverseDns) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *ReverseDns) Run(wg *sync.WaitGroup) {
+func (segment *ReverseDns) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -96,5 +99,8 @@ func (segment *ReverseDns) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &ReverseDns{}
-	segments.RegisterSegment("reversedns", segment)
+	segments.RegisterSegment("reversedns", segment,
+		segments.Param{Name: "cache", Type: segments.Bool, Default: "true", Description: "cache lookups, disable to use a caching resolver directly"},
+		segments.Param{Name: "refreshinterval", Type: segments.Duration, Default: "5m", Description: "interval in which cached entries are refreshed"},
+	)
 }
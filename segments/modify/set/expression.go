@@ -0,0 +1,506 @@
+package set
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BelWue/flowfilter/parser"
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+	"github.com/BelWue/flowpipeline/segments/filter/flowfilter"
+)
+
+// The types of values in expressions. Integers are held as int64, floats as
+// float64, strings as string and addresses as net.IP.
+type valueType int
+
+const (
+	typeInt valueType = iota
+	typeFloat
+	typeString
+	typeAddress
+)
+
+func (typ valueType) String() string {
+	return [...]string{"integer", "float", "string", "address"}[typ]
+}
+
+func (typ valueType) numeric() bool {
+	return typ == typeInt || typ == typeFloat
+}
+
+// An expression of a statement, which evaluates to a value of its type, or
+// returns false if it is undefined for a flow, such as a division by zero.
+type expression struct {
+	typ  valueType
+	eval func(flow *pb.EnrichedFlow) (any, bool)
+}
+
+// A single assignment of the `set` segment.
+type statement struct {
+	target segments.FlowField
+	value  expression
+	guard  *parser.Expression // nil if the statement is unconditional
+}
+
+// Applies the statement to a flow if its guard matches and its value is
+// defined.
+func (s *statement) apply(filter *flowfilter.Filter, flow *pb.EnrichedFlow) {
+	if s.guard != nil {
+		if match, _ := filter.CheckFlow(s.guard, flow); !match {
+			return
+		}
+	}
+	value, ok := s.value.eval(flow)
+	if !ok {
+		return
+	}
+	if value, ok = convert(value, s.target.Type()); ok {
+		s.target.Set(flow, value)
+	}
+}
+
+// Splits statements separated by newlines or semicolons outside of strings.
+func splitStatements(input string) []string {
+	var statements []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range input {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == '\n' || c == ';'):
+			statements = append(statements, input[start:i])
+			start = i + 1
+		}
+	}
+	statements = append(statements, input[start:])
+	var result []string
+	for _, statement := range statements {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			result = append(result, statement)
+		}
+	}
+	return result
+}
+
+// Parses a statement of the form `<field> = <expression> [if <filter>]`.
+func parseStatement(input string) (*statement, error) {
+	p := &expressionParser{input: input}
+	name := p.next()
+	if name.kind != tokenName {
+		return nil, fmt.Errorf("expected a field at position %d", name.pos)
+	}
+	target, err := segments.ParseFlowField(name.text)
+	if err != nil {
+		return nil, err
+	}
+	targetType, err := fieldType(target)
+	if err != nil {
+		return nil, err
+	}
+	if equals := p.next(); equals.text != "=" {
+		return nil, fmt.Errorf("expected '=' at position %d", equals.pos)
+	}
+	value, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case targetType.numeric() && !value.typ.numeric(),
+		targetType == typeAddress && value.typ != typeAddress && value.typ != typeString:
+		return nil, fmt.Errorf("can not assign a value of type %s to field '%s' of type %s", value.typ, target.Name, targetType)
+	}
+
+	s := &statement{target: target, value: value}
+	switch next := p.next(); {
+	case next.kind == tokenEnd:
+	case next.kind == tokenName && next.text == "if":
+		s.guard, err = parser.Parse(input[p.pos:])
+		if err != nil {
+			return nil, fmt.Errorf("syntax error in filter expression: %w", err)
+		}
+		filter := &flowfilter.Filter{}
+		if _, err := filter.CheckFlow(s.guard, &pb.EnrichedFlow{}); err != nil {
+			return nil, fmt.Errorf("semantic error in filter expression: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected '%s' at position %d", next.text, next.pos)
+	}
+	return s, nil
+}
+
+// Returns the type of the values of a field, or an error if it is not
+// supported by expressions.
+func fieldType(field segments.FlowField) (valueType, error) {
+	switch typ := field.Type(); typ.Kind() {
+	case reflect.Uint32, reflect.Uint64, reflect.Int32, reflect.Int64, reflect.Bool:
+		return typeInt, nil
+	case reflect.Float32, reflect.Float64:
+		return typeFloat, nil
+	case reflect.String:
+		return typeString, nil
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return typeAddress, nil
+		}
+	}
+	return 0, fmt.Errorf("field '%s' of type %s is not supported", field.Name, field.Type())
+}
+
+// Returns the value of a field of a flow as a value of its valueType, or false
+// if it is undefined, such as unsigned numbers exceeding the range of integers.
+func fieldValue(field segments.FlowField, flow *pb.EnrichedFlow) (any, bool) {
+	value := reflect.ValueOf(field.Value(flow))
+	switch value.Kind() {
+	case reflect.Uint32, reflect.Uint64:
+		if value.Uint() > math.MaxInt64 {
+			return nil, false
+		}
+		return int64(value.Uint()), true
+	case reflect.Int32, reflect.Int64:
+		return value.Int(), true
+	case reflect.Bool:
+		if value.Bool() {
+			return int64(1), true
+		}
+		return int64(0), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	case reflect.String:
+		return value.String(), true
+	default:
+		return net.IP(value.Bytes()), true
+	}
+}
+
+// Converts a value to the type of a field, or returns false if it can not be
+// represented, such as a malformed address. Negative numbers are set to zero
+// and numbers exceeding unsigned fields to their maximum.
+func convert(value any, typ reflect.Type) (any, bool) {
+	switch typ.Kind() {
+	case reflect.Uint32, reflect.Uint64:
+		var number float64
+		switch value := value.(type) {
+		case int64:
+			if value < 0 {
+				return uint64(0), true
+			}
+			if typ.Kind() == reflect.Uint64 {
+				return uint64(value), true
+			}
+			number = float64(value)
+		case float64:
+			number = value
+		}
+		limit := uint64(math.MaxUint64)
+		if typ.Kind() == reflect.Uint32 {
+			limit = math.MaxUint32
+		}
+		if number >= float64(limit) {
+			return limit, true
+		}
+		return uint64(math.Max(0, number)), true
+	case reflect.Int32, reflect.Int64:
+		if number, ok := value.(float64); ok {
+			return int64(number), true
+		}
+		return value, true
+	case reflect.Bool:
+		return toFloat(value) != 0, true
+	case reflect.Float32, reflect.Float64:
+		return toFloat(value), true
+	case reflect.String:
+		switch value := value.(type) {
+		case int64:
+			return strconv.FormatInt(value, 10), true
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64), true
+		case net.IP:
+			if len(value) == 0 {
+				return "", true
+			}
+			return value.String(), true
+		}
+		return value, true
+	default: // addresses
+		address, ok := value.(net.IP)
+		if text, isString := value.(string); isString {
+			address, ok = net.ParseIP(text), net.ParseIP(text) != nil
+		}
+		if v4 := address.To4(); v4 != nil {
+			address = v4
+		}
+		return []byte(address), ok
+	}
+}
+
+func toFloat(value any) float64 {
+	if number, ok := value.(int64); ok {
+		return float64(number)
+	}
+	number, _ := value.(float64)
+	return number
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenName
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// A recursive descent parser of expressions consisting of numbers, strings,
+// fields, the operators `+`, `-`, `*`, `/` and `%`, and parentheses.
+type expressionParser struct {
+	input  string
+	pos    int
+	peeked *token
+}
+
+func (p *expressionParser) peek() token {
+	if p.peeked == nil {
+		t := p.lex()
+		p.peeked = &t
+	}
+	return *p.peeked
+}
+
+func (p *expressionParser) next() token {
+	t := p.peek()
+	p.peeked = nil
+	return t
+}
+
+func (p *expressionParser) lex() token {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos == len(p.input) {
+		return token{kind: tokenEnd, pos: start}
+	}
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isLetter := func(c byte) bool { return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+	switch c := p.input[p.pos]; {
+	case isDigit(c):
+		for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		return token{kind: tokenNumber, text: p.input[start:p.pos], pos: start}
+	case isLetter(c):
+		for p.pos < len(p.input) && (isLetter(p.input[p.pos]) || isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		return token{kind: tokenName, text: p.input[start:p.pos], pos: start}
+	case c == '"':
+		for p.pos++; p.pos < len(p.input) && p.input[p.pos] != '"'; p.pos++ {
+			if p.input[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		p.pos++
+		if p.pos > len(p.input) {
+			p.pos = len(p.input)
+		}
+		return token{kind: tokenString, text: p.input[start:p.pos], pos: start}
+	default:
+		p.pos++
+		return token{kind: tokenOperator, text: p.input[start:p.pos], pos: start}
+	}
+}
+
+// Parses a sum of terms.
+func (p *expressionParser) expression() (expression, error) {
+	left, err := p.term()
+	if err != nil {
+		return left, err
+	}
+	for op := p.peek(); op.text == "+" || op.text == "-"; op = p.peek() {
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return left, err
+		}
+		if left, err = binary(op.text, left, right); err != nil {
+			return left, fmt.Errorf("position %d: %w", op.pos, err)
+		}
+	}
+	return left, nil
+}
+
+// Parses a product of factors.
+func (p *expressionParser) term() (expression, error) {
+	left, err := p.factor()
+	if err != nil {
+		return left, err
+	}
+	for op := p.peek(); op.text == "*" || op.text == "/" || op.text == "%"; op = p.peek() {
+		p.next()
+		right, err := p.factor()
+		if err != nil {
+			return left, err
+		}
+		if left, err = binary(op.text, left, right); err != nil {
+			return left, fmt.Errorf("position %d: %w", op.pos, err)
+		}
+	}
+	return left, nil
+}
+
+// Parses a literal, a field, a negation or an expression in parentheses.
+func (p *expressionParser) factor() (expression, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		if number, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return constant(typeInt, number), nil
+		}
+		number, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return expression{}, fmt.Errorf("invalid number '%s' at position %d", t.text, t.pos)
+		}
+		return constant(typeFloat, number), nil
+	case tokenString:
+		text, err := strconv.Unquote(t.text)
+		if err != nil {
+			return expression{}, fmt.Errorf("invalid string at position %d", t.pos)
+		}
+		return constant(typeString, text), nil
+	case tokenName:
+		field, err := segments.ParseFlowField(t.text)
+		if err != nil {
+			return expression{}, err
+		}
+		typ, err := fieldType(field)
+		if err != nil {
+			return expression{}, err
+		}
+		return expression{typ: typ, eval: func(flow *pb.EnrichedFlow) (any, bool) {
+			return fieldValue(field, flow)
+		}}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			inner, err := p.expression()
+			if err != nil {
+				return inner, err
+			}
+			if closing := p.next(); closing.text != ")" {
+				return inner, fmt.Errorf("expected ')' at position %d", closing.pos)
+			}
+			return inner, nil
+		case "-":
+			operand, err := p.factor()
+			if err != nil {
+				return operand, err
+			}
+			return binary("-", constant(typeInt, int64(0)), operand)
+		}
+	case tokenEnd:
+		return expression{}, errors.New("unexpected end of expression")
+	}
+	return expression{}, fmt.Errorf("unexpected '%s' at position %d", t.text, t.pos)
+}
+
+func constant(typ valueType, value any) expression {
+	return expression{typ: typ, eval: func(*pb.EnrichedFlow) (any, bool) { return value, true }}
+}
+
+// Returns the expression applying an operator to two expressions, or an error
+// if the operator does not apply to their types.
+func binary(op string, left, right expression) (expression, error) {
+	switch {
+	case op == "+" && left.typ == typeString && right.typ == typeString:
+		return expression{typ: typeString, eval: func(flow *pb.EnrichedFlow) (any, bool) {
+			l, lok := left.eval(flow)
+			r, rok := right.eval(flow)
+			return l.(string) + r.(string), lok && rok
+		}}, nil
+	case !left.typ.numeric() || !right.typ.numeric():
+		return expression{}, fmt.Errorf("operator '%s' does not apply to types %s and %s", op, left.typ, right.typ)
+	case left.typ == typeInt && right.typ == typeInt:
+		return expression{typ: typeInt, eval: func(flow *pb.EnrichedFlow) (any, bool) {
+			l, lok := left.eval(flow)
+			r, rok := right.eval(flow)
+			if !lok || !rok {
+				return nil, false
+			}
+			if result, ok := intOperation(op, l.(int64), r.(int64)); ok {
+				return result, true
+			}
+			return nil, false
+		}}, nil
+	case op == "%":
+		return expression{}, fmt.Errorf("operator '%%' does not apply to types %s and %s", left.typ, right.typ)
+	default:
+		return expression{typ: typeFloat, eval: func(flow *pb.EnrichedFlow) (any, bool) {
+			l, lok := left.eval(flow)
+			r, rok := right.eval(flow)
+			if !lok || !rok {
+				return nil, false
+			}
+			a, b := toFloat(l), toFloat(r)
+			switch op {
+			case "+":
+				return a + b, true
+			case "-":
+				return a - b, true
+			case "*":
+				return a * b, true
+			default:
+				if b == 0 {
+					return nil, false
+				}
+				return a / b, true
+			}
+		}}, nil
+	}
+}
+
+// Applies an operator to two integers, or returns false if the result is
+// undefined, such as a division by zero or an overflow.
+func intOperation(op string, a, b int64) (int64, bool) {
+	switch op {
+	case "+":
+		result := a + b
+		return result, (result > a) == (b > 0)
+	case "-":
+		result := a - b
+		return result, (result < a) == (b > 0)
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		result := a * b
+		return result, result/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+	case "/":
+		if b == 0 || (a == math.MinInt64 && b == -1) {
+			return 0, false
+		}
+		return a / b, true
+	default:
+		if b == 0 {
+			return 0, false
+		}
+		return a % b, true
+	}
+}
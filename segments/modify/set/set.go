@@ -0,0 +1,93 @@
+// The `set` segment sets fields of flows to the values of expressions, as a
+// generic alternative to segments such as `normalize` for one-off
+// transformations. The `set` parameter contains one or more statements,
+// separated by newlines or semicolons, which are applied in order to every
+// flow, each seeing the results of the previous ones:
+//
+// ```yaml
+// - segment: set
+//   config:
+//     set: |
+//       Bytes = Bytes * SamplingRate
+//       Packets = Packets * SamplingRate
+//       SamplingRate = 1
+//       Note = "peering" if port 179
+//       labels.interface = SrcIfName + " " + SrcIfDesc
+//       values.bytes_per_packet = Bytes * 1.0 / Packets if packets >0
+// ```
+//
+// Each statement assigns an expression to a field, optionally followed by
+// `if` and a condition in [flowfilter syntax](https://github.com/BelWue/flowfilter),
+// in which case only flows matching the condition are modified. Fields are
+// selected by their name as in the
+// [protobuf definition](https://github.com/BelWue/flowpipeline/blob/master/pb/enrichedflow.proto),
+// entries of map fields as `<field>.<key>`, such as `labels.site`.
+//
+// Expressions consist of fields, numbers, strings in double quotes, the
+// operators `+`, `-`, `*`, `/` and `%`, and parentheses. Arithmetic on integer
+// fields yields integers, unless a float such as `1.0` is involved, and `+`
+// concatenates strings. Values are converted to the type of the assigned field,
+// strings are parsed when assigned to address fields, and numbers are clamped
+// to the range of unsigned fields. Statements whose value is undefined, such
+// as a division by zero, an integer overflow or a malformed address, leave the
+// field unchanged. This includes unsigned fields exceeding 2^63-1, which can't
+// be used in integer arithmetic.
+// Fields can be renamed by copying them and clearing the original, for example
+// `Note = SrcIfDesc; SrcIfDesc = ""`.
+package set
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/segments"
+	"github.com/BelWue/flowpipeline/segments/filter/flowfilter"
+)
+
+type Set struct {
+	segments.BaseSegment
+	Statements []string // required, the statements applied to each flow in this order
+
+	statements []*statement
+}
+
+func (segment Set) New(config map[string]string) segments.Segment {
+	newsegment := &Set{Statements: splitStatements(config["set"])}
+	if len(newsegment.Statements) == 0 {
+		log.Error().Msg("Set: The 'set' parameter is required to contain at least one statement.")
+		return nil
+	}
+	for _, text := range newsegment.Statements {
+		statement, err := parseStatement(text)
+		if err != nil {
+			log.Error().Err(err).Msgf("Set: Invalid statement '%s': ", text)
+			return nil
+		}
+		newsegment.statements = append(newsegment.statements, statement)
+	}
+	return newsegment
+}
+
+func (segment *Set) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+
+	filter := &flowfilter.Filter{}
+	for msg := range segment.In {
+		for _, statement := range segment.statements {
+			statement.apply(filter, msg)
+		}
+		segment.Out <- msg
+	}
+}
+
+func init() {
+	segment := &Set{}
+	segments.RegisterSegment("set", segment,
+		segments.Param{Name: "set", Required: true, Description: "statements such as Note = \"peering\" if port 179, separated by newlines or semicolons"},
+	)
+}
@@ -0,0 +1,108 @@
+package set
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Set Segment test, arithmetic and conversions
+func TestSegment_Set_assign(t *testing.T) {
+	result := segments.TestSegment("set", map[string]string{"set": `
+		Bytes = Bytes * SamplingRate; Packets = Packets * SamplingRate
+		SamplingRate = 1
+		SrcAddrPreservedLen = 24 - 32
+		DstAddrPreservedLen = (Packets + 2) % 7
+		Note = "peer; " + SrcIfDesc
+		labels.interface = SrcIfName
+		values.bpp = Bytes * 1.0 / Packets
+		values.half = Packets / 2
+		NextHop = "192.0.2.1"
+	`}, &pb.EnrichedFlow{Bytes: 1000, Packets: 3, SamplingRate: 32, SrcIfName: "et-0/0/1", SrcIfDesc: "transit", Proto: 6})
+
+	if result.Bytes != 32000 || result.Packets != 96 || result.SamplingRate != 1 {
+		t.Errorf("([error] Segment Set did not normalize the flow: %d bytes, %d packets, sampling rate %d", result.Bytes, result.Packets, result.SamplingRate)
+	}
+	if result.SrcAddrPreservedLen != 0 || result.DstAddrPreservedLen != 0 {
+		t.Errorf("([error] Segment Set did not compute integers correctly: %d, %d", result.SrcAddrPreservedLen, result.DstAddrPreservedLen)
+	}
+	if result.Note != "peer; transit" || result.Labels["interface"] != "et-0/0/1" {
+		t.Errorf("([error] Segment Set did not set the strings correctly: %q, %v", result.Note, result.Labels)
+	}
+	if result.Values["bpp"] != 32000.0/96 || result.Values["half"] != 48 {
+		t.Errorf("([error] Segment Set did not set the numeric values correctly: %v", result.Values)
+	}
+	if !net.IP(result.NextHop).Equal(net.ParseIP("192.0.2.1")) || len(result.NextHop) != 4 {
+		t.Errorf("([error] Segment Set did not set the address correctly: %v", result.NextHop)
+	}
+}
+
+// Set Segment test, guards and undefined values
+func TestSegment_Set_conditions(t *testing.T) {
+	config := map[string]string{"set": `
+		Note = "web" if proto tcp and port 443
+		Note = "dns" if port 53
+		Bytes = Bytes / Packets
+		SrcAddr = Note
+	`}
+	result := segments.TestSegment("set", config,
+		&pb.EnrichedFlow{Proto: 6, DstPort: 443, Bytes: 100, Packets: 0, SrcAddr: net.ParseIP("2001:db8::1")})
+	if result.Note != "web" {
+		t.Errorf("([error] Segment Set did not apply a matching guard: %q", result.Note)
+	}
+	if result.Bytes != 100 || !net.IP(result.SrcAddr).Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("([error] Segment Set did not keep fields with undefined values: %d, %v", result.Bytes, result.SrcAddr)
+	}
+
+	result = segments.TestSegment("set", config, &pb.EnrichedFlow{Proto: 17, SrcPort: 53})
+	if result.Note != "dns" {
+		t.Errorf("([error] Segment Set applied a guard which did not match: %q", result.Note)
+	}
+}
+
+// Set Segment test, integer overflows and unsigned fields exceeding integers
+func TestSegment_Set_overflow(t *testing.T) {
+	config := map[string]string{"set": `
+		Bytes = Bytes + 1
+		Packets = Packets * Packets
+		SamplingRate = Packets * 2.0
+	`}
+	result := segments.TestSegment("set", config, &pb.EnrichedFlow{Bytes: math.MaxUint64, Packets: 1 << 40, SamplingRate: 1})
+	if result.Bytes != math.MaxUint64 || result.Packets != 1<<40 {
+		t.Errorf("([error] Segment Set did not keep fields with overflowing values: %d bytes, %d packets", result.Bytes, result.Packets)
+	}
+	if result.SamplingRate != 1<<41 {
+		t.Errorf("([error] Segment Set did not compute floats from large integers: %d", result.SamplingRate)
+	}
+
+	result = segments.TestSegment("set", map[string]string{"set": "Bytes = Bytes * 3 - Packets"}, &pb.EnrichedFlow{Bytes: 1 << 62, Packets: 1})
+	if result.Bytes != 1<<62 {
+		t.Errorf("([error] Segment Set did not keep a field with an overflowing product: %d", result.Bytes)
+	}
+}
+
+// Set Segment test, rejects invalid statements
+func TestSegment_Set_invalid(t *testing.T) {
+	for _, statement := range []string{
+		"",
+		"Bytes",
+		"Bytes = ",
+		"Unknown = 1",
+		"Bytes = \"many\"",
+		"Bytes = Bytes + Note",
+		"Note = 1.5 % 2",
+		"Note = (1",
+		"SrcAddr = 1",
+		"AsPath = 1",
+		"Labels = 1",
+		"Bytes = 1 if nonsense",
+		"Bytes = 1 2",
+	} {
+		if (Set{}).New(map[string]string{"set": statement}) != nil {
+			t.Errorf("([error] Segment Set accepted the invalid statement '%s'.", statement)
+		}
+	}
+}
@@ -33,6 +33,7 @@
 package snmp
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"regexp"
@@ -104,7 +105,7 @@ func (segment SNMP) New(config map[string]string) segments.Segment {
 	}
 }
 
-func (segment *SNMP) Run(wg *sync.WaitGroup) {
+func (segment *SNMP) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -205,5 +206,9 @@ func (segment *SNMP) fetchInterfaceData(router string, iface uint32) (string, st
 
 func init() {
 	segment := &SNMP{}
-	segments.RegisterSegment("SNMP", segment)
+	segments.RegisterSegment("SNMP", segment,
+		segments.Param{Name: "community", Default: "public", Description: "the SNMP community"},
+		segments.Param{Name: "regex", Default: "^(.*)$", Description: "regular expression to extract the interface description, its first group is used"},
+		segments.Param{Name: "connlimit", Type: segments.Uint, Default: "16", Description: "maximum number of concurrent SNMP connections"},
+	)
 }
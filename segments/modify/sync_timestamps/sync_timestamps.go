@@ -14,6 +14,7 @@
 package sync_timestamps
 
 import (
+	"context"
 	"sync"
 
 	"github.com/BelWue/flowpipeline/segments"
@@ -27,7 +28,7 @@ func (segment SyncTimestamps) New(config map[string]string) segments.Segment {
 	return &SyncTimestamps{}
 }
 
-func (segment *SyncTimestamps) Run(wg *sync.WaitGroup) {
+func (segment *SyncTimestamps) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -2,11 +2,15 @@
 //
 // The `batchsize` parameter determines the number of flows stored in memory before writing them to the database. Default is 1000.\
 // The `dsn` parameter is used to specify the `Data Source Name` of the clickhouse database to which the flows should be dumped.\
-// The `preset` parameter is used to specify the schema used to insert into clickhouse. Currently only the default value `flowhouse` is supported.
+// The `preset` parameter is used to specify the schema used to insert into clickhouse. Currently only the default value `flowhouse` is supported.\
+// The `labels` parameter adds the `src_labels` and `dst_labels` columns of type `Map(String, String)` to the schema, containing the `SrcLabels` and `DstLabels` of flows,
+// see the `addlabels` segment. Existing tables have to be altered to add these columns.
 package clickhouse_segment
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"math"
 	"net"
 	"strconv"
@@ -31,6 +35,7 @@ type Clickhouse struct {
 	DSN       string // required
 	Preset    string // optional, what schema to use, currently only the option and default is "flowhouse"
 	BatchSize int    // optional how many flows to hold in memory between INSERTs, default is 1000
+	Labels    bool   // optional, default is false, whether to add the SrcLabels and DstLabels maps to the schema
 
 	bulkInsert func(unsavedFlows []*pb.EnrichedFlow) error
 }
@@ -66,11 +71,30 @@ func (segment Clickhouse) New(config map[string]string) segments.Segment {
 		log.Info().Msg("Clickhouse: 'batchsize' set to default '1000'.")
 	}
 
+	if config["labels"] != "" {
+		labels, err := strconv.ParseBool(config["labels"])
+		if err != nil {
+			log.Error().Err(err).Msg("Clickhouse: Could not parse 'labels' parameter: ")
+			return nil
+		}
+		newsegment.Labels = labels
+	}
+
 	// determine field set
 	newsegment.Preset = strings.ToLower(config["preset"])
 	switch newsegment.Preset {
 	case "flowhouse":
-		newsegment.createStatement = `CREATE TABLE IF NOT EXISTS flows (
+		var labelColumns, labelNames, labelValues string
+		if newsegment.Labels {
+			labelColumns = `,
+			src_labels      Map(String, String),
+			dst_labels      Map(String, String)`
+			labelNames = `,
+			src_labels,
+			dst_labels`
+			labelValues = ", ?, ?"
+		}
+		newsegment.createStatement = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS flows (
 			agent           IPv6,
 			int_in          String,
 			int_out         String,
@@ -90,13 +114,13 @@ func (segment Clickhouse) New(config map[string]string) segments.Segment {
 			timestamp       DateTime,
 			size            UInt64,
 			packets         UInt64,
-			samplerate      UInt64
+			samplerate      UInt64%s
 		) ENGINE = MergeTree()
 		PARTITION BY toStartOfTenMinutes(timestamp)
 		ORDER BY (timestamp)
 		TTL timestamp + INTERVAL 14 DAY
-		SETTINGS index_granularity = 8192`
-		newsegment.insertStatement = `INSERT INTO flows (
+		SETTINGS index_granularity = 8192`, labelColumns)
+		newsegment.insertStatement = fmt.Sprintf(`INSERT INTO flows (
 			agent,
 			int_in,
 			int_out,
@@ -116,8 +140,8 @@ func (segment Clickhouse) New(config map[string]string) segments.Segment {
 			timestamp,
 			size,
 			packets,
-			samplerate
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? , ?, ?, ?)`
+			samplerate%s
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? , ?, ?, ?%s)`, labelNames, labelValues)
 		newsegment.bulkInsert = newsegment.bulkInsertFlowhouse
 	default:
 		log.Error().Msgf("Clickhouse: Unknown preset selected.")
@@ -127,7 +151,7 @@ func (segment Clickhouse) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *Clickhouse) Run(wg *sync.WaitGroup) {
+func (segment *Clickhouse) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -163,7 +187,9 @@ func (segment *Clickhouse) Run(wg *sync.WaitGroup) {
 		}
 		segment.Out <- msg
 	}
-	segment.bulkInsert(unsaved)
+	segments.Flush("Clickhouse", func(context.Context) error {
+		return segment.bulkInsert(unsaved)
+	})
 }
 
 func (segment Clickhouse) bulkInsertFlowhouse(unsavedFlows []*pb.EnrichedFlow) error {
@@ -205,6 +231,9 @@ func (segment Clickhouse) bulkInsertFlowhouse(unsavedFlows []*pb.EnrichedFlow) e
 			msg.Packets,
 			msg.SamplingRate,
 		}
+		if segment.Labels {
+			valueArgs = append(valueArgs, msg.SrcLabels, msg.DstLabels)
+		}
 		_, err := tx.Exec(segment.insertStatement, valueArgs...)
 		if err != nil {
 			log.Error().Err(err).Msg("Clickhouse: Error inserting flow into transaction")
@@ -216,5 +245,10 @@ func (segment Clickhouse) bulkInsertFlowhouse(unsavedFlows []*pb.EnrichedFlow) e
 
 func init() {
 	segment := &Clickhouse{}
-	segments.RegisterSegment("clickhouse", segment)
+	segments.RegisterSegment("clickhouse", segment,
+		segments.Param{Name: "dsn", Required: true, Description: "data source name of the ClickHouse server"},
+		segments.Param{Name: "preset", Default: "flowhouse", Description: "the table schema to use, currently only \"flowhouse\""},
+		segments.Param{Name: "batchsize", Type: segments.Uint, Default: "1000", Description: "number of flows inserted at once"},
+		segments.Param{Name: "labels", Type: segments.Bool, Default: "false", Description: "add the SrcLabels and DstLabels of flows as map columns"},
+	)
 }
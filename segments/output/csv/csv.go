@@ -2,35 +2,39 @@
 // can be instructed to write to file using the filename parameter. The fields
 // parameter can be used to limit which fields will be exported. If no filename is
 // provided or empty, the output goes to stdout. By default all fields are exported.
-// To reduce them, use a valid comma separated list of fields.
+// To reduce them, use a valid comma separated list of fields. Entries of map
+// fields are selected as `<field>.<key>`, such as `labels.site`, while maps
+// selected as a whole are exported as JSON objects.
+//
+// The output can be rotated just like the output of the `json` segment, each
+// file starting with the heading.
 package csv
 
 import (
+	"context"
 	"encoding/csv"
-	"fmt"
-	"net"
-	"reflect"
-	"strconv"
+	"errors"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
 )
 
 type Csv struct {
 	segments.BaseTextOutputSegment
-	writer     *csv.Writer
-	fieldNames []string
+	writer  *csv.Writer
+	fields  []segments.FlowField
+	heading []string
 
 	Fields string // optional comma-separated list of fields to export, default is "", meaning all fields
 }
 
 func (segment Csv) New(config map[string]string) segments.Segment {
 	newsegment := &Csv{}
-	file, err := segment.GetOutput(config)
+	file, err := newsegment.GetOutput(config)
 	if err != nil {
 		log.Error().Err(err).Msg("Csv: File specified in 'filename' is not accessible: ")
 		return nil
@@ -39,75 +43,92 @@ func (segment Csv) New(config map[string]string) segments.Segment {
 
 	var heading []string
 	if config["fields"] != "" {
-		protofields := reflect.TypeOf(pb.EnrichedFlow{})
 		conffields := strings.Split(config["fields"], ",")
-		for _, field := range conffields {
-			field = strings.TrimSpace(field)
-			protoField, found := protofields.FieldByName(field)
-			if !found || !protoField.IsExported() {
-				log.Error().Msgf("Csv: Field '%s' specified in 'fields' does not exist.", field)
+		for _, name := range conffields {
+			field, err := segments.ParseFlowField(name)
+			if err != nil {
+				log.Error().Err(err).Msgf("Csv: Field '%s' specified in 'fields' is invalid: ", name)
 				return nil
 			}
-			heading = append(heading, field)
-			newsegment.fieldNames = append(newsegment.fieldNames, field)
+			heading = append(heading, field.Name)
+			newsegment.fields = append(newsegment.fields, field)
 		}
 	} else {
-		protofields := reflect.TypeOf(pb.EnrichedFlow{})
-		for i := 0; i < protofields.NumField(); i++ {
-			field := protofields.Field(i)
-			if field.IsExported() {
-				newsegment.fieldNames = append(newsegment.fieldNames, field.Name)
-				heading = append(heading, field.Name)
-			}
+		newsegment.fields = segments.AllFlowFields()
+		for _, field := range newsegment.fields {
+			heading = append(heading, field.Name)
 		}
 		newsegment.Fields = config["fields"]
 	}
 
-	newsegment.writer = csv.NewWriter(file)
-	if err := newsegment.writer.Write(heading); err != nil {
+	newsegment.heading = heading
+	if err := newsegment.start(); err != nil {
 		log.Error().Err(err).Msg("Csv: Failed to write to destination:")
 		return nil
 	}
-	newsegment.writer.Flush()
 
 	return newsegment
 }
 
-func (segment *Csv) Run(wg *sync.WaitGroup) {
+// Sets up the writer for the current file and writes the heading.
+func (segment *Csv) start() error {
+	segment.writer = csv.NewWriter(segment.File)
+	if err := segment.writer.Write(segment.heading); err != nil {
+		return err
+	}
+	segment.writer.Flush()
+	return segment.writer.Error()
+}
+
+func (segment *Csv) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
-		segment.writer.Flush()
-		segment.File.Close()
+		segments.Flush("Csv", func(context.Context) error {
+			segment.writer.Flush()
+			return errors.Join(segment.writer.Error(), segment.File.Close())
+		})
 		close(segment.Out)
 		wg.Done()
 	}()
-	for msg := range segment.In {
-		var record []string
-		values := reflect.ValueOf(msg).Elem()
-		for _, fieldname := range segment.fieldNames {
-			value := values.FieldByName(fieldname).Interface()
-			switch value := value.(type) {
-			case []uint8: // this is necessary for proper formatting
-				ipstring := net.IP(value).String()
-				if ipstring == "<nil>" {
-					ipstring = ""
-				}
-				record = append(record, ipstring)
-			case uint32: // this is because FormatUint is much faster than Sprint
-				record = append(record, strconv.FormatUint(uint64(value), 10))
-			case uint64: // this is because FormatUint is much faster than Sprint
-				record = append(record, strconv.FormatUint(uint64(value), 10))
-			case string: // this is because doing nothing is also much faster than Sprint
-				record = append(record, value)
-			default:
-				record = append(record, fmt.Sprint(value))
+	ticker := time.NewTicker(segments.RotationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			record := make([]string, 0, len(segment.fields))
+			for _, field := range segment.fields {
+				record = append(record, field.Format(msg))
+			}
+			segment.writer.Write(record)
+			if segment.File.Due() {
+				segment.rotate()
+			}
+			segment.Out <- msg
+		case <-ticker.C:
+			if segment.File.Due() {
+				segment.rotate()
 			}
 		}
-		segment.writer.Write(record)
-		segment.Out <- msg
+	}
+}
+
+// Completes the current file and continues with the next one.
+func (segment *Csv) rotate() {
+	segment.writer.Flush()
+	err := errors.Join(segment.writer.Error(), segment.File.Rotate(), segment.start())
+	if err != nil {
+		log.Error().Err(err).Msgf("Csv: Failed to rotate output to %s: ", segment.File.Name())
 	}
 }
 
 func init() {
 	segment := &Csv{}
-	segments.RegisterSegment("csv", segment)
+	segments.RegisterSegment("csv", segment,
+		append([]segments.Param{
+			{Name: "filename", Description: "file to write to instead of stdout, may contain strftime-style directives"},
+			{Name: "fields", Description: "comma-separated list of fields to export, all fields if unset"},
+		}, segments.RotationParams...)...,
+	)
 }
@@ -1,8 +1,10 @@
 package csv
 
 import (
+	"context"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
@@ -33,7 +35,7 @@ func BenchmarkCsv(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{Proto: 45}
@@ -41,3 +43,21 @@ func BenchmarkCsv(b *testing.B) {
 	}
 	close(in)
 }
+
+// Csv Segment test, exports entries of maps and whole maps
+func TestSegment_Csv_maps(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.csv")
+	segments.TestSegment("csv", map[string]string{"filename": filename, "fields": "Proto,labels.site,Labels.tenant,values"},
+		&pb.EnrichedFlow{Proto: 6, Labels: map[string]string{"site": "fra1"}, Values: map[string]float64{"rtt": 1.5}})
+	output, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Proto,labels.site,Labels.tenant,values\n6,fra1,,\"{\"\"rtt\"\":1.5}\"\n"; string(output) != expected {
+		t.Errorf("([error] Segment Csv did not export the maps correctly, got %q.", output)
+	}
+
+	if (Csv{}).New(map[string]string{"fields": "Proto.site"}) != nil {
+		t.Error("([error] Segment Csv accepted a key of a field which is not a map.")
+	}
+}
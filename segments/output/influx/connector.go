@@ -2,15 +2,12 @@ package influx
 
 import (
 	"context"
-	"fmt"
-	"net"
-	"reflect"
-	"strconv"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
 )
@@ -23,8 +20,8 @@ type Connector struct {
 	Token        string
 	ExportFreq   int
 	Batchsize    int
-	Tags         []string
-	Fields       []string
+	Tags         []segments.FlowField
+	Fields       []segments.FlowField
 	influxClient influxdb2.Client
 }
 
@@ -52,30 +49,13 @@ func (c *Connector) checkBucket() {
 func (c *Connector) CreatePoint(msg *pb.EnrichedFlow) *write.Point {
 	// write tags for datapoint and drop them to not insert as fields
 	tags := make(map[string]string)
-	values := reflect.ValueOf(msg).Elem()
-	for _, tagname := range c.Tags {
-		value := values.FieldByName(tagname).Interface()
-		switch value := value.(type) {
-		case []uint8: // this is necessary for proper formatting
-			ipstring := net.IP(value).String()
-			if ipstring == "<nil>" {
-				ipstring = ""
-			}
-			tags[tagname] = ipstring
-		case uint32: // this is because FormatUint is much faster than Sprint
-			tags[tagname] = strconv.FormatUint(uint64(value), 10)
-		case uint64: // this is because FormatUint is much faster than Sprint
-			tags[tagname] = strconv.FormatUint(uint64(value), 10)
-		case string: // this is because doing nothing is also much faster than Sprint
-			tags[tagname] = value
-		default:
-			tags[tagname] = fmt.Sprint(value)
-		}
+	for _, tag := range c.Tags {
+		tags[tag.Name] = tag.Format(msg)
 	}
 
 	fields := make(map[string]interface{})
-	for _, fieldname := range c.Fields {
-		fields[fieldname] = values.FieldByName(fieldname).Interface()
+	for _, field := range c.Fields {
+		fields[field.Name] = field.Value(msg)
 	}
 
 	// create point
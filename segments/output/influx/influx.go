@@ -2,6 +2,8 @@
 // The `tags` parameter allows any field to be used as a tag and takes a comma-separated list from any
 // field available in the [protobuf definition](https://github.com/BelWue/flowpipeline/blob/master/pb/flow.proto).
 // The `fields` works in the exact same way, except that these protobuf fields won't be indexed by InfluxDB.
+// Entries of map fields are selected as `<field>.<key>`, such as `SrcLabels.zone`
+// or `labels.site`, numeric values such as `values.rtt` are best used as fields.
 //
 // Note that some of the above fields might not be present depending on the method
 // of flow export, the input segment used in this pipeline, or the modify segments
@@ -9,14 +11,13 @@
 package influx
 
 import (
+	"context"
 	"net/url"
-	"reflect"
 	"strings"
 	"sync"
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
 )
 
@@ -28,6 +29,9 @@ type Influx struct {
 	Token   string   // required, Influx access token
 	Tags    []string // optional, list of Tags to be created.
 	Fields  []string // optional, list of Fields to be created, default is "Bytes,Packets"
+
+	tagFields   []segments.FlowField
+	fieldFields []segments.FlowField
 }
 
 func (segment Influx) New(config map[string]string) segments.Segment {
@@ -73,15 +77,18 @@ func (segment Influx) New(config map[string]string) segments.Segment {
 		newsegment.Tags = []string{"ProtoName"}
 	} else {
 		newsegment.Tags = strings.Split(config["tags"], ",")
-		protomembers := reflect.TypeOf(pb.EnrichedFlow{})
-		for _, tagname := range newsegment.Tags {
-			tagname = strings.TrimSpace(tagname)
-			_, found := protomembers.FieldByName(tagname)
-			if !found {
-				log.Error().Msgf("Influx: Unknown name '%s' specified in 'tags'.", tagname)
-				return nil
-			}
+	}
+	for _, tagname := range newsegment.Tags {
+		field, err := segments.ParseFlowField(tagname)
+		if err != nil {
+			log.Error().Err(err).Msgf("Influx: Unknown name '%s' specified in 'tags': ", tagname)
+			return nil
 		}
+		if field.IsMap() {
+			log.Error().Msgf("Influx: Field '%s' specified in 'tags' is a map, select one of its entries as '%s.<key>'.", field.Name, field.Name)
+			return nil
+		}
+		newsegment.tagFields = append(newsegment.tagFields, field)
 	}
 
 	// set default Fields if not configured
@@ -90,20 +97,24 @@ func (segment Influx) New(config map[string]string) segments.Segment {
 		newsegment.Fields = []string{"Bytes", "Packets"}
 	} else {
 		newsegment.Fields = strings.Split(config["fields"], ",")
-		for _, fieldname := range newsegment.Fields {
-			protomembers := reflect.TypeOf(pb.EnrichedFlow{})
-			_, found := protomembers.FieldByName(fieldname)
-			if !found {
-				log.Error().Msgf("Influx: Unknown name '%s' specified in 'fields'.", fieldname)
-				return nil
-			}
+	}
+	for _, fieldname := range newsegment.Fields {
+		field, err := segments.ParseFlowField(fieldname)
+		if err != nil {
+			log.Error().Err(err).Msgf("Influx: Unknown name '%s' specified in 'fields': ", fieldname)
+			return nil
+		}
+		if field.IsMap() {
+			log.Error().Msgf("Influx: Field '%s' specified in 'fields' is a map, select one of its entries as '%s.<key>'.", field.Name, field.Name)
+			return nil
 		}
+		newsegment.fieldFields = append(newsegment.fieldFields, field)
 	}
 
 	return newsegment
 }
 
-func (segment *Influx) Run(wg *sync.WaitGroup) {
+func (segment *Influx) Run(ctx context.Context, wg *sync.WaitGroup) {
 	// TODO: extend options
 	var connector = Connector{
 		Address:   segment.Address,
@@ -111,18 +122,21 @@ func (segment *Influx) Run(wg *sync.WaitGroup) {
 		Org:       segment.Org,
 		Token:     segment.Token,
 		Batchsize: 5000,
-		Tags:      segment.Tags,
-		Fields:    segment.Fields,
+		Tags:      segment.tagFields,
+		Fields:    segment.fieldFields,
 	}
 
 	// initialize Influx endpoint
 	connector.Initialize()
 	writeAPI := connector.influxClient.WriteAPI(connector.Org, connector.Bucket)
 	defer func() {
-		close(segment.Out)
 		// Force all unwritten data to be sent
-		writeAPI.Flush()
-		connector.influxClient.Close()
+		segments.Flush("Influx", func(context.Context) error {
+			writeAPI.Flush()
+			connector.influxClient.Close()
+			return nil
+		})
+		close(segment.Out)
 		wg.Done()
 	}()
 
@@ -140,5 +154,12 @@ func (segment *Influx) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Influx{}
-	segments.RegisterSegment("influx", segment)
+	segments.RegisterSegment("influx", segment,
+		segments.Param{Name: "address", Default: "http://127.0.0.1:8086", Description: "URL of the InfluxDB server"},
+		segments.Param{Name: "org", Required: true, Description: "the InfluxDB organization"},
+		segments.Param{Name: "bucket", Required: true, Description: "the InfluxDB bucket"},
+		segments.Param{Name: "token", Required: true, Description: "the InfluxDB access token"},
+		segments.Param{Name: "tags", Default: "ProtoName", Description: "comma-separated list of flow fields to be written as tags"},
+		segments.Param{Name: "fields", Default: "Bytes,Packets", Description: "comma-separated list of flow fields to be written as fields"},
+	)
 }
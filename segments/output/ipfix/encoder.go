@@ -0,0 +1,393 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+)
+
+// An Information Element as defined by RFC 7011, or a field type of NetFlow
+// v9, which share their numbers.
+type standardElement struct {
+	id     uint16
+	idIPv6 uint16 // set for elements with a separate IPv6 variant
+	length uint16
+}
+
+// The standard elements used for fields of EnrichedFlow, if there is one.
+// Addresses and ICMP fields have separate IPv4 and IPv6 elements, and timestamps
+// are handled separately as they are encoded differently by IPFIX and NetFlow v9.
+var standardElements = map[string]standardElement{
+	"Bytes":              {id: 1, length: 8},
+	"Packets":            {id: 2, length: 8},
+	"Proto":              {id: 4, length: 1},
+	"IpTos":              {id: 5, length: 1},
+	"TcpFlags":           {id: 6, length: 2},
+	"SrcPort":            {id: 7, length: 2},
+	"SrcAddr":            {id: 8, idIPv6: 27, length: 4},
+	"SrcNet":             {id: 9, idIPv6: 29, length: 1},
+	"InIf":               {id: 10, length: 4},
+	"DstPort":            {id: 11, length: 2},
+	"DstAddr":            {id: 12, idIPv6: 28, length: 4},
+	"DstNet":             {id: 13, idIPv6: 30, length: 1},
+	"OutIf":              {id: 14, length: 4},
+	"NextHop":            {id: 15, idIPv6: 62, length: 4},
+	"SrcAs":              {id: 16, length: 4},
+	"DstAs":              {id: 17, length: 4},
+	"BgpNextHop":         {id: 18, idIPv6: 63, length: 4},
+	"Ipv6FlowLabel":      {id: 31, length: 4},
+	"SamplingRate":       {id: 34, length: 4},
+	"IpTtl":              {id: 52, length: 1},
+	"FragmentId":         {id: 54, length: 4},
+	"SrcMac":             {id: 56, length: 6},
+	"SrcVlan":            {id: 58, length: 2},
+	"DstVlan":            {id: 59, length: 2},
+	"FlowDirection":      {id: 61, length: 1},
+	"DstMac":             {id: 80, length: 6},
+	"FragmentOffset":     {id: 88, length: 2},
+	"ForwardingStatus":   {id: 89, length: 1},
+	"ObservationPointId": {id: 138, length: 4},
+	"IcmpType":           {id: 176, idIPv6: 178, length: 1},
+	"IcmpCode":           {id: 177, idIPv6: 179, length: 1},
+	"IngressVrfId":       {id: 234, length: 4},
+	"EgressVrfId":        {id: 235, length: 4},
+	"BiFlowDirection":    {id: 239, length: 1},
+	"Etype":              {id: 256, length: 2},
+}
+
+// The timestamps, which are encoded as flowStartMilliseconds and
+// flowEndMilliseconds by IPFIX and as FIRST_SWITCHED and LAST_SWITCHED by
+// NetFlow v9.
+var timeElements = map[string][2]uint16{
+	"TimeFlowStartNs": {152, 22},
+	"TimeFlowEndNs":   {153, 21},
+}
+
+// The length of variable length elements in templates.
+const variableLength = math.MaxUint16
+
+// A field of EnrichedFlow as it is exported in a template.
+type element struct {
+	name       string
+	index      int    // of the field in EnrichedFlow
+	id         uint16 // without the enterprise bit
+	enterprise uint32 // zero for standard elements
+	length     uint16
+	encode     func(dst []byte, v reflect.Value) []byte
+}
+
+// Returns the elements exporting the given field for the IPv4 and IPv6
+// templates, which are the same for most fields.
+func newElements(name string, version uint16, enterprise uint32, forceEnterprise bool, started time.Time) ([2]element, error) {
+	field, found := reflect.TypeOf(pb.EnrichedFlow{}).FieldByName(name)
+	if !found || !field.IsExported() {
+		return [2]element{}, fmt.Errorf("field '%s' does not exist", name)
+	}
+	e := element{name: name, index: field.Index[0]}
+
+	if ids, ok := timeElements[name]; ok && !forceEnterprise {
+		if version == 10 {
+			e.id, e.length = ids[0], 8
+			e.encode = func(dst []byte, v reflect.Value) []byte {
+				return binary.BigEndian.AppendUint64(dst, v.Uint()/uint64(time.Millisecond))
+			}
+		} else {
+			// relative to the sysUptime in the header, which counts from
+			// the start of the segment, earlier flows are clamped to it
+			e.id, e.length = ids[1], 4
+			e.encode = func(dst []byte, v reflect.Value) []byte {
+				uptime := max(time.Unix(0, int64(v.Uint())).Sub(started), 0)
+				return binary.BigEndian.AppendUint32(dst, uint32(uptime.Milliseconds()))
+			}
+		}
+		return [2]element{e, e}, nil
+	}
+
+	if standard, ok := standardElements[name]; ok && !forceEnterprise {
+		e.id, e.length = standard.id, standard.length
+		if field.Type.Kind() == reflect.Slice {
+			e.encode = encodeAddress(4)
+		} else {
+			e.encode = encodeUnsigned(e.length)
+		}
+		v6 := e
+		if standard.idIPv6 != 0 {
+			v6.id = standard.idIPv6
+			if field.Type.Kind() == reflect.Slice {
+				v6.length, v6.encode = 16, encodeAddress(16)
+			}
+		}
+		return [2]element{e, v6}, nil
+	}
+
+	// any other field is exported as an enterprise-specific element using
+	// its protobuf field number
+	if version != 10 {
+		return [2]element{}, fmt.Errorf("field '%s' has no standard element, enterprise-specific elements require IPFIX", name)
+	}
+	if enterprise == 0 {
+		return [2]element{}, fmt.Errorf("field '%s' has no standard element, exporting it requires the 'enterprise' parameter", name)
+	}
+	number, err := protobufNumber(field)
+	if err != nil {
+		return [2]element{}, err
+	}
+	e.id, e.enterprise = number, enterprise
+	switch field.Type.Kind() {
+	case reflect.Uint32, reflect.Int32:
+		e.length, e.encode = 4, encodeUnsigned(4)
+	case reflect.Uint64:
+		e.length, e.encode = 8, encodeUnsigned(8)
+	case reflect.Bool:
+		e.length, e.encode = 1, encodeUnsigned(1)
+	case reflect.String, reflect.Slice:
+		e.length, e.encode = variableLength, encodeVariable
+	default:
+		return [2]element{}, fmt.Errorf("field '%s' can't be exported", name)
+	}
+	return [2]element{e, e}, nil
+}
+
+// Returns the field number from the struct tag generated by protoc.
+func protobufNumber(field reflect.StructField) (uint16, error) {
+	parts := strings.Split(field.Tag.Get("protobuf"), ",")
+	if len(parts) < 2 {
+		return 0, fmt.Errorf("field '%s' is not a protobuf field", field.Name)
+	}
+	number, err := strconv.ParseUint(parts[1], 10, 15)
+	if err != nil {
+		return 0, fmt.Errorf("field '%s' has a field number too large for IPFIX", field.Name)
+	}
+	return uint16(number), nil
+}
+
+// Encodes unsigned integers, enums and booleans, truncating integers to the
+// given number of bytes. Booleans are encoded as defined by RFC 7011.
+func encodeUnsigned(length uint16) func([]byte, reflect.Value) []byte {
+	return func(dst []byte, v reflect.Value) []byte {
+		var value uint64
+		switch v.Kind() {
+		case reflect.Bool:
+			value = 2
+			if v.Bool() {
+				value = 1
+			}
+		case reflect.Int32:
+			value = uint64(v.Int())
+		default:
+			value = v.Uint()
+		}
+		for i := int(length) - 1; i >= 0; i-- {
+			dst = append(dst, byte(value>>(8*i)))
+		}
+		return dst
+	}
+}
+
+// Encodes an address of the given length, or zeros if the address is of the
+// other family.
+func encodeAddress(length int) func([]byte, reflect.Value) []byte {
+	return func(dst []byte, v reflect.Value) []byte {
+		ip := net.IP(v.Bytes())
+		if length == 4 {
+			ip = ip.To4()
+		} else if ip.To4() != nil || len(ip) != net.IPv6len {
+			ip = nil
+		}
+		if ip == nil {
+			return append(dst, make([]byte, length)...)
+		}
+		return append(dst, ip...)
+	}
+}
+
+// Encodes strings, bytes and repeated fields, the latter as a sequence of
+// their encoded elements.
+func encodeVariable(dst []byte, v reflect.Value) []byte {
+	var value []byte
+	switch v.Kind() {
+	case reflect.String:
+		value = []byte(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			value = v.Bytes()
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			element := v.Index(i)
+			switch element.Kind() {
+			case reflect.Uint32, reflect.Int32:
+				value = encodeUnsigned(4)(value, element)
+			case reflect.Uint64:
+				value = encodeUnsigned(8)(value, element)
+			case reflect.Slice:
+				value = append(value, element.Bytes()...)
+			}
+		}
+	}
+	if len(value) > math.MaxUint16 {
+		value = value[:math.MaxUint16]
+	}
+	if len(value) < 255 {
+		dst = append(dst, byte(len(value)))
+	} else {
+		dst = append(dst, 255)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(value)))
+	}
+	return append(dst, value...)
+}
+
+type template struct {
+	id       uint16
+	elements []element
+}
+
+// Encodes flows into IPFIX or NetFlow v9 messages of at most mtu bytes and
+// passes them to send.
+type exporter struct {
+	version   uint16
+	domain    uint32
+	mtu       int
+	templates []template // the IPv4 and IPv6 templates, or a single one
+	started   time.Time
+	send      func([]byte) error
+
+	sequence uint32 // the number of data records (IPFIX) or messages (NetFlow v9) sent
+	message  []byte // the current message, empty if no record was added yet
+	set      int    // the offset of the current set in message
+	template uint16 // the template used by the current set
+	records  uint32 // the number of records in the current message
+	record   []byte
+}
+
+func (e *exporter) headerLength() int {
+	if e.version == 9 {
+		return 20
+	}
+	return 16
+}
+
+// Adds a flow to the current message, sending it first if it is full.
+func (e *exporter) add(flow *pb.EnrichedFlow) error {
+	t := e.templates[0]
+	if len(e.templates) > 1 && isIPv6(flow) {
+		t = e.templates[1]
+	}
+	v := reflect.ValueOf(flow).Elem()
+	e.record = e.record[:0]
+	for _, element := range t.elements {
+		e.record = element.encode(e.record, v.Field(element.index))
+	}
+
+	needed := len(e.record)
+	if len(e.message) == 0 || e.template != t.id {
+		needed += 4 // for a new set
+	}
+	if len(e.message) > 0 && len(e.message)+needed > e.mtu {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	if len(e.message) == 0 {
+		e.message = make([]byte, e.headerLength(), e.mtu)
+	}
+	if len(e.message) == e.headerLength() || e.template != t.id {
+		e.completeSet()
+		e.set, e.template = len(e.message), t.id
+		e.message = binary.BigEndian.AppendUint16(e.message, t.id)
+		e.message = append(e.message, 0, 0) // length, set by completeSet
+	}
+	e.message = append(e.message, e.record...)
+	e.records += 1
+	return nil
+}
+
+// Sets the length of the current set, if any.
+func (e *exporter) completeSet() {
+	if e.set > 0 {
+		binary.BigEndian.PutUint16(e.message[e.set+2:], uint16(len(e.message)-e.set))
+		e.set = 0
+	}
+}
+
+// Sends the current message, if it contains any records.
+func (e *exporter) flush() error {
+	if len(e.message) == 0 {
+		return nil
+	}
+	e.completeSet()
+	e.writeHeader(e.message, e.records)
+	if e.version == 10 {
+		e.sequence += e.records
+	} else {
+		e.sequence += 1
+	}
+	err := e.send(e.message)
+	e.message, e.records = e.message[:0], 0
+	return err
+}
+
+// Sends all templates in a message of their own.
+func (e *exporter) sendTemplates() error {
+	message := make([]byte, e.headerLength())
+	setID := uint16(2)
+	if e.version == 9 {
+		setID = 0
+	}
+	message = binary.BigEndian.AppendUint16(message, setID)
+	message = append(message, 0, 0)
+	for _, t := range e.templates {
+		message = binary.BigEndian.AppendUint16(message, t.id)
+		message = binary.BigEndian.AppendUint16(message, uint16(len(t.elements)))
+		for _, element := range t.elements {
+			if element.enterprise != 0 {
+				message = binary.BigEndian.AppendUint16(message, element.id|0x8000)
+				message = binary.BigEndian.AppendUint16(message, element.length)
+				message = binary.BigEndian.AppendUint32(message, element.enterprise)
+			} else {
+				message = binary.BigEndian.AppendUint16(message, element.id)
+				message = binary.BigEndian.AppendUint16(message, element.length)
+			}
+		}
+	}
+	headerLength := e.headerLength()
+	binary.BigEndian.PutUint16(message[headerLength+2:], uint16(len(message)-headerLength))
+	e.writeHeader(message, uint32(len(e.templates)))
+	if e.version == 9 {
+		e.sequence += 1
+	}
+	return e.send(message)
+}
+
+func (e *exporter) writeHeader(message []byte, records uint32) {
+	now := time.Now()
+	binary.BigEndian.PutUint16(message[0:], e.version)
+	if e.version == 9 {
+		binary.BigEndian.PutUint16(message[2:], uint16(records))
+		binary.BigEndian.PutUint32(message[4:], uint32(now.Sub(e.started).Milliseconds()))
+		binary.BigEndian.PutUint32(message[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(message[12:], e.sequence)
+		binary.BigEndian.PutUint32(message[16:], e.domain)
+	} else {
+		binary.BigEndian.PutUint16(message[2:], uint16(len(message)))
+		binary.BigEndian.PutUint32(message[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(message[8:], e.sequence)
+		binary.BigEndian.PutUint32(message[12:], e.domain)
+	}
+}
+
+func isIPv6(flow *pb.EnrichedFlow) bool {
+	for _, addr := range [][]byte{flow.SrcAddr, flow.DstAddr} {
+		if len(addr) > 0 {
+			return net.IP(addr).To4() == nil
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+// The `ipfix` segment exports flows to a collector using IPFIX, or NetFlow v9
+// if `version` is set to 9, over UDP. This allows flowpipeline to act as a
+// filtering or anonymizing proxy in front of collectors which can't consume
+// any of the other outputs.
+//
+// The `fields` parameter selects the fields of each flow which are exported,
+// a selection of the fields usually exported by routers is used by default.
+// Fields are exported using their standard Information Elements where one
+// exists, with separate templates for IPv4 and IPv6 flows if needed. Any
+// other field, for instance `Cid` or `SrcIfName`, is exported as an
+// enterprise-specific element numbered like the field in the protobuf
+// definition of flowpipeline's flows, using the Private Enterprise Number
+// given by `enterprise`. Fields listed in `enterprisefields` are exported as
+// enterprise-specific elements even if a standard one exists, for instance
+// `SrcAs` and `DstAs` after they have been enriched by the `aslookup` segment.
+// NetFlow v9 does not support enterprise-specific elements.
+//
+// Templates are sent on startup and every `templateinterval` afterwards, as
+// collectors may have missed them. Flows are sent as soon as a message of
+// `mtu` bytes is full, or after a second at most. NetFlow v9 timestamps are
+// relative to the start of the segment, so older flows are exported as having
+// started and ended at that time.
+package ipfix
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// The default fields, which match the fields usually exported by routers.
+const defaultFields = "TimeFlowStartNs,TimeFlowEndNs,Bytes,Packets,SrcAddr,DstAddr,SrcPort,DstPort,Proto,TcpFlags,IpTos,InIf,OutIf,SrcNet,DstNet,NextHop,SrcAs,DstAs,FlowDirection"
+
+// Messages are sent at least this often, so collectors don't have to wait for
+// a full message on slow pipelines.
+const flushInterval = time.Second
+
+type Ipfix struct {
+	segments.BaseSegment
+	Target           string        // required, the collector as host:port
+	Version          uint16        // optional, default is 10 for IPFIX, or 9 for NetFlow v9
+	Fields           string        // optional comma-separated list of fields to export, default is defaultFields
+	EnterpriseFields string        // optional comma-separated list of fields to export as enterprise-specific elements
+	Enterprise       uint32        // optional Private Enterprise Number, required for enterprise-specific elements
+	Domain           uint32        // optional, default is 0, the observation domain or source id
+	TemplateInterval time.Duration // optional, default is 1m
+	Mtu              int           // optional, default is 1400
+
+	conn     net.Conn
+	exporter *exporter
+}
+
+func (segment Ipfix) New(config map[string]string) segments.Segment {
+	newsegment := &Ipfix{
+		Target:           config["target"],
+		Version:          10,
+		Fields:           config["fields"],
+		EnterpriseFields: config["enterprisefields"],
+		TemplateInterval: time.Minute,
+		Mtu:              1400,
+	}
+	if newsegment.Target == "" {
+		log.Error().Msg("Ipfix: Parameter 'target' is required.")
+		return nil
+	}
+	if newsegment.Fields == "" {
+		newsegment.Fields = defaultFields
+	}
+	switch config["version"] {
+	case "", "10":
+	case "9":
+		newsegment.Version = 9
+	default:
+		log.Error().Msgf("Ipfix: Unknown version '%s', it has to be 10 for IPFIX or 9 for NetFlow v9.", config["version"])
+		return nil
+	}
+	if config["enterprise"] != "" {
+		enterprise, err := strconv.ParseUint(config["enterprise"], 10, 32)
+		if err != nil {
+			log.Error().Err(err).Msg("Ipfix: Could not parse 'enterprise' parameter: ")
+			return nil
+		}
+		newsegment.Enterprise = uint32(enterprise)
+	}
+	if config["domain"] != "" {
+		domain, err := strconv.ParseUint(config["domain"], 10, 32)
+		if err != nil {
+			log.Error().Err(err).Msg("Ipfix: Could not parse 'domain' parameter: ")
+			return nil
+		}
+		newsegment.Domain = uint32(domain)
+	}
+	if config["templateinterval"] != "" {
+		interval, err := time.ParseDuration(config["templateinterval"])
+		if err != nil || interval <= 0 {
+			log.Error().Err(err).Msg("Ipfix: Could not parse 'templateinterval' parameter, it has to be a positive duration: ")
+			return nil
+		}
+		newsegment.TemplateInterval = interval
+	}
+	if config["mtu"] != "" {
+		mtu, err := strconv.Atoi(config["mtu"])
+		if err != nil || mtu < 512 || mtu > 65507 {
+			log.Error().Err(err).Msg("Ipfix: Could not parse 'mtu' parameter, it has to be between 512 and 65507: ")
+			return nil
+		}
+		newsegment.Mtu = mtu
+	}
+
+	exporter := &exporter{version: newsegment.Version, domain: newsegment.Domain, mtu: newsegment.Mtu, started: time.Now()}
+	forced := make(map[string]bool)
+	var names []string
+	for _, name := range strings.Split(newsegment.Fields, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	if newsegment.EnterpriseFields != "" {
+		for _, name := range strings.Split(newsegment.EnterpriseFields, ",") {
+			name = strings.TrimSpace(name)
+			if !forced[name] {
+				forced[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	var v4, v6 template
+	v4.id, v6.id = 256, 257
+	exported := make(map[string]bool)
+	differ := false
+	for _, name := range names {
+		if exported[name] {
+			continue
+		}
+		exported[name] = true
+		elements, err := newElements(name, newsegment.Version, newsegment.Enterprise, forced[name], exporter.started)
+		if err != nil {
+			log.Error().Err(err).Msg("Ipfix: Invalid 'fields' or 'enterprisefields' parameter: ")
+			return nil
+		}
+		v4.elements = append(v4.elements, elements[0])
+		v6.elements = append(v6.elements, elements[1])
+		differ = differ || elements[0].id != elements[1].id
+	}
+	exporter.templates = []template{v4}
+	if differ {
+		exporter.templates = append(exporter.templates, v6)
+	}
+	newsegment.exporter = exporter
+
+	if segments.DryRun(config) {
+		if _, err := net.ResolveUDPAddr("udp", newsegment.Target); err != nil {
+			log.Error().Err(err).Msg("Ipfix: Could not resolve 'target' parameter: ")
+			return nil
+		}
+		return newsegment
+	}
+	conn, err := net.Dial("udp", newsegment.Target)
+	if err != nil {
+		log.Error().Err(err).Msg("Ipfix: Could not resolve 'target' parameter: ")
+		return nil
+	}
+	newsegment.conn = conn
+	exporter.send = func(message []byte) error {
+		_, err := conn.Write(message)
+		return err
+	}
+	log.Info().Msgf("Ipfix: Exporting to %s using version %d.", newsegment.Target, newsegment.Version)
+	return newsegment
+}
+
+func (segment *Ipfix) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		segments.Flush("Ipfix", func(context.Context) error {
+			err := segment.exporter.flush()
+			segment.conn.Close()
+			return err
+		})
+		close(segment.Out)
+		wg.Done()
+	}()
+
+	var templatesSent time.Time
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			if time.Since(templatesSent) >= segment.TemplateInterval {
+				// complete the current message, so it isn't sent before the
+				// templates it uses
+				if err := segment.exporter.flush(); err != nil {
+					log.Warn().Err(err).Msgf("Ipfix: Failed to send flows to %s: ", segment.Target)
+				}
+				if err := segment.exporter.sendTemplates(); err != nil {
+					log.Warn().Err(err).Msgf("Ipfix: Failed to send templates to %s: ", segment.Target)
+				}
+				templatesSent = time.Now()
+			}
+			if err := segment.exporter.add(msg); err != nil {
+				log.Warn().Err(err).Msgf("Ipfix: Failed to send flows to %s: ", segment.Target)
+			}
+			segment.Out <- msg
+		case <-ticker.C:
+			if err := segment.exporter.flush(); err != nil {
+				log.Warn().Err(err).Msgf("Ipfix: Failed to send flows to %s: ", segment.Target)
+			}
+		}
+	}
+}
+
+func init() {
+	segment := &Ipfix{}
+	segments.RegisterSegment("ipfix", segment,
+		segments.Param{Name: "target", Required: true, Description: "the collector to export to, as host:port"},
+		segments.Param{Name: "version", Type: segments.Uint, Default: "10", Description: "10 for IPFIX or 9 for NetFlow v9"},
+		segments.Param{Name: "fields", Default: defaultFields, Description: "comma-separated list of fields to export"},
+		segments.Param{Name: "enterprisefields", Description: "comma-separated list of fields to export as enterprise-specific elements even if a standard element exists"},
+		segments.Param{Name: "enterprise", Type: segments.Uint, Description: "Private Enterprise Number used for enterprise-specific elements"},
+		segments.Param{Name: "domain", Type: segments.Uint, Default: "0", Description: "observation domain id, or source id for NetFlow v9"},
+		segments.Param{Name: "templateinterval", Type: segments.Duration, Default: "1m", Description: "how often to send the templates again"},
+		segments.Param{Name: "mtu", Type: segments.Uint, Default: "1400", Description: "maximum size of the messages sent"},
+	)
+}
@@ -0,0 +1,205 @@
+package ipfix
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netsampler/goflow2/v2/decoders/netflow"
+	"github.com/netsampler/goflow2/v2/producer"
+	protoproducer "github.com/netsampler/goflow2/v2/producer/proto"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Ipfix Segment test, passthrough test
+func TestSegment_Ipfix_passthrough(t *testing.T) {
+	result := segments.TestSegment("ipfix", map[string]string{"target": "127.0.0.1:4739"},
+		&pb.EnrichedFlow{Type: 3})
+
+	if result.Type != 3 {
+		t.Error("([error] Segment Ipfix is not working.")
+	}
+}
+
+// Runs the segment with the given config and returns all messages it sent.
+func export(t *testing.T, config map[string]string, flows []*pb.EnrichedFlow) [][]byte {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	config["target"] = listener.LocalAddr().String()
+	segment := Ipfix{}.New(config)
+	if segment == nil {
+		t.Fatal("([error] Segment Ipfix failed to initialize.")
+	}
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+	for _, flow := range flows {
+		in <- flow
+		<-out
+	}
+	close(in)
+	wg.Wait()
+
+	var messages [][]byte
+	buf := make([]byte, 65536)
+	for {
+		listener.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			return messages
+		}
+		messages = append(messages, bytes.Clone(buf[:n]))
+	}
+}
+
+// Decodes the messages as goflow2, and thus the goflow segment, would.
+func decode(t *testing.T, messages [][]byte) ([]*protoproducer.ProtoProducerMessage, []netflow.DataField) {
+	config, _ := (&protoproducer.ProducerConfig{}).Compile()
+	flowProducer, err := protoproducer.CreateProtoProducer(config, protoproducer.CreateSamplingSystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := netflow.CreateTemplateSystem()
+	var flows []*protoproducer.ProtoProducerMessage
+	var fields []netflow.DataField
+	for _, message := range messages {
+		var packetNFv9 netflow.NFv9Packet
+		var packetIPFIX netflow.IPFIXPacket
+		if err := netflow.DecodeMessageVersion(bytes.NewBuffer(message), templates, &packetNFv9, &packetIPFIX); err != nil {
+			t.Fatalf("([error] Segment Ipfix sent an invalid message: %v", err)
+		}
+		var packet any = &packetIPFIX
+		var flowSets = packetIPFIX.FlowSets
+		if packetNFv9.Version == 9 {
+			packet, flowSets = &packetNFv9, packetNFv9.FlowSets
+		}
+		for _, flowSet := range flowSets {
+			if dataFlowSet, ok := flowSet.(netflow.DataFlowSet); ok {
+				for _, record := range dataFlowSet.Records {
+					fields = append(fields, record.Values...)
+				}
+			}
+		}
+		produced, err := flowProducer.Produce(packet, &producer.ProduceArgs{TimeReceived: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, flow := range produced {
+			flows = append(flows, flow.(*protoproducer.ProtoProducerMessage))
+		}
+	}
+	return flows, fields
+}
+
+// Ipfix Segment test, exports IPv4 and IPv6 flows using IPFIX
+func TestSegment_Ipfix_export(t *testing.T) {
+	start := uint64(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	var flows []*pb.EnrichedFlow
+	for i := range 100 {
+		flow := &pb.EnrichedFlow{
+			TimeFlowStartNs: start,
+			TimeFlowEndNs:   start + uint64(i)*uint64(time.Millisecond),
+			Bytes:           uint64(1500 * i),
+			Packets:         uint64(i),
+			SrcAddr:         net.ParseIP("192.0.2.1").To4(),
+			DstAddr:         net.ParseIP("198.51.100.1").To4(),
+			SrcPort:         uint32(i),
+			DstPort:         443,
+			Proto:           6,
+			SrcAs:           553,
+			Cid:             uint32(i),
+			SrcIfName:       "eth0",
+		}
+		if i%2 == 1 {
+			flow.SrcAddr, flow.DstAddr = net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+		}
+		flows = append(flows, flow)
+	}
+
+	messages := export(t, map[string]string{"fields": "TimeFlowStartNs,TimeFlowEndNs,Bytes,Packets,SrcAddr,DstAddr,SrcPort,DstPort,Proto,SrcIfName", "enterprisefields": "SrcAs,Cid", "enterprise": "64496", "mtu": "512"}, flows)
+	if len(messages) < 3 {
+		t.Fatalf("([error] Segment Ipfix sent %d messages, expected the templates and several messages of at most 512 bytes.", len(messages))
+	}
+	for _, message := range messages {
+		if len(message) > 512 {
+			t.Errorf("([error] Segment Ipfix sent a message of %d bytes.", len(message))
+		}
+	}
+
+	decoded, fields := decode(t, messages)
+	if len(decoded) != len(flows) {
+		t.Fatalf("([error] Segment Ipfix exported %d flows, expected %d.", len(decoded), len(flows))
+	}
+	for i, flow := range decoded {
+		if flow.Bytes != flows[i].Bytes || flow.SrcPort != flows[i].SrcPort || flow.DstPort != 443 || flow.Proto != 6 {
+			t.Errorf("([error] Segment Ipfix exported flow %d incorrectly: %v", i, flow)
+		}
+		if !net.IP(flow.SrcAddr).Equal(flows[i].SrcAddr) || !net.IP(flow.DstAddr).Equal(flows[i].DstAddr) {
+			t.Errorf("([error] Segment Ipfix exported the addresses of flow %d incorrectly: %v", i, flow)
+		}
+		if flow.TimeFlowStartNs != flows[i].TimeFlowStartNs || flow.TimeFlowEndNs != flows[i].TimeFlowEndNs {
+			t.Errorf("([error] Segment Ipfix exported the timestamps of flow %d incorrectly: %v", i, flow)
+		}
+		if flow.SrcAs != 0 {
+			t.Errorf("([error] Segment Ipfix exported SrcAs as a standard element.")
+		}
+	}
+
+	var cids, names int
+	for _, field := range fields {
+		if !field.PenProvided {
+			continue
+		}
+		if field.Pen != 64496 {
+			t.Errorf("([error] Segment Ipfix exported an enterprise-specific element using PEN %d.", field.Pen)
+		}
+		value := field.Value.([]byte)
+		switch field.Type {
+		case 2000: // Cid
+			if len(value) != 4 || value[3] != byte(cids) {
+				t.Errorf("([error] Segment Ipfix exported Cid incorrectly: %v", value)
+			}
+			cids += 1
+		case 2003: // SrcIfName
+			if string(value) != "eth0" {
+				t.Errorf("([error] Segment Ipfix exported SrcIfName incorrectly: %v", value)
+			}
+			names += 1
+		}
+	}
+	if cids != len(flows) || names != len(flows) {
+		t.Errorf("([error] Segment Ipfix exported %d Cids and %d SrcIfNames, expected %d.", cids, names, len(flows))
+	}
+}
+
+// Ipfix Segment test, exports flows using NetFlow v9
+func TestSegment_Ipfix_netflow(t *testing.T) {
+	now := uint64(time.Now().Add(time.Second).Truncate(time.Millisecond).UnixNano())
+	flows := []*pb.EnrichedFlow{
+		{TimeFlowStartNs: now, TimeFlowEndNs: now, Bytes: 1500, SrcAddr: net.ParseIP("192.0.2.1").To4(), SrcAs: 553},
+	}
+	messages := export(t, map[string]string{"version": "9"}, flows)
+	decoded, _ := decode(t, messages)
+	if len(decoded) != 1 || decoded[0].Bytes != 1500 || decoded[0].SrcAs != 553 || !net.IP(decoded[0].SrcAddr).Equal(flows[0].SrcAddr) {
+		t.Fatalf("([error] Segment Ipfix did not export using NetFlow v9 correctly: %v", decoded)
+	}
+	// goflow2 only uses the export time in seconds as base
+	if diff := int64(decoded[0].TimeFlowStartNs) - int64(now); diff < -int64(2*time.Second) || diff > int64(2*time.Second) {
+		t.Errorf("([error] Segment Ipfix exported the timestamps incorrectly, off by %d ns.", diff)
+	}
+
+	if (Ipfix{}).New(map[string]string{"target": "127.0.0.1:2055", "version": "9", "fields": "Cid", "enterprise": "64496"}) != nil {
+		t.Error("([error] Segment Ipfix accepted enterprise-specific elements for NetFlow v9.")
+	}
+}
@@ -11,6 +11,13 @@
 // the archive will get corrupted. Simply use `zstdcat` to decompress the archive and
 // remove the last line (`| head -n -1`).
 //
+// The `filename` may contain strftime-style directives such as
+// `flows-%Y%m%d-%H.json.zst` to start a new file every hour, and the output can
+// be rotated by size or time using `rotatesize` and `rotateinterval`. Rotated
+// files are written as `.tmp` files and renamed once complete, each with its
+// own compressed stream. Old files are deleted according to `retaincount` and
+// `retainage`.
+//
 // If the option `pretty` is set to true, the every flow will be formatted in a
 // human-readable way (indented and with line breaks). When omitted, the output will be
 // a single line per flow.
@@ -18,9 +25,12 @@ package json
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
@@ -31,13 +41,15 @@ import (
 
 type Json struct {
 	segments.BaseTextOutputSegment
-	writer *bufio.Writer
-	Pretty bool // optional, default is false
+	writer  *bufio.Writer
+	encoder *zstd.Encoder // only set if compression is enabled
+	level   zstd.EncoderLevel
+	Pretty  bool // optional, default is false
 }
 
 func (segment Json) New(config map[string]string) segments.Segment {
 	newsegment := &Json{}
-	file, err := segment.GetOutput(config)
+	file, err := newsegment.GetOutput(config)
 	if err != nil {
 		log.Error().Err(err).Msg("Json: File specified in 'filename' is not accessible: ")
 		return nil
@@ -54,14 +66,11 @@ func (segment Json) New(config map[string]string) segments.Segment {
 		} else {
 			level = zstd.EncoderLevelFromZstd(rawLevel)
 		}
-		encoder, err := zstd.NewWriter(file, zstd.WithEncoderLevel(level))
-		if err != nil {
-			log.Fatal().Err(err).Msg("Json: error creating zstd encoder: ")
-		}
-		newsegment.writer = bufio.NewWriter(encoder)
-	} else {
-		// no compression
-		newsegment.writer = bufio.NewWriter(file)
+		newsegment.level = level
+	}
+	if err := newsegment.start(); err != nil {
+		log.Error().Err(err).Msg("Json: error creating zstd encoder: ")
+		return nil
 	}
 	var pretty bool
 	if config["pretty"] != "" {
@@ -80,36 +89,99 @@ func (segment Json) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *Json) Run(wg *sync.WaitGroup) {
+func (segment *Json) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
-		_ = segment.writer.Flush()
-		segment.File.Close()
+		segments.Flush("Json", segment.flush)
 		close(segment.Out)
 		wg.Done()
 	}()
 
 	marshalOptions := protojson.MarshalOptions{Multiline: segment.Pretty}
-	for msg := range segment.In {
-		data, err := marshalOptions.Marshal(msg)
-		if err != nil {
-			log.Warn().Err(err).Msg("Json: Skipping a flow, failed to recode protobuf as JSON: ")
-			continue
-		}
+	ticker := time.NewTicker(segments.RotationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			data, err := marshalOptions.Marshal(msg)
+			if err != nil {
+				log.Warn().Err(err).Msg("Json: Skipping a flow, failed to recode protobuf as JSON: ")
+				continue
+			}
 
-		// use Fprintln because it adds an OS specific newline
-		_, err = fmt.Fprintln(segment.writer, string(data))
-		if err != nil {
-			log.Warn().Err(err).Msgf("Json: Skipping a flow, failed to write to file %s", segment.File.Name())
-			continue
+			// use Fprintln because it adds an OS specific newline
+			_, err = fmt.Fprintln(segment.writer, string(data))
+			if err != nil {
+				log.Warn().Err(err).Msgf("Json: Skipping a flow, failed to write to file %s", segment.File.Name())
+				continue
+			}
+			// we need to flush here every time because we need full lines and can not wait
+			// in case of using this output as in input for other instances consuming flow data
+			_ = segment.writer.Flush()
+			if segment.File.Due() {
+				segment.rotate()
+			}
+			segment.Out <- msg
+		case <-ticker.C:
+			if segment.File.Due() {
+				segment.rotate()
+			}
 		}
-		// we need to flush here every time because we need full lines and can not wait
-		// in case of using this output as in input for other instances consuming flow data
-		_ = segment.writer.Flush()
-		segment.Out <- msg
 	}
 }
 
+// Sets up the writers for the current file, compressing its contents if
+// configured.
+func (segment *Json) start() error {
+	if segment.level == 0 {
+		// no compression
+		segment.writer = bufio.NewWriter(segment.File)
+		return nil
+	}
+	encoder, err := zstd.NewWriter(segment.File, zstd.WithEncoderLevel(segment.level))
+	if err != nil {
+		return err
+	}
+	segment.writer = bufio.NewWriter(encoder)
+	segment.encoder = encoder
+	return nil
+}
+
+// Completes the current file, including its compressed stream, and continues
+// with the next one.
+func (segment *Json) rotate() {
+	err := segment.writer.Flush()
+	if segment.encoder != nil {
+		err = errors.Join(err, segment.encoder.Close())
+	}
+	err = errors.Join(err, segment.File.Rotate())
+	if err != nil {
+		log.Error().Err(err).Msgf("Json: Failed to rotate output to %s: ", segment.File.Name())
+	}
+	if err := segment.start(); err != nil {
+		log.Error().Err(err).Msg("Json: error creating zstd encoder: ")
+	}
+}
+
+// Flushes all buffered output, finishes the compressed stream if compression
+// is enabled, and closes the file.
+func (segment *Json) flush(context.Context) error {
+	err := segment.writer.Flush()
+	if segment.encoder != nil {
+		err = errors.Join(err, segment.encoder.Close())
+	}
+	return errors.Join(err, segment.File.Close())
+}
+
 func init() {
 	segment := &Json{}
-	segments.RegisterSegment("json", segment)
+	segments.RegisterSegment("json", segment,
+		append([]segments.Param{
+			{Name: "filename", Description: "file to write to instead of stdout, may contain strftime-style directives"},
+			{Name: "pretty", Type: segments.Bool, Default: "false", Description: "write indented JSON instead of one flow per line"},
+			{Name: "zstd", Type: segments.Int, Description: "zstd compression level, compression is disabled if unset"},
+		}, segments.RotationParams...)...,
+	)
 }
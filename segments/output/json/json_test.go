@@ -1,12 +1,19 @@
 package json
 
 import (
+	"bytes"
+	"context"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog"
 )
 
@@ -31,7 +38,7 @@ func BenchmarkJson(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{}
@@ -39,3 +46,102 @@ func BenchmarkJson(b *testing.B) {
 	}
 	close(in)
 }
+
+// Json Segment test, rotation and retention of files named by a template
+func TestSegment_Json_rotation(t *testing.T) {
+	dir := t.TempDir()
+	segment := Json{}.New(map[string]string{
+		"filename":       filepath.Join(dir, "%Y", "flows-%Y%m%d.json.zst"),
+		"zstd":           "1",
+		"rotateinterval": "1ns",
+		"retaincount":    "3",
+	})
+	if segment == nil {
+		t.Fatal("([error] Segment Json failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+	for i := range 5 {
+		in <- &pb.EnrichedFlow{Proto: uint32(i)}
+		<-out
+	}
+	close(in)
+	wg.Wait()
+
+	pattern := filepath.Join(dir, time.Now().Format("2006"), "flows-"+time.Now().Format("20060102")+"*")
+	files, _ := filepath.Glob(pattern)
+	if len(files) != 3 {
+		t.Fatalf("([error] Segment Json did not rotate and retain 3 files, wrote %v.", files)
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".json.zst") {
+			t.Errorf("([error] Segment Json left an incomplete file %s.", file)
+			continue
+		}
+		compressed, _ := os.ReadFile(file)
+		decoder, _ := zstd.NewReader(nil)
+		data, err := decoder.DecodeAll(compressed, nil)
+		if err != nil || bytes.Count(data, []byte("\n")) != 1 {
+			t.Errorf("([error] Segment Json did not write a single flow to %s: %s %v", file, data, err)
+		}
+	}
+}
+
+// Json Segment test, files are rotated while no flows arrive and retention
+// only deletes files named after the template
+func TestSegment_Json_idleRotation(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"flows-old.json", "flowsuite.json", "flows.json.bak"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segment := Json{}.New(map[string]string{
+		"filename":       filepath.Join(dir, "flows.json"),
+		"rotateinterval": "10ms",
+		"retaincount":    "1",
+	})
+	if segment == nil {
+		t.Fatal("([error] Segment Json failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+	// each flow is completed without another flow arriving
+	completed := func(previous []string) []string {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			files, _ := filepath.Glob(filepath.Join(dir, "flows*.json"))
+			tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+			if len(files) == 3 && len(tmp) == 1 && !slices.Equal(files, previous) {
+				return files
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("([error] Segment Json did not rotate its file while idle: %v %v", files, tmp)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	in <- &pb.EnrichedFlow{Proto: 6}
+	<-out
+	first := completed(nil)
+	in <- &pb.EnrichedFlow{Proto: 17}
+	<-out
+	second := completed(first)
+	close(in)
+	wg.Wait()
+
+	if !slices.Contains(first, filepath.Join(dir, "flows.json")) || slices.Contains(second, filepath.Join(dir, "flows.json")) {
+		t.Errorf("([error] Segment Json did not retain only the last completed file: %v, then %v", first, second)
+	}
+	for _, name := range []string{"flows-old.json", "flowsuite.json", "flows.json.bak"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("([error] Segment Json deleted %s, which was not named after its template.", name)
+		}
+	}
+}
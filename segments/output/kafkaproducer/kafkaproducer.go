@@ -16,6 +16,7 @@
 package kafkaproducer
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"reflect"
@@ -184,13 +185,24 @@ func (segment KafkaProducer) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *KafkaProducer) Run(wg *sync.WaitGroup) {
+func (segment *KafkaProducer) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
 	}()
 
 	producer, err := sarama.NewAsyncProducer(strings.Split(segment.Server, ","), segment.saramaConfig)
+	if err != nil {
+		log.Error().Err(err).Msg("KafkaProducer: Creating Kafka producer failed. ")
+		segments.ShutdownParentPipeline(ctx)
+		for msg := range segment.In {
+			segment.Out <- msg
+		}
+		return
+	}
+	defer segments.Flush("KafkaProducer", func(context.Context) error {
+		return producer.Close() // flushes all buffered messages
+	})
 
 	for msg := range segment.In {
 		segment.Out <- msg
@@ -234,7 +246,10 @@ func (segment *KafkaProducer) Run(wg *sync.WaitGroup) {
 				suffix = field.Interface().(string)
 			default:
 				log.Error().Msg("KafkaProducer: TopicSuffix must be of type uint or string.")
-				segment.ShutdownParentPipeline()
+				segments.ShutdownParentPipeline(ctx)
+				for msg := range segment.In {
+					segment.Out <- msg
+				}
 				return
 			}
 			producer.Input() <- &sarama.ProducerMessage{
@@ -247,5 +262,15 @@ func (segment *KafkaProducer) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &KafkaProducer{}
-	segments.RegisterSegment("kafkaproducer", segment)
+	segments.RegisterSegment("kafkaproducer", segment,
+		segments.Param{Name: "server", Required: true, Description: "the Kafka broker to connect to, e.g. \"kafka.example.com:9093\""},
+		segments.Param{Name: "topic", Required: true, Description: "the topic to produce to"},
+		segments.Param{Name: "topicsuffix", Description: "name of a flow field whose value is appended to the topic name"},
+		segments.Param{Name: "user", Description: "SASL user name, required if auth is enabled"},
+		segments.Param{Name: "pass", Description: "SASL password, required if auth is enabled"},
+		segments.Param{Name: "tls", Type: segments.Bool, Default: "true", Description: "use TLS to connect to Kafka"},
+		segments.Param{Name: "auth", Type: segments.Bool, Default: "true", Description: "authenticate using SASL"},
+		segments.Param{Name: "legacy", Type: segments.Bool, Default: "false", Description: "write flows in the legacy protobuf format"},
+		segments.Param{Name: "kafka-version", Default: "3.8.0", Description: "the Kafka version to assume for the protocol"},
+	)
 }
@@ -70,6 +70,9 @@
 package lumberjack
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/url"
 	"runtime"
 	"strconv"
@@ -129,10 +132,12 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 	} else {
 		defaultCompression, err = strconv.Atoi(defaultCompressionString)
 		if err != nil {
-			log.Fatal().Err(err).Msgf("Lumberjack: Failed to parse default compression level %s", defaultCompressionString)
+			log.Error().Err(err).Msgf("Lumberjack: Failed to parse default compression level %s", defaultCompressionString)
+			return nil
 		}
 		if defaultCompression < 0 || defaultCompression > 9 {
-			log.Fatal().Msgf("Lumberjack: Default compression level %d is out of range", defaultCompression)
+			log.Error().Msgf("Lumberjack: Default compression level %d is out of range", defaultCompression)
+			return nil
 		}
 	}
 
@@ -142,13 +147,15 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 		rawServerStrings[idx] = strings.TrimSpace(serverName)
 	}
 	if len(rawServerStrings) == 0 {
-		log.Fatal().Msg("Lumberjack: No servers specified in 'servers' config option.")
+		log.Error().Msg("Lumberjack: No servers specified in 'servers' config option.")
+		return nil
 	} else {
 		segment.Servers = make(map[string]ServerOptions)
 		for _, rawServerString := range rawServerStrings {
 			serverURL, err := url.Parse(rawServerString)
 			if err != nil {
-				log.Fatal().Err(err).Msgf("Lumberjack: Failed to parse server URL %s", rawServerString)
+				log.Error().Err(err).Msgf("Lumberjack: Failed to parse server URL %s", rawServerString)
+				return nil
 			}
 			urlQueryParams := serverURL.Query()
 
@@ -165,7 +172,8 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 				useTLS = true
 				verifyTLS = false
 			default:
-				log.Fatal().Msgf("Lumberjack: Unknown scheme %s in server URL %s", serverURL.Scheme, rawServerString)
+				log.Error().Msgf("Lumberjack: Unknown scheme %s in server URL %s", serverURL.Scheme, rawServerString)
+				return nil
 			}
 
 			// parse compression level
@@ -178,10 +186,12 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 			} else {
 				compressionLevel, err = strconv.Atoi(compressionString)
 				if err != nil {
-					log.Fatal().Err(err).Msgf("Lumberjack: Failed to parse compression level %s for host %s", compressionString, serverURL.Host)
+					log.Error().Err(err).Msgf("Lumberjack: Failed to parse compression level %s for host %s", compressionString, serverURL.Host)
+					return nil
 				}
 				if compressionLevel < 0 || compressionLevel > 9 {
-					log.Fatal().Msgf("Lumberjack: Compression level %d out of range for host %s", compressionLevel, serverURL.Host)
+					log.Error().Msgf("Lumberjack: Compression level %d out of range for host %s", compressionLevel, serverURL.Host)
+					return nil
 				}
 			}
 
@@ -194,7 +204,8 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 				numRoutines, err = strconv.Atoi(numRoutinesString)
 				switch {
 				case err != nil:
-					log.Fatal().Err(err).Msgf("Lumberjack: Failed to parse count %s for host %s", numRoutinesString, serverURL.Host)
+					log.Error().Err(err).Msgf("Lumberjack: Failed to parse count %s for host %s", numRoutinesString, serverURL.Host)
+					return nil
 				case numRoutines < 1:
 					log.Warn().Msgf("Lumberjack: count is smaller than 1, setting to 1")
 					numRoutines = 1
@@ -217,7 +228,8 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 	if config["batchsize"] != "" {
 		segment.BatchSize, err = strconv.Atoi(strings.ReplaceAll(config["batchsize"], "_", ""))
 		if err != nil {
-			log.Fatal().Err(err).Msg("Lumberjack: Failed to parse batchsize config option: ")
+			log.Error().Err(err).Msg("Lumberjack: Failed to parse batchsize config option: ")
+			return nil
 		}
 	}
 	if segment.BatchSize < 0 {
@@ -228,7 +240,8 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 	if config["batchtimeout"] != "" {
 		segment.BatchTimeout, err = time.ParseDuration(config["batchtimeout"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Lumberjack: Failed to parse timeout config option: ")
+			log.Error().Err(err).Msg("Lumberjack: Failed to parse timeout config option: ")
+			return nil
 		}
 	}
 
@@ -244,7 +257,8 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 	if config["batchdebug"] != "" {
 		batchDebug, err := strconv.ParseBool(config["batchdebug"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Lumberjack: Failed to parse batchdebug config option: ")
+			log.Error().Err(err).Msg("Lumberjack: Failed to parse batchdebug config option: ")
+			return nil
 		}
 		// set the correct BatchDebugPrintf function
 		if batchDebug {
@@ -259,7 +273,8 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 	if config["reconnectwait"] != "" {
 		segment.ReconnectWait, err = time.ParseDuration(config["reconnectwait"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Lumberjack: Failed to parse reconnectwait config option: ")
+			log.Error().Err(err).Msg("Lumberjack: Failed to parse reconnectwait config option: ")
+			return nil
 		}
 	}
 
@@ -268,7 +283,8 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 	if config["queuestatusinterval"] != "" {
 		segment.QueueStatusInterval, err = time.ParseDuration(config["queuestatusinterval"])
 		if err != nil {
-			log.Fatal().Err(err).Msg("Lumberjack: Failed to parse queuestatussnterval config option: ")
+			log.Error().Err(err).Msg("Lumberjack: Failed to parse queuestatussnterval config option: ")
+			return nil
 		}
 	}
 
@@ -276,7 +292,8 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 	if config["queuesize"] != "" {
 		bufferLength, err = strconv.Atoi(strings.ReplaceAll(config["queuesize"], "_", ""))
 		if err != nil {
-			log.Fatal().Err(err).Msg("Lumberjack: Failed to parse queuesize config option: ")
+			log.Error().Err(err).Msg("Lumberjack: Failed to parse queuesize config option: ")
+			return nil
 		}
 	} else {
 		bufferLength = defaultQueueSize
@@ -290,14 +307,12 @@ func (segment *Lumberjack) New(config map[string]string) segments.Segment {
 	return segment
 }
 
-func (segment *Lumberjack) Run(wg *sync.WaitGroup) {
+func (segment *Lumberjack) Run(ctx context.Context, wg *sync.WaitGroup) {
 	var writerWG sync.WaitGroup
 
 	defer func() {
 		close(segment.Out)
-		writerWG.Wait()
 		wg.Done()
-		log.Info().Msg("Lumberjack: All writer functions have stopped, exiting…")
 	}()
 
 	// print queue status information
@@ -313,10 +328,15 @@ func (segment *Lumberjack) Run(wg *sync.WaitGroup) {
 	}
 
 	// run a goroutine for each lumberjack server
+	writers := 0
+	for _, options := range segment.Servers {
+		writers += options.Parallelism
+	}
+	finalErrs := make(chan error, writers) // errors sending the final batches
 	for server, options := range segment.Servers {
-		writerWG.Add(1)
 		options := options
 		for i := 0; i < options.Parallelism; i++ {
+			writerWG.Add(1)
 			go func(server string, numServer int) {
 				defer writerWG.Done()
 				// connect to lumberjack server
@@ -341,11 +361,10 @@ func (segment *Lumberjack) Run(wg *sync.WaitGroup) {
 							// send local buffer
 							count, err := client.SendNoRetry(flowInterface[:idx])
 							if err != nil {
-								log.Error().Err(err).Msgf("Lumberjack: Failed to send final flow batch upon exit to %s", server)
+								finalErrs <- fmt.Errorf("sending final flow batch to %s: %w", server, err)
 							} else {
 								segment.BatchDebugPrintf("Lumberjack: %s Sent final batch (%d)", server, count)
 							}
-							wg.Done()
 							return
 						}
 
@@ -401,10 +420,28 @@ func (segment *Lumberjack) Run(wg *sync.WaitGroup) {
 		segment.Out <- msg
 	}
 	close(segment.LumberjackOut)
+	segments.Flush("Lumberjack", func(context.Context) error {
+		writerWG.Wait()
+		close(finalErrs)
+		var errs []error
+		for err := range finalErrs {
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	})
 }
 
 // register segment
 func init() {
 	segment := &Lumberjack{}
-	segments.RegisterSegment("lumberjack", segment)
+	segments.RegisterSegment("lumberjack", segment,
+		segments.Param{Name: "servers", Required: true, Description: "comma-separated list of server URLs using the schemes tcp, tls or tlsnoverify, accepting the query parameters compression and count"},
+		segments.Param{Name: "compression", Type: segments.Int, Default: "0", Description: "default compression level between 0 and 9 for all servers"},
+		segments.Param{Name: "batchsize", Default: "64", Description: "number of flows sent at once, may contain underscores"},
+		segments.Param{Name: "batchtimeout", Type: segments.Duration, Default: "5s", Description: "maximum time to wait for a batch to fill up, at most 1m"},
+		segments.Param{Name: "batchdebug", Type: segments.Bool, Default: "false", Description: "log debug messages for each batch"},
+		segments.Param{Name: "reconnectwait", Type: segments.Duration, Default: "1s", Description: "time to wait before reconnecting to a server"},
+		segments.Param{Name: "queuestatusinterval", Type: segments.Duration, Default: "0s", Description: "interval for logging the fill level of the queue, disabled if 0"},
+		segments.Param{Name: "queuesize", Default: "65536", Description: "number of flows queued for sending, may contain underscores"},
+	)
 }
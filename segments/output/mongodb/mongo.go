@@ -63,6 +63,10 @@ func (segment Mongodb) New(configx map[string]string) segments.Segment {
 		return nil
 	}
 
+	if segments.DryRun(configx) {
+		// neither connect nor convert the collection
+		return newsegment
+	}
 	ctx := context.Background()
 
 	//Test if db connection works
@@ -82,29 +86,36 @@ func (segment Mongodb) New(configx map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *Mongodb) Run(wg *sync.WaitGroup) {
-	ctx := context.Background()
+func (segment *Mongodb) Run(ctx context.Context, wg *sync.WaitGroup) {
+	dbCtx := context.Background() // not ctx, as the final batch is written after it was cancelled
 	defer func() {
 		close(segment.Out)
 		wg.Done()
 	}()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(segment.mongodbUri))
+	client, err := mongo.Connect(dbCtx, options.Client().ApplyURI(segment.mongodbUri))
 	if err != nil {
 		log.Panic().Err(err).Msg("MongoDB: Failed to connect to DB") // this has already been checked in New
 	}
 	db := client.Database(segment.databaseName)
 	segment.dbCollection = db.Collection(segment.collectionName)
 
-	defer client.Disconnect(ctx)
+	defer client.Disconnect(dbCtx)
 	unsavedJson := make(chan []interface{})
 	messagesToSave := make(chan *pb.EnrichedFlow)
-	go segment.bulkInsert(ctx, unsavedJson)
+	inserted := make(chan error, 1)
+	go func() {
+		inserted <- segment.bulkInsert(dbCtx, unsavedJson)
+	}()
 	go segment.prepareDataForBulkInsert(messagesToSave, unsavedJson)
 	for msg := range segment.In {
 		messagesToSave <- msg
 		segment.Out <- msg
 	}
+	close(messagesToSave)
+	segments.Flush("MongoDB", func(context.Context) error {
+		return <-inserted
+	})
 }
 
 func fillSegmentWithConfig(newsegment *Mongodb, config map[string]string) (*Mongodb, error) {
@@ -197,22 +208,30 @@ func (segment Mongodb) prepareDataForBulkInsert(msgChan chan *pb.EnrichedFlow, u
 		nrOfFlows += 1
 		if nrOfFlows >= segment.BatchSize {
 			unsavedJsonFlows <- unsavedFlowData
+			unsavedFlowData = make([]interface{}, segment.BatchSize)
 			nrOfFlows = 0
 		}
 	}
-
+	if nrOfFlows > 0 {
+		unsavedJsonFlows <- unsavedFlowData[:nrOfFlows]
+	}
+	close(unsavedJsonFlows)
 }
 
-func (segment Mongodb) bulkInsert(ctx context.Context, unsavedJsonFlows chan []interface{}) {
+// Inserts all batches until unsavedJsonFlows is closed and returns the outcome
+// of the last insert, i.e. the one done on shutdown.
+func (segment Mongodb) bulkInsert(ctx context.Context, unsavedJsonFlows chan []interface{}) error {
 	// not using transactions due to limitations of capped collectiction
 	// ("You cannot write to capped collections in transactions."
 	// https://www.mongodb.com/docs/manual/core/capped-collections/)
+	var err error
 	for unsavedFlows := range unsavedJsonFlows {
-		_, err := segment.dbCollection.InsertMany(ctx, unsavedFlows)
+		_, err = segment.dbCollection.InsertMany(ctx, unsavedFlows)
 		if err != nil {
 			log.Error().Err(err).Msg("MongoDB: Failed to insert to mongo db")
 		}
 	}
+	return err
 }
 
 func formatFlowToMongoDbJson(msg *pb.EnrichedFlow, segment Mongodb) bson.M {
@@ -238,7 +257,14 @@ func formatFlowToMongoDbJson(msg *pb.EnrichedFlow, segment Mongodb) bson.M {
 
 func init() {
 	segment := &Mongodb{}
-	segments.RegisterSegment("mongodb", segment)
+	segments.RegisterSegment("mongodb", segment,
+		segments.Param{Name: "mongodb_uri", Required: true, Description: "connection string of the MongoDB server"},
+		segments.Param{Name: "database", Default: "flowdata", Description: "the database to write to"},
+		segments.Param{Name: "collection", Default: "ringbuffer", Description: "the collection to write to, it is converted to a capped collection"},
+		segments.Param{Name: "fields", Description: "comma-separated list of fields to export, all fields if unset"},
+		segments.Param{Name: "batchsize", Type: segments.Int, Default: "1000", Description: "number of flows inserted at once"},
+		segments.Param{Name: "max_disk_usage", Default: "10 GB", Description: "size of the capped collection, e.g. \"500 MB\""},
+	)
 }
 
 func sizeInBytes(sizeStr string) (int64, error) {
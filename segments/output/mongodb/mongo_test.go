@@ -4,13 +4,14 @@
 package mongodb
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
 
 	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
 	"github.com/rs/zerolog"
-	// "github.com/BelWue/flowpipeline/segments"
 )
 
 // Mongodb Segment test, passthrough test only
@@ -30,7 +31,7 @@ func TestSegment_Mongodb_passthrough(t *testing.T) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 	in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 1}, DstAddr: []byte{192, 168, 88, 1}, Proto: 1}
 	<-out
 	in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 2}, DstAddr: []byte{192, 168, 88, 2}, Proto: 2}
@@ -39,6 +40,14 @@ func TestSegment_Mongodb_passthrough(t *testing.T) {
 	wg.Wait()
 }
 
+// Mongodb Segment test, does not connect when validating a config
+func TestSegment_Mongodb_dryRun(t *testing.T) {
+	segment := Mongodb{}.New(segments.WithDryRun(map[string]string{"mongodb_uri": "mongodb://127.0.0.1:1/", "database": "testing"}))
+	if segment == nil {
+		t.Error("([error] Segment Mongodb tried to connect in dry run mode.")
+	}
+}
+
 // Mongodb Segment benchmark with 1000 samples stored in memory
 func BenchmarkMongodb_1000(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
@@ -54,7 +63,7 @@ func BenchmarkMongodb_1000(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, Proto: 45}
@@ -78,7 +87,7 @@ func BenchmarkMongodb_10000(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, Proto: 45}
@@ -102,7 +111,7 @@ func BenchmarkMongodb_100000(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, Proto: 45}
@@ -130,7 +139,7 @@ func BenchmarkMongodb_100000_with_storage_limit(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, Proto: 45}
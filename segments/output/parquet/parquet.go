@@ -0,0 +1,248 @@
+// The `parquet` segment writes flows to [Apache Parquet](https://parquet.apache.org/)
+// files, which can be queried directly using tools such as DuckDB or Spark.
+// The `filename` parameter is required. Just like with the `csv` segment, the
+// `fields` parameter can be used to limit which fields will be exported, all
+// fields are exported by default.
+//
+// Integer fields are written as unsigned integers and addresses as strings,
+// while repeated fields such as `AsPath` are written as comma-separated
+// strings. Flows are buffered in memory and written in row groups of about
+// `rowgroupsize` bytes, compressed using the codec given by `compression`.
+// As buffered flows do not count towards `rotatesize`, row groups are limited
+// to that size as well.
+//
+// A Parquet file is only readable once it has been completed. Thus, the
+// output is usually rolled over using `rotateinterval` or a `filename` such as
+// `flows-%Y%m%d-%H.parquet`, just like the output of the `json` segment,
+// which also writes files as `.tmp` files until they are complete.
+package parquet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/rs/zerolog/log"
+	parquetformat "github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+type Parquet struct {
+	segments.BaseTextOutputSegment
+	writer  *writer.CSVWriter
+	columns []column
+
+	Fields       string // optional comma-separated list of fields to export, default is "", meaning all fields
+	RowGroupSize int64  // optional, default is 128MB
+	Compression  string // optional, default is "snappy", one of "none", "snappy", "gzip" or "zstd"
+}
+
+var codecs = map[string]parquetformat.CompressionCodec{
+	"none":   parquetformat.CompressionCodec_UNCOMPRESSED,
+	"snappy": parquetformat.CompressionCodec_SNAPPY,
+	"gzip":   parquetformat.CompressionCodec_GZIP,
+	"zstd":   parquetformat.CompressionCodec_ZSTD,
+}
+
+// A field of EnrichedFlow written to a Parquet column.
+type column struct {
+	schema string // the column's schema in the metadata format of parquet-go
+	index  int
+	value  func(reflect.Value) any
+}
+
+func (segment Parquet) New(config map[string]string) segments.Segment {
+	newsegment := &Parquet{
+		Fields:       config["fields"],
+		Compression:  config["compression"],
+		RowGroupSize: 128 * humanize.MiByte,
+	}
+
+	if config["filename"] == "" {
+		log.Error().Msg("Parquet: Parameter 'filename' is required.")
+		return nil
+	}
+	if config["rowgroupsize"] != "" {
+		size, err := humanize.ParseBytes(config["rowgroupsize"])
+		if err != nil {
+			log.Error().Err(err).Msg("Parquet: Could not parse 'rowgroupsize' parameter: ")
+			return nil
+		}
+		newsegment.RowGroupSize = int64(size)
+	}
+	if newsegment.Compression == "" {
+		newsegment.Compression = "snappy"
+	}
+	if _, ok := codecs[newsegment.Compression]; !ok {
+		log.Error().Msgf("Parquet: Unknown compression codec '%s'.", newsegment.Compression)
+		return nil
+	}
+
+	protofields := reflect.TypeOf(pb.EnrichedFlow{})
+	var fieldNames []string
+	if newsegment.Fields != "" {
+		for _, field := range strings.Split(newsegment.Fields, ",") {
+			fieldNames = append(fieldNames, strings.TrimSpace(field))
+		}
+	} else {
+		for i := 0; i < protofields.NumField(); i++ {
+			if field := protofields.Field(i); field.IsExported() {
+				fieldNames = append(fieldNames, field.Name)
+			}
+		}
+	}
+	for _, name := range fieldNames {
+		field, found := protofields.FieldByName(name)
+		if !found || !field.IsExported() {
+			log.Error().Msgf("Parquet: Field '%s' specified in 'fields' does not exist.", name)
+			return nil
+		}
+		newsegment.columns = append(newsegment.columns, newColumn(field))
+	}
+
+	file, err := newsegment.GetOutput(config)
+	if err != nil {
+		log.Error().Err(err).Msg("Parquet: File specified in 'filename' is not accessible: ")
+		return nil
+	}
+	if file.RotateSize > 0 && uint64(newsegment.RowGroupSize) > file.RotateSize {
+		newsegment.RowGroupSize = int64(file.RotateSize)
+	}
+	if segments.DryRun(config) {
+		return newsegment
+	}
+	if err := newsegment.start(); err != nil {
+		log.Error().Err(err).Msg("Parquet: Failed to write to destination: ")
+		return nil
+	}
+	log.Info().Msgf("Parquet: configured output to %s", file.Name())
+	return newsegment
+}
+
+// Returns the column for a field, choosing the Parquet type matching its Go
+// type.
+func newColumn(field reflect.StructField) column {
+	c := column{index: field.Index[0]}
+	switch field.Type.Kind() {
+	case reflect.Uint32:
+		c.schema = "type=INT32, convertedtype=UINT_32"
+		c.value = func(v reflect.Value) any { return int32(v.Uint()) }
+	case reflect.Uint64:
+		c.schema = "type=INT64, convertedtype=UINT_64"
+		c.value = func(v reflect.Value) any { return int64(v.Uint()) }
+	case reflect.Bool:
+		c.schema = "type=BOOLEAN"
+		c.value = func(v reflect.Value) any { return v.Bool() }
+	default:
+		c.schema = "type=BYTE_ARRAY, convertedtype=UTF8"
+		c.value = func(v reflect.Value) any { return formatValue(v) }
+	}
+	c.schema = fmt.Sprintf("name=%s, %s, repetitiontype=REQUIRED", field.Name, c.schema)
+	return c
+}
+
+// Formats addresses, enums and repeated fields as strings.
+func formatValue(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case string:
+		return value
+	case []byte:
+		if len(value) == 0 {
+			return ""
+		}
+		return net.IP(value).String()
+	case fmt.Stringer:
+		return value.String()
+	}
+	if v.Kind() == reflect.Slice {
+		elements := make([]string, v.Len())
+		for i := range elements {
+			elements[i] = formatValue(v.Index(i))
+		}
+		return strings.Join(elements, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func (segment *Parquet) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		segments.Flush("Parquet", func(context.Context) error {
+			return errors.Join(segment.writer.WriteStop(), segment.File.Close())
+		})
+		close(segment.Out)
+		wg.Done()
+	}()
+	ticker := time.NewTicker(segments.RotationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			values := reflect.ValueOf(msg).Elem()
+			record := make([]any, len(segment.columns))
+			for i, c := range segment.columns {
+				record[i] = c.value(values.Field(c.index))
+			}
+			if err := segment.writer.Write(record); err != nil {
+				log.Warn().Err(err).Msgf("Parquet: Skipping a flow, failed to write to %s: ", segment.File.Name())
+			}
+			if segment.File.Due() {
+				segment.rotate()
+			}
+			segment.Out <- msg
+		case <-ticker.C:
+			if segment.File.Due() {
+				segment.rotate()
+			}
+		}
+	}
+}
+
+// Completes the current file and continues with the next one.
+func (segment *Parquet) rotate() {
+	err := errors.Join(segment.writer.WriteStop(), segment.File.Rotate(), segment.start())
+	if err != nil {
+		log.Error().Err(err).Msgf("Parquet: Failed to rotate output to %s: ", segment.File.Name())
+	}
+}
+
+// Sets up the writer for the current file, which writes the schema once it
+// is completed by WriteStop.
+func (segment *Parquet) start() error {
+	schema := make([]string, len(segment.columns))
+	for i, c := range segment.columns {
+		schema[i] = c.schema
+	}
+	w, err := writer.NewCSVWriterFromWriter(schema, segment.File, 1)
+	if w != nil {
+		createdBy := "flowpipeline"
+		w.Footer.CreatedBy = &createdBy
+		w.RowGroupSize = segment.RowGroupSize
+		w.CompressionType = codecs[segment.Compression]
+		segment.writer = w
+	}
+	return err
+}
+
+func init() {
+	segment := &Parquet{}
+	segments.RegisterSegment("parquet", segment,
+		append([]segments.Param{
+			{Name: "filename", Required: true, Description: "file to write to, may contain strftime-style directives"},
+			{Name: "fields", Description: "comma-separated list of fields to export, all fields if unset"},
+			{Name: "rowgroupsize", Default: "128MiB", Description: "approximate size of the row groups buffered in memory, at most rotatesize"},
+			{Name: "compression", Default: "snappy", Description: "compression codec, one of none, snappy, gzip or zstd"},
+		}, segments.RotationParams...)...,
+	)
+}
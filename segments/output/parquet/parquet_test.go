@@ -0,0 +1,213 @@
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+	"github.com/rs/zerolog"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	parquetreader "github.com/xitongsys/parquet-go/reader"
+)
+
+// Parquet Segment test, passthrough test
+func TestSegment_Parquet_passthrough(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.parquet")
+	result := segments.TestSegment("parquet", map[string]string{"filename": filename},
+		&pb.EnrichedFlow{Type: 3, SamplerAddress: net.ParseIP("192.0.2.1")})
+
+	if result.Type != 3 {
+		t.Error("([error] Segment Parquet is not working.")
+	}
+}
+
+// Runs the segment on the flows and returns the name of the written file.
+func write(t *testing.T, config map[string]string, flows []*pb.EnrichedFlow) string {
+	config["filename"] = filepath.Join(t.TempDir(), "flows.parquet")
+	segment := Parquet{}.New(config)
+	if segment == nil {
+		t.Fatal("([error] Segment Parquet failed to initialize.")
+	}
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+	for _, flow := range flows {
+		in <- flow
+		<-out
+	}
+	close(in)
+	wg.Wait()
+	return config["filename"]
+}
+
+// Parquet Segment test, checks the structure of an uncompressed file
+func TestSegment_Parquet_format(t *testing.T) {
+	filename := write(t, map[string]string{"fields": "Bytes,SrcAddr,AsPath", "compression": "none"}, []*pb.EnrichedFlow{
+		{Bytes: 1500, SrcAddr: net.ParseIP("192.0.2.1"), AsPath: []uint32{553, 64496}},
+		{Bytes: 40, SrcAddr: net.ParseIP("2001:db8::1")},
+	})
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("([error] Segment Parquet did not write the magic bytes.")
+	}
+	footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footer <= 0 || footer > len(data)-12 {
+		t.Fatalf("([error] Segment Parquet wrote an invalid footer length %d.", footer)
+	}
+	metadata := data[len(data)-8-footer : len(data)-8]
+	for _, name := range []string{"Bytes", "SrcAddr", "AsPath", "flowpipeline"} {
+		if !bytes.Contains(metadata, []byte(name)) {
+			t.Errorf("([error] Segment Parquet did not write %s to the metadata.", name)
+		}
+	}
+
+	values := binary.LittleEndian.AppendUint64(nil, 1500)
+	values = binary.LittleEndian.AppendUint64(values, 40)
+	if !bytes.Contains(data, values) {
+		t.Error("([error] Segment Parquet did not write the values of Bytes.")
+	}
+	values = binary.LittleEndian.AppendUint32(nil, 9)
+	values = append(values, "192.0.2.1"...)
+	values = binary.LittleEndian.AppendUint32(values, 11)
+	values = append(values, "2001:db8::1"...)
+	if !bytes.Contains(data, values) {
+		t.Error("([error] Segment Parquet did not write the values of SrcAddr.")
+	}
+	if !bytes.Contains(data, []byte("553,64496")) {
+		t.Error("([error] Segment Parquet did not write the values of AsPath.")
+	}
+}
+
+// Parquet Segment test, reads back the files written using each codec and
+// several row groups
+func TestSegment_Parquet_roundTrip(t *testing.T) {
+	var flows []*pb.EnrichedFlow
+	for i := range 10000 {
+		flows = append(flows, &pb.EnrichedFlow{
+			Bytes:   uint64(i) << 40,
+			Proto:   uint32(i % 256),
+			SrcAddr: net.IPv4(192, 0, 2, byte(i)),
+			AsPath:  []uint32{553, uint32(i)},
+			HasMpls: i%2 == 0,
+		})
+	}
+	for compression := range codecs {
+		filename := write(t, map[string]string{
+			"fields":       "Bytes,Proto,SrcAddr,AsPath,HasMpls",
+			"compression":  compression,
+			"rowgroupsize": "4KiB",
+		}, flows)
+
+		file, err := local.NewLocalFileReader(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		reader, err := parquetreader.NewParquetColumnReader(file, 1)
+		if err != nil {
+			t.Fatalf("([error] Segment Parquet wrote an unreadable %s file: %v", compression, err)
+		}
+		if rowGroups := len(reader.Footer.RowGroups); rowGroups < 2 {
+			t.Errorf("([error] Segment Parquet wrote %d row groups, expected several.", rowGroups)
+		}
+		if rows := reader.GetNumRows(); rows != int64(len(flows)) {
+			t.Fatalf("([error] Segment Parquet wrote %d rows to the %s file, expected %d.", rows, compression, len(flows))
+		}
+		columns := map[string][]any{}
+		for _, name := range []string{"Bytes", "Proto", "SrcAddr", "AsPath", "HasMpls"} {
+			columns[name], _, _, err = reader.ReadColumnByPath(common.ReformPathStr(reader.SchemaHandler.GetRootInName()+"."+name), int64(len(flows)))
+			if err != nil || len(columns[name]) != len(flows) {
+				t.Fatalf("([error] Segment Parquet wrote an unreadable %s column to the %s file: %v", name, compression, err)
+			}
+		}
+		for i, flow := range flows {
+			if columns["Bytes"][i] != int64(flow.Bytes) || columns["Proto"][i] != int32(flow.Proto) ||
+				columns["SrcAddr"][i] != flow.SrcAddrObj().String() || columns["AsPath"][i] != fmt.Sprintf("553,%d", i) ||
+				columns["HasMpls"][i] != flow.HasMpls {
+				t.Fatalf("([error] Segment Parquet wrote row %d to the %s file incorrectly: %v %v %v %v %v", i, compression,
+					columns["Bytes"][i], columns["Proto"][i], columns["SrcAddr"][i], columns["AsPath"][i], columns["HasMpls"][i])
+			}
+		}
+		reader.ReadStop()
+	}
+}
+
+// Parquet Segment test, files are rotated while no flows arrive and once
+// their row groups reach rotatesize
+func TestSegment_Parquet_rotation(t *testing.T) {
+	for _, config := range []map[string]string{
+		{"rotateinterval": "10ms"},
+		{"rotatesize": "4KiB"},
+	} {
+		dir := t.TempDir()
+		config["filename"] = filepath.Join(dir, "flows.parquet")
+		config["fields"] = "Bytes,SrcAddr"
+		segment := Parquet{}.New(config)
+		if segment == nil {
+			t.Fatal("([error] Segment Parquet failed to initialize.")
+		}
+		in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+		segment.Rewire(in, out)
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go segment.Run(context.Background(), wg)
+		flows := 1
+		if config["rotatesize"] != "" {
+			flows = 10000
+		}
+		for i := range flows {
+			in <- &pb.EnrichedFlow{Bytes: uint64(i), SrcAddr: net.IPv4(192, 0, 2, byte(i))}
+			<-out
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			files, _ := filepath.Glob(filepath.Join(dir, "*.parquet"))
+			if len(files) > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("([error] Segment Parquet did not rotate its file with %v.", config)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(in)
+		wg.Wait()
+	}
+}
+
+// Parquet Segment benchmark passthrough
+func BenchmarkParquet(b *testing.B) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	segment := Parquet{}.New(map[string]string{"filename": filepath.Join(b.TempDir(), "flows.parquet")})
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+
+	for n := 0; n < b.N; n++ {
+		in <- &pb.EnrichedFlow{Proto: 45}
+		<-out
+	}
+	close(in)
+}
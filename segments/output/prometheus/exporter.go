@@ -22,6 +22,7 @@ type Exporter struct {
 	flowAsPathBytes   *prometheus.CounterVec
 
 	labels []string
+	server *http.Server
 }
 
 // Initialize Prometheus Exporter
@@ -95,15 +96,23 @@ func (e *Exporter) ServeEndpoints(segment *Prometheus) {
 			</body>
 		</html>`))
 	})
+	e.server = &http.Server{Addr: segment.Endpoint, Handler: mux}
 	go func() {
-		err := http.ListenAndServe(segment.Endpoint, mux)
-		if err != nil {
+		err := e.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			log.Error().Err(err).Msgf("Prometheus Exporter: Failed to start https endpoint on port %s", segment.Endpoint)
 		}
 	}()
 	log.Info().Msgf("Prometheus Exporter: Enabled metrics on %s and %s, listening at %s.", segment.MetricsPath, segment.FlowdataPath, segment.Endpoint)
 }
 
+// stop serving the endpoints, releasing the address for a new instance
+func (e *Exporter) Shutdown() {
+	if e.server != nil {
+		e.server.Close()
+	}
+}
+
 func (e *Exporter) Increment(bytes uint64, packets uint64, labelset prometheus.Labels) {
 	e.kafkaMessageCount.Inc()
 	// e.flowNumber.With(labels).Inc()
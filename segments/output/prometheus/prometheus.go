@@ -2,6 +2,10 @@
 // own monitoring info at `:8080/metrics` and its flow data at `:8080/flowdata` by
 // default. The label set included with each metric is freely configurable with a
 // comma-separated list from any field available in the [protobuf definition](https://github.com/BelWue/flowpipeline/blob/master/pb/flow.proto).
+// Entries of map fields are selected as `<field>.<key>`, such as `SrcLabels.zone`
+// or `labels.site`, and exported as a label named `SrcLabels_zone` or `labels_site`.
+// Any characters of keys not allowed in Prometheus label names are replaced by
+// underscores too, e.g. `SrcLabels.security-zone` results in `SrcLabels_security_zone`.
 //
 // Note that some of the above fields might not be present depending on the method
 // of flow export, the input segment used in this pipeline, or the modify segments
@@ -9,14 +13,14 @@
 package prometheus
 
 import (
+	"context"
 	"fmt"
-	"net"
-	"reflect"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/prometheus/common/model"
 	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/pb"
@@ -29,12 +33,14 @@ type Prometheus struct {
 	Endpoint          string         // optional, default value is ":8080"
 	MetricsPath       string         // optional, default is "/metrics"
 	FlowdataPath      string         // optional, default is "/flowdata"
-	Labels            []string       // optional, list of labels to be exported
+	Labels            []string       // optional, list of labels to be exported, named after their fields
 	VacuumInterval    *time.Duration // optional, intervall in which counters should be reset (can lead to dataloss)
 	ExportASPathPairs bool           // optional, if true, as path pairs will be exported
 	ExportASPaths     bool           // optional, if true, as paths will be exported
 
 	PromExporter *Exporter
+
+	fields []segments.FlowField // the fields of the labels
 }
 
 func (segment Prometheus) New(config map[string]string) segments.Segment {
@@ -99,20 +105,43 @@ func (segment Prometheus) New(config map[string]string) segments.Segment {
 	} else {
 		labels = strings.Split(config["labels"], ",")
 	}
-	protofields := reflect.TypeOf(pb.EnrichedFlow{})
-	for _, field := range labels {
-		field = strings.TrimSpace(field)
-		_, found := protofields.FieldByName(field)
-		if !found {
-			log.Error().Msgf("Prometheus: Field '%s' specified in 'labels' does not exist.", field)
+	for _, name := range labels {
+		field, err := segments.ParseFlowField(name)
+		if err != nil {
+			log.Error().Err(err).Msgf("Prometheus: Field '%s' specified in 'labels' is invalid: ", name)
+			return nil
+		}
+		if field.IsMap() {
+			log.Error().Msgf("Prometheus: Field '%s' specified in 'labels' is a map, select one of its entries as '%s.<key>'.", field.Name, field.Name)
+			return nil
+		}
+		label := labelName(field)
+		if !model.LabelName(label).IsValidLegacy() {
+			log.Error().Msgf("Prometheus: Field '%s' specified in 'labels' can not be used as a label named '%s'.", name, label)
 			return nil
 		}
-		newsegment.Labels = append(newsegment.Labels, field)
+		if slices.Contains(newsegment.Labels, label) {
+			log.Error().Msgf("Prometheus: Field '%s' specified in 'labels' results in the duplicate label '%s'.", name, label)
+			return nil
+		}
+		newsegment.Labels = append(newsegment.Labels, label)
+		newsegment.fields = append(newsegment.fields, field)
 	}
 	return newsegment
 }
 
-func (segment *Prometheus) Run(wg *sync.WaitGroup) {
+// Returns the name of the label of a field, with any characters not allowed in
+// Prometheus label names replaced by underscores.
+func labelName(field segments.FlowField) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, field.LabelName())
+}
+
+func (segment *Prometheus) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -126,25 +155,8 @@ func (segment *Prometheus) Run(wg *sync.WaitGroup) {
 
 	for msg := range segment.In {
 		labelset := make(map[string]string)
-		values := reflect.ValueOf(msg).Elem()
-		for _, fieldname := range segment.Labels {
-			value := values.FieldByName(fieldname).Interface()
-			switch value.(type) {
-			case []uint8: // this is necessary for proper formatting
-				ipstring := net.IP(value.([]uint8)).String()
-				if ipstring == "<nil>" {
-					ipstring = ""
-				}
-				labelset[fieldname] = ipstring
-			case uint32: // this is because FormatUint is much faster than Sprint
-				labelset[fieldname] = strconv.FormatUint(uint64(value.(uint32)), 10)
-			case uint64: // this is because FormatUint is much faster than Sprint
-				labelset[fieldname] = strconv.FormatUint(uint64(value.(uint64)), 10)
-			case string: // this is because doing nothing is also much faster than Sprint
-				labelset[fieldname] = value.(string)
-			default:
-				labelset[fieldname] = fmt.Sprint(value)
-			}
+		for i, field := range segment.fields {
+			labelset[segment.Labels[i]] = field.Format(msg)
 		}
 		segment.PromExporter.Increment(msg.Bytes, msg.Packets, labelset)
 
@@ -157,6 +169,7 @@ func (segment *Prometheus) Run(wg *sync.WaitGroup) {
 		}
 		segment.Out <- msg
 	}
+	segment.PromExporter.Shutdown()
 }
 
 func (segment *Prometheus) initializeExporter(exporter *Exporter) {
@@ -232,5 +245,13 @@ func DedupConsecutiveASNs(asPath []uint32) []uint32 {
 
 func init() {
 	segment := &Prometheus{}
-	segments.RegisterSegment("prometheus", segment)
+	segments.RegisterSegment("prometheus", segment,
+		segments.Param{Name: "endpoint", Default: ":8080", Description: "address to serve the metrics on"},
+		segments.Param{Name: "metricspath", Default: "/metrics", Description: "URL path of the flow metrics"},
+		segments.Param{Name: "flowdatapath", Default: "/flowdata", Description: "URL path of the flow data metrics"},
+		segments.Param{Name: "labels", Default: "Etype,Proto", Description: "comma-separated list of flow fields to be exported as labels"},
+		segments.Param{Name: "vacuum_interval", Type: segments.Duration, Description: "interval in which all counters are reset, which may lose data of up to one scrape interval"},
+		segments.Param{Name: "export_as_pairs", Type: segments.Bool, Default: "false", Description: "export AS path pairs"},
+		segments.Param{Name: "export_as_paths", Type: segments.Bool, Default: "false", Description: "export AS paths"},
+	)
 }
@@ -15,3 +15,30 @@ func TestSegment_PrometheusExporter_passthrough(t *testing.T) {
 		t.Error("([error] Segment Prometheus is not passing through flows.")
 	}
 }
+
+// Prometheus Segment test, labels of map entries
+func TestSegment_PrometheusExporter_mapLabels(t *testing.T) {
+	segment := Prometheus{}.New(map[string]string{"labels": "Proto,SrcLabels.zone"})
+	if segment == nil {
+		t.Fatal("([error] Segment Prometheus did not accept the entry of a map as a label.")
+	}
+	if labels := segment.(*Prometheus).Labels; len(labels) != 2 || labels[1] != "SrcLabels_zone" {
+		t.Errorf("([error] Segment Prometheus did not name the label of a map entry correctly: %v", labels)
+	}
+	segment = Prometheus{}.New(map[string]string{"labels": "SrcLabels.security-zone,DstLabels.zone"})
+	if segment == nil {
+		t.Fatal("([error] Segment Prometheus did not accept a map key which is not a valid label name.")
+	}
+	if labels := segment.(*Prometheus).Labels; labels[0] != "SrcLabels_security_zone" {
+		t.Errorf("([error] Segment Prometheus did not replace invalid characters of a label: %v", labels)
+	}
+	if (Prometheus{}).New(map[string]string{"labels": "SrcLabels.a-b,SrcLabels.a_b"}) != nil {
+		t.Error("([error] Segment Prometheus accepted two fields resulting in the same label.")
+	}
+	if (Prometheus{}).New(map[string]string{"labels": "SrcLabels"}) != nil {
+		t.Error("([error] Segment Prometheus accepted a map without a key as a label.")
+	}
+	if (Prometheus{}).New(map[string]string{"labels": "Proto.zone"}) != nil {
+		t.Error("([error] Segment Prometheus accepted a key of a field which is not a map.")
+	}
+}
@@ -0,0 +1,162 @@
+// The `protobuf` segment writes flows as length-delimited binary protobuf
+// records, which is much faster and more compact than the `json` segment. It
+// uses stdout by default, but can be instructed to write to file using the
+// `filename` parameter. The output is read by the `protobufreader` segment,
+// making this pair a drop-in replacement for piping flows between instances
+// of flowpipeline using `json` and `stdin`.
+//
+// Every file starts with a header recording the schema version of the
+// flows, see Magic and SchemaVersion. If the option `zstd` is set, the output
+// will be compressed using the [zstandard algorithm](https://facebook.github.io/zstd/)
+// with the given compression level.
+//
+// The output can be rotated just like the output of the `json` segment, with
+// each file starting with its own header and compressed stream. All files of
+// a rotated output, for instance `flows-%Y%m%d-%H.pb.zst`, can be read by
+// passing `flows-*.pb.zst` to the `protobufreader` segment.
+package protobuf
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Every output starts with these bytes, followed by the SchemaVersion encoded
+// as an unsigned varint.
+var Magic = []byte("FPPB")
+
+// The version of the EnrichedFlow schema written by this segment. It is only
+// increased for changes breaking the compatibility of protobuf, such as
+// reusing field numbers.
+const SchemaVersion = 1
+
+// Output buffers are flushed at least this often, so readers do not starve
+// on slow pipelines. Rotation is checked just as often.
+const flushInterval = time.Second
+
+type Protobuf struct {
+	segments.BaseTextOutputSegment
+	Zstd     int  // optional, zstd compression level, only used if Compress is set
+	Compress bool // optional, default is false
+
+	encoder *zstd.Encoder // only set if compression is enabled
+	writer  *bufio.Writer
+}
+
+func (segment Protobuf) New(config map[string]string) segments.Segment {
+	newsegment := &Protobuf{}
+	if config["zstd"] != "" {
+		level, err := strconv.Atoi(config["zstd"])
+		if err != nil {
+			log.Error().Err(err).Msg("Protobuf: Could not parse 'zstd' parameter: ")
+			return nil
+		}
+		newsegment.Zstd, newsegment.Compress = level, true
+	}
+
+	file, err := newsegment.GetOutput(config)
+	if err != nil {
+		log.Error().Err(err).Msg("Protobuf: File specified in 'filename' is not accessible: ")
+		return nil
+	}
+	if err := newsegment.start(); err != nil {
+		log.Error().Err(err).Msgf("Protobuf: Failed to write to %s: ", file.Name())
+		return nil
+	}
+	log.Info().Msgf("Protobuf: configured output to %s", file.Name())
+	return newsegment
+}
+
+func (segment *Protobuf) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		segments.Flush("Protobuf", segment.flush)
+		close(segment.Out)
+		wg.Done()
+	}()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			if _, err := protodelim.MarshalTo(segment.writer, msg); err != nil {
+				log.Warn().Err(err).Msgf("Protobuf: Skipping a flow, failed to write to %s: ", segment.File.Name())
+			}
+			if segment.File.Due() {
+				segment.rotate()
+			}
+			segment.Out <- msg
+		case <-ticker.C:
+			if segment.File.Due() {
+				segment.rotate()
+			} else if err := segment.writer.Flush(); err != nil {
+				log.Warn().Err(err).Msgf("Protobuf: Failed to write to %s: ", segment.File.Name())
+			}
+		}
+	}
+}
+
+// Sets up the writers for the current file and writes the header.
+func (segment *Protobuf) start() error {
+	var output io.Writer = segment.File
+	segment.encoder = nil
+	if segment.Compress {
+		encoder, err := zstd.NewWriter(output, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(segment.Zstd)))
+		if err != nil {
+			return err
+		}
+		segment.encoder = encoder
+		output = encoder
+	}
+	segment.writer = bufio.NewWriter(output)
+	header := binary.AppendUvarint(append([]byte{}, Magic...), SchemaVersion)
+	_, err := segment.writer.Write(header)
+	return err
+}
+
+// Completes the current file and continues with the next one.
+func (segment *Protobuf) rotate() {
+	err := errors.Join(segment.finish(), segment.File.Rotate(), segment.start())
+	if err != nil {
+		log.Error().Err(err).Msgf("Protobuf: Failed to rotate output to %s: ", segment.File.Name())
+	}
+}
+
+// Flushes all buffered output and finishes the compressed stream if
+// compression is enabled.
+func (segment *Protobuf) finish() error {
+	err := segment.writer.Flush()
+	if segment.encoder != nil {
+		err = errors.Join(err, segment.encoder.Close())
+	}
+	return err
+}
+
+func (segment *Protobuf) flush(context.Context) error {
+	return errors.Join(segment.finish(), segment.File.Close())
+}
+
+func init() {
+	segment := &Protobuf{}
+	segments.RegisterSegment("protobuf", segment,
+		append([]segments.Param{
+			{Name: "filename", Description: "file to write to instead of stdout, may contain strftime-style directives"},
+			{Name: "zstd", Type: segments.Int, Description: "zstd compression level, compression is disabled if unset"},
+		}, segments.RotationParams...)...,
+	)
+}
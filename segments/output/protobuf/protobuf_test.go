@@ -0,0 +1,102 @@
+package protobuf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Protobuf Segment test, passthrough test
+func TestSegment_Protobuf_passthrough(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.pb")
+	result := segments.TestSegment("protobuf", map[string]string{"filename": filename},
+		&pb.EnrichedFlow{Proto: 6})
+	if result == nil || result.Proto != 6 {
+		t.Error("([error] Segment Protobuf is not passing through flows.")
+	}
+}
+
+// Protobuf Segment test, header and flows written
+func TestSegment_Protobuf_format(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.pb")
+	segments.TestSegment("protobuf", map[string]string{"filename": filename},
+		&pb.EnrichedFlow{Proto: 17, SrcPort: 53})
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, Magic) {
+		t.Fatalf("([error] Segment Protobuf did not write the header: %v", data)
+	}
+	reader := bufio.NewReader(bytes.NewReader(data[len(Magic):]))
+	if version, err := binary.ReadUvarint(reader); err != nil || version != SchemaVersion {
+		t.Errorf("([error] Segment Protobuf wrote schema version %d instead of %d.", version, SchemaVersion)
+	}
+	msg := &pb.EnrichedFlow{}
+	if err := protodelim.UnmarshalFrom(reader, msg); err != nil || msg.Proto != 17 || msg.SrcPort != 53 {
+		t.Errorf("([error] Segment Protobuf did not write the flow correctly: %v %v", msg, err)
+	}
+}
+
+// Protobuf Segment test, size based rotation
+func TestSegment_Protobuf_rotation(t *testing.T) {
+	dir := t.TempDir()
+	segment := Protobuf{}.New(map[string]string{"filename": filepath.Join(dir, "flows.pb"), "rotatesize": "1KB"})
+	if segment == nil {
+		t.Fatal("([error] Segment Protobuf failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+	for range 1000 {
+		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 0, 2, 1}, Bytes: 1500}
+		<-out
+	}
+	close(in)
+	wg.Wait()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "flows*"))
+	if len(files) < 2 {
+		t.Errorf("([error] Segment Protobuf did not rotate its output, wrote %v.", files)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil || !bytes.HasPrefix(data, Magic) {
+			t.Errorf("([error] Segment Protobuf did not start %s with the header.", file)
+		}
+	}
+}
+
+// Protobuf Segment benchmark passthrough
+func BenchmarkProtobuf(b *testing.B) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	segment := Protobuf{}.New(map[string]string{"filename": os.DevNull})
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+
+	for n := 0; n < b.N; n++ {
+		in <- &pb.EnrichedFlow{}
+		<-out
+	}
+	close(in)
+}
@@ -0,0 +1,73 @@
+// The `publish` segment hands a copy of every flow to all `connect` segments
+// subscribed to the given `topic` in other pipelines of the same flowpipeline
+// process, and passes the original flow on to the next segment. This allows
+// defining several pipelines in a single configuration, for instance to feed
+// both a storage and an alerting pipeline from a single `goflow` input, each
+// with its own concurrency. See the `connect` segment for an example.
+//
+// Flows are discarded if no pipeline is connected to the topic. Otherwise,
+// this segment waits for all connected pipelines to receive each flow. The
+// connected pipelines keep receiving until this segment has been closed.
+package publish
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/BelWue/flowpipeline/segments"
+	"github.com/BelWue/flowpipeline/segments/input/connect"
+)
+
+type Publish struct {
+	segments.BaseSegment
+	Topic string // required, the topic connect segments receive the flows from
+
+	advertised bool
+}
+
+func (segment Publish) New(config map[string]string) segments.Segment {
+	if config["topic"] == "" {
+		log.Error().Msg("Publish: Parameter 'topic' is required.")
+		return nil
+	}
+	newsegment := &Publish{Topic: config["topic"]}
+	if !segments.DryRun(config) {
+		connect.Advertise(newsegment.Topic)
+		newsegment.advertised = true
+	}
+	return newsegment
+}
+
+func (segment *Publish) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		close(segment.Out)
+		wg.Done()
+	}()
+	unconnected := false
+	for msg := range segment.In {
+		delivered := connect.Publish(segment.Topic, msg)
+		if delivered == 0 && !unconnected {
+			log.Warn().Msgf("Publish: No pipeline is connected to topic '%s', discarding flows.", segment.Topic)
+		}
+		unconnected = delivered == 0
+		segment.Out <- msg
+	}
+}
+
+// Lets the connect segments of the topic stop receiving once all of its
+// publishers have been closed.
+func (segment *Publish) Close() {
+	if segment.advertised {
+		connect.Unadvertise(segment.Topic)
+		segment.advertised = false
+	}
+}
+
+func init() {
+	segment := &Publish{}
+	segments.RegisterSegment("publish", segment,
+		segments.Param{Name: "topic", Required: true, Description: "the topic connect segments receive the flows from"},
+	)
+}
@@ -0,0 +1,91 @@
+package publish
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/pipeline"
+	"github.com/BelWue/flowpipeline/segments"
+	_ "github.com/BelWue/flowpipeline/segments/input/connect"
+	_ "github.com/BelWue/flowpipeline/segments/testing/generator"
+)
+
+func TestSegment_Publish_passthrough(t *testing.T) {
+	result := segments.TestSegment("publish", map[string]string{"topic": "unconnected"},
+		&pb.EnrichedFlow{Proto: 6})
+	if result == nil || result.Proto != 6 {
+		t.Error("([error] Segment Publish is not passing through flows.")
+	}
+}
+
+// Publish Segment test, pipelines connected by a topic shut down while flows
+// are in flight
+func TestSegment_Publish_shutdown(t *testing.T) {
+	pipelineReprs, err := pipeline.PipelineReprsFromConfig([]byte(`
+pipelines:
+- name: ingest
+  segments:
+  - segment: generator
+  - segment: publish
+    config:
+      topic: shutdown
+- name: storage
+  segments:
+  - segment: connect
+    config:
+      topic: shutdown
+`))
+	if err == nil {
+		pipelineReprs, err = pipeline.ShutdownOrder(pipelineReprs)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pipes []*pipeline.Pipeline
+	for _, pipelineRepr := range pipelineReprs {
+		pipe, err := pipeline.NewFromPipelineRepr(pipelineRepr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipes = append(pipes, pipe)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, pipe := range pipes {
+		pipe.StartContext(ctx)
+	}
+	storage := pipes[len(pipes)-1]
+	received := make(chan struct{})
+	go func() {
+		for range storage.Out {
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	go func() {
+		for range pipes[0].Out {
+		}
+	}()
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("([error] Segment Publish did not deliver any flows.")
+	}
+
+	cancel()
+	closed := make(chan struct{})
+	go func() {
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("([error] Pipelines connected by Segment Publish did not shut down.")
+	}
+}
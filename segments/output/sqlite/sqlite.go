@@ -6,7 +6,10 @@
 // The `sqlite` segment provides a SQLite output option. It is intended for use as
 // an ad-hoc dump method to answer questions on live traffic, i.e. average packet
 // size for a specific class of traffic. The fields parameter optionally takes a
-// string of comma-separated fieldnames, e.g. `SrcAddr,Bytes,Packets`.
+// string of comma-separated fieldnames, e.g. `SrcAddr,Bytes,Packets`. Entries of
+// map fields are selected as `<field>.<key>`, e.g. `labels.site`, and stored in
+// a column such as `labels_site`, while maps selected as a whole are stored as
+// JSON objects.
 //
 // The batchsize parameter determines the number of flows stored in memory before
 // writing them to the database in a transaction made up from as many insert
@@ -17,11 +20,10 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"math"
-	"net"
-	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -37,8 +39,7 @@ import (
 type Sqlite struct {
 	segments.BaseSegment
 	db              *sql.DB
-	fieldTypes      []string
-	fieldNames      []string
+	fields          []segments.FlowField
 	createStatement string
 	insertStatement string
 
@@ -84,55 +85,50 @@ func (segment Sqlite) New(config map[string]string) segments.Segment {
 
 	// determine field set
 	if config["fields"] != "" {
-		protofields := reflect.TypeOf(pb.EnrichedFlow{})
 		conffields := strings.Split(config["fields"], ",")
-		for _, field := range conffields {
-			protofield, found := protofields.FieldByName(field)
-			if !found || !protofield.IsExported() {
-				log.Error().Msgf("Sqlite: Field '%s' specified in 'fields' does not exist.", field)
+		for _, name := range conffields {
+			field, err := segments.ParseFlowField(name)
+			if err != nil {
+				log.Error().Err(err).Msgf("Sqlite: Field '%s' specified in 'fields' is invalid: ", name)
 				return nil
 			}
-			newsegment.fieldNames = append(newsegment.fieldNames, field)
-			newsegment.fieldTypes = append(newsegment.fieldTypes, protofield.Type.String())
+			newsegment.fields = append(newsegment.fields, field)
 		}
 	} else {
-		protofields := reflect.TypeOf(pb.EnrichedFlow{})
-		for i := 0; i < protofields.NumField(); i++ {
-			field := protofields.Field(i)
-			if field.IsExported() {
-				newsegment.fieldNames = append(newsegment.fieldNames, field.Name)
-				newsegment.fieldTypes = append(newsegment.fieldTypes, field.Type.String())
-			}
-		}
+		newsegment.fields = segments.AllFlowFields()
 		newsegment.Fields = config["fields"]
 	}
 
 	// use field set to pre-gen statements
 	// create
-	var fields []string
-	for i, fieldname := range newsegment.fieldNames {
-		switch newsegment.fieldTypes[i] {
+	var fields, fieldNames []string
+	for _, field := range newsegment.fields {
+		fieldName := `"` + field.LabelName() + `"` // quoted, as names such as Values are keywords
+		fieldNames = append(fieldNames, fieldName)
+		switch field.Type().String() {
 		case "uint64", "uint32":
-			fields = append(fields, fieldname+" INTEGER")
+			fields = append(fields, fieldName+" INTEGER")
+		case "float64":
+			fields = append(fields, fieldName+" REAL")
 		default:
-			fields = append(fields, fieldname+" TEXT")
+			fields = append(fields, fieldName+" TEXT")
 		}
 	}
 	newsegment.createStatement = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS flows (%s);`, strings.Join(fields, ","))
 
 	// insert
-	qmList := make([]string, 0, len(newsegment.fieldNames))
-	for i := 0; i < len(newsegment.fieldNames); i++ {
+	qmList := make([]string, 0, len(fieldNames))
+	for i := 0; i < len(fieldNames); i++ {
 		qmList = append(qmList, "?")
 	}
-	valueStrings := make([]string, 0, len(newsegment.fieldNames))
+	valueStrings := make([]string, 0, len(fieldNames))
 	valueStrings = append(valueStrings, fmt.Sprintf("(%s)", strings.Join(qmList, ",")))
-	newsegment.insertStatement = fmt.Sprintf("INSERT INTO flows (%s) VALUES %s", strings.Join(newsegment.fieldNames, ","), strings.Join(valueStrings, ","))
+	newsegment.insertStatement = fmt.Sprintf("INSERT INTO flows (%s) VALUES %s", strings.Join(fieldNames, ","), strings.Join(valueStrings, ","))
 
 	return newsegment
 }
 
-func (segment *Sqlite) Run(wg *sync.WaitGroup) {
+func (segment *Sqlite) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
@@ -168,7 +164,9 @@ func (segment *Sqlite) Run(wg *sync.WaitGroup) {
 		}
 		segment.Out <- msg
 	}
-	segment.bulkInsert(unsaved)
+	segments.Flush("Sqlite", func(context.Context) error {
+		return segment.bulkInsert(unsaved)
+	})
 }
 
 func (segment Sqlite) bulkInsert(unsavedFlows []*pb.EnrichedFlow) error {
@@ -180,22 +178,9 @@ func (segment Sqlite) bulkInsert(unsavedFlows []*pb.EnrichedFlow) error {
 		log.Error().Err(err).Msgf("Sqlite: Error starting transaction for current batch of %d flows", len(unsavedFlows))
 	}
 	for _, msg := range unsavedFlows {
-		valueArgs := make([]interface{}, 0, len(segment.fieldNames))
-		values := reflect.ValueOf(msg).Elem()
-		for i, fieldname := range segment.fieldNames {
-			protofield := values.FieldByName(fieldname)
-			switch segment.fieldTypes[i] {
-			case "[]uint8": // this is neccessary for proper formatting
-				ipstring := net.IP(protofield.Interface().([]uint8)).String()
-				if ipstring == "<nil>" {
-					ipstring = ""
-				}
-				valueArgs = append(valueArgs, ipstring)
-			case "string": // this is because doing nothing is also much faster than Sprint
-				valueArgs = append(valueArgs, protofield.Interface().(string))
-			default:
-				valueArgs = append(valueArgs, fmt.Sprint(protofield))
-			}
+		valueArgs := make([]interface{}, 0, len(segment.fields))
+		for _, field := range segment.fields {
+			valueArgs = append(valueArgs, field.Format(msg))
 		}
 		_, err := tx.Exec(segment.insertStatement, valueArgs...)
 		if err != nil {
@@ -208,5 +193,9 @@ func (segment Sqlite) bulkInsert(unsavedFlows []*pb.EnrichedFlow) error {
 
 func init() {
 	segment := &Sqlite{}
-	segments.RegisterSegment("sqlite", segment)
+	segments.RegisterSegment("sqlite", segment,
+		segments.Param{Name: "filename", Required: true, Description: "the database file to write to, created if it does not exist"},
+		segments.Param{Name: "fields", Description: "comma-separated list of fields to export, all fields if unset"},
+		segments.Param{Name: "batchsize", Type: segments.Int, Default: "1000", Description: "number of flows inserted at once"},
+	)
 }
@@ -4,7 +4,10 @@
 package sqlite
 
 import (
+	"context"
+	"database/sql"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
@@ -15,19 +18,19 @@ import (
 
 // Sqlite Segment test, passthrough test only
 func TestSegment_Sqlite_passthrough(t *testing.T) {
-	// result := segments.TestSegment("sqlite", map[string]string{"filename": "test.sqlite"},
+	// result := segments.TestSegment("sqlite", map[string]string{"filename": filepath.Join(t.TempDir(), "test.sqlite")},
 	// 	&pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, Proto: 45})
 	// if result == nil {
 	// 	t.Error("([error] Segment Sqlite is not passing through flows.")
 	// }
-	segment := Sqlite{}.New(map[string]string{"filename": "test.sqlite"})
+	segment := Sqlite{}.New(map[string]string{"filename": filepath.Join(t.TempDir(), "test.sqlite")})
 
 	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
 	segment.Rewire(in, out)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 	in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 1}, DstAddr: []byte{192, 168, 88, 1}, Proto: 1}
 	<-out
 	in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 2}, DstAddr: []byte{192, 168, 88, 2}, Proto: 2}
@@ -41,14 +44,14 @@ func BenchmarkSqlite_1000(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 	os.Stdout, _ = os.Open(os.DevNull)
 
-	segment := Sqlite{}.New(map[string]string{"filename": "bench.sqlite"})
+	segment := Sqlite{}.New(map[string]string{"filename": filepath.Join(b.TempDir(), "bench.sqlite")})
 
 	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
 	segment.Rewire(in, out)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, Proto: 45}
@@ -62,14 +65,14 @@ func BenchmarkSqlite_10000(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 	os.Stdout, _ = os.Open(os.DevNull)
 
-	segment := Sqlite{}.New(map[string]string{"filename": "bench.sqlite", "batchsize": "10000"})
+	segment := Sqlite{}.New(map[string]string{"filename": filepath.Join(b.TempDir(), "bench.sqlite"), "batchsize": "10000"})
 
 	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
 	segment.Rewire(in, out)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, Proto: 45}
@@ -83,14 +86,14 @@ func BenchmarkSqlite_100000(b *testing.B) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 	os.Stdout, _ = os.Open(os.DevNull)
 
-	segment := Sqlite{}.New(map[string]string{"filename": "bench.sqlite", "batchsize": "100000"})
+	segment := Sqlite{}.New(map[string]string{"filename": filepath.Join(b.TempDir(), "bench.sqlite"), "batchsize": "100000"})
 
 	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
 	segment.Rewire(in, out)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{SrcAddr: []byte{192, 168, 88, 142}, DstAddr: []byte{192, 168, 88, 143}, Proto: 45}
@@ -98,3 +101,36 @@ func BenchmarkSqlite_100000(b *testing.B) {
 	}
 	close(in)
 }
+
+// Sqlite Segment test, stores entries of maps and whole maps
+func TestSegment_Sqlite_maps(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.sqlite")
+	segment := Sqlite{}.New(map[string]string{"filename": filename, "fields": "Proto,labels.site,Values"})
+	if segment == nil {
+		t.Fatal("([error] Segment Sqlite did not accept entries of maps as fields.")
+	}
+
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+	in <- &pb.EnrichedFlow{Proto: 6, Labels: map[string]string{"site": "fra1"}, Values: map[string]float64{"rtt": 1.5}}
+	<-out
+	close(in)
+	wg.Wait()
+
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var proto uint32
+	var site, values string
+	if err := db.QueryRow(`SELECT Proto, labels_site, "Values" FROM flows`).Scan(&proto, &site, &values); err != nil {
+		t.Fatal(err)
+	}
+	if proto != 6 || site != "fra1" || values != `{"rtt":1.5}` {
+		t.Errorf("([error] Segment Sqlite did not store the maps correctly, got %d, %s and %s.", proto, site, values)
+	}
+}
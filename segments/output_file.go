@@ -0,0 +1,380 @@
+package segments
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/rs/zerolog/log"
+)
+
+// Parameters accepted by all segments supporting the rotation of their
+// OutputFile, to be passed to RegisterSegment along with their own ones.
+var RotationParams = []Param{
+	{Name: "rotatesize", Description: "start a new file once this size is reached, e.g. 100MB"},
+	{Name: "rotateinterval", Type: Duration, Description: "start a new file after this time"},
+	{Name: "retaincount", Type: Uint, Description: "delete the oldest completed files exceeding this number"},
+	{Name: "retainage", Type: Duration, Description: "delete completed files older than this"},
+}
+
+// How often segments check OutputFile.Due while no flows arrive, so that files
+// are completed and rotated in time on idle pipelines.
+const RotationCheckInterval = time.Second
+
+// The file written by a text output segment, which is either stdout, a
+// regular file, or a series of files rotated by the segment. The file name
+// may contain strftime-style directives such as `flows-%Y%m%d-%H.json`,
+// which are expanded when a file is created. A new file is started whenever
+// the expanded name changes, once `rotatesize` bytes have been written, or
+// once the file has been written to for `rotateinterval`. If the name is taken
+// already, a counter is inserted before the extension. Directories named by
+// directives are created as needed.
+//
+// Files of a rotated output are written as `<name>.tmp` and renamed once
+// completed, so that other tools only ever see complete files. Completed files
+// named after the template, i.e. with any directives expanded and a counter
+// inserted, are deleted once they exceed `retaincount` files or are older than
+// `retainage`.
+type OutputFile struct {
+	file *os.File
+
+	template       string // empty for stdout
+	rotating       bool   // set if rotating or if the template contains directives
+	RotateSize     uint64
+	RotateInterval time.Duration
+	RetainCount    uint64
+	RetainAge      time.Duration
+
+	name     string // the final name of the current file
+	base     string // the expanded template the current file was named after
+	sequence int    // the counter inserted into the name of the current file
+	written  uint64
+	opened   time.Time
+	checked  time.Time // the last time the template was expanded to check for changes
+}
+
+// Creates an OutputFile using the parameters `filename` and those in
+// RotationParams. Nothing is created for dry runs, see DryRun.
+func NewOutputFile(config map[string]string) (*OutputFile, error) {
+	f := &OutputFile{template: config["filename"]}
+	var err error
+	if config["rotatesize"] != "" {
+		if f.RotateSize, err = humanize.ParseBytes(config["rotatesize"]); err != nil {
+			return nil, &ParamError{Param: "rotatesize", Err: err}
+		}
+	}
+	if config["rotateinterval"] != "" {
+		if f.RotateInterval, err = time.ParseDuration(config["rotateinterval"]); err != nil {
+			return nil, &ParamError{Param: "rotateinterval", Err: err}
+		}
+	}
+	if config["retaincount"] != "" {
+		if f.RetainCount, err = strconv.ParseUint(config["retaincount"], 10, 64); err != nil {
+			return nil, &ParamError{Param: "retaincount", Err: err}
+		}
+	}
+	if config["retainage"] != "" {
+		if f.RetainAge, err = time.ParseDuration(config["retainage"]); err != nil {
+			return nil, &ParamError{Param: "retainage", Err: err}
+		}
+	}
+
+	if f.template == "" {
+		if f.RotateSize > 0 || f.RotateInterval > 0 || f.RetainCount > 0 || f.RetainAge > 0 {
+			return nil, errors.New("rotating the output requires the 'filename' parameter")
+		}
+		f.file, f.name = os.Stdout, os.Stdout.Name()
+		return f, nil
+	}
+	now := time.Now()
+	f.base, err = strftime(f.template, now)
+	if err != nil {
+		return nil, &ParamError{Param: "filename", Err: err}
+	}
+	f.rotating = f.RotateSize > 0 || f.RotateInterval > 0 || f.base != f.template
+
+	if DryRun(config) {
+		// only check whether the file could be created, without truncating
+		// it, ignoring any directories named by directives
+		static := f.template
+		if i := strings.Index(static, "%"); i >= 0 {
+			static = static[:i]
+		}
+		if _, err := os.Stat(filepath.Dir(static)); err != nil {
+			return nil, err
+		}
+		f.file, f.name = os.Stdout, os.Stdout.Name()
+		return f, nil
+	}
+	if !f.rotating {
+		if f.file, err = os.Create(f.base); err != nil {
+			return nil, err
+		}
+		f.name, f.opened = f.base, now
+		return f, nil
+	}
+	return f, f.open(now)
+}
+
+// Returns the name of the current file, without the `.tmp` suffix.
+func (f *OutputFile) Name() string {
+	return f.name
+}
+
+func (f *OutputFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := f.file.Write(p)
+	f.written += uint64(n)
+	return n, err
+}
+
+func (f *OutputFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Returns whether the segment should rotate the file, i.e. call Rotate after
+// completing anything it has written to it so far. This is to be checked
+// between flows, as it is cheap enough.
+func (f *OutputFile) Due() bool {
+	if !f.rotating {
+		return false
+	}
+	if f.file == nil || (f.RotateSize > 0 && f.written >= f.RotateSize) {
+		return true
+	}
+	now := time.Now()
+	if f.RotateInterval > 0 && now.Sub(f.opened) >= f.RotateInterval {
+		return true
+	}
+	if now.Sub(f.checked) >= time.Second {
+		f.checked = now
+		base, _ := strftime(f.template, now)
+		return base != f.base
+	}
+	return false
+}
+
+// Completes the current file and opens the next one. The segment has to
+// write any headers again afterwards.
+func (f *OutputFile) Rotate() error {
+	err := f.complete()
+	now := time.Now()
+	base, _ := strftime(f.template, now)
+	if base != f.base {
+		f.base, f.sequence = base, 0
+	} else {
+		// continue counting, even if earlier files have been deleted
+		f.sequence += 1
+	}
+	if openErr := f.open(now); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	f.retain()
+	return err
+}
+
+// Closes the file, completing it if it is rotated. Stdout is left open.
+func (f *OutputFile) Close() error {
+	if f.file == os.Stdout {
+		return nil
+	}
+	if !f.rotating {
+		return f.file.Close()
+	}
+	err := f.complete()
+	f.retain()
+	return err
+}
+
+// Opens a temporary file for the current expanded template.
+func (f *OutputFile) open(now time.Time) error {
+	f.written, f.opened, f.checked = 0, now, now
+	dir, file := filepath.Split(f.base)
+	stem, ext := splitExt(file)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			f.file = nil
+			return err
+		}
+	}
+	for ; ; f.sequence++ {
+		name := f.base
+		if f.sequence > 0 {
+			name = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, f.sequence, ext))
+		}
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			tmp, err := os.OpenFile(name+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err == nil {
+				f.file, f.name = tmp, name
+				return nil
+			} else if !errors.Is(err, os.ErrExist) {
+				f.file = nil
+				return err
+			}
+		}
+	}
+}
+
+// Closes the current file and renames it to its final name.
+func (f *OutputFile) complete() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	if f.written == 0 && err == nil {
+		// don't leave empty files behind, e.g. when the template's hour
+		// changed while no flows arrived
+		return os.Remove(f.name + ".tmp")
+	}
+	return errors.Join(err, os.Rename(f.name+".tmp", f.name))
+}
+
+// Deletes completed files exceeding RetainCount or RetainAge.
+func (f *OutputFile) retain() {
+	if f.RetainCount == 0 && f.RetainAge == 0 {
+		return
+	}
+	dir, file := filepath.Split(f.template)
+	stem, ext := splitExt(file)
+	matches, err := filepath.Glob(filepath.Join(globTemplate(dir), "*"))
+	if err != nil {
+		log.Warn().Err(err).Msg("OutputFile: Failed to list files for retention: ")
+		return
+	}
+	// only the files this template could have been expanded to
+	named := regexp.MustCompile("^" + regexpTemplate(filepath.Join(dir, stem)) + "(-[0-9]+)?" + regexpTemplate(ext) + "$")
+	type completed struct {
+		name     string
+		modified time.Time
+	}
+	var files []completed
+	for _, name := range matches {
+		if !named.MatchString(name) {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+			files = append(files, completed{name, info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].modified.Equal(files[j].modified) {
+			return files[i].name > files[j].name
+		}
+		return files[i].modified.After(files[j].modified)
+	})
+	for i, file := range files {
+		if (f.RetainCount > 0 && uint64(i) >= f.RetainCount) || (f.RetainAge > 0 && time.Since(file.modified) > f.RetainAge) {
+			if err := os.Remove(file.name); err != nil {
+				log.Warn().Err(err).Msgf("OutputFile: Failed to delete %s: ", file.name)
+			} else {
+				log.Info().Msgf("OutputFile: Deleted %s due to retention.", file.name)
+			}
+		}
+	}
+}
+
+// Splits a file name at the first dot, so that multiple extensions such as
+// `.json.zst` are kept together.
+func splitExt(name string) (string, string) {
+	if i := strings.Index(name, "."); i > 0 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// Expands strftime-style directives using the given time.
+func strftime(template string, t time.Time) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			b.WriteByte(template[i])
+			continue
+		}
+		i++
+		if i == len(template) {
+			return "", errors.New("incomplete directive at the end")
+		}
+		switch template[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 's':
+			fmt.Fprintf(&b, "%d", t.Unix())
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unknown directive '%%%c'", template[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// Replaces strftime-style directives by wildcards, escaping everything else.
+func globTemplate(template string) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] == '%' && i+1 < len(template) {
+			i++
+			if template[i] == '%' {
+				b.WriteByte('%')
+			} else {
+				b.WriteByte('*')
+			}
+			continue
+		}
+		if strings.IndexByte(`*?[\`, template[i]) >= 0 && filepath.Separator != '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(template[i])
+	}
+	return b.String()
+}
+
+// Replaces strftime-style directives by regular expressions matching their
+// expansions, quoting everything else.
+func regexpTemplate(template string) string {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' || i+1 == len(template) {
+			b.WriteString(regexp.QuoteMeta(template[i : i+1]))
+			continue
+		}
+		i++
+		switch template[i] {
+		case 'Y':
+			b.WriteString("[0-9]{4}")
+		case 'j':
+			b.WriteString("[0-9]{3}")
+		case 's':
+			b.WriteString("[0-9]+")
+		case '%':
+			b.WriteString("%")
+		default:
+			b.WriteString("[0-9]{2}")
+		}
+	}
+	return b.String()
+}
@@ -5,6 +5,7 @@
 package segments
 
 import (
+	"context"
 	"sync"
 
 	"github.com/BelWue/flowpipeline/pb"
@@ -36,12 +37,12 @@ func (segment *ParallelizedSegment) SubscribeDrops(drop chan *pb.EnrichedFlow) {
 	}
 }
 
-func (segment *ParallelizedSegment) Run(wg *sync.WaitGroup) {
+func (segment *ParallelizedSegment) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 	segmentWg := sync.WaitGroup{}
 	for _, segment := range segment.segments {
 		segmentWg.Add(1)
-		go segment.Run(&segmentWg)
+		go segment.Run(ctx, &segmentWg)
 	}
 	segmentWg.Wait()
 }
@@ -52,13 +53,10 @@ func (segment *ParallelizedSegment) Rewire(in chan *pb.EnrichedFlow, out chan *p
 	}
 }
 
-func (segment *ParallelizedSegment) AddSegment(nestedSegment Segment) {
-	segment.segments = append(segment.segments, nestedSegment)
+func (segment *ParallelizedSegment) Segments() []Segment {
+	return segment.segments
 }
 
-// ShutdownParentPipeline implements Segment.
-func (segment *ParallelizedSegment) ShutdownParentPipeline() {
-	for _, segment := range segment.segments {
-		segment.ShutdownParentPipeline()
-	}
+func (segment *ParallelizedSegment) AddSegment(nestedSegment Segment) {
+	segment.segments = append(segment.segments, nestedSegment)
 }
@@ -0,0 +1,147 @@
+package segments
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrUnknownParam = errors.New("not supported by this segment")
+	ErrMissingParam = errors.New("required, but not set")
+)
+
+// The type of a segment's configuration parameter, which determines which
+// values are accepted for it.
+type ParamType int
+
+const (
+	String   ParamType = iota // any value
+	Bool                      // anything accepted by strconv.ParseBool
+	Int                       // a signed integer
+	Uint                      // an unsigned integer
+	Float                     // a floating point number
+	Duration                  // anything accepted by time.ParseDuration
+	File                      // the path of an existing file
+)
+
+func (t ParamType) String() string {
+	switch t {
+	case Bool:
+		return "bool"
+	case Int:
+		return "int"
+	case Uint:
+		return "uint"
+	case Float:
+		return "float"
+	case Duration:
+		return "duration"
+	case File:
+		return "file"
+	default:
+		return "string"
+	}
+}
+
+// Describes a configuration parameter accepted by a segment. Segments declare
+// their parameters when calling RegisterSegment, which allows the pipeline to
+// check configurations before handing them to the segment's New method, and
+// is used to generate the documentation.
+type Param struct {
+	Name        string
+	Type        ParamType
+	Default     string // set by ApplyParams if the parameter is empty
+	Required    bool   // whether the parameter has to be set, ignoring any Default
+	Description string // a short sentence for the documentation
+}
+
+// Returned for problems with a single configuration parameter.
+type ParamError struct {
+	Param string
+	Err   error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("config parameter '%s': %v", e.Param, e.Err)
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// Checks whether the value is acceptable for this parameter. Empty values are
+// always acceptable, as segments treat them as unset.
+func (p Param) Check(value string) error {
+	if value == "" {
+		return nil
+	}
+	var err error
+	switch p.Type {
+	case Bool:
+		_, err = strconv.ParseBool(value)
+	case Int:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case Uint:
+		_, err = strconv.ParseUint(value, 10, 64)
+	case Float:
+		_, err = strconv.ParseFloat(value, 64)
+	case Duration:
+		_, err = time.ParseDuration(value)
+	case File:
+		if _, err = os.Stat(value); err != nil && ContainerVolumePrefix != "" {
+			_, err = os.Stat(ContainerVolumePrefix + value)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("bad value '%s': %w", value, err)
+	}
+	return nil
+}
+
+// Checks a segment's configuration against the parameters it declared and
+// returns a copy with the defaults of all unset parameters filled in. All
+// problems found are returned as ParamErrors, ordered by parameter name for
+// unknown or malformed parameters, followed by missing ones in the order of
+// declaration. Segments without declared parameters do not accept any.
+func ApplyParams(name string, config map[string]string) (map[string]string, []error) {
+	params := LookupParams(name)
+	declared := make(map[string]Param, len(params))
+	for _, param := range params {
+		declared[param.Name] = param
+	}
+
+	var errs []error
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		param, ok := declared[key]
+		if !ok {
+			errs = append(errs, &ParamError{Param: key, Err: ErrUnknownParam})
+		} else if err := param.Check(config[key]); err != nil {
+			errs = append(errs, &ParamError{Param: key, Err: err})
+		}
+	}
+
+	result := make(map[string]string, len(params))
+	for key, value := range config {
+		result[key] = value
+	}
+	for _, param := range params {
+		if result[param.Name] != "" {
+			continue
+		}
+		if param.Required {
+			errs = append(errs, &ParamError{Param: param.Name, Err: ErrMissingParam})
+		} else if param.Default != "" {
+			result[param.Name] = param.Default
+		}
+	}
+	return result, errs
+}
@@ -5,6 +5,7 @@
 package pass
 
 import (
+	"context"
 	"sync"
 
 	"github.com/BelWue/flowpipeline/segments"
@@ -27,12 +28,17 @@ func (segment Pass) New(config map[string]string) segments.Segment {
 // The main goroutine of any Segment. Any Run method must:
 // 1. close(segment.Out) when the In channel is closed by the previous segment or the Pipeline itself
 // 2. call wg.Done() before exiting
-// 3. if exiting for any other reason, use segment.ShutdownParentPipeline() or just continue to pass from In to Out
+// 3. if exiting for any other reason, use segments.ShutdownParentPipeline(ctx) and continue to pass from In to Out
+//
+// The ctx is cancelled once the pipeline is shutting down. Input segments should
+// stop accepting new flows then, but any segment must keep draining In until it
+// is closed. Segments buffering flows should write them out using segments.Flush
+// before closing Out.
 //
 // Usually, when using a range over In in combination with below defer, nothing
 // will go wrong. However, some segments have a legitimate use case for using
 // `for {}`, in which case care must be taken to keep draining In.
-func (segment *Pass) Run(wg *sync.WaitGroup) {
+func (segment *Pass) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		// This defer clause is important and needs to be present in
 		// any Segment.Run method in some form, but with at least the
@@ -48,7 +54,11 @@ func (segment *Pass) Run(wg *sync.WaitGroup) {
 
 // Every Segment needs an init() function of some form in its file to be
 // callable from config. An unregistered Segment will only be available using
-// the API.
+// the API. Any config parameters should be declared here as well, e.g.
+// `segments.Param{Name: "filename", Type: segments.File, Required: true}`.
+// Configurations containing unknown keys or bad values are then rejected
+// before New is called, unset parameters are filled in from their Default,
+// and the parameters are listed in CONFIGURATION.md.
 func init() {
 	segment := &Pass{}
 	segments.RegisterSegment("pass", segment)
@@ -1,6 +1,7 @@
 package pass
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -31,7 +32,7 @@ func BenchmarkPass(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{}
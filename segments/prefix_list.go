@@ -0,0 +1,313 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwNetFlow/ip_prefix_trie"
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Parameters accepted by all segments reading a PrefixList, to be passed to
+// RegisterSegment along with their own ones.
+var PrefixListParams = []Param{
+	{Name: "watch", Type: Bool, Default: "true", Description: "reload the prefix list whenever the file changes"},
+	{Name: "reloadinterval", Type: Duration, Description: "check the sources of the prefix list for changes in this interval, for file systems which can not be watched"},
+}
+
+var (
+	prefixListLabels = []string{"segment", "source"}
+
+	prefixListEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flowpipeline_prefixlist_entries",
+		Help: "Number of prefixes currently loaded from a prefix source.",
+	}, prefixListLabels)
+	prefixListLastReload = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flowpipeline_prefixlist_last_reload_timestamp_seconds",
+		Help: "Time a prefix source was last loaded successfully.",
+	}, prefixListLabels)
+	prefixListReloadErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flowpipeline_prefixlist_reload_errors_total",
+		Help: "Number of reloads of a prefix source which were rejected.",
+	}, prefixListLabels)
+
+	// The metrics of all prefix lists, registered by the pipeline package.
+	PrefixListMetrics = []prometheus.Collector{prefixListEntries, prefixListLastReload, prefixListReloadErrors}
+)
+
+// A row read from a PrefixSource, consisting of a prefix and its value.
+type PrefixRow struct {
+	Position string   // where the row was read from, such as `line 3`
+	Columns  []string // the prefix followed by its values
+	Err      error    // set if the row could not be read
+}
+
+// A source of prefixes and their values, such as a CSV file. Missing values,
+// such as JSON nulls, are read as empty strings.
+type PrefixSource interface {
+	// Returns the rows of the source, or an error if it could not be read.
+	Read() ([]PrefixRow, error)
+	// Returns whether the source was modified since it was read last.
+	// Sources which can not tell always return true.
+	Modified() bool
+	// Returns a name of the source for logs and metrics.
+	String() string
+}
+
+// A list of prefixes and their values read from one or more sources, as used
+// by enriching segments. The prefixes are looked up by a segment while the
+// sources are reloaded in the background whenever they are modified, see
+// Start.
+//
+// A reload reads a source completely, then replaces all prefixes with a new
+// set built from the prefixes of all sources, so that lookups are never blocked
+// or see a partial list. If a modified source contains a malformed row, it is
+// rejected and the previous prefixes of that source are kept. Rows whose values
+// are all empty are skipped, such as prefixes without a tenant in an IPAM.
+type PrefixList struct {
+	Sources        []PrefixSource // merged in this order, later sources take precedence for identical prefixes
+	Watch          bool           // optional, default is true, whether to reload files when they are changed
+	ReloadInterval time.Duration  // optional, default is 0 (off) if only files are read, check the sources for changes in this interval
+
+	name    string                             // of the segment, prefixing logs
+	parse   func(values []string) (any, error) // returns the value stored for a prefix
+	entries [][]prefixEntry                    // the last valid entries of each source
+	tries   atomic.Pointer[prefixTries]
+}
+
+type prefixEntry struct {
+	prefix *net.IPNet
+	value  any
+}
+
+type prefixTries struct {
+	v4, v6 ip_prefix_trie.TrieNode
+}
+
+// Creates a PrefixList using the parameters `filename` as well as those in
+// PrefixListParams and PrefixSourceParams. The name of the segment prefixes
+// logs and labels its metrics, the parse function returns the value stored for
+// a prefix from the columns following it, or an error if they are malformed. A
+// nil function stores the first value as it is.
+func NewPrefixList(name string, config map[string]string, parse func(values []string) (any, error)) (*PrefixList, error) {
+	list := &PrefixList{Watch: true, name: name, parse: parse}
+	if config["filename"] != "" {
+		list.Sources = append(list.Sources, &prefixFile{name: config["filename"]})
+	}
+	sources, err := newPrefixSources(config)
+	if err != nil {
+		return nil, err
+	}
+	list.Sources = append(list.Sources, sources...)
+	if len(list.Sources) == 0 {
+		return nil, errors.New("a 'filename', 'url' or 'sqlquery' parameter is required")
+	}
+	if config["watch"] != "" {
+		watch, err := strconv.ParseBool(config["watch"])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse 'watch' parameter: %w", err)
+		}
+		list.Watch = watch
+	}
+	if len(sources) > 0 {
+		list.ReloadInterval = 5 * time.Minute
+	}
+	if config["reloadinterval"] != "" {
+		interval, err := time.ParseDuration(config["reloadinterval"])
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("could not parse 'reloadinterval' parameter, it has to be a positive duration: %v", err)
+		}
+		list.ReloadInterval = interval
+	}
+	if list.parse == nil {
+		list.parse = func(values []string) (any, error) { return values[0], nil }
+	}
+	list.entries = make([][]prefixEntry, len(list.Sources))
+	return list, nil
+}
+
+// Returns the value of the most specific prefix containing the address, or nil
+// if there is none.
+func (list *PrefixList) Lookup(address net.IP) any {
+	tries := list.tries.Load()
+	if tries == nil {
+		return nil
+	}
+	if address.To4() == nil {
+		return tries.v6.Lookup(address)
+	}
+	return tries.v4.Lookup(address)
+}
+
+// Loads all sources and keeps reloading them in the background whenever they
+// are changed until the context is done. Changes of files are noticed by
+// watching their directories if Watch is set, and all sources are checked for
+// changes every ReloadInterval if set. Errors are logged, if a source can not
+// be read initially, its prefixes will not match until it can.
+func (list *PrefixList) Start(ctx context.Context) {
+	var watcher *fsnotify.Watcher
+	if list.Watch {
+		var err error
+		watcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			log.Error().Err(err).Msgf("%s: Failed to set up prefix list watcher: ", list.name)
+		}
+	}
+	for i, source := range list.Sources {
+		if file, ok := source.(*prefixFile); ok && watcher != nil {
+			// watch the directory, as files are usually replaced instead of written
+			if err := watcher.Add(filepath.Dir(ContainerVolumePrefix + file.name)); err != nil {
+				log.Error().Err(err).Msgf("%s: Failed to watch prefix list: ", list.name)
+			}
+		}
+		if err := list.load(i, false); err != nil {
+			log.Error().Err(err).Msgf("%s: Could not read prefix list %s: ", list.name, source)
+		}
+	}
+	list.build()
+	go list.watch(ctx, watcher)
+}
+
+func (list *PrefixList) watch(ctx context.Context, watcher *fsnotify.Watcher) {
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if watcher != nil {
+		defer watcher.Close()
+		events, errs = watcher.Events, watcher.Errors
+	}
+	var ticks <-chan time.Time
+	if list.ReloadInterval > 0 {
+		ticker := time.NewTicker(list.ReloadInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			settled = time.After(500 * time.Millisecond)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Warn().Err(err).Msgf("%s: Prefix list watcher: ", list.name)
+		case <-settled:
+			settled = nil
+			list.reload(true)
+		case <-ticks:
+			list.reload(false)
+		}
+	}
+}
+
+// Reloads all modified sources, or only the files if filesOnly is set, and
+// rebuilds the prefixes if any of them changed.
+func (list *PrefixList) reload(filesOnly bool) {
+	var changed bool
+	for i, source := range list.Sources {
+		if _, ok := source.(*prefixFile); (filesOnly && !ok) || !source.Modified() {
+			continue
+		}
+		if err := list.load(i, true); err != nil {
+			prefixListReloadErrors.With(list.labels(source)).Inc()
+			log.Error().Err(err).Msgf("%s: Keeping the previous prefixes of %s, as it could not be reloaded: ", list.name, source)
+			continue
+		}
+		changed = true
+	}
+	if changed {
+		list.build()
+	}
+}
+
+// Reads the entries of a source. Malformed rows are skipped with a warning
+// unless strict is set, in which case the first one is returned as an error
+// and the previous entries are kept.
+func (list *PrefixList) load(index int, strict bool) error {
+	source := list.Sources[index]
+	rows, err := source.Read()
+	if err != nil {
+		return err
+	}
+	entries := make([]prefixEntry, 0, len(rows))
+	for _, row := range rows {
+		entry, err := list.parseRow(row)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", row.Position, err)
+			if strict {
+				return err
+			}
+			log.Warn().Err(err).Msgf("%s: Skipping malformed row in prefix list %s: ", list.name, source)
+			continue
+		}
+		if entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	list.entries[index] = entries
+	prefixListEntries.With(list.labels(source)).Set(float64(len(entries)))
+	prefixListLastReload.With(list.labels(source)).SetToCurrentTime()
+	log.Info().Msgf("%s: Read prefix list %s with %d prefixes.", list.name, source, len(entries))
+	return nil
+}
+
+// Returns the entry of a row, or nil if all of its values are empty.
+func (list *PrefixList) parseRow(row PrefixRow) (*prefixEntry, error) {
+	if row.Err != nil {
+		return nil, row.Err
+	}
+	if len(row.Columns) < 2 {
+		return nil, fmt.Errorf("expected 'prefix,value' but got '%s'", strings.Join(row.Columns, ","))
+	}
+	_, prefix, err := net.ParseCIDR(strings.TrimSpace(row.Columns[0]))
+	if err != nil {
+		return nil, err
+	}
+	values := row.Columns[1:]
+	if !slices.ContainsFunc(values, func(value string) bool { return value != "" }) {
+		return nil, nil
+	}
+	value, err := list.parse(values)
+	if err != nil {
+		return nil, err
+	}
+	return &prefixEntry{prefix: prefix, value: value}, nil
+}
+
+// Builds new tries from the entries of all sources and replaces the current
+// ones.
+func (list *PrefixList) build() {
+	tries := &prefixTries{}
+	for _, entries := range list.entries {
+		for _, entry := range entries {
+			if len(entry.prefix.IP) == net.IPv4len {
+				tries.v4.Insert(entry.value, []string{entry.prefix.String()})
+			} else {
+				tries.v6.Insert(entry.value, []string{entry.prefix.String()})
+			}
+		}
+	}
+	list.tries.Store(tries)
+}
+
+func (list *PrefixList) labels(source PrefixSource) prometheus.Labels {
+	return prometheus.Labels{"segment": strings.ToLower(list.name), "source": source.String()}
+}
@@ -9,6 +9,7 @@
 package count
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -38,7 +39,7 @@ func (segment Count) New(config map[string]string) segments.Segment {
 	}
 }
 
-func (segment *Count) Run(wg *sync.WaitGroup) {
+func (segment *Count) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		segment.File.Close()
 		close(segment.Out)
@@ -55,5 +56,8 @@ func (segment *Count) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &Count{}
-	segments.RegisterSegment("count", segment)
+	segments.RegisterSegment("count", segment,
+		segments.Param{Name: "filename", Description: "file to write to instead of stdout"},
+		segments.Param{Name: "prefix", Description: "printed along with the result"},
+	)
 }
@@ -5,6 +5,7 @@
 package printdots
 
 import (
+	"context"
 	"strconv"
 	"sync"
 
@@ -44,7 +45,7 @@ func (segment PrintDots) New(config map[string]string) segments.Segment {
 	}
 }
 
-func (segment *PrintDots) Run(wg *sync.WaitGroup) {
+func (segment *PrintDots) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		segment.File.Close()
@@ -62,5 +63,8 @@ func (segment *PrintDots) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &PrintDots{}
-	segments.RegisterSegment("printdots", segment)
+	segments.RegisterSegment("printdots", segment,
+		segments.Param{Name: "filename", Description: "file to write to instead of stdout"},
+		segments.Param{Name: "flowsperdot", Type: segments.Uint, Default: "5000", Description: "number of flows per dot"},
+	)
 }
@@ -9,7 +9,7 @@ import (
 
 // PrintDots Segment test, passthrough test only
 func TestSegment_PrintDots_passthrough(t *testing.T) {
-	result := segments.TestSegment("printdots", map[string]string{"flowsPerDot": "100"},
+	result := segments.TestSegment("printdots", map[string]string{"flowsperdot": "100"},
 		&pb.EnrichedFlow{})
 	if result == nil {
 		t.Error("([error] Segment PrintDots is not passing through flows.")
@@ -24,9 +24,11 @@
 // `highlight` parameter causes the output of this segment to be printed in red,
 // see the [relevant example](https://github.com/BelWue/flowpipeline/tree/master/examples/configuration/highlighted_flowdump)
 // for an application. The parameter `filename`can be used to redirect the output to a file instead of printing it to stdout.
+// The file can be rotated just like the output of the `json` segment.
 package printflowdump
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
@@ -48,16 +50,38 @@ type PrintFlowdump struct {
 	Highlight    bool // optional, default is false
 }
 
-func (segment *PrintFlowdump) Run(wg *sync.WaitGroup) {
+func (segment *PrintFlowdump) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		segment.File.Close()
 		fmt.Println("\033[0m") // reset color in case we're still highlighting
 		wg.Done()
 	}()
-	for msg := range segment.In {
-		segment.File.WriteString(segment.format_flow(msg))
-		segment.Out <- msg
+	ticker := time.NewTicker(segments.RotationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-segment.In:
+			if !ok {
+				return
+			}
+			segment.File.WriteString(segment.format_flow(msg))
+			if segment.File.Due() {
+				segment.rotate()
+			}
+			segment.Out <- msg
+		case <-ticker.C:
+			if segment.File.Due() {
+				segment.rotate()
+			}
+		}
+	}
+}
+
+// Completes the current file and continues with the next one.
+func (segment *PrintFlowdump) rotate() {
+	if err := segment.File.Rotate(); err != nil {
+		log.Error().Err(err).Msgf("PrintFlowdump: Failed to rotate output to %s: ", segment.File.Name())
 	}
 }
 
@@ -268,5 +292,12 @@ func (segment PrintFlowdump) format_flow(flowmsg *pb.EnrichedFlow) string {
 
 func init() {
 	segment := &PrintFlowdump{}
-	segments.RegisterSegment("printflowdump", segment)
+	segments.RegisterSegment("printflowdump", segment,
+		append([]segments.Param{
+			{Name: "filename", Description: "file to write to instead of stdout, may contain strftime-style directives"},
+			{Name: "useprotoname", Type: segments.Bool, Default: "true", Description: "print protocol names instead of numbers"},
+			{Name: "verbose", Type: segments.Bool, Default: "false", Description: "print additional fields"},
+			{Name: "highlight", Type: segments.Bool, Default: "false", Description: "highlight the output using colors"},
+		}, segments.RotationParams...)...,
+	)
 }
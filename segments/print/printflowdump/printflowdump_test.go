@@ -1,6 +1,7 @@
 package printflowdump
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -31,7 +32,7 @@ func BenchmarkPrintFlowdump(b *testing.B) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	for n := 0; n < b.N; n++ {
 		in <- &pb.EnrichedFlow{}
@@ -19,6 +19,7 @@ package toptalkers
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"math"
 	"sort"
@@ -140,7 +141,7 @@ func (segment TopTalkers) New(config map[string]string) segments.Segment {
 	return newsegment
 }
 
-func (segment *TopTalkers) Run(wg *sync.WaitGroup) {
+func (segment *TopTalkers) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		segment.writer.Flush()
 		close(segment.Out)
@@ -211,5 +212,13 @@ func (segment *TopTalkers) Run(wg *sync.WaitGroup) {
 
 func init() {
 	segment := &TopTalkers{}
-	segments.RegisterSegment("toptalkers", segment)
+	segments.RegisterSegment("toptalkers", segment,
+		segments.Param{Name: "filename", Description: "file to write to instead of stdout"},
+		segments.Param{Name: "window", Type: segments.Int, Default: "60", Description: "size of the sliding window in seconds"},
+		segments.Param{Name: "reportinterval", Type: segments.Int, Default: "10", Description: "seconds between reports"},
+		segments.Param{Name: "thresholdbps", Type: segments.Uint, Default: "0", Description: "only report talkers exceeding this average bits per second"},
+		segments.Param{Name: "thresholdpps", Type: segments.Uint, Default: "0", Description: "only report talkers exceeding this average packets per second"},
+		segments.Param{Name: "topn", Type: segments.Uint, Default: "10", Description: "number of talkers per report"},
+		segments.Param{Name: "logprefix", Description: "prefix for each line, useful when several segments write to the same file"},
+	)
 }
@@ -5,24 +5,54 @@
 package segments
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"strings"
 	"sync"
-	"syscall"
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/pipeline/config"
 	"github.com/rs/zerolog/log"
 )
 
+var ErrUnknownSegment = errors.New("could not find a segment named")
+
 var (
 	registeredSegments    = make(map[string]Segment)
+	registeredParams      = make(map[string][]Param)
 	lock                  = &sync.RWMutex{}
 	ContainerVolumePrefix = ""
 )
 
-// Used by Segments to register themselves in their init() functions. Errors
-// and exits immediately on conflicts.
-func RegisterSegment(name string, s Segment) {
+// The config key marking a segment as only created to validate a
+// configuration, see WithDryRun.
+const dryRunKey = "_dryrun"
+
+// Returns a copy of config which marks the segment created from it as only
+// used to validate a configuration. See DryRun.
+func WithDryRun(config map[string]string) map[string]string {
+	result := make(map[string]string, len(config)+1)
+	for key, value := range config {
+		result[key] = value
+	}
+	result[dryRunKey] = "true"
+	return result
+}
+
+// Reports whether a segment is only created to validate a configuration, in
+// which case it should not create any files, connect to any servers or start
+// any goroutines.
+func DryRun(config map[string]string) bool {
+	return config[dryRunKey] == "true"
+}
+
+// Used by Segments to register themselves in their init() functions,
+// declaring all configuration parameters they accept. Configurations of
+// segments declaring none are rejected if they set any parameter. Errors and
+// exits immediately on conflicts.
+func RegisterSegment(name string, s Segment, params ...Param) {
 	name = strings.ToLower(name)
 	_, ok := registeredSegments[name]
 	if ok {
@@ -30,35 +60,66 @@ func RegisterSegment(name string, s Segment) {
 	}
 	lock.Lock()
 	registeredSegments[name] = s
+	registeredParams[name] = params
 	lock.Unlock()
 }
 
 // Used by the pipeline package to convert segment names in configuration to
-// actual Segment objects.
-func LookupSegment(name string) Segment {
+// actual Segment objects. Returns an error wrapping ErrUnknownSegment if no
+// segment of this name has been registered.
+func LookupSegment(name string) (Segment, error) {
 	name = strings.ToLower(name)
 	lock.RLock()
 	segment, ok := registeredSegments[name]
 	lock.RUnlock()
 	if !ok {
-		log.Fatal().Msgf("Segments: Could not find a segment named '%s'.", name)
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownSegment, name)
 	}
-	return segment
+	return segment, nil
+}
+
+// Used to check configurations for unknown segment names.
+func IsRegistered(name string) bool {
+	name = strings.ToLower(name)
+	lock.RLock()
+	_, ok := registeredSegments[name]
+	lock.RUnlock()
+	return ok
+}
+
+// Returns the name the type of a segment instance was registered with, or an
+// empty string if it is unknown.
+func RegisteredName(segment Segment) string {
+	segmentType := reflect.TypeOf(segment)
+	lock.RLock()
+	defer lock.RUnlock()
+	for name, registered := range registeredSegments {
+		if reflect.TypeOf(registered) == segmentType {
+			return name
+		}
+	}
+	return ""
+}
+
+// Returns the configuration parameters declared by a segment. Segments not
+// declaring any parameters, such as most plugins, return an empty list.
+func LookupParams(name string) []Param {
+	name = strings.ToLower(name)
+	lock.RLock()
+	defer lock.RUnlock()
+	return registeredParams[name]
 }
 
 // Used by the tests to run single flow messages through a segment.
 func TestSegment(name string, config map[string]string, msg *pb.EnrichedFlow) *pb.EnrichedFlow {
-	segment := LookupSegment(name).New(config)
-	if segment == nil {
-		log.Fatal().Msgf("Configured segment '%s' could not be initialized properly, see previous messages.", name)
-	}
+	segment := newTestSegment(name, config)
 
 	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
 	segment.Rewire(in, out)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	go segment.Run(wg)
+	go segment.Run(context.Background(), wg)
 
 	in <- msg
 	close(in)
@@ -68,15 +129,70 @@ func TestSegment(name string, config map[string]string, msg *pb.EnrichedFlow) *p
 	return resultMsg
 }
 
+// Used by the tests to run several flow messages through a segment, returning
+// all flows it passed on and, for filter segments, all flows it dropped, in
+// the order they were produced.
+func TestSegmentFlows(name string, config map[string]string, msgs []*pb.EnrichedFlow) ([]*pb.EnrichedFlow, []*pb.EnrichedFlow) {
+	segment := newTestSegment(name, config)
+
+	in, out, drops := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow, len(msgs))
+	segment.Rewire(in, out)
+	if filter, ok := segment.(FilterSegment); ok {
+		filter.SubscribeDrops(drops)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(context.Background(), wg)
+	go func() {
+		for _, msg := range msgs {
+			in <- msg
+		}
+		close(in)
+	}()
+
+	var resultMsgs, droppedMsgs []*pb.EnrichedFlow
+	for msg := range out {
+		resultMsgs = append(resultMsgs, msg)
+	}
+	wg.Wait()
+	close(drops)
+	for msg := range drops {
+		droppedMsgs = append(droppedMsgs, msg)
+	}
+	return resultMsgs, droppedMsgs
+}
+
+// Creates a configured segment for the tests, exiting on any errors.
+func newTestSegment(name string, config map[string]string) Segment {
+	config, errs := ApplyParams(name, config)
+	if len(errs) > 0 {
+		log.Fatal().Err(errors.Join(errs...)).Msgf("Configured segment '%s' is misconfigured.", name)
+	}
+	template, err := LookupSegment(name)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Segments: ")
+	}
+	segment := template.New(config)
+	if segment == nil {
+		log.Fatal().Msgf("Configured segment '%s' could not be initialized properly, see previous messages.", name)
+	}
+	return segment
+}
+
 // This interface is central to an Pipeline object, as it operates on a list of
 // them. In general, Segments should embed the BaseSegment to provide the
 // Rewire function and the associated vars.
+// The context passed to Run is cancelled as soon as the Pipeline is shutting
+// down, which is when input segments should stop accepting new flows. All
+// segments must however keep passing flows from In to Out until In is closed,
+// as any flows still in flight are drained through the Pipeline afterwards.
+// Input segments close Out only once they passed on all flows they accepted.
 type Segment interface {
 	New(config map[string]string) Segment                       // for reading the provided config
-	Run(wg *sync.WaitGroup)                                     // goroutine, must close(segment.Out) when segment.In is closed
+	Run(ctx context.Context, wg *sync.WaitGroup)                // goroutine, must close(segment.Out) when segment.In is closed
 	Rewire(in chan *pb.EnrichedFlow, out chan *pb.EnrichedFlow) // embed this using BaseSegment
-	ShutdownParentPipeline()                                    // shut down Parent Pipeline gracefully
-	AddCustomConfig(segmentReprs config.SegmentRepr)            //Add segment specific sturctured config parameters
+	AddCustomConfig(segmentReprs config.SegmentRepr) error      //Add segment specific sturctured config parameters
 	Close()
 }
 
@@ -99,16 +215,11 @@ func (segment *BaseSegment) Rewire(in chan *pb.EnrichedFlow, out chan *pb.Enrich
 	segment.Out = out
 }
 
-// This functions shutdown Parent Pipeline segments on the given syscall.
-// It is used for intended termination within pipeline function, e.g. end pipeline on read from file.
-func (segment *BaseSegment) ShutdownParentPipeline() {
-	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-}
-
 func (segment *BaseSegment) Close() {
 	//placeholder since most segments dont need to do anything
 }
 
-func (segment *BaseSegment) AddCustomConfig(config.SegmentRepr) {
+func (segment *BaseSegment) AddCustomConfig(config.SegmentRepr) error {
 	//placeholder since most segments dont have a custom sturctured config
+	return nil
 }
@@ -0,0 +1,60 @@
+package segments
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The time any output segment is given to flush buffered flows on shutdown,
+// see Flush.
+var FlushTimeout = 10 * time.Second
+
+type shutdownKey struct{}
+
+// Returns a context carrying the function ShutdownParentPipeline calls. This
+// is used by the pipeline package when starting a Pipeline's segments. If ctx
+// already carries such a function, it is replaced, i.e. the innermost
+// registration wins.
+func WithShutdown(ctx context.Context, shutdown func()) context.Context {
+	return context.WithValue(ctx, shutdownKey{}, shutdown)
+}
+
+// Shuts down the Pipeline the segment is running in gracefully, using the
+// context passed to its Run method. It is used for intended termination
+// within a pipeline, e.g. ending it after reading a file.
+func ShutdownParentPipeline(ctx context.Context) {
+	shutdown, ok := ctx.Value(shutdownKey{}).(func())
+	if !ok {
+		log.Warn().Msg("Segments: Shutdown requested, but the segment is not running in a Pipeline.")
+		return
+	}
+	shutdown()
+}
+
+// Runs the final flush of an output segment, typically once its In channel
+// has been closed. The flush function is given a context expiring after
+// FlushTimeout, after which Flush returns regardless of whether flush did.
+// The outcome is logged in either case and returned.
+func Flush(name string, flush func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), FlushTimeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- flush(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Error().Err(err).Msgf("%s: Final flush failed after %s.", name, time.Since(start).Round(time.Millisecond))
+			return err
+		}
+		log.Info().Msgf("%s: Final flush completed in %s.", name, time.Since(start).Round(time.Millisecond))
+		return nil
+	case <-ctx.Done():
+		log.Error().Msgf("%s: Final flush did not complete within %s, buffered flows may have been lost.", name, FlushTimeout)
+		return ctx.Err()
+	}
+}
@@ -1,40 +1,415 @@
+// The `generator` segment generates synthetic flows, which is useful to
+// benchmark pipelines or to test enrichers such as `addnetid` or `aslookup`
+// without production data. Flows received from previous segments are passed
+// on as usual.
+//
+// Most parameters are weighted lists of the form `value=weight,value`, with
+// the weight defaulting to 1, from which values are drawn at random:
+//
+//   - `srcaddrs` and `dstaddrs` are lists of CIDR prefixes, and addresses are
+//     drawn uniformly from the chosen prefix. Destination addresses are drawn
+//     from prefixes of the source address' family, if there are any.
+//   - `protocols` lists protocol numbers or the names `tcp`, `udp`, `icmp`
+//     and `icmpv6`.
+//   - `dstports` lists the destination ports of TCP and UDP flows, while
+//     source ports are drawn from the ephemeral port range.
+//   - `packetsizes` lists the sizes of the packets of a flow.
+//   - `samplers` and `interfaces` list the addresses of the exporting devices
+//     and their interface indices, used for both `InIf` and `OutIf`.
+//
+// The number of packets of each flow follows a heavy-tailed Pareto
+// distribution with the shape `pareto`, limited to `maxpackets`, such that
+// most flows are short while a few elephant flows account for most of the
+// traffic. Bytes are the number of packets times the chosen packet size.
+//
+// Flows are generated as fast as possible unless `rate` limits the number of
+// flows per second. After `count` flows, the segment stops generating and
+// shuts down the pipeline. Setting `seed` makes the generated flows
+// reproducible, except for their timestamps, which are based on the time they
+// were generated.
 package generator
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
 
 	"github.com/BelWue/flowpipeline/pb"
 	"github.com/BelWue/flowpipeline/segments"
 )
 
+var protocolNames = map[string]uint32{
+	"icmp":   1,
+	"tcp":    6,
+	"udp":    17,
+	"icmpv6": 58,
+}
+
 type Generator struct {
 	segments.BaseSegment
+	Rate       float64 // optional, default is 0 which means as fast as possible, flows per second
+	Count      uint64  // optional, default is 0 which means unlimited, shuts down the pipeline after this many flows
+	Seed       uint64  // optional, default is random
+	Pareto     float64 // optional, default is 1.2, the shape of the distribution of packets per flow
+	MaxPackets uint64  // optional, default is 1000000
+
+	srcAddrs    weighted[*net.IPNet]
+	dstAddrs    [2]weighted[*net.IPNet] // by family of the source address, IPv4 first
+	protocols   weighted[uint32]
+	dstPorts    weighted[uint32]
+	packetSizes weighted[uint64]
+	samplers    weighted[net.IP]
+	interfaces  weighted[uint32]
+
+	random    *rand.Rand
+	generated uint64
+}
+
+// Values and their cumulative weights, to draw values at random.
+type weighted[T any] struct {
+	values     []T
+	cumulative []float64
+}
+
+func (w *weighted[T]) add(value T, weight float64) {
+	total := weight
+	if len(w.cumulative) > 0 {
+		total += w.cumulative[len(w.cumulative)-1]
+	}
+	w.values = append(w.values, value)
+	w.cumulative = append(w.cumulative, total)
+}
+
+func (w weighted[T]) draw(random *rand.Rand) T {
+	x := random.Float64() * w.cumulative[len(w.cumulative)-1]
+	for i, c := range w.cumulative {
+		if x < c {
+			return w.values[i]
+		}
+	}
+	return w.values[len(w.values)-1]
+}
+
+// Parses the weighted list given by a parameter, or its default.
+func parseParam[T any](config map[string]string, name string, parse func(string) (T, error)) (weighted[T], error) {
+	list := config[name]
+	if list == "" {
+		list = defaults[name]
+	}
+	w, err := parseWeighted(list, parse)
+	if err != nil {
+		return w, fmt.Errorf("'%s': %w", name, err)
+	}
+	return w, nil
+}
+
+// Parses a weighted list such as `443=40,80=10,53`.
+func parseWeighted[T any](list string, parse func(string) (T, error)) (weighted[T], error) {
+	var w weighted[T]
+	for _, item := range strings.Split(list, ",") {
+		value, weightString, found := strings.Cut(strings.TrimSpace(item), "=")
+		weight := 1.0
+		if found {
+			var err error
+			if weight, err = strconv.ParseFloat(weightString, 64); err != nil || weight <= 0 {
+				return w, fmt.Errorf("invalid weight '%s'", weightString)
+			}
+		}
+		parsed, err := parse(value)
+		if err != nil {
+			return w, err
+		}
+		w.add(parsed, weight)
+	}
+	return w, nil
+}
+
+func parsePrefix(value string) (*net.IPNet, error) {
+	_, prefix, err := net.ParseCIDR(value)
+	return prefix, err
+}
+
+func parseProtocol(value string) (uint32, error) {
+	if proto, ok := protocolNames[strings.ToLower(value)]; ok {
+		return proto, nil
+	}
+	proto, err := strconv.ParseUint(value, 10, 8)
+	return uint32(proto), err
+}
+
+func parsePort(value string) (uint32, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	return uint32(port), err
+}
+
+func parseInterface(value string) (uint32, error) {
+	index, err := strconv.ParseUint(value, 10, 32)
+	return uint32(index), err
+}
+
+func parseSize(value string) (uint64, error) {
+	size, err := strconv.ParseUint(value, 10, 16)
+	if err == nil && size == 0 {
+		err = errors.New("packets can't be empty")
+	}
+	return size, err
+}
+
+func parseAddress(value string) (net.IP, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		return ip, nil
+	}
+	return nil, fmt.Errorf("invalid address '%s'", value)
 }
 
 func (segment Generator) New(config map[string]string) segments.Segment {
-	return &Generator{}
+	newsegment := &Generator{Seed: rand.Uint64(), Pareto: 1.2, MaxPackets: 1000000}
+	var err error
+	if config["rate"] != "" {
+		if newsegment.Rate, err = strconv.ParseFloat(config["rate"], 64); err != nil || newsegment.Rate < 0 {
+			log.Error().Err(err).Msg("Generator: Could not parse 'rate' parameter, it has to be a non-negative number: ")
+			return nil
+		}
+	}
+	if config["count"] != "" {
+		if newsegment.Count, err = strconv.ParseUint(config["count"], 10, 64); err != nil {
+			log.Error().Err(err).Msg("Generator: Could not parse 'count' parameter: ")
+			return nil
+		}
+	}
+	if config["seed"] != "" {
+		if newsegment.Seed, err = strconv.ParseUint(config["seed"], 10, 64); err != nil {
+			log.Error().Err(err).Msg("Generator: Could not parse 'seed' parameter: ")
+			return nil
+		}
+	} else {
+		log.Info().Msgf("Generator: 'seed' unset, using random seed %d.", newsegment.Seed)
+	}
+	if config["pareto"] != "" {
+		if newsegment.Pareto, err = strconv.ParseFloat(config["pareto"], 64); err != nil || newsegment.Pareto <= 0 {
+			log.Error().Err(err).Msg("Generator: Could not parse 'pareto' parameter, it has to be a positive number: ")
+			return nil
+		}
+	}
+	if config["maxpackets"] != "" {
+		if newsegment.MaxPackets, err = strconv.ParseUint(config["maxpackets"], 10, 64); err != nil || newsegment.MaxPackets == 0 {
+			log.Error().Err(err).Msg("Generator: Could not parse 'maxpackets' parameter, it has to be a positive integer: ")
+			return nil
+		}
+	}
+
+	var errs [7]error
+	newsegment.srcAddrs, errs[0] = parseParam(config, "srcaddrs", parsePrefix)
+	newsegment.dstAddrs[0], errs[1] = parseParam(config, "dstaddrs", parsePrefix)
+	newsegment.protocols, errs[2] = parseParam(config, "protocols", parseProtocol)
+	newsegment.dstPorts, errs[3] = parseParam(config, "dstports", parsePort)
+	newsegment.packetSizes, errs[4] = parseParam(config, "packetsizes", parseSize)
+	newsegment.samplers, errs[5] = parseParam(config, "samplers", parseAddress)
+	newsegment.interfaces, errs[6] = parseParam(config, "interfaces", parseInterface)
+	if err := errors.Join(errs[:]...); err != nil {
+		log.Error().Err(err).Msg("Generator: Could not parse parameters: ")
+		return nil
+	}
+
+	// split the destination prefixes by family, unless there's just one
+	all := newsegment.dstAddrs[0]
+	var byFamily [2]weighted[*net.IPNet]
+	for i, prefix := range all.values {
+		family := 0
+		if prefix.IP.To4() == nil {
+			family = 1
+		}
+		weight := all.cumulative[i]
+		if i > 0 {
+			weight -= all.cumulative[i-1]
+		}
+		byFamily[family].add(prefix, weight)
+	}
+	for family := range byFamily {
+		if len(byFamily[family].values) == 0 {
+			byFamily[family] = all
+		}
+	}
+	newsegment.dstAddrs = byFamily
+
+	newsegment.random = rand.New(rand.NewPCG(newsegment.Seed, newsegment.Seed))
+	return newsegment
 }
 
-func (segment *Generator) Run(wg *sync.WaitGroup) {
+// The defaults of all weighted lists.
+var defaults = map[string]string{
+	"srcaddrs":    "10.0.0.0/8=3,2001:db8:1000::/36",
+	"dstaddrs":    "192.0.2.0/24,198.51.100.0/24,203.0.113.0/24,2001:db8::/36",
+	"protocols":   "tcp=80,udp=17,icmp=2,icmpv6=1",
+	"dstports":    "443=50,80=15,53=15,123=5,22=5,25=5,8080=5",
+	"packetsizes": "64=40,576=15,1500=45",
+	"samplers":    "192.0.2.1",
+	"interfaces":  "1,2,3,4",
+}
+
+func (segment *Generator) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		close(segment.Out)
 		wg.Done()
 	}()
 
+	start := time.Now()
+	generating := true
 	for {
+		if generating && (ctx.Err() != nil || (segment.Count > 0 && segment.generated >= segment.Count)) {
+			generating = false
+			if ctx.Err() == nil {
+				log.Info().Msgf("Generator: Generated %d flows, closing pipeline", segment.generated)
+				segments.ShutdownParentPipeline(ctx)
+			}
+		}
+		if !generating {
+			msg, ok := <-segment.In
+			if !ok {
+				return
+			}
+			segment.Out <- msg
+			continue
+		}
+
+		var wait <-chan time.Time
+		if segment.Rate > 0 {
+			due := start.Add(time.Duration(float64(segment.generated+1) / segment.Rate * float64(time.Second)))
+			if delay := time.Until(due); delay > 0 {
+				wait = time.After(delay)
+			}
+		}
+		if wait == nil {
+			select {
+			case msg, ok := <-segment.In:
+				if !ok {
+					return
+				}
+				segment.Out <- msg
+			default:
+				segment.Out <- segment.generate()
+				segment.generated += 1
+			}
+			continue
+		}
 		select {
 		case msg, ok := <-segment.In:
 			if !ok {
 				return
 			}
 			segment.Out <- msg
-		default:
-			segment.Out <- &pb.EnrichedFlow{Proto: 6, Bytes: 42, Note: "generated test flow"}
+		case <-wait:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Returns a new random flow.
+func (segment *Generator) generate() *pb.EnrichedFlow {
+	random := segment.random
+	src := segment.srcAddrs.draw(random)
+	family := 0
+	if src.IP.To4() == nil {
+		family = 1
+	}
+	dst := segment.dstAddrs[family].draw(random)
+	srcAddr, dstAddr := randomAddress(random, src), randomAddress(random, dst)
+	srcIs4, dstIs4 := srcAddr.To4() != nil, dstAddr.To4() != nil
+
+	proto := segment.protocols.draw(random)
+	// match the ICMP version to the addresses
+	if proto == 1 && !srcIs4 {
+		proto = 58
+	} else if proto == 58 && srcIs4 {
+		proto = 1
+	}
+
+	// Pareto distributed, with a minimum of one packet
+	packets := math.Floor(math.Pow(1-random.Float64(), -1/segment.Pareto))
+	if packets > float64(segment.MaxPackets) || math.IsInf(packets, 0) {
+		packets = float64(segment.MaxPackets)
+	}
+	size := segment.packetSizes.draw(random)
+
+	now := time.Now()
+	duration := time.Duration(packets) * time.Duration(1+random.IntN(100)) * time.Millisecond
+	duration = min(duration, 30*time.Minute)
+	flow := &pb.EnrichedFlow{
+		TimeReceivedNs:  uint64(now.UnixNano()),
+		TimeFlowStartNs: uint64(now.Add(-duration).UnixNano()),
+		TimeFlowEndNs:   uint64(now.UnixNano()),
+		SamplerAddress:  segment.samplers.draw(random),
+		SrcAddr:         srcAddr,
+		DstAddr:         dstAddr,
+		Proto:           proto,
+		Packets:         uint64(packets),
+		Bytes:           uint64(packets) * size,
+		InIf:            segment.interfaces.draw(random),
+		OutIf:           segment.interfaces.draw(random),
+		FlowDirection:   uint32(random.IntN(2)),
+		Note:            "generated test flow",
+	}
+	flow.SyncMissingTimeStamps()
+	if srcIs4 && dstIs4 {
+		flow.Etype = 0x0800
+	} else {
+		flow.Etype = 0x86dd
+	}
+
+	switch proto {
+	case 6, 17:
+		flow.SrcPort = uint32(32768 + random.IntN(28232))
+		flow.DstPort = segment.dstPorts.draw(random)
+		if proto == 6 {
+			flow.TcpFlags = 0x02 // SYN
+			if packets > 1 {
+				flow.TcpFlags = 0x1b // SYN, ACK, PSH, FIN
+			}
 		}
+	case 1, 58:
+		flow.IcmpType = 8 // echo request
+		if proto == 58 {
+			flow.IcmpType = 128
+		}
+	}
+	return flow
+}
+
+// Returns a random address within the prefix.
+func randomAddress(random *rand.Rand, prefix *net.IPNet) net.IP {
+	ip := make(net.IP, len(prefix.IP))
+	for i := range ip {
+		ip[i] = prefix.IP[i] | (byte(random.UintN(256)) &^ prefix.Mask[i])
+	}
+	if len(ip) == net.IPv4len {
+		return ip.To16()
 	}
+	return ip
 }
 
 func init() {
 	segment := &Generator{}
-	segments.RegisterSegment("generator", segment)
+	segments.RegisterSegment("generator", segment,
+		segments.Param{Name: "rate", Type: segments.Float, Default: "0", Description: "flows generated per second, as many as possible if 0"},
+		segments.Param{Name: "count", Type: segments.Uint, Default: "0", Description: "shut down the pipeline after generating this many flows, unlimited if 0"},
+		segments.Param{Name: "seed", Type: segments.Uint, Description: "seed of the random generator, to generate the same flows again"},
+		segments.Param{Name: "srcaddrs", Default: defaults["srcaddrs"], Description: "weighted list of prefixes to draw source addresses from"},
+		segments.Param{Name: "dstaddrs", Default: defaults["dstaddrs"], Description: "weighted list of prefixes to draw destination addresses from"},
+		segments.Param{Name: "protocols", Default: defaults["protocols"], Description: "weighted list of protocol numbers or names"},
+		segments.Param{Name: "dstports", Default: defaults["dstports"], Description: "weighted list of destination ports of TCP and UDP flows"},
+		segments.Param{Name: "packetsizes", Default: defaults["packetsizes"], Description: "weighted list of packet sizes in bytes"},
+		segments.Param{Name: "samplers", Default: defaults["samplers"], Description: "weighted list of sampler addresses"},
+		segments.Param{Name: "interfaces", Default: defaults["interfaces"], Description: "weighted list of interface indices"},
+		segments.Param{Name: "pareto", Type: segments.Float, Default: "1.2", Description: "shape of the Pareto distribution of packets per flow, smaller values result in more elephant flows"},
+		segments.Param{Name: "maxpackets", Type: segments.Uint, Default: "1000000", Description: "maximum number of packets per flow"},
+	)
 }
@@ -0,0 +1,138 @@
+package generator
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BelWue/flowpipeline/pb"
+	"github.com/BelWue/flowpipeline/segments"
+)
+
+// Runs the segment until it shuts down the pipeline, returning the flows.
+func generate(t *testing.T, config map[string]string) []*pb.EnrichedFlow {
+	segment := Generator{}.New(config)
+	if segment == nil {
+		t.Fatal("([error] Segment Generator failed to initialize.")
+	}
+	in, out := make(chan *pb.EnrichedFlow), make(chan *pb.EnrichedFlow)
+	segment.Rewire(in, out)
+	done := make(chan struct{})
+	ctx := segments.WithShutdown(context.Background(), func() { close(done) })
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go segment.Run(ctx, wg)
+
+	var flows []*pb.EnrichedFlow
+	for {
+		select {
+		case flow := <-out:
+			flows = append(flows, flow)
+		case <-done:
+			close(in)
+			for flow := range out {
+				flows = append(flows, flow)
+			}
+			wg.Wait()
+			return flows
+		}
+	}
+}
+
+// Generator Segment test, passthrough test
+func TestSegment_Generator_passthrough(t *testing.T) {
+	result := segments.TestSegment("generator", map[string]string{"rate": "1"},
+		&pb.EnrichedFlow{Type: 3})
+
+	if result.Type != 3 {
+		t.Error("([error] Segment Generator is not working.")
+	}
+}
+
+// Generator Segment test, generates flows from the configured pools
+func TestSegment_Generator_pools(t *testing.T) {
+	config := map[string]string{
+		"count":       "1000",
+		"seed":        "42",
+		"srcaddrs":    "10.1.0.0/16=9,2001:db8:1::/48",
+		"dstaddrs":    "192.0.2.0/24,2001:db8:2::/48",
+		"protocols":   "tcp=3,udp",
+		"dstports":    "443=9,53",
+		"packetsizes": "100",
+		"samplers":    "198.51.100.1,198.51.100.2",
+		"interfaces":  "7",
+		"maxpackets":  "500",
+	}
+	flows := generate(t, config)
+	if len(flows) != 1000 {
+		t.Fatalf("([error] Segment Generator generated %d flows, expected 1000.", len(flows))
+	}
+
+	_, src4, _ := net.ParseCIDR("10.1.0.0/16")
+	_, src6, _ := net.ParseCIDR("2001:db8:1::/48")
+	_, dst4, _ := net.ParseCIDR("192.0.2.0/24")
+	_, dst6, _ := net.ParseCIDR("2001:db8:2::/48")
+	var v4, https, elephants int
+	for _, flow := range flows {
+		src, dst := net.IP(flow.SrcAddr), net.IP(flow.DstAddr)
+		switch {
+		case src4.Contains(src) && dst4.Contains(dst) && flow.Etype == 0x0800:
+			v4 += 1
+		case src6.Contains(src) && dst6.Contains(dst) && flow.Etype == 0x86dd:
+		default:
+			t.Fatalf("([error] Segment Generator generated a flow from %s to %s outside of the pools.", src, dst)
+		}
+		if flow.Proto != 6 && flow.Proto != 17 {
+			t.Fatalf("([error] Segment Generator generated a flow with protocol %d.", flow.Proto)
+		}
+		if flow.DstPort == 443 {
+			https += 1
+		} else if flow.DstPort != 53 {
+			t.Fatalf("([error] Segment Generator generated a flow to port %d.", flow.DstPort)
+		}
+		if flow.Packets == 0 || flow.Packets > 500 || flow.Bytes != flow.Packets*100 {
+			t.Fatalf("([error] Segment Generator generated a flow with %d packets and %d bytes.", flow.Packets, flow.Bytes)
+		}
+		if flow.Packets >= 10 {
+			elephants += 1
+		}
+		if flow.InIf != 7 || flow.OutIf != 7 || flow.TimeFlowStartNs > flow.TimeFlowEndNs || flow.TimeReceivedNs == 0 {
+			t.Fatalf("([error] Segment Generator generated an invalid flow: %v", flow)
+		}
+	}
+	if v4 < 800 || v4 > 980 || https < 800 || https > 980 {
+		t.Errorf("([error] Segment Generator did not use the weights, got %d IPv4 flows and %d flows to port 443.", v4, https)
+	}
+	if elephants == 0 || elephants > 300 {
+		t.Errorf("([error] Segment Generator generated %d flows with at least 10 packets.", elephants)
+	}
+
+	// the same seed always results in the same flows
+	again := generate(t, config)
+	for i := range flows {
+		if !net.IP(again[i].SrcAddr).Equal(flows[i].SrcAddr) || again[i].DstPort != flows[i].DstPort || again[i].Bytes != flows[i].Bytes {
+			t.Fatalf("([error] Segment Generator generated flow %d differently using the same seed.", i)
+		}
+	}
+}
+
+// Generator Segment test, limits the rate of flows
+func TestSegment_Generator_rate(t *testing.T) {
+	began := time.Now()
+	flows := generate(t, map[string]string{"count": "20", "rate": "100"})
+	if elapsed := time.Since(began); elapsed < 200*time.Millisecond {
+		t.Errorf("([error] Segment Generator generated 20 flows at 100 flows/s in %s.", elapsed)
+	}
+	if len(flows) != 20 {
+		t.Errorf("([error] Segment Generator generated %d flows, expected 20.", len(flows))
+	}
+
+	if (Generator{}).New(map[string]string{"protocols": "tcp=-1"}) != nil {
+		t.Error("([error] Segment Generator accepted a negative weight.")
+	}
+	if (Generator{}).New(map[string]string{"srcaddrs": "10.0.0.1"}) != nil {
+		t.Error("([error] Segment Generator accepted an address instead of a prefix.")
+	}
+}
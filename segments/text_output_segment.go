@@ -4,31 +4,26 @@
 // additional init() function to register itself using RegisterSegment.
 package segments
 
-import (
-	"os"
-)
-
 type TextOutputSegment interface {
 	Segment
-	GetOutput(config map[string]string) (*os.File, error)
+	GetOutput(config map[string]string) (*OutputFile, error)
 }
 
 // An extended basis for Segment implementations in the filter group. It
 // contains the necessities to process filtered (dropped) flows.
 type BaseTextOutputSegment struct {
 	BaseSegment
-	File *os.File // optional, default is empty which means stdout
+	File *OutputFile // optional, default is empty which means stdout
 }
 
-func (s *BaseTextOutputSegment) GetOutput(config map[string]string) (*os.File, error) {
-	var err error
-	if config["filename"] != "" {
-		s.File, err = os.Create(config["filename"])
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		s.File = os.Stdout
+// Opens the output configured by the `filename` parameter and, if the
+// segment declared them, the RotationParams. Segments supporting rotation
+// check File.Due between flows.
+func (s *BaseTextOutputSegment) GetOutput(config map[string]string) (*OutputFile, error) {
+	file, err := NewOutputFile(config)
+	if err != nil {
+		return nil, err
 	}
+	s.File = file
 	return s.File, nil
 }